@@ -1,22 +1,78 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"go.uber.org/zap"
 	"net/http"
 	. "nms-backend/db"
 	. "nms-backend/utils"
+	"strconv"
+	"strings"
 )
 
 type userQueryRequest struct {
-	From                  uint32   `json:"from" binding:"required"`
-	To                    uint32   `json:"to" binding:"required"`
-	ObjectIds             []string `json:"object_ids"`
-	CounterId             uint16   `json:"counter_id" binding:"required"`
-	ObjectWiseAggregation string   `json:"object_wise_aggregation" binding:"required"`
-	TimestampAggregation  string   `json:"timestamp_aggregation" binding:"required"`
-	Interval              uint32   `json:"interval"`
+	From                  uint64       `json:"from" binding:"required"`
+	To                    uint64       `json:"to" binding:"required"`
+	Precision             string       `json:"precision"`
+	ObjectIds             []string     `json:"object_ids"`
+	CounterId             uint16       `json:"counter_id"`
+	CounterIds            []uint16     `json:"counter_ids"`
+	Expressions           []Expression `json:"expressions"`
+	ObjectWiseAggregation string       `json:"object_wise_aggregation" binding:"required"`
+	TimestampAggregation  string       `json:"timestamp_aggregation" binding:"required"`
+	Interval              uint64       `json:"interval"`
+	RankAggregation       string       `json:"rank_aggregation"`
+	RankOrder             string       `json:"rank_order"`
+	RankLimit             uint32       `json:"rank_limit"`
+	RankWithSeries        bool         `json:"rank_with_series"`
+	Limit                 uint32       `json:"limit"`
+	Offset                uint32       `json:"offset"`
+	Timeout               uint32       `json:"timeout"`
+	Priority              string       `json:"priority"`
+	Explain               bool         `json:"explain"`
+	StringMode            string       `json:"string_mode"`
+	Instances             []string     `json:"instances"`
+	InstanceAggregation   string       `json:"instance_aggregation"`
+}
+
+type statementQueryRequest struct {
+	Statement string `json:"statement"`
+	Precision string `json:"precision"`
+	Timeout   uint32 `json:"timeout"`
+	Priority  string `json:"priority"`
+	Explain   bool   `json:"explain"`
+}
+
+type latestQueryRequest struct {
+	CounterId  uint16   `json:"counter_id"`
+	CounterIds []uint16 `json:"counter_ids"`
+	ObjectIds  []string `json:"object_ids"`
+	Precision  string   `json:"precision"`
+}
+
+type metadataQueryRequest struct {
+	Metadata  string   `json:"metadata" binding:"required"`
+	From      uint64   `json:"from" binding:"required"`
+	To        uint64   `json:"to" binding:"required"`
+	Precision string   `json:"precision"`
+	CounterId uint16   `json:"counter_id" binding:"required"`
+	ObjectIds []string `json:"object_ids"`
+}
+
+type continuousQueryRequest struct {
+	Name            string   `json:"name" binding:"required"`
+	SourceCounterId uint16   `json:"source_counter_id" binding:"required"`
+	ObjectIds       []string `json:"object_ids"`
+	Aggregation     string   `json:"aggregation" binding:"required"`
+	Interval        uint32   `json:"interval" binding:"required"`
+	CounterId       uint16   `json:"counter_id" binding:"required"`
+}
+
+type registerCountersRequest struct {
+	Counters []CounterMetadata `json:"counters" binding:"required,min=1"`
 }
 
 type QueryController struct {
@@ -33,11 +89,50 @@ func NewQueryController(report *ReportDBClient) *QueryController {
 }
 
 func (queryController *QueryController) HandleQuery(ctx *gin.Context) {
+	var statementReq statementQueryRequest
+
+	// Text query statements are compiled by the reportDB itself
+
+	if err := ctx.ShouldBindBodyWith(&statementReq, binding.JSON); err == nil && statementReq.Statement != "" {
+
+		if !validPriority(statementReq.Priority) {
+
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid priority. Its must be either 'interactive' or 'batch'"})
+
+			return
+
+		}
+
+		if !validPrecision(statementReq.Precision) {
+
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid precision. Its must be either 's' or 'ms'"})
+
+			return
+
+		}
+
+		queryController.query(ctx, Query{
+
+			Statement: statementReq.Statement,
+
+			Precision: statementReq.Precision,
+
+			Timeout: statementReq.Timeout,
+
+			Priority: statementReq.Priority,
+
+			Explain: statementReq.Explain,
+		})
+
+		return
+
+	}
+
 	var req userQueryRequest
 
 	// Bind JSON from request body
 
-	if err := ctx.ShouldBindJSON(&req); err != nil {
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err != nil {
 
 		Logger.Error("Error parsing request", zap.Error(err))
 
@@ -58,40 +153,168 @@ func (queryController *QueryController) HandleQuery(ctx *gin.Context) {
 		return
 	}
 
+	// Validate Counters
+
+	if req.CounterId == 0 && len(req.CounterIds) == 0 && len(req.Expressions) == 0 {
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "either counter_id, counter_ids or expressions must be provided"})
+
+		return
+
+	}
+
+	for _, expression := range req.Expressions {
+
+		if expression.Name == "" || expression.Expression == "" {
+
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "every expression must have a name and an expression"})
+
+			return
+
+		}
+
+	}
+
 	// Validate Aggregators
 
-	switch req.ObjectWiseAggregation {
+	for _, aggregation := range []string{req.ObjectWiseAggregation, req.TimestampAggregation} {
 
-	case "avg", "sum", "min", "max", "count", "none":
+		if aggregation != "none" && !validAggregation(aggregation) {
 
-	default:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid aggregation function. Its must be either 'avg', 'sum', 'min', 'max', 'count', 'uptime', a percentile like 'p99' or 'none'"})
+
+			return
+
+		}
+
+	}
 
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid aggregation function. Its must be either 'avg', 'sum', 'min', 'max', 'count' or 'none'"})
+	if !validPriority(req.Priority) {
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid priority. Its must be either 'interactive' or 'batch'"})
+
+		return
+
+	}
+
+	if !validPrecision(req.Precision) {
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid precision. Its must be either 's' or 'ms'"})
 
 		return
 
 	}
 
-	switch req.TimestampAggregation {
+	switch req.StringMode {
 
-	case "avg", "sum", "min", "max", "count", "none":
+	case "", "distinct", "changes", "current":
 
 	default:
 
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid aggregation function. Its must be either 'avg', 'sum', 'min', 'max', 'count' or 'none'"})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid string mode. Its must be either 'distinct', 'changes' or 'current'"})
+
+		return
+
+	}
+
+	if req.InstanceAggregation != "" && req.InstanceAggregation != "none" && !validAggregation(req.InstanceAggregation) {
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid instance aggregation. Its must be either 'none', 'avg', 'sum', 'min', 'max', 'count', 'uptime' or a percentile like 'p99'"})
 
 		return
 
 	}
 
+	// Validate Ranking
+
+	if req.RankLimit > 0 {
+
+		if !validAggregation(req.RankAggregation) {
+
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid rank aggregation. Its must be either 'avg', 'sum', 'min', 'max', 'count', 'uptime' or a percentile like 'p99'"})
+
+			return
+
+		}
+
+		switch req.RankOrder {
+
+		case "top", "bottom":
+
+		default:
+
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid rank order. Its must be either 'top' or 'bottom'"})
+
+			return
+
+		}
+
+		if req.ObjectWiseAggregation != "none" {
+
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "ranking requires object_wise_aggregation to be 'none'"})
+
+			return
+
+		}
+
+	}
+
 	// ------------------- Query ReportDB --------------------
 
-	response, err := queryController.ReportDB.Query(req.From, req.To, req.Interval, req.ObjectIds, req.CounterId, req.ObjectWiseAggregation, req.TimestampAggregation)
+	queryController.query(ctx, Query{
+		From:                  req.From,
+		To:                    req.To,
+		Precision:             req.Precision,
+		Objects:               req.ObjectIds,
+		CounterId:             req.CounterId,
+		CounterIds:            req.CounterIds,
+		Expressions:           req.Expressions,
+		ObjectWiseAggregation: req.ObjectWiseAggregation,
+		TimestampAggregation:  req.TimestampAggregation,
+		Interval:              req.Interval,
+		RankAggregation:       req.RankAggregation,
+		RankOrder:             req.RankOrder,
+		RankLimit:             req.RankLimit,
+		RankWithSeries:        req.RankWithSeries,
+		Limit:                 req.Limit,
+		Offset:                req.Offset,
+		Timeout:               req.Timeout,
+		Priority:              req.Priority,
+		Explain:               req.Explain,
+		StringMode:            req.StringMode,
+		Instances:             req.Instances,
+		InstanceAggregation:   req.InstanceAggregation,
+	})
+
+}
+
+func (queryController *QueryController) query(ctx *gin.Context, query Query) {
+
+	response, err := queryController.ReportDB.Query(ctx.Request.Context(), query)
 
 	if err != nil {
 
 		Logger.Warn("Error querying database", zap.Error(err))
 
+		var reportDBError *ReportDBError
+
+		if errors.As(err, &reportDBError) {
+
+			// Queries rejected by the reportDB's admission control can be retried later
+			if strings.HasPrefix(reportDBError.Message, "query rejected") {
+
+				ctx.JSON(http.StatusTooManyRequests, gin.H{"error": reportDBError.Error()})
+
+				return
+
+			}
+
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": reportDBError.Error()})
+
+			return
+
+		}
+
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error: %v", err)})
 
 		return
@@ -100,3 +323,257 @@ func (queryController *QueryController) HandleQuery(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, response)
 }
+
+// HandleLatestQuery returns the latest value of the objects, for current status lookups.
+func (queryController *QueryController) HandleLatestQuery(ctx *gin.Context) {
+
+	var req latestQueryRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+
+		Logger.Error("Error parsing request", zap.Error(err))
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid JSON request: %v", err)})
+
+		return
+
+	}
+
+	if req.CounterId == 0 && len(req.CounterIds) == 0 {
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "either counter_id or counter_ids must be provided"})
+
+		return
+
+	}
+
+	if !validPrecision(req.Precision) {
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid precision. Its must be either 's' or 'ms'"})
+
+		return
+
+	}
+
+	queryController.query(ctx, Query{
+
+		Latest: true,
+
+		CounterId: req.CounterId,
+
+		CounterIds: req.CounterIds,
+
+		Objects: req.ObjectIds,
+
+		Precision: req.Precision,
+	})
+
+}
+
+// HandleMetadataQuery lists the objects having data for a counter in the range, or describes their series.
+func (queryController *QueryController) HandleMetadataQuery(ctx *gin.Context) {
+
+	var req metadataQueryRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+
+		Logger.Error("Error parsing request", zap.Error(err))
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid JSON request: %v", err)})
+
+		return
+
+	}
+
+	if req.From > req.To {
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time range, from must be less than or equal to to"})
+
+		return
+	}
+
+	if req.Metadata != "objects" && req.Metadata != "series" {
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid metadata. Its must be either 'objects' or 'series'"})
+
+		return
+
+	}
+
+	if !validPrecision(req.Precision) {
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid precision. Its must be either 's' or 'ms'"})
+
+		return
+
+	}
+
+	queryController.query(ctx, Query{
+
+		Metadata: req.Metadata,
+
+		From: req.From,
+
+		To: req.To,
+
+		Precision: req.Precision,
+
+		CounterId: req.CounterId,
+
+		Objects: req.ObjectIds,
+	})
+
+}
+
+func (queryController *QueryController) GetCounters(ctx *gin.Context) {
+
+	queryController.query(ctx, Query{
+
+		Metadata: "counters",
+	})
+
+}
+
+// RegisterContinuousQuery registers a continuous query, its results are queried through its derived counter_id.
+func (queryController *QueryController) RegisterContinuousQuery(ctx *gin.Context) {
+
+	var req continuousQueryRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+
+		Logger.Error("Error parsing request", zap.Error(err))
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid JSON request: %v", err)})
+
+		return
+
+	}
+
+	switch req.Aggregation {
+
+	case "avg", "sum", "min", "max", "count", "uptime":
+
+	default:
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid aggregation function. Its must be either 'avg', 'sum', 'min', 'max', 'count' or 'uptime'"})
+
+		return
+
+	}
+
+	queryController.query(ctx, Query{
+
+		RegisterContinuousQuery: &ContinuousQuery{
+
+			Name: req.Name,
+
+			SourceCounterId: req.SourceCounterId,
+
+			// Resolved by the reportDB's object registry, like the objects of a query
+			Objects: req.ObjectIds,
+
+			Aggregation: req.Aggregation,
+
+			Interval: req.Interval,
+
+			CounterId: req.CounterId,
+		},
+	})
+
+}
+
+func (queryController *QueryController) GetContinuousQueries(ctx *gin.Context) {
+
+	queryController.query(ctx, Query{
+
+		ListContinuousQueries: true,
+	})
+
+}
+
+// RegisterCounters adds or updates the reportDB's counters, a data type change is refused for a counter having data.
+func (queryController *QueryController) RegisterCounters(ctx *gin.Context) {
+
+	var req registerCountersRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+
+		Logger.Error("Error parsing request", zap.Error(err))
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid JSON request: %v", err)})
+
+		return
+
+	}
+
+	queryController.query(ctx, Query{
+
+		RegisterCounters: req.Counters,
+	})
+
+}
+
+// ReloadCounters makes the reportDB reload its counters file.
+func (queryController *QueryController) ReloadCounters(ctx *gin.Context) {
+
+	queryController.query(ctx, Query{
+
+		ReloadCounters: true,
+	})
+
+}
+
+func validPriority(priority string) bool {
+
+	switch priority {
+
+	case "", "interactive", "batch":
+
+		return true
+
+	default:
+
+		return false
+
+	}
+
+}
+
+func validPrecision(precision string) bool {
+
+	switch precision {
+
+	case "", "s", "ms":
+
+		return true
+
+	default:
+
+		return false
+
+	}
+
+}
+
+// validAggregation reports whether the aggregation is supported by the datastore, percentiles being named like p99.
+func validAggregation(aggregation string) bool {
+
+	switch aggregation {
+
+	case "avg", "sum", "min", "max", "count", "uptime":
+
+		return true
+
+	}
+
+	if !strings.HasPrefix(aggregation, "p") {
+
+		return false
+
+	}
+
+	percentile, err := strconv.ParseFloat(aggregation[1:], 64)
+
+	return err == nil && percentile >= 0 && percentile <= 100
+
+}
@@ -1,14 +1,18 @@
 package db
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	zmq "github.com/pebbe/zmq4"
 	"github.com/vmihailenco/msgpack/v5"
 	"go.uber.org/zap"
+	"maps"
 	. "nms-backend/utils"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -17,42 +21,354 @@ var (
 	ErrServerShutdown = errors.New("server got shutdown")
 )
 
+// ReportDBError is an error reported by the reportDB for a query it refused, like a text statement with a syntax error.
+type ReportDBError struct {
+	Message string
+}
+
+func (err *ReportDBError) Error() string {
+
+	return err.Message
+
+}
+
 type Query struct {
 	QueryId uint64 `json:"query_id" msgpack:"query_id"`
 
-	From uint32 `json:"from" msgpack:"from"`
+	Statement string `json:"statement" msgpack:"statement"`
+
+	From uint64 `json:"from" msgpack:"from"`
+
+	To uint64 `json:"to" msgpack:"to"`
 
-	To uint32 `json:"to" msgpack:"to"`
+	// Precision of From, To, Interval and the result timestamps, either s (default) or ms
+	Precision string `json:"precision" msgpack:"precision"`
 
 	ObjectIds []uint32 `json:"object_ids" msgpack:"object_ids"`
 
+	Objects []string `json:"objects" msgpack:"objects"`
+
 	CounterId uint16 `json:"counter_id" msgpack:"counter_id"`
 
+	CounterIds []uint16 `json:"counter_ids" msgpack:"counter_ids"`
+
+	Expressions []Expression `json:"expressions" msgpack:"expressions"`
+
 	ObjectWiseAggregation string `json:"object_wise_aggregation" msgpack:"object_wise_aggregation"`
 
 	TimestampAggregation string `json:"timestamp_aggregation" msgpack:"timestamp_aggregation"`
 
+	Interval uint64 `json:"interval" msgpack:"interval"`
+
+	RankAggregation string `json:"rank_aggregation" msgpack:"rank_aggregation"`
+
+	RankOrder string `json:"rank_order" msgpack:"rank_order"`
+
+	RankLimit uint32 `json:"rank_limit" msgpack:"rank_limit"`
+
+	RankWithSeries bool `json:"rank_with_series" msgpack:"rank_with_series"`
+
+	Cancel bool `json:"cancel" msgpack:"cancel"`
+
+	Timeout uint32 `json:"timeout" msgpack:"timeout"`
+
+	Priority string `json:"priority" msgpack:"priority"`
+
+	Limit uint32 `json:"limit" msgpack:"limit"`
+
+	Offset uint32 `json:"offset" msgpack:"offset"`
+
+	Explain bool `json:"explain" msgpack:"explain"`
+
+	StringMode string `json:"string_mode" msgpack:"string_mode"`
+
+	Latest bool `json:"latest" msgpack:"latest"`
+
+	Metadata string `json:"metadata" msgpack:"metadata"`
+
+	RegisterContinuousQuery *ContinuousQuery `json:"register_continuous_query" msgpack:"register_continuous_query"`
+
+	ListContinuousQueries bool `json:"list_continuous_queries" msgpack:"list_continuous_queries"`
+
+	RegisterCounters []CounterMetadata `json:"register_counters" msgpack:"register_counters"`
+
+	ReloadCounters bool `json:"reload_counters" msgpack:"reload_counters"`
+
+	Instances []string `json:"instances" msgpack:"instances"`
+
+	InstanceAggregation string `json:"instance_aggregation" msgpack:"instance_aggregation"`
+}
+
+// ContinuousQuery is evaluated by the reportDB every Interval seconds into the derived counter CounterId.
+type ContinuousQuery struct {
+	Name string `json:"name" msgpack:"name"`
+
+	SourceCounterId uint16 `json:"source_counter_id" msgpack:"source_counter_id"`
+
+	ObjectIds []uint32 `json:"object_ids" msgpack:"object_ids"`
+
+	Objects []string `json:"objects,omitempty" msgpack:"objects,omitempty"`
+
+	Aggregation string `json:"aggregation" msgpack:"aggregation"`
+
 	Interval uint32 `json:"interval" msgpack:"interval"`
+
+	CounterId uint16 `json:"counter_id" msgpack:"counter_id"`
+
+	Watermark uint64 `json:"watermark" msgpack:"watermark"`
+}
+
+type DistinctValue struct {
+	Value string `json:"value" msgpack:"value"`
+
+	Count uint32 `json:"count" msgpack:"count"`
+}
+
+type ValueChange struct {
+	Timestamp uint64 `json:"timestamp" msgpack:"timestamp"`
+
+	Old string `json:"old" msgpack:"old"`
+
+	New string `json:"new" msgpack:"new"`
+}
+
+// SeriesInstance is the object and instance of an instance series key.
+type SeriesInstance struct {
+	ObjectId uint32 `json:"object_id" msgpack:"object_id"`
+
+	Instance string `json:"instance" msgpack:"instance"`
+}
+
+type SeriesMetadata struct {
+	ObjectId uint32 `json:"object_id" msgpack:"object_id"`
+
+	Instance string `json:"instance,omitempty" msgpack:"instance,omitempty"`
+
+	FirstTimestamp uint64 `json:"first_timestamp" msgpack:"first_timestamp"`
+
+	LastTimestamp uint64 `json:"last_timestamp" msgpack:"last_timestamp"`
+
+	Points uint32 `json:"points" msgpack:"points"`
+}
+
+type seriesMetadataResponse struct {
+	ObjectId string `json:"object_id"`
+
+	Instance string `json:"instance,omitempty"`
+
+	FirstTimestamp uint64 `json:"first_timestamp"`
+
+	LastTimestamp uint64 `json:"last_timestamp"`
+
+	Points uint32 `json:"points"`
+}
+
+type CounterMetadata struct {
+	CounterId uint16 `json:"counter_id" msgpack:"counter_id"`
+
+	DataType string `json:"data_type" msgpack:"data_type"`
+
+	Precision string `json:"precision,omitempty" msgpack:"precision,omitempty"`
+}
+
+type continuousQueryResponse struct {
+	Name string `json:"name"`
+
+	SourceCounterId uint16 `json:"source_counter_id"`
+
+	ObjectIds []string `json:"object_ids"`
+
+	Aggregation string `json:"aggregation"`
+
+	Interval uint32 `json:"interval"`
+
+	CounterId uint16 `json:"counter_id"`
+
+	Watermark uint64 `json:"watermark"`
+}
+
+type Expression struct {
+	Name string `json:"name" msgpack:"name"`
+
+	Expression string `json:"expression" msgpack:"expression"`
 }
 
 type DataPoint struct {
-	Timestamp uint32 `json:"timestamp" msgpack:"timestamp"`
+	Timestamp uint64 `json:"timestamp" msgpack:"timestamp"`
 
 	Value interface{} `json:"value" msgpack:"value"`
 }
 
+type RankedObject struct {
+	ObjectId uint32 `json:"object_id" msgpack:"object_id"`
+
+	Value float64 `json:"value" msgpack:"value"`
+}
+
+type rankedObjectResponse struct {
+	ObjectId string `json:"object_id"`
+
+	Instance string `json:"instance,omitempty"`
+
+	Value float64 `json:"value"`
+
+	Data []DataPoint `json:"data,omitempty"`
+}
+
 type Result struct {
 	QueryId uint64 `json:"query_id" msgpack:"query_id"`
 
 	Data map[uint32][]DataPoint `json:"data" msgpack:"data"`
 
+	Ranking []RankedObject `json:"ranking" msgpack:"ranking"`
+
+	Series map[string]map[uint32][]DataPoint `json:"series" msgpack:"series"`
+
+	TotalObjects uint32 `json:"total_objects" msgpack:"total_objects"`
+
+	NextOffset uint32 `json:"next_offset" msgpack:"next_offset"`
+
 	Error string `json:"error" msgpack:"error"`
+
+	Stats *QueryStats `json:"stats,omitempty" msgpack:"stats,omitempty"`
+
+	ContinuousQueries []ContinuousQuery `json:"continuous_queries,omitempty" msgpack:"continuous_queries,omitempty"`
+
+	DistinctValues map[uint32][]DistinctValue `json:"distinct_values,omitempty" msgpack:"distinct_values,omitempty"`
+
+	Changes map[uint32][]ValueChange `json:"changes,omitempty" msgpack:"changes,omitempty"`
+
+	Objects []uint32 `json:"objects,omitempty" msgpack:"objects,omitempty"`
+
+	SeriesMetadata []SeriesMetadata `json:"series_metadata,omitempty" msgpack:"series_metadata,omitempty"`
+
+	Counters []CounterMetadata `json:"counters,omitempty" msgpack:"counters,omitempty"`
+
+	Instances map[uint32]SeriesInstance `json:"instances,omitempty" msgpack:"instances,omitempty"`
+
+	ObjectIdentifiers map[uint32]string `json:"object_identifiers,omitempty" msgpack:"object_identifiers,omitempty"`
+
+	Sequence uint32 `json:"sequence" msgpack:"sequence"`
+
+	Final bool `json:"final" msgpack:"final"`
+}
+
+// QueryStats describes how the reportDB executed a query, times are in microseconds.
+type QueryStats struct {
+	DaysScanned uint32 `json:"days_scanned" msgpack:"days_scanned"`
+
+	StoragesLoaded uint32 `json:"storages_loaded" msgpack:"storages_loaded"`
+
+	StoragesPooled uint32 `json:"storages_pooled" msgpack:"storages_pooled"`
+
+	StoragesMissing uint32 `json:"storages_missing" msgpack:"storages_missing"`
+
+	ResultCacheHits uint32 `json:"result_cache_hits" msgpack:"result_cache_hits"`
+
+	ResultCacheMisses uint32 `json:"result_cache_misses" msgpack:"result_cache_misses"`
+
+	CacheHits uint32 `json:"cache_hits" msgpack:"cache_hits"`
+
+	CacheMisses uint32 `json:"cache_misses" msgpack:"cache_misses"`
+
+	BytesRead uint64 `json:"bytes_read" msgpack:"bytes_read"`
+
+	PointsDecoded uint64 `json:"points_decoded" msgpack:"points_decoded"`
+
+	ReadTime int64 `json:"read_time" msgpack:"read_time"`
+
+	ObjectWiseAggregationTime int64 `json:"object_wise_aggregation_time" msgpack:"object_wise_aggregation_time"`
+
+	TimestampAggregationTime int64 `json:"timestamp_aggregation_time" msgpack:"timestamp_aggregation_time"`
+
+	TotalTime int64 `json:"total_time" msgpack:"total_time"`
+}
+
+type paginatedResponse struct {
+	Data interface{} `json:"data"`
+
+	TotalObjects uint32 `json:"total_objects"`
+
+	NextOffset uint32 `json:"next_offset"`
+
+	Stats *QueryStats `json:"stats,omitempty"`
+}
+
+type explainedResponse struct {
+	Data interface{} `json:"data"`
+
+	Stats *QueryStats `json:"stats"`
+}
+
+// resultReceiver collects the streamed result chunks of a single query. The chunks are queued, so that the receiver
+// routine never waits for a query and the chunks of the other queries are not held behind.
+type resultReceiver struct {
+	chunks [][]byte
+
+	// closed is set on shutdown, the query's remaining chunks will never arrive
+	closed bool
+
+	// ready signals the query that chunks were queued, or the receiver closed
+	ready chan struct{}
+
+	lock sync.Mutex
+}
+
+func (receiver *resultReceiver) push(chunkBytes []byte) {
+
+	receiver.lock.Lock()
+
+	receiver.chunks = append(receiver.chunks, chunkBytes)
+
+	receiver.lock.Unlock()
+
+	receiver.signal()
+
+}
+
+func (receiver *resultReceiver) close() {
+
+	receiver.lock.Lock()
+
+	receiver.closed = true
+
+	receiver.lock.Unlock()
+
+	receiver.signal()
+
+}
+
+func (receiver *resultReceiver) signal() {
+
+	select {
+
+	case receiver.ready <- struct{}{}:
+
+	default:
+
+	}
+
+}
+
+// take returns the queued chunks, and whether the receiver was closed.
+func (receiver *resultReceiver) take() ([][]byte, bool) {
+
+	receiver.lock.Lock()
+
+	defer receiver.lock.Unlock()
+
+	chunks := receiver.chunks
+
+	receiver.chunks = nil
+
+	return chunks, receiver.closed
+
 }
 
 type ReportDBClient struct {
 	context *zmq.Context
 
-	receiverWaitChannels map[uint64]chan []byte
+	receiverWaitChannels map[uint64]*resultReceiver
 
 	lock sync.RWMutex
 
@@ -75,7 +391,7 @@ func InitReportDBClient() (*ReportDBClient, error) {
 
 	}
 
-	receiverWaitChannels := make(map[uint64]chan []byte)
+	receiverWaitChannels := make(map[uint64]*resultReceiver)
 
 	querySendChannel := make(chan []byte, QuerySendChannelSize)
 
@@ -102,33 +418,33 @@ func InitReportDBClient() (*ReportDBClient, error) {
 
 }
 
-func (db *ReportDBClient) putReceiverChannel(queryId uint64, channel chan []byte) {
+func (db *ReportDBClient) putReceiverChannel(queryId uint64, receiver *resultReceiver) {
 
 	db.lock.Lock()
 
 	defer db.lock.Unlock()
 
-	db.receiverWaitChannels[queryId] = channel
+	db.receiverWaitChannels[queryId] = receiver
 
 }
 
-func (db *ReportDBClient) getReceiverChannel(queryId uint64) chan []byte {
+func (db *ReportDBClient) getReceiverChannel(queryId uint64) *resultReceiver {
 
 	db.lock.RLock()
 
 	defer db.lock.RUnlock()
 
-	if channel, ok := db.receiverWaitChannels[queryId]; ok {
+	return db.receiverWaitChannels[queryId]
 
-		delete(db.receiverWaitChannels, queryId)
+}
 
-		return channel
+func (db *ReportDBClient) removeReceiverChannel(queryId uint64) {
 
-	} else {
+	db.lock.Lock()
 
-		return nil
+	defer db.lock.Unlock()
 
-	}
+	delete(db.receiverWaitChannels, queryId)
 
 }
 
@@ -138,17 +454,47 @@ func (db *ReportDBClient) closeReceivers() {
 
 	defer db.lock.Unlock()
 
-	for _, channel := range db.receiverWaitChannels {
+	for queryId, receiver := range db.receiverWaitChannels {
+
+		receiver.close()
 
-		close(channel)
+		delete(db.receiverWaitChannels, queryId)
 
 	}
 
 }
 
+// Status the reportDB replies to every batch of poll data, along with the batch's id
+const (
+	pollStatusRejected = "rejected"
+
+	pollStatusInvalid = "invalid"
+)
+
+const (
+	// Batches rejected by the reportDB, like while its disk space is low, are sent again every pollRetryInterval
+	pollRetryInterval = 10 * time.Second
+
+	// Batches kept while awaiting their status or a retry at most, the oldest are dropped beyond
+	pollRetryBatches = 10000
+
+	// Statuses are read between the sends, at least every pollStatusInterval
+	pollStatusInterval = time.Second
+)
+
+// pollBatches keeps the batches of poll data sent to the reportDB till their status, and the rejected ones till they
+// are sent again.
+type pollBatches struct {
+	lastBatchId uint64
+
+	awaiting map[uint64][]byte
+
+	rejected [][]byte
+}
+
 func pollSenderRoutine(context *zmq.Context, pollDataChannel chan []byte) {
 
-	socket, err := context.NewSocket(zmq.PUSH)
+	socket, err := context.NewSocket(zmq.DEALER)
 
 	if err != nil {
 
@@ -183,157 +529,569 @@ func pollSenderRoutine(context *zmq.Context, pollDataChannel chan []byte) {
 
 	}
 
-	for data := range pollDataChannel {
-
-		_, err := socket.SendBytes(data, 0)
+	batches := pollBatches{awaiting: make(map[uint64][]byte)}
 
-		if err != nil {
+	retryTicker := time.NewTicker(pollRetryInterval)
 
-			Logger.Error("Error sending polled data", zap.Error(err))
+	defer retryTicker.Stop()
 
-		}
+	statusTicker := time.NewTicker(pollStatusInterval)
 
-	}
+	defer statusTicker.Stop()
 
-	Logger.Info("Poll sender routine closed")
-}
+	for {
 
-func querySenderRoutine(context *zmq.Context, queryChannel chan []byte) {
+		select {
 
-	socket, err := context.NewSocket(zmq.PUSH)
+		case data, ok := <-pollDataChannel:
 
-	if err != nil {
+			if !ok {
 
-		Logger.Error("Error creating zmq socket", zap.Error(err))
+				Logger.Info("Poll sender routine closed", zap.Int("unsentBatches", len(batches.awaiting)+len(batches.rejected)))
 
-		return
+				return
 
-	}
+			}
 
-	if err = socket.SetLinger(0); err != nil {
+			batches.send(socket, data)
 
-		Logger.Error("Error setting linger", zap.Error(err))
+		case <-retryTicker.C:
 
-		return
-	}
+			rejected := batches.rejected
 
-	defer func(socket *zmq.Socket) {
+			batches.rejected = nil
 
-		if err := socket.Close(); err != nil {
+			for _, data := range rejected {
 
-			Logger.Error("Error closing query sender socket", zap.Error(err))
+				batches.send(socket, data)
 
-		}
+			}
 
-	}(socket)
+		case <-statusTicker.C:
 
-	err = socket.Connect("tcp://" + ReportDBHost + ":" + ReportDBQueryPort)
+		}
 
-	for query := range queryChannel {
+		batches.receiveStatuses(socket)
 
-		_, err := socket.SendBytes(query, 0)
+	}
 
-		if err != nil {
+}
 
-			Logger.Error("Error sending query", zap.Error(err))
+// send sends the batch under a new id without waiting, the batches the socket can't take now are retried.
+func (batches *pollBatches) send(socket *zmq.Socket, data []byte) {
 
-		}
+	batches.lastBatchId++
 
-	}
+	batchId := binary.LittleEndian.AppendUint64(nil, batches.lastBatchId)
 
-	Logger.Info("Query sender routine closed")
-}
+	if _, err := socket.SendMessageDontwait(batchId, data); err != nil {
 
-func resultReceiverRoutine(context *zmq.Context, dbClient *ReportDBClient, shutdown chan struct{}) {
+		Logger.Warn("Error sending polled data, retrying later", zap.Error(err))
 
-	socket, err := context.NewSocket(zmq.PULL)
+		batches.rejected = append(batches.rejected, data)
 
-	if err != nil {
+	} else {
 
-		Logger.Error("Error creating zmq socket", zap.Error(err))
+		batches.awaiting[batches.lastBatchId] = data
 
-		return
 	}
 
-	err = socket.Connect("tcp://" + ReportDBHost + ":" + ReportDBQueryResultPort)
-
-	if err != nil {
+	batches.trim()
 
-		Logger.Error("Error connecting the socket", zap.Error(err))
+}
 
-	}
+// receiveStatuses reads the statuses replied by the reportDB, keeping the rejected batches for a retry.
+func (batches *pollBatches) receiveStatuses(socket *zmq.Socket) {
 
 	for {
-		select {
 
-		case <-shutdown:
+		frames, err := socket.RecvMessageBytes(zmq.DONTWAIT)
 
-			if err = socket.Close(); err != nil {
+		if err != nil {
 
-				Logger.Error("Error closing result receiver socket", zap.Error(err))
+			if zmq.AsErrno(err) != zmq.Errno(syscall.EAGAIN) && !errors.Is(zmq.AsErrno(err), zmq.ETERM) {
+
+				Logger.Error("Error receiving poll data status", zap.Error(err))
 
-				return
 			}
 
-			// Acknowledge
-			shutdown <- struct{}{}
+			return
 
-			Logger.Info("Result receiver routine closed")
+		}
 
-			return
+		if len(frames) != 2 || len(frames[0]) != 8 {
 
-		default:
+			Logger.Error("malformed poll data status", zap.Int("frames", len(frames)))
 
-			resultBytes, err := socket.RecvBytes(0)
+			continue
 
-			if err != nil {
+		}
 
-				if errors.Is(zmq.AsErrno(err), zmq.ETERM) {
+		batchId := binary.LittleEndian.Uint64(frames[0])
 
-					Logger.Info("Result receiver's ZMQ-Context terminated, closing the socket")
+		data, ok := batches.awaiting[batchId]
 
-				} else {
+		if !ok {
 
-					Logger.Error("error receiving query ", zap.Error(err))
+			continue
 
-				}
+		}
 
-				continue
+		delete(batches.awaiting, batchId)
 
-			}
+		switch string(frames[1]) {
 
-			queryId := binary.LittleEndian.Uint64(resultBytes[:8])
+		case pollStatusRejected:
 
-			receiverChannel := dbClient.getReceiverChannel(queryId)
+			Logger.Warn("Polled data rejected by the reportDB, retrying later", zap.Uint64("batchId", batchId))
 
-			receiverChannel <- resultBytes[8:]
+			batches.rejected = append(batches.rejected, data)
 
-			close(receiverChannel)
+		case pollStatusInvalid:
+
+			Logger.Error("Polled data refused by the reportDB as invalid", zap.Uint64("batchId", batchId))
 
 		}
+
+	}
+
+}
+
+// trim drops the oldest batches beyond pollRetryBatches, the rejected ones first.
+func (batches *pollBatches) trim() {
+
+	dropped := 0
+
+	for ; len(batches.awaiting)+len(batches.rejected) > pollRetryBatches; dropped++ {
+
+		if len(batches.rejected) > 0 {
+
+			batches.rejected = batches.rejected[1:]
+
+			continue
+
+		}
+
+		delete(batches.awaiting, slices.Min(slices.Collect(maps.Keys(batches.awaiting))))
+
+	}
+
+	if dropped > 0 {
+
+		Logger.Error("Dropped polled data kept for the reportDB", zap.Int("batches", dropped))
+
 	}
 
 }
 
-func parseResponse(data map[uint32][]DataPoint) interface{} {
+func querySenderRoutine(context *zmq.Context, queryChannel chan []byte) {
+
+	socket, err := context.NewSocket(zmq.PUSH)
+
+	if err != nil {
+
+		Logger.Error("Error creating zmq socket", zap.Error(err))
+
+		return
+
+	}
+
+	if err = socket.SetLinger(0); err != nil {
+
+		Logger.Error("Error setting linger", zap.Error(err))
+
+		return
+	}
+
+	defer func(socket *zmq.Socket) {
+
+		if err := socket.Close(); err != nil {
+
+			Logger.Error("Error closing query sender socket", zap.Error(err))
+
+		}
+
+	}(socket)
+
+	err = socket.Connect("tcp://" + ReportDBHost + ":" + ReportDBQueryPort)
+
+	for query := range queryChannel {
+
+		_, err := socket.SendBytes(query, 0)
+
+		if err != nil {
+
+			Logger.Error("Error sending query", zap.Error(err))
+
+		}
+
+	}
+
+	Logger.Info("Query sender routine closed")
+}
+
+func resultReceiverRoutine(context *zmq.Context, dbClient *ReportDBClient, shutdown chan struct{}) {
+
+	socket, err := context.NewSocket(zmq.PULL)
+
+	if err != nil {
+
+		Logger.Error("Error creating zmq socket", zap.Error(err))
+
+		return
+	}
+
+	err = socket.Connect("tcp://" + ReportDBHost + ":" + ReportDBQueryResultPort)
+
+	if err != nil {
+
+		Logger.Error("Error connecting the socket", zap.Error(err))
+
+	}
+
+	for {
+		select {
+
+		case <-shutdown:
+
+			if err = socket.Close(); err != nil {
+
+				Logger.Error("Error closing result receiver socket", zap.Error(err))
+
+				return
+			}
+
+			// Acknowledge
+			shutdown <- struct{}{}
+
+			Logger.Info("Result receiver routine closed")
+
+			return
+
+		default:
+
+			resultBytes, err := socket.RecvBytes(0)
+
+			if err != nil {
+
+				if errors.Is(zmq.AsErrno(err), zmq.ETERM) {
+
+					Logger.Info("Result receiver's ZMQ-Context terminated, closing the socket")
+
+				} else {
+
+					Logger.Error("error receiving query ", zap.Error(err))
+
+				}
+
+				continue
+
+			}
+
+			// Every chunk is prefixed by the queryId and the final chunk marker
+			if len(resultBytes) < 9 {
+
+				Logger.Error("malformed query result chunk", zap.Int("length", len(resultBytes)))
+
+				continue
+
+			}
+
+			queryId := binary.LittleEndian.Uint64(resultBytes[:8])
+
+			final := resultBytes[8] == 1
+
+			receiver := dbClient.getReceiverChannel(queryId)
+
+			if receiver == nil {
+
+				// Query already timed out on our side
+				Logger.Debug("dropping result chunk of an expired query", zap.Uint64("queryId", queryId))
+
+				continue
+
+			}
+
+			receiver.push(resultBytes[9:])
+
+			if final {
+
+				dbClient.removeReceiverChannel(queryId)
+
+			}
+
+		}
+	}
+
+}
+
+// parseObjectValues keys the per object values by the object's identifier. Instance series are keyed by the object's
+// identifier then by instance, the object's own series being the "" instance, or by instance alone once aggregated
+// across objects.
+func parseObjectValues[V any](objectValues map[uint32]V, result Result) interface{} {
+
+	if len(result.Instances) == 0 {
+
+		response := make(map[string]V, len(objectValues))
+
+		for objectId, values := range objectValues {
+
+			response[result.object(objectId)] = values
+
+		}
+
+		return response
+
+	}
+
+	acrossObjects := true
+
+	for seriesKey := range objectValues {
+
+		if result.seriesInstance(seriesKey).ObjectId != 0 {
+
+			acrossObjects = false
+
+			break
+
+		}
+
+	}
+
+	if acrossObjects {
+
+		response := make(map[string]V, len(objectValues))
+
+		for seriesKey, values := range objectValues {
+
+			response[result.seriesInstance(seriesKey).Instance] = values
+
+		}
+
+		return response
+
+	}
+
+	response := make(map[string]map[string]V)
+
+	for seriesKey, values := range objectValues {
+
+		series := result.seriesInstance(seriesKey)
+
+		object := result.object(series.ObjectId)
+
+		if response[object] == nil {
+
+			response[object] = make(map[string]V)
+
+		}
+
+		response[object][series.Instance] = values
+
+	}
+
+	return response
+
+}
+
+// seriesInstance returns the object and instance of the series key, keys not describing an instance are objectIds.
+func (result Result) seriesInstance(seriesKey uint32) SeriesInstance {
+
+	if series, ok := result.Instances[seriesKey]; ok {
+
+		return series
+
+	}
+
+	return SeriesInstance{ObjectId: seriesKey}
+
+}
+
+// object returns the identifier of the objectId, the IPv4 address for the objectIds not assigned by the reportDB's
+// object registry.
+func (result Result) object(objectId uint32) string {
+
+	if object, ok := result.ObjectIdentifiers[objectId]; ok {
+
+		return object
+
+	}
+
+	return ConvertNumericToIp(objectId)
+
+}
+
+func parseMetadata(result Result) interface{} {
+
+	if result.Counters != nil {
+
+		return result.Counters
+
+	}
+
+	if result.SeriesMetadata != nil {
+
+		series := make([]seriesMetadataResponse, len(result.SeriesMetadata))
+
+		for index, metadata := range result.SeriesMetadata {
+
+			series[index] = seriesMetadataResponse{
+
+				ObjectId: result.object(metadata.ObjectId),
+
+				Instance: metadata.Instance,
+
+				FirstTimestamp: metadata.FirstTimestamp,
+
+				LastTimestamp: metadata.LastTimestamp,
+
+				Points: metadata.Points,
+			}
+
+		}
+
+		return series
+
+	}
+
+	objects := make([]string, len(result.Objects))
+
+	for index, objectId := range result.Objects {
+
+		objects[index] = result.object(objectId)
+
+	}
 
-	if result, exist := data[0]; exist {
+	return objects
+
+}
+
+func parseContinuousQueries(result Result) []continuousQueryResponse {
+
+	response := make([]continuousQueryResponse, len(result.ContinuousQueries))
+
+	for index, continuousQuery := range result.ContinuousQueries {
+
+		objectIds := make([]string, len(continuousQuery.ObjectIds))
+
+		for objectIndex, objectId := range continuousQuery.ObjectIds {
+
+			objectIds[objectIndex] = result.object(objectId)
+
+		}
+
+		response[index] = continuousQueryResponse{
+
+			Name: continuousQuery.Name,
+
+			SourceCounterId: continuousQuery.SourceCounterId,
+
+			ObjectIds: objectIds,
+
+			Aggregation: continuousQuery.Aggregation,
+
+			Interval: continuousQuery.Interval,
+
+			CounterId: continuousQuery.CounterId,
+
+			Watermark: continuousQuery.Watermark,
+		}
+
+	}
+
+	return response
+
+}
+
+func parseResponse(result Result) interface{} {
+
+	if result.DistinctValues != nil {
+
+		return parseObjectValues(result.DistinctValues, result)
+
+	}
+
+	if result.Changes != nil {
+
+		return parseObjectValues(result.Changes, result)
+
+	}
+
+	if result.Series != nil {
+
+		// multi counter query, parse every named series on its own
+
+		response := make(map[string]interface{}, len(result.Series))
+
+		for name, data := range result.Series {
+
+			response[name] = parseData(data, result)
+
+		}
+
+		return response
+
+	}
+
+	if result.Ranking != nil {
+
+		// ranking query, return the ordered objects along with their series if asked for
+
+		ranking := make([]rankedObjectResponse, len(result.Ranking))
+
+		for index, rankedObject := range result.Ranking {
+
+			series := result.seriesInstance(rankedObject.ObjectId)
+
+			ranking[index] = rankedObjectResponse{
+
+				ObjectId: result.object(series.ObjectId),
+
+				Instance: series.Instance,
+
+				Value: rankedObject.Value,
+
+				Data: result.Data[rankedObject.ObjectId],
+			}
+
+		}
+
+		return ranking
+
+	}
+
+	return parseData(result.Data, result)
+
+}
+
+// parseData parses the data of the result, a single counter's or one of its named series.
+func parseData(data map[uint32][]DataPoint, result Result) interface{} {
+
+	if len(result.Instances) > 0 {
+
+		// query selecting instances, group the series by object and instance
+
+		return parseObjectValues(data, result)
+
+	}
+
+	if points, exist := data[0]; exist {
 
 		// result of query without groupBy
 		// hence return the single result array.
 
-		return result
+		return points
 
 	} else {
 
 		// query with groupBy over objectIds
-		// convert objectIds to string IPs and return the map.
+		// convert objectIds to their identifiers and return the map.
 
 		response := make(map[string][]DataPoint)
 
-		for objectId, result := range data {
+		for objectId, points := range data {
 
-			response[ConvertNumericToIp(objectId)] = result
+			response[result.object(objectId)] = points
 
 		}
 
@@ -342,75 +1100,308 @@ func parseResponse(data map[uint32][]DataPoint) interface{} {
 
 }
 
-func (db *ReportDBClient) Query(from, to, interval uint32, objectIps []string, counterId uint16, objectWiseAggregation, timestampAggregation string) (interface{}, error) {
+// Query assigns a new queryId to the query, sends it to the reportDB and waits for its result.
+// The query is cancelled on the reportDB if ctx is done or the wait times out.
+func (db *ReportDBClient) Query(ctx context.Context, query Query) (interface{}, error) {
+
+	query.QueryId = atomic.AddUint64(&db.queryId, 1)
+
+	queryBytes, err := msgpack.Marshal(query)
+
+	if err != nil {
+
+		Logger.Error("Error serializing query", zap.Error(err))
+
+		return nil, err
+
+	}
+
+	receiver := &resultReceiver{
+
+		chunks: make([][]byte, 0, ResultChunkBufferSize),
+
+		ready: make(chan struct{}, 1),
+	}
+
+	db.putReceiverChannel(query.QueryId, receiver)
+
+	defer db.removeReceiverChannel(query.QueryId)
+
+	// Send query
+	db.queryChannel <- queryBytes
+
+	// Receive the result chunks, until the final one and all its predecessors arrive
+	chunks := make(map[uint32]Result)
+
+	totalChunks := 0
+
+	// Wait a little longer than the query's own timeout, so that its timeout result reaches us
+	waitTime := 40 * time.Second
+
+	if query.Timeout > 0 {
+
+		waitTime = time.Duration(query.Timeout)*time.Second + 5*time.Second
+
+	}
+
+	timeout := time.NewTimer(waitTime)
+
+	defer timeout.Stop()
+
+	for totalChunks == 0 || len(chunks) < totalChunks {
+
+		select {
+
+		case <-timeout.C:
+
+			Logger.Info("Query timeout", zap.Uint64("queryId", query.QueryId))
+
+			db.cancelQuery(query.QueryId, query.Timeout)
+
+			return nil, ErrQueryTimedOut
+
+		case <-ctx.Done():
+
+			Logger.Info("Query cancelled by client", zap.Uint64("queryId", query.QueryId))
+
+			db.cancelQuery(query.QueryId, query.Timeout)
+
+			return nil, ctx.Err()
+
+		case <-receiver.ready:
+
+			queuedChunks, closed := receiver.take()
+
+			for _, chunkBytes := range queuedChunks {
+
+				var chunk Result
+
+				if err = msgpack.Unmarshal(chunkBytes, &chunk); err != nil {
+
+					Logger.Info("Error deserializing query result", zap.Error(err))
+
+					return nil, err
+				}
+
+				chunks[chunk.Sequence] = chunk
+
+				if chunk.Final {
+
+					totalChunks = int(chunk.Sequence) + 1
+
+				}
+
+			}
+
+			if closed && (totalChunks == 0 || len(chunks) < totalChunks) {
+
+				// Receiver closed due to shutdown
+				return nil, ErrServerShutdown
+
+			}
+
+		}
+
+	}
+
+	result := assembleResult(chunks, totalChunks)
+
+	if result.Error == ErrQueryTimedOut.Error() {
+
+		return nil, ErrQueryTimedOut
+
+	}
+
+	if result.Error != "" {
+
+		return nil, &ReportDBError{result.Error}
+
+	}
+
+	if query.Metadata != "" {
+
+		return parseMetadata(result), nil
+
+	}
+
+	if len(query.RegisterCounters) > 0 || query.ReloadCounters {
 
-	queryId := atomic.AddUint64(&db.queryId, 1)
+		return result.Counters, nil
 
-	objectIds := make([]uint32, len(objectIps))
+	}
 
-	for index, ip := range objectIps {
+	if query.RegisterContinuousQuery != nil || query.ListContinuousQueries {
 
-		objectIds[index] = ConvertIpToNumeric(ip)
+		return parseContinuousQueries(result), nil
 
 	}
 
-	queryBytes, err := msgpack.Marshal(Query{
-		QueryId:               queryId,
-		From:                  from,
-		To:                    to,
-		ObjectIds:             objectIds,
-		CounterId:             counterId,
-		ObjectWiseAggregation: objectWiseAggregation,
-		TimestampAggregation:  timestampAggregation,
-		Interval:              interval,
+	if query.Limit > 0 || query.Offset > 0 {
+
+		return paginatedResponse{
+
+			Data: parseResponse(result),
+
+			TotalObjects: result.TotalObjects,
+
+			NextOffset: result.NextOffset,
+
+			Stats: result.Stats,
+		}, nil
+
+	}
+
+	if query.Explain {
+
+		return explainedResponse{
+
+			Data: parseResponse(result),
+
+			Stats: result.Stats,
+		}, nil
+
+	}
+
+	return parseResponse(result), nil
+}
+
+// cancelQuery asks the reportDB to stop processing the query, timeout being the query's own so that a cancellation
+// arriving before the query is remembered as long as the query could run.
+func (db *ReportDBClient) cancelQuery(queryId uint64, timeout uint32) {
+
+	defer func() {
+
+		if err := recover(); err != nil {
+
+			Logger.Info("query channel already closed", zap.Any("error", err))
+
+		}
+
+	}()
+
+	cancelBytes, err := msgpack.Marshal(Query{
+
+		QueryId: queryId,
+
+		Cancel: true,
+
+		Timeout: timeout,
 	})
 
 	if err != nil {
 
-		Logger.Error("Error serializing query", zap.Error(err))
+		Logger.Error("Error serializing query cancellation", zap.Error(err))
 
-		return nil, err
+		return
 
 	}
 
-	receiverChannel := make(chan []byte)
+	db.queryChannel <- cancelBytes
 
-	db.putReceiverChannel(queryId, receiverChannel)
+}
 
-	// Send query
-	db.queryChannel <- queryBytes
+// assembleResult merges the result chunks in their sequence order, the final chunk completing the result.
+func assembleResult(chunks map[uint32]Result, totalChunks int) Result {
 
-	// Receive result
-	var result Result
+	result := chunks[0]
 
-	select {
+	for sequence := 1; sequence < totalChunks; sequence++ {
 
-	case <-time.NewTimer(40 * time.Second).C:
+		chunk := chunks[uint32(sequence)]
 
-		Logger.Info("Query timeout", zap.Uint64("queryId", queryId))
+		for objectId, object := range chunk.ObjectIdentifiers {
 
-		return nil, ErrQueryTimedOut
+			if result.ObjectIdentifiers == nil {
+
+				result.ObjectIdentifiers = make(map[uint32]string)
+
+			}
+
+			result.ObjectIdentifiers[objectId] = object
+
+		}
+
+		for seriesKey, series := range chunk.Instances {
+
+			if result.Instances == nil {
+
+				result.Instances = make(map[uint32]SeriesInstance)
+
+			}
+
+			result.Instances[seriesKey] = series
+
+		}
+
+		for objectId, points := range chunk.Data {
+
+			if result.Data == nil {
+
+				result.Data = make(map[uint32][]DataPoint)
+
+			}
+
+			result.Data[objectId] = append(result.Data[objectId], points...)
+
+		}
 
-	case resultBytes := <-receiverChannel:
+		for name, data := range chunk.Series {
 
-		if len(resultBytes) == 0 {
+			if result.Series == nil {
 
-			// Empty result due to closing of receiver channel
+				result.Series = make(map[string]map[uint32][]DataPoint)
 
-			return nil, ErrServerShutdown
+			}
+
+			if result.Series[name] == nil {
+
+				result.Series[name] = make(map[uint32][]DataPoint)
+
+			}
+
+			for objectId, points := range data {
+
+				result.Series[name][objectId] = append(result.Series[name][objectId], points...)
+
+			}
 
 		}
 
-		if err = msgpack.Unmarshal(resultBytes, &result); err != nil {
+		for objectId, values := range chunk.DistinctValues {
+
+			if result.DistinctValues == nil {
 
-			Logger.Info("Error deserializing query result", zap.Error(err))
+				result.DistinctValues = make(map[uint32][]DistinctValue)
+
+			}
+
+			result.DistinctValues[objectId] = append(result.DistinctValues[objectId], values...)
+
+		}
+
+		for objectId, changes := range chunk.Changes {
+
+			if result.Changes == nil {
+
+				result.Changes = make(map[uint32][]ValueChange)
+
+			}
+
+			result.Changes[objectId] = append(result.Changes[objectId], changes...)
 
-			return nil, err
 		}
 
 	}
 
-	return parseResponse(result.Data), nil
+	// The ranking, pagination, error, stats and metadata come with the final chunk
+	final := chunks[uint32(totalChunks-1)]
+
+	result.Ranking, result.TotalObjects, result.NextOffset, result.Error, result.Stats = final.Ranking, final.TotalObjects, final.NextOffset, final.Error, final.Stats
+
+	result.ContinuousQueries, result.Objects, result.SeriesMetadata, result.Counters = final.ContinuousQueries, final.Objects, final.SeriesMetadata, final.Counters
+
+	return result
+
 }
 
 func (db *ReportDBClient) SendPollData(data []byte) {
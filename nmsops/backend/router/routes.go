@@ -43,4 +43,18 @@ func SetupRoutes(router *gin.Engine, reportDB *ReportDBClient, configDB *ConfigD
 	// Query endpoints
 	api.POST("/query", queryController.HandleQuery)
 
+	api.POST("/query/latest", queryController.HandleLatestQuery)
+
+	api.POST("/query/metadata", queryController.HandleMetadataQuery)
+
+	api.GET("/counters", queryController.GetCounters)
+
+	api.POST("/counters", queryController.RegisterCounters)
+
+	api.POST("/counters/reload", queryController.ReloadCounters)
+
+	api.POST("/continuous-queries", queryController.RegisterContinuousQuery)
+
+	api.GET("/continuous-queries", queryController.GetContinuousQueries)
+
 }
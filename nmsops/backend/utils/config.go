@@ -22,6 +22,7 @@ var (
 	PollSenderPort          string
 	PollDataChannelSize     int
 	QuerySendChannelSize    int
+	ResultChunkBufferSize   int
 	MaxLogFileSizeInMB      int
 	LogFileRetentionInDays  int
 	IsProductionEnvironment bool
@@ -94,6 +95,8 @@ func LoadConfig() (err error) {
 
 	QuerySendChannelSize = int(generalConfig["QuerySendChannelSize"].(float64))
 
+	ResultChunkBufferSize = int(generalConfig["ResultChunkBufferSize"].(float64))
+
 	MaxLogFileSizeInMB = int(generalConfig["MaxLogFileSizeInMB"].(float64))
 
 	LogFileRetentionInDays = int(generalConfig["LogFileRetentionInDays"].(float64))
@@ -4,27 +4,40 @@ import (
 	"context"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/ssh"
+	"net"
 	. "poller/containers"
 	. "poller/utils"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type PolledDataPoint struct {
-	Timestamp uint32 `json:"timestamp" msgpack:"timestamp"`
+	// Timestamp in the precision of the counter, seconds unless the counter's precision is ms
+	Timestamp uint64 `json:"timestamp" msgpack:"timestamp"`
 
 	CounterId uint16 `json:"counter_id" msgpack:"counter_id"`
 
 	ObjectId uint32 `json:"object_id" msgpack:"object_id"`
 
+	// Object is the device's address or hostname, the reportDB assigns it an objectId
+	Object string `json:"object,omitempty" msgpack:"object,omitempty"`
+
+	// Instance of the device the value belongs to, like eth0 or /var
+	Instance string `json:"instance,omitempty" msgpack:"instance,omitempty"`
+
 	Value interface{} `json:"value" msgpack:"value"`
 }
 
+// CounterCommand gives the command polling each counter. Commands of the counters having instances print a line per
+// instance, the instance followed by its value.
 var CounterCommand = map[uint16]string{
 	1: "free -m | awk 'NR==2 {print $3}'",
 	2: "top -bn 1 | awk 'NR==3 {print $2}'",
 	3: "whoami",
+	4: "df -P | awk 'NR>1 {print $6, $5+0}'",
+	5: "awk -F'[: ]+' 'NR>2 {print $2, $3}' /proc/net/dev",
 }
 
 func InitPollers(pollJobChannel <-chan PollJob, pollResultChannel chan<- PolledDataPoint, globalShutdownChannel <-chan struct{}, globalShutdownWaitGroup *sync.WaitGroup) {
@@ -98,61 +111,149 @@ func Poller(pollJobChannel <-chan PollJob, pollResultChannel chan<- PolledDataPo
 
 			}
 
+			polledAt := time.Now()
+
 			for index, counterId := range job.CounterIds {
 
-				var value interface{}
+				if index >= len(resp) {
 
-				switch CounterConfig[counterId]["dataType"] {
+					break
 
-				case "int", "int32", "int64", "uint", "uint32", "uint64":
+				}
 
-					value, err = strconv.Atoi(resp[index])
+				for _, dataPoint := range parseCounterOutput(counterId, strings.TrimSpace(resp[index])) {
 
-					if err != nil {
+					dataPoint.Timestamp = counterTimestamp(counterId, job.Timestamp, polledAt)
 
-						Logger.Error("error converting string to int", zap.String("value", resp[index]), zap.Uint16("counterId", counterId), zap.Error(err))
+					dataPoint.Object = job.DeviceIP
 
-						continue
+					pollResultChannel <- dataPoint
 
-					}
+					Logger.Info("poll success for", zap.String("ObjectId", job.DeviceIP), zap.Any("DataPoint", dataPoint))
 
-				case "float32", "float64":
+				}
 
-					value, err = strconv.ParseFloat(resp[index], 64)
+			}
 
-					if err != nil {
+		}
+	}
 
-						Logger.Error("error converting string to float", zap.String("value", resp[index]), zap.Uint16("counterId", counterId), zap.Error(err))
+}
 
-						continue
+// counterTimestamp returns the timestamp of the counter's dataPoints, the poll's tick for the counters of seconds
+// precision and the time the output was received for the counters of millisecond precision.
+func counterTimestamp(counterId uint16, tick uint32, polledAt time.Time) uint64 {
 
-					}
+	if precision, _ := CounterConfig[counterId]["precision"].(string); precision == "ms" {
 
-				case "string":
+		return uint64(polledAt.UnixMilli())
 
-					value = resp[index]
+	}
 
-				}
+	return uint64(tick)
 
-				dataPoint := PolledDataPoint{
+}
 
-					Timestamp: job.Timestamp,
+// parseCounterOutput returns the dataPoints of the counter's command output, one per line for the counters having
+// instances. Values not matching the counter's dataType are skipped.
+func parseCounterOutput(counterId uint16, output string) []PolledDataPoint {
 
-					ObjectId: ConvertIpToNumeric(job.DeviceIP),
+	if hasInstances, _ := CounterConfig[counterId]["instances"].(bool); !hasInstances {
 
-					CounterId: counterId,
+		value, err := parseValue(counterId, output)
 
-					Value: value,
-				}
+		if err != nil {
 
-				pollResultChannel <- dataPoint
+			return nil
 
-				Logger.Info("poll success for", zap.String("ObjectId", job.DeviceIP), zap.Any("DataPoint", dataPoint))
-			}
+		}
+
+		return []PolledDataPoint{{CounterId: counterId, Value: value}}
+
+	}
+
+	var dataPoints []PolledDataPoint
+
+	for _, line := range strings.Split(output, "\n") {
+
+		line = strings.TrimSpace(line)
+
+		// Instances may contain spaces, the value is the last field
+		separator := strings.LastIndexAny(line, " \t")
+
+		if separator <= 0 {
+
+			continue
+
+		}
+
+		value, err := parseValue(counterId, line[separator+1:])
+
+		if err != nil {
+
+			continue
+
+		}
+
+		dataPoints = append(dataPoints, PolledDataPoint{
+
+			CounterId: counterId,
+
+			Instance: strings.TrimSpace(line[:separator]),
+
+			Value: value,
+		})
+
+	}
+
+	return dataPoints
+
+}
+
+func parseValue(counterId uint16, output string) (interface{}, error) {
+
+	switch CounterConfig[counterId]["dataType"] {
+
+	case "int", "int32", "int64", "uint", "uint32", "uint64":
+
+		value, err := strconv.Atoi(output)
+
+		if err != nil {
+
+			Logger.Error("error converting string to int", zap.String("value", output), zap.Uint16("counterId", counterId), zap.Error(err))
+
+		}
+
+		return value, err
+
+	case "float32", "float64":
+
+		value, err := strconv.ParseFloat(output, 64)
+
+		if err != nil {
+
+			Logger.Error("error converting string to float", zap.String("value", output), zap.Uint16("counterId", counterId), zap.Error(err))
 
 		}
+
+		return value, err
+
+	case "bool":
+
+		value, err := strconv.ParseBool(output)
+
+		if err != nil {
+
+			Logger.Error("error converting string to bool", zap.String("value", output), zap.Uint16("counterId", counterId), zap.Error(err))
+
+		}
+
+		return value, err
+
 	}
 
+	return output, nil
+
 }
 
 func poll(deviceIp, hostname, password, port, cmd string) ([]string, error) {
@@ -169,7 +270,8 @@ func poll(deviceIp, hostname, password, port, cmd string) ([]string, error) {
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
 
-	client, err := ssh.Dial("tcp", deviceIp+":"+port, &config)
+	// IPv6 addresses are bracketed
+	client, err := ssh.Dial("tcp", net.JoinHostPort(deviceIp, port), &config)
 
 	if err != nil {
 
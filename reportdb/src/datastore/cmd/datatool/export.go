@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	. "datastore/containers"
+	. "datastore/storage"
+	. "datastore/utils"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// runExport writes the counter's dataPoints of the range to CSV or newline-delimited JSON, day by day and object by object.
+func runExport(config *Config, args []string) error {
+
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+
+	counterId := flags.Uint("counter", 0, "counterId to export")
+
+	objects := flags.String("objects", "", "comma separated objectIds, IPs or objects, all the objects when empty")
+
+	from := flags.Uint("from", 0, "start of the range, unix seconds")
+
+	to := flags.Uint("to", 0, "end of the range, unix seconds")
+
+	format := flags.String("format", "csv", "output format, either csv or ndjson")
+
+	out := flags.String("out", "", "output file, stdout when empty")
+
+	_ = flags.Parse(args)
+
+	dataType, ok := config.CounterDataType(uint16(*counterId))
+
+	if !ok {
+
+		return fmt.Errorf("unknown counter %d", *counterId)
+
+	}
+
+	if *to == 0 || *from > *to {
+
+		return errors.New("invalid range, from must be less than or equal to to")
+
+	}
+
+	if *format != "csv" && *format != "ndjson" {
+
+		return errors.New("invalid format, it must be either csv or ndjson")
+
+	}
+
+	unlockStorageDirectory, err := LockStorageDirectory(config.StorageDirectory)
+
+	if err != nil {
+
+		return fmt.Errorf("the server must be stopped before exporting: %w", err)
+
+	}
+
+	defer unlockStorageDirectory()
+
+	var output io.Writer = os.Stdout
+
+	if *out != "" {
+
+		file, err := os.Create(*out)
+
+		if err != nil {
+
+			return err
+
+		}
+
+		defer file.Close()
+
+		output = file
+
+	}
+
+	bufferedOutput := bufio.NewWriter(output)
+
+	defer bufferedOutput.Flush()
+
+	writeDataPoint := newDataPointWriter(bufferedOutput, *format)
+
+	if *format == "csv" {
+
+		if err = writeDataPoint(nil); err != nil {
+
+			return err
+
+		}
+
+	}
+
+	storagePool, err := InitStoragePool(config)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	defer storagePool.ClosePool()
+
+	var objectIds []uint32
+
+	if *objects != "" {
+
+		for _, object := range strings.Split(*objects, ",") {
+
+			objectId, err := parseObjectId(object)
+
+			if err != nil {
+
+				var ok bool
+
+				if objectId, ok = storagePool.Objects.Resolve(object); !ok {
+
+					return fmt.Errorf("unknown object %q", object)
+
+				}
+
+			}
+
+			objectIds = append(objectIds, objectId)
+
+		}
+
+	}
+
+	exported := 0
+
+	// Timestamps are exported in the precision of the counter
+	precision := config.CounterPrecision(uint16(*counterId))
+
+	startDate := uint64(*from) - uint64(*from)%86400
+
+	for date := startDate; date <= uint64(*to); date += 86400 {
+
+		storageKey := StoragePoolKey{
+
+			Date: UnixToDate(date),
+
+			CounterId: uint16(*counterId),
+		}
+
+		storageEngine, err := storagePool.GetStorage(storageKey, false)
+
+		if errors.Is(err, ErrStorageDoesNotExist) {
+
+			continue
+
+		}
+
+		if err != nil {
+
+			return err
+
+		}
+
+		// Keys of the objects' own series and of their instances
+		var seriesKeys []uint32
+
+		if len(objectIds) == 0 {
+
+			if seriesKeys, err = storageEngine.GetAllKeys(); err != nil {
+
+				return err
+
+			}
+
+		}
+
+		for _, objectId := range objectIds {
+
+			seriesKeys = append(append(seriesKeys, objectId), storageEngine.ObjectInstanceKeys(objectId)...)
+
+		}
+
+		slices.Sort(seriesKeys)
+
+		for _, seriesKey := range seriesKeys {
+
+			series, ok := storageEngine.Instance(seriesKey)
+
+			if !ok {
+
+				series.ObjectId = seriesKey
+
+			}
+
+			data, err := storageEngine.Get(seriesKey)
+
+			if errors.Is(err, ErrObjectDoesNotExist) {
+
+				continue
+
+			}
+
+			if err != nil {
+
+				return err
+
+			}
+
+			// Registered objects get another objectId once imported, they are exported by identifier
+			var object string
+
+			if storagePool.Objects.IsRegistered(series.ObjectId) {
+
+				object = storagePool.Objects.Object(series.ObjectId)
+
+			}
+
+			dataPoints, err := DeserializeStorageBatch(data, storageEngine, dataType, precision)
+
+			if err != nil {
+
+				return fmt.Errorf("object %d %s of %s: %w", series.ObjectId, series.Instance, storageKey.Date.Format(), err)
+
+			}
+
+			for _, dataPoint := range dataPoints {
+
+				if timestamp := TimestampSeconds(dataPoint.Timestamp, precision); timestamp < uint64(*from) || timestamp > uint64(*to) {
+
+					continue
+
+				}
+
+				if err = writeDataPoint(&PolledDataPoint{
+
+					Timestamp: dataPoint.Timestamp,
+
+					CounterId: uint16(*counterId),
+
+					ObjectId: series.ObjectId,
+
+					Object: object,
+
+					Instance: series.Instance,
+
+					Value: dataPoint.Value,
+				}); err != nil {
+
+					return err
+
+				}
+
+				exported++
+
+			}
+
+		}
+
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d dataPoints\n", exported)
+
+	return nil
+
+}
+
+// newDataPointWriter returns the function writing a single dataPoint in the format, a nil dataPoint writes the CSV header.
+func newDataPointWriter(output io.Writer, format string) func(*PolledDataPoint) error {
+
+	if format == "ndjson" {
+
+		encoder := json.NewEncoder(output)
+
+		return func(dataPoint *PolledDataPoint) error {
+
+			return encoder.Encode(dataPoint)
+
+		}
+
+	}
+
+	csvWriter := csv.NewWriter(output)
+
+	return func(dataPoint *PolledDataPoint) error {
+
+		record := append(slices.Clone(csvHeader), csvInstanceColumn)
+
+		if dataPoint != nil {
+
+			object := dataPoint.Object
+
+			if object == "" {
+
+				object = strconv.FormatUint(uint64(dataPoint.ObjectId), 10)
+
+			}
+
+			record = []string{
+
+				strconv.FormatUint(uint64(dataPoint.Timestamp), 10),
+
+				strconv.FormatUint(uint64(dataPoint.CounterId), 10),
+
+				object,
+
+				formatValue(dataPoint.Value),
+
+				dataPoint.Instance,
+			}
+
+		}
+
+		if err := csvWriter.Write(record); err != nil {
+
+			return err
+
+		}
+
+		// The csv writer buffers on its own, hand the row over to the output buffer
+		csvWriter.Flush()
+
+		return csvWriter.Error()
+
+	}
+
+}
+
+func formatValue(value interface{}) string {
+
+	switch typedValue := value.(type) {
+
+	case float64:
+
+		return strconv.FormatFloat(typedValue, 'f', -1, 64)
+
+	case float32:
+
+		return strconv.FormatFloat(float64(typedValue), 'f', -1, 32)
+
+	case Histogram:
+
+		// Imported back from its JSON
+		histogramBytes, _ := json.Marshal(typedValue)
+
+		return string(histogramBytes)
+
+	default:
+
+		return fmt.Sprint(value)
+
+	}
+
+}
+
+// parseObjectId accepts an objectId either as a number or as an IPv4 address, the other objects are resolved by the
+// object registry.
+func parseObjectId(object string) (uint32, error) {
+
+	object = strings.TrimSpace(object)
+
+	if objectId, err := strconv.ParseUint(object, 10, 32); err == nil {
+
+		return uint32(objectId), nil
+
+	}
+
+	ip := net.ParseIP(object).To4()
+
+	if ip == nil {
+
+		return 0, fmt.Errorf("invalid object %q, it must be either a number or an IPv4 address", object)
+
+	}
+
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3]), nil
+
+}
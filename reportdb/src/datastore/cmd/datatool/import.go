@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	. "datastore/containers"
+	. "datastore/db"
+	. "datastore/utils"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var csvHeader = []string{"timestamp", "counter_id", "object_id", "value"}
+
+// csvInstanceColumn is optional, rows without it are the objects' own series
+const csvInstanceColumn = "instance"
+
+type importSummary struct {
+	imported int
+
+	skipped int
+}
+
+// runImport writes the files' dataPoints to storage through the writers, exactly like the server does.
+func runImport(config *Config, args []string) error {
+
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+
+	batchSize := flags.Int("batch", 1000, "number of dataPoints handed to the writers at once")
+
+	_ = flags.Parse(args)
+
+	if flags.NArg() == 0 {
+
+		return errors.New("no files to import")
+
+	}
+
+	reportDB, err := Open(config.StorageDirectory, Options{Config: config})
+
+	if err != nil {
+
+		return err
+
+	}
+
+	var summary importSummary
+
+	batch := make([]PolledDataPoint, 0, *batchSize)
+
+	importDataPoint := func(dataPoint PolledDataPoint) error {
+
+		dataType, ok := config.CounterDataType(dataPoint.CounterId)
+
+		if !ok {
+
+			summary.skipped++
+
+			return nil
+
+		}
+
+		if dataPoint.Value, err = normalizeValue(dataPoint.Value, dataType); err != nil {
+
+			return fmt.Errorf("counter %d, object %d at %d: %w", dataPoint.CounterId, dataPoint.ObjectId, dataPoint.Timestamp, err)
+
+		}
+
+		batch = append(batch, dataPoint)
+
+		if len(batch) == *batchSize {
+
+			if err = reportDB.Write(batch); err != nil {
+
+				return err
+
+			}
+
+			batch = make([]PolledDataPoint, 0, *batchSize)
+
+		}
+
+		summary.imported++
+
+		return nil
+
+	}
+
+	for _, fileName := range flags.Args() {
+
+		if err = importFile(fileName, importDataPoint); err != nil {
+
+			err = fmt.Errorf("%s: %w", fileName, err)
+
+			break
+
+		}
+
+	}
+
+	if len(batch) > 0 && err == nil {
+
+		err = reportDB.Write(batch)
+
+	}
+
+	// Closing flushes the buffered dataPoints
+	if closeErr := reportDB.Close(); err == nil {
+
+		err = closeErr
+
+	}
+
+	fmt.Printf("imported %d dataPoints, skipped %d of unknown counters\n", summary.imported, summary.skipped)
+
+	return err
+
+}
+
+func importFile(fileName string, importDataPoint func(PolledDataPoint) error) error {
+
+	file, err := os.Open(fileName)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+
+	case ".json":
+
+		return importJSON(file, importDataPoint)
+
+	case ".ndjson", ".jsonl":
+
+		return importNDJSON(file, importDataPoint)
+
+	case ".csv":
+
+		return importCSV(file, importDataPoint)
+
+	default:
+
+		return errors.New("unsupported file type, it must be either .json, .ndjson or .csv")
+
+	}
+
+}
+
+// importJSON reads an array of dataPoints, or an object holding it in "polling_data" like the test-data fixtures.
+func importJSON(reader io.Reader, importDataPoint func(PolledDataPoint) error) error {
+
+	fileBytes, err := io.ReadAll(reader)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	var dataPoints []PolledDataPoint
+
+	if trimmed := bytes.TrimSpace(fileBytes); len(trimmed) > 0 && trimmed[0] == '{' {
+
+		var fixture struct {
+			PollingData []PolledDataPoint `json:"polling_data"`
+		}
+
+		err = json.Unmarshal(fileBytes, &fixture)
+
+		dataPoints = fixture.PollingData
+
+	} else {
+
+		err = json.Unmarshal(fileBytes, &dataPoints)
+
+	}
+
+	if err != nil {
+
+		return err
+
+	}
+
+	for _, dataPoint := range dataPoints {
+
+		if err = importDataPoint(dataPoint); err != nil {
+
+			return err
+
+		}
+
+	}
+
+	return nil
+
+}
+
+func importNDJSON(reader io.Reader, importDataPoint func(PolledDataPoint) error) error {
+
+	scanner := bufio.NewScanner(reader)
+
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+
+			continue
+
+		}
+
+		var dataPoint PolledDataPoint
+
+		if err := json.Unmarshal(scanner.Bytes(), &dataPoint); err != nil {
+
+			return fmt.Errorf("line %d: %w", line, err)
+
+		}
+
+		if err := importDataPoint(dataPoint); err != nil {
+
+			return err
+
+		}
+
+	}
+
+	return scanner.Err()
+
+}
+
+// importCSV reads rows of timestamp, counter_id, object_id and value, in the order given by the header row. The
+// object_id is either a number, an IPv4 address or the identifier of an object like a hostname.
+func importCSV(reader io.Reader, importDataPoint func(PolledDataPoint) error) error {
+
+	csvReader := csv.NewReader(reader)
+
+	header, err := csvReader.Read()
+
+	if err != nil {
+
+		return err
+
+	}
+
+	columns := make(map[string]int, len(header))
+
+	for index, name := range header {
+
+		columns[strings.TrimSpace(strings.ToLower(name))] = index
+
+	}
+
+	for _, name := range csvHeader {
+
+		if _, ok := columns[name]; !ok {
+
+			return fmt.Errorf("missing %q column", name)
+
+		}
+
+	}
+
+	for line := 2; ; line++ {
+
+		record, err := csvReader.Read()
+
+		if errors.Is(err, io.EOF) {
+
+			return nil
+
+		}
+
+		if err != nil {
+
+			return err
+
+		}
+
+		timestamp, err := strconv.ParseUint(record[columns["timestamp"]], 10, 64)
+
+		if err != nil {
+
+			return fmt.Errorf("line %d: invalid timestamp: %w", line, err)
+
+		}
+
+		counterId, err := strconv.ParseUint(record[columns["counter_id"]], 10, 16)
+
+		if err != nil {
+
+			return fmt.Errorf("line %d: invalid counter_id: %w", line, err)
+
+		}
+
+		// Objects other than IPv4 addresses are registered by the writers
+		var object string
+
+		objectId, err := parseObjectId(record[columns["object_id"]])
+
+		if err != nil {
+
+			if object = strings.TrimSpace(record[columns["object_id"]]); object == "" {
+
+				return fmt.Errorf("line %d: %w", line, err)
+
+			}
+
+		}
+
+		var instance string
+
+		if column, ok := columns[csvInstanceColumn]; ok {
+
+			instance = record[column]
+
+		}
+
+		if err = importDataPoint(PolledDataPoint{
+
+			Timestamp: timestamp,
+
+			CounterId: uint16(counterId),
+
+			ObjectId: objectId,
+
+			Object: object,
+
+			Instance: instance,
+
+			Value: record[columns["value"]],
+		}); err != nil {
+
+			return err
+
+		}
+
+	}
+
+}
+
+// normalizeValue converts the value to the type the writers serialize for the counter's dataType.
+func normalizeValue(value interface{}, dataType string) (interface{}, error) {
+
+	if dataType == "string" {
+
+		if stringValue, ok := value.(string); ok {
+
+			return stringValue, nil
+
+		}
+
+		return fmt.Sprint(value), nil
+
+	}
+
+	switch typedValue := value.(type) {
+
+	case float64:
+
+		return typedValue, nil
+
+	case string:
+
+		floatValue, err := strconv.ParseFloat(strings.TrimSpace(typedValue), 64)
+
+		if err != nil {
+
+			return nil, fmt.Errorf("invalid %s value %q", dataType, typedValue)
+
+		}
+
+		return floatValue, nil
+
+	default:
+
+		return nil, fmt.Errorf("invalid %s value %v", dataType, value)
+
+	}
+
+}
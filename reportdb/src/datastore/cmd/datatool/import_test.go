@@ -0,0 +1,63 @@
+package main
+
+import (
+	. "datastore/containers"
+	"strings"
+	"testing"
+)
+
+func TestImportCSV(t *testing.T) {
+
+	input := "counter_id,timestamp,object_id,value\n1,1700000000,10.0.0.1,42\n3,1700000060,7,up\n1,1700000120,web-01,7\n"
+
+	var dataPoints []PolledDataPoint
+
+	err := importCSV(strings.NewReader(input), func(dataPoint PolledDataPoint) error {
+
+		dataPoints = append(dataPoints, dataPoint)
+
+		return nil
+
+	})
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if len(dataPoints) != 3 || dataPoints[2].Object != "web-01" || dataPoints[0].ObjectId != 167772161 || dataPoints[0].CounterId != 1 || dataPoints[1].Timestamp != 1700000060 || dataPoints[1].Value != "up" {
+
+		t.Errorf("unexpected dataPoints %+v", dataPoints)
+
+	}
+
+	if err = importCSV(strings.NewReader("timestamp,object_id,value\n"), nil); err == nil {
+
+		t.Errorf("expected missing column error")
+
+	}
+
+}
+
+func TestNormalizeValue(t *testing.T) {
+
+	if value, err := normalizeValue("2.5", "float64"); err != nil || value != 2.5 {
+
+		t.Errorf("expected 2.5, got %v, %v", value, err)
+
+	}
+
+	if _, err := normalizeValue("up", "uint64"); err == nil {
+
+		t.Errorf("expected invalid value error")
+
+	}
+
+	if value, _ := normalizeValue(3.0, "string"); value != "3" {
+
+		t.Errorf("expected \"3\", got %v", value)
+
+	}
+
+}
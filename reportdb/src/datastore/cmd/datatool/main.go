@@ -0,0 +1,77 @@
+// Command datatool imports, exports and migrates reportdb data while the server is offline.
+//
+//	datatool import [-batch N] <file.json|file.ndjson|file.csv>...
+//	datatool export -counter ID [-objects 1,2] -from UNIX -to UNIX [-format csv|ndjson] [-out FILE]
+//	datatool migrate -counter ID -type DATATYPE [-drop-invalid]
+//
+// It must be run from the reportdb directory, like the server, to pick up its config and data directories.
+package main
+
+import (
+	. "datastore/utils"
+	"fmt"
+	"log"
+	"os"
+)
+
+func main() {
+
+	if len(os.Args) < 2 {
+
+		usage()
+
+	}
+
+	config, err := LoadConfig()
+
+	if err != nil {
+
+		log.Fatal("error loading config:", err)
+
+	}
+
+	if err = InitLogger(config); err != nil {
+
+		log.Fatal("error initializing logger", err)
+
+	}
+
+	switch os.Args[1] {
+
+	case "import":
+
+		err = runImport(config, os.Args[2:])
+
+	case "export":
+
+		err = runExport(config, os.Args[2:])
+
+	case "migrate":
+
+		err = runMigrate(config, os.Args[2:])
+
+	default:
+
+		usage()
+
+	}
+
+	if err != nil {
+
+		log.Fatal(err)
+
+	}
+
+}
+
+func usage() {
+
+	fmt.Fprintln(os.Stderr, "usage: datatool import [-batch N] <file.json|file.ndjson|file.csv>...")
+
+	fmt.Fprintln(os.Stderr, "       datatool export -counter ID [-objects 1,2] -from UNIX -to UNIX [-format csv|ndjson] [-out FILE]")
+
+	fmt.Fprintln(os.Stderr, "       datatool migrate -counter ID -type DATATYPE [-drop-invalid]")
+
+	os.Exit(2)
+
+}
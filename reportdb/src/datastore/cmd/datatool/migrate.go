@@ -0,0 +1,361 @@
+package main
+
+import (
+	. "datastore/containers"
+	. "datastore/storage"
+	. "datastore/utils"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// runMigrate rewrites every day of the counter to the new dataType, then sets it in the counters file. Days are
+// swapped in one by one with their dataType recorded, so an interrupted migration leaves readable days and is resumed
+// by running it again.
+func runMigrate(config *Config, args []string) error {
+
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+
+	counterId := flags.Uint("counter", 0, "counterId to migrate")
+
+	dataType := flags.String("type", "", "new dataType of the counter")
+
+	dropInvalid := flags.Bool("drop-invalid", false, "drop the values not convertible to the new dataType instead of failing")
+
+	_ = flags.Parse(args)
+
+	currentDataType, ok := config.CounterDataType(uint16(*counterId))
+
+	if !ok {
+
+		return fmt.Errorf("unknown counter %d", *counterId)
+
+	}
+
+	if !SupportedDataType(*dataType) {
+
+		return fmt.Errorf("%w: %q", ErrUnsupportedDataType, *dataType)
+
+	}
+
+	unlockStorageDirectory, err := LockStorageDirectory(config.StorageDirectory)
+
+	if err != nil {
+
+		return fmt.Errorf("the server must be stopped before migrating: %w", err)
+
+	}
+
+	defer unlockStorageDirectory()
+
+	storagePaths, err := counterStoragePaths(config.StorageDirectory, uint16(*counterId))
+
+	if err != nil {
+
+		return err
+
+	}
+
+	migrated, dropped := 0, 0
+
+	for _, storagePath := range storagePaths {
+
+		dayMigrated, dayDropped, err := migrateDay(storagePath, currentDataType, *dataType, *dropInvalid, config)
+
+		if err != nil {
+
+			return fmt.Errorf("%s: %w", storagePath, err)
+
+		}
+
+		if dayMigrated {
+
+			migrated++
+
+		}
+
+		dropped += dayDropped
+
+	}
+
+	latestValues, err := LoadLatestValues(config)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	dropped += latestValues.ConvertCounter(uint16(*counterId), *dataType)
+
+	if err = latestValues.Persist(); err != nil {
+
+		return err
+
+	}
+
+	if err = setCounterDataType(config.CountersFile, uint16(*counterId), *dataType); err != nil {
+
+		return err
+
+	}
+
+	fmt.Fprintf(os.Stderr, "migrated %d of %d days of counter %d to %s, %d values dropped\n", migrated, len(storagePaths), *counterId, *dataType, dropped)
+
+	return nil
+
+}
+
+// counterStoragePaths returns the day-storages of the counter, after cleaning up the ones of an interrupted migration.
+func counterStoragePaths(storageDirectory string, counterId uint16) ([]string, error) {
+
+	counterDirectory := "/*/*/*/" + strconv.Itoa(int(counterId))
+
+	migratingPaths, _ := filepath.Glob(storageDirectory + counterDirectory + ".migrating")
+
+	for _, migratingPath := range migratingPaths {
+
+		if err := os.RemoveAll(migratingPath); err != nil {
+
+			return nil, err
+
+		}
+
+	}
+
+	replacedPaths, _ := filepath.Glob(storageDirectory + counterDirectory + ".replaced")
+
+	for _, replacedPath := range replacedPaths {
+
+		storagePath := replacedPath[:len(replacedPath)-len(".replaced")]
+
+		// Interrupted between the two renames of the swap
+		if _, err := os.Stat(storagePath); errors.Is(err, os.ErrNotExist) {
+
+			if err = os.Rename(replacedPath, storagePath); err != nil {
+
+				return nil, err
+
+			}
+
+		} else if err = os.RemoveAll(replacedPath); err != nil {
+
+			return nil, err
+
+		}
+
+	}
+
+	storagePaths, err := filepath.Glob(storageDirectory + counterDirectory)
+
+	slices.Sort(storagePaths)
+
+	return storagePaths, err
+
+}
+
+// migrateDay rewrites the day-storage with the dataType, migrated is false for a day already in the dataType.
+func migrateDay(storagePath string, counterDataType string, dataType string, dropInvalid bool, config *Config) (migrated bool, dropped int, err error) {
+
+	storage, err := NewStorage(storagePath, Metadata{DataType: counterDataType}, config, false)
+
+	if err != nil {
+
+		return false, 0, err
+
+	}
+
+	if storage.DataType() == dataType {
+
+		storage.ClearStorage()
+
+		return false, 0, nil
+
+	}
+
+	migratingPath := storagePath + ".migrating"
+
+	// The day keeps its precision
+	migratedStorage, err := NewStorage(migratingPath, Metadata{DataType: dataType, Precision: storage.Precision()}, config, true)
+
+	if err != nil {
+
+		storage.ClearStorage()
+
+		return false, 0, err
+
+	}
+
+	dropped, err = rewriteDay(storage, migratedStorage, counterDataType, dataType, dropInvalid)
+
+	storage.ClearStorage()
+
+	migratedStorage.ClearStorage()
+
+	if err != nil {
+
+		return false, 0, err
+
+	}
+
+	// Swap the migrated day in
+	if err = os.Rename(storagePath, storagePath+".replaced"); err != nil {
+
+		return false, 0, err
+
+	}
+
+	if err = os.Rename(migratingPath, storagePath); err != nil {
+
+		return false, 0, err
+
+	}
+
+	return true, dropped, os.RemoveAll(storagePath + ".replaced")
+
+}
+
+// rewriteDay writes every object of the storage, converted to the dataType, to the migrated storage.
+func rewriteDay(storage *Storage, migratedStorage *Storage, counterDataType string, dataType string, dropInvalid bool) (dropped int, err error) {
+
+	objectIds, err := storage.GetAllKeys()
+
+	if err != nil {
+
+		return 0, err
+
+	}
+
+	// Days of an interrupted migration to another dataType are converted from their own
+	storageDataType := storage.DataType()
+
+	if storageDataType == "" {
+
+		storageDataType = counterDataType
+
+	}
+
+	var data []byte
+
+	for _, objectId := range objectIds {
+
+		storageData, err := storage.Get(objectId)
+
+		if err != nil {
+
+			return 0, err
+
+		}
+
+		dataPoints, err := DeserializeBatch(storageData, storageDataType, storage.Precision())
+
+		if err != nil {
+
+			return 0, fmt.Errorf("object %d: %w", objectId, err)
+
+		}
+
+		convertedDataPoints := dataPoints[:0]
+
+		for _, dataPoint := range dataPoints {
+
+			value, err := ConvertValue(dataPoint.Value, dataType)
+
+			if err != nil && !dropInvalid {
+
+				return 0, fmt.Errorf("object %d at %d: %w", objectId, dataPoint.Timestamp, err)
+
+			}
+
+			if err != nil {
+
+				dropped++
+
+				continue
+
+			}
+
+			convertedDataPoints = append(convertedDataPoints, DataPoint{Timestamp: dataPoint.Timestamp, Value: value})
+
+		}
+
+		if len(convertedDataPoints) == 0 {
+
+			continue
+
+		}
+
+		if err = SerializeBatch(convertedDataPoints, &data, dataType, migratedStorage.Precision()); err != nil {
+
+			return 0, err
+
+		}
+
+		// Instance series are keyed by the migrated storage's own instance dictionary
+		migratedKey := objectId
+
+		if series, ok := storage.Instance(objectId); ok {
+
+			if migratedKey, err = migratedStorage.SeriesKey(series.ObjectId, series.Instance); err != nil {
+
+				return 0, err
+
+			}
+
+		}
+
+		if err = migratedStorage.Put(migratedKey, data); err != nil {
+
+			return 0, err
+
+		}
+
+	}
+
+	return dropped, nil
+
+}
+
+// setCounterDataType sets the counter's dataType in the counters file, keeping its other counters and keys.
+func setCounterDataType(countersFile string, counterId uint16, dataType string) error {
+
+	countersBytes, err := os.ReadFile(countersFile)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	counters := make(map[string]map[string]interface{})
+
+	if err = json.Unmarshal(countersBytes, &counters); err != nil {
+
+		return err
+
+	}
+
+	counter, ok := counters[strconv.Itoa(int(counterId))]
+
+	if !ok {
+
+		return fmt.Errorf("counter %d is not in %s", counterId, countersFile)
+
+	}
+
+	counter[DataType] = dataType
+
+	if countersBytes, err = json.MarshalIndent(counters, "", "  "); err != nil {
+
+		return err
+
+	}
+
+	return os.WriteFile(countersFile, append(countersBytes, '\n'), 0644)
+
+}
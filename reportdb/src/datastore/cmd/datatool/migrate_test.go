@@ -0,0 +1,118 @@
+package main
+
+import (
+	. "datastore/containers"
+	. "datastore/db"
+	. "datastore/storage"
+	. "datastore/utils"
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+
+	directory := t.TempDir()
+
+	config := DefaultConfig()
+
+	config.CountersFile = directory + "/counters.json"
+
+	if err := os.WriteFile(config.CountersFile, []byte(`{"1": {"dataType": "uint64"}, "2": {"dataType": "float64"}}`), 0644); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if _, err := config.ReloadCounters(func(uint16) bool { return false }); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	reportDB, err := Open(directory+"/data", Options{Config: config})
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	const day uint64 = 1700006400
+
+	if err = reportDB.Write([]PolledDataPoint{{Timestamp: day + 10, CounterId: 1, ObjectId: 7, Value: 42.0}, {Timestamp: day + 86400, CounterId: 1, ObjectId: 7, Value: 43.0}}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if err = reportDB.Close(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	// Day created before the dataType was recorded
+	legacyStoragePath := config.StorageDirectory + "/" + UnixToDate(day).Format() + "/1"
+
+	if err = os.Remove(legacyStoragePath + "/metadata.bin"); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if err = runMigrate(config, []string{"-counter", "1", "-type", "string"}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	countersBytes, _ := os.ReadFile(config.CountersFile)
+
+	var counters map[string]map[string]string
+
+	if err = json.Unmarshal(countersBytes, &counters); err != nil || counters["1"][DataType] != "string" || counters["2"][DataType] != "float64" {
+
+		t.Errorf("unexpected counters file %s, %v", countersBytes, err)
+
+	}
+
+	for index, date := range []uint64{day, day + 86400} {
+
+		storage, err := NewStorage(config.StorageDirectory+"/"+UnixToDate(date).Format()+"/1", Metadata{}, config, false)
+
+		if err != nil {
+
+			t.Fatal(err)
+
+		}
+
+		data, err := storage.Get(7)
+
+		if err != nil {
+
+			t.Fatal(err)
+
+		}
+
+		dataPoints, err := DeserializeStorageBatch(data, storage, "string", PrecisionSeconds)
+
+		if storage.DataType() != "string" || err != nil || len(dataPoints) != 1 || dataPoints[0].Value != strconv.Itoa(42+index) {
+
+			t.Errorf("unexpected day %d, %s: %+v, %v", date, storage.DataType(), dataPoints, err)
+
+		}
+
+		storage.ClearStorage()
+
+	}
+
+	// Days already in the dataType are skipped
+	if err = runMigrate(config, []string{"-counter", "1", "-type", "string"}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+}
@@ -3,30 +3,79 @@ package containers
 import (
 	. "datastore/utils"
 	"github.com/dgraph-io/ristretto"
+	"maps"
+	"slices"
 	"strconv"
+	"sync"
 )
 
-var DataPointsCache *ristretto.Cache
+// Caches of a datastore, shared by its writers and readers through the StoragePool.
+type Caches struct {
+	DataPoints *ristretto.Cache
 
-func InitDataPointsCache() error {
+	// QueryResults holds per day partial query results, see CreateQueryResultCacheKey.
+	QueryResults *ristretto.Cache
 
-	config := ristretto.Config{
-		NumCounters: MaxCacheKeys,
-		MaxCost:     MaxCacheSizeInMB * 1024 * 1024, // TODO: shift the cache size to config
+	// Every writer flush to a storage bumps its generation, invalidating the cached query results built over it.
+	// Beyond maxQueryResultGenerations storages the oldest days are pruned, their generation is prunedGeneration
+	// from then on: newer than any they had, so the results cached before are never hit again.
+	queryResultGenerations map[StoragePoolKey]uint64
+
+	maxQueryResultGenerations int
+
+	lastGeneration uint64
+
+	prunedGeneration uint64
+
+	queryResultGenerationsLock sync.RWMutex
+}
+
+func NewCaches(config *Config) (*Caches, error) {
+
+	dataPointsCache, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: config.MaxCacheKeys,
+		MaxCost:     config.MaxCacheSizeInMB * 1024 * 1024,
 		BufferItems: 64,
-	}
+	})
 
-	var err error
+	if err != nil {
+
+		return nil, err
+
+	}
 
-	DataPointsCache, err = ristretto.NewCache(&config)
+	queryResultCache, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: config.MaxQueryCacheKeys,
+		MaxCost:     config.MaxQueryCacheSizeInMB * 1024 * 1024,
+		BufferItems: 64,
+	})
 
 	if err != nil {
 
-		return err
+		dataPointsCache.Close()
+
+		return nil, err
 
 	}
 
-	return nil
+	return &Caches{
+
+		DataPoints: dataPointsCache,
+
+		QueryResults: queryResultCache,
+
+		queryResultGenerations: make(map[StoragePoolKey]uint64),
+
+		maxQueryResultGenerations: int(max(config.MaxQueryCacheKeys, 1)),
+	}, nil
+
+}
+
+func (caches *Caches) Close() {
+
+	caches.DataPoints.Close()
+
+	caches.QueryResults.Close()
 
 }
 
@@ -35,3 +84,75 @@ func CreateCacheKey(storageKey StoragePoolKey, objectId uint32) string {
 	return storageKey.Date.Format() + strconv.Itoa(int(storageKey.CounterId)) + strconv.Itoa(int(objectId))
 
 }
+
+// CreateQueryResultCacheKey returns the key of a day's partial result, querySignature identifying the normalized query.
+func (caches *Caches) CreateQueryResultCacheKey(storageKey StoragePoolKey, querySignature string) string {
+
+	caches.queryResultGenerationsLock.RLock()
+
+	generation, ok := caches.queryResultGenerations[storageKey]
+
+	if !ok {
+
+		generation = caches.prunedGeneration
+
+	}
+
+	caches.queryResultGenerationsLock.RUnlock()
+
+	return storageKey.Date.Format() + "/" + strconv.Itoa(int(storageKey.CounterId)) + "/" + strconv.FormatUint(generation, 10) + "/" + querySignature
+
+}
+
+func (caches *Caches) InvalidateQueryResults(storageKey StoragePoolKey) {
+
+	caches.queryResultGenerationsLock.Lock()
+
+	defer caches.queryResultGenerationsLock.Unlock()
+
+	caches.lastGeneration++
+
+	caches.queryResultGenerations[storageKey] = caches.lastGeneration
+
+	if len(caches.queryResultGenerations) > caches.maxQueryResultGenerations {
+
+		caches.pruneQueryResultGenerations()
+
+	}
+
+}
+
+// pruneQueryResultGenerations keeps the generations of the newest half of the storages. The lock must be held.
+func (caches *Caches) pruneQueryResultGenerations() {
+
+	storageKeys := slices.Collect(maps.Keys(caches.queryResultGenerations))
+
+	slices.SortFunc(storageKeys, func(first, second StoragePoolKey) int {
+
+		if second.Date.Before(first.Date) {
+
+			return -1
+
+		}
+
+		if first.Date.Before(second.Date) {
+
+			return 1
+
+		}
+
+		return 0
+
+	})
+
+	for _, storageKey := range storageKeys[caches.maxQueryResultGenerations/2:] {
+
+		delete(caches.queryResultGenerations, storageKey)
+
+	}
+
+	caches.lastGeneration++
+
+	caches.prunedGeneration = caches.lastGeneration
+
+}
@@ -0,0 +1,55 @@
+package containers
+
+import (
+	. "datastore/utils"
+	"testing"
+)
+
+func TestQueryResultGenerationsPruning(t *testing.T) {
+
+	config := DefaultConfig()
+
+	config.MaxCacheKeys, config.MaxCacheSizeInMB, config.MaxQueryCacheKeys, config.MaxQueryCacheSizeInMB = 1000, 1, 4, 1
+
+	caches, err := NewCaches(config)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer caches.Close()
+
+	oldest := StoragePoolKey{Date: UnixToDate(uint32(0)), CounterId: 1}
+
+	caches.InvalidateQueryResults(oldest)
+
+	cacheKey := caches.CreateQueryResultCacheKey(oldest, "signature")
+
+	for day := uint32(1); day <= 4; day++ {
+
+		caches.InvalidateQueryResults(StoragePoolKey{Date: UnixToDate(day * 86400), CounterId: 1})
+
+	}
+
+	if int64(len(caches.queryResultGenerations)) > config.MaxQueryCacheKeys {
+
+		t.Errorf("expected at most %d generations, got %d", config.MaxQueryCacheKeys, len(caches.queryResultGenerations))
+
+	}
+
+	// The oldest day's generation was pruned, the results cached before under its key are not hit again
+	if _, ok := caches.queryResultGenerations[oldest]; ok {
+
+		t.Errorf("generation of the oldest day not pruned")
+
+	}
+
+	if caches.CreateQueryResultCacheKey(oldest, "signature") == cacheKey {
+
+		t.Errorf("cache key of the pruned day unchanged")
+
+	}
+
+}
@@ -0,0 +1,130 @@
+package containers
+
+import (
+	. "datastore/storage"
+	"go.uber.org/zap"
+	"time"
+)
+
+// CompactColdDays compacts the days older than CompactionAgeInDays into segments, returning the number of day-storages
+// compacted. Days in use are left for the next compaction. The points written late to a compacted day expand it back
+// into a mutable storage, see GetStorage.
+func (storagePool *StoragePool) CompactColdDays() int {
+
+	var compacted int
+
+	// Today is never compacted, whatever the config
+	cutoff := UnixToDate(time.Now().AddDate(0, 0, -max(1, storagePool.Config.CompactionAgeInDays)).Unix())
+
+	for _, key := range storagePool.storagesBefore(cutoff) {
+
+		if IsSegment(storagePool.storagePath(key)) {
+
+			continue
+
+		}
+
+		storageCompacted, ok := storagePool.compact(key)
+
+		if !ok {
+
+			break
+
+		}
+
+		if storageCompacted {
+
+			compacted++
+
+		}
+
+	}
+
+	storagePool.Config.Logger.Info("Compacted cold days", zap.Int("storages", compacted))
+
+	return compacted
+
+}
+
+// compact compacts the storage of the key unless it is open, ok is false once the pool is closed.
+func (storagePool *StoragePool) compact(key StoragePoolKey) (compacted bool, ok bool) {
+
+	storagePool.maintenanceLock.Lock()
+
+	defer storagePool.maintenanceLock.Unlock()
+
+	select {
+
+	case <-storagePool.cleanupShutdown:
+
+		return false, false
+
+	default:
+
+	}
+
+	storagePool.lock.Lock()
+
+	_, pooled := storagePool.pool[key]
+
+	// Days being expanded for late writes are left too
+	if _, busy := storagePool.compacting[key]; pooled || busy {
+
+		storagePool.lock.Unlock()
+
+		return false, true
+
+	}
+
+	done := make(chan struct{})
+
+	storagePool.compacting[key] = done
+
+	storagePool.lock.Unlock()
+
+	storagePath := storagePool.storagePath(key)
+
+	err := CompactStorage(storagePath, storagePool.Config)
+
+	storagePool.lock.Lock()
+
+	delete(storagePool.compacting, key)
+
+	close(done)
+
+	storagePool.lock.Unlock()
+
+	if err != nil {
+
+		storagePool.Config.Logger.Error("error compacting storage", zap.String("storagePath", storagePath), zap.Error(err))
+
+		return false, true
+
+	}
+
+	// Cached points stay valid, the segment holds the same data
+	return true, true
+
+}
+
+func storagePoolCompaction(storagePool *StoragePool, compactionTicker *time.Ticker) {
+
+	defer compactionTicker.Stop()
+
+	for {
+
+		select {
+
+		case <-storagePool.cleanupShutdown:
+
+			return
+
+		case <-compactionTicker.C:
+
+			storagePool.CompactColdDays()
+
+		}
+
+	}
+
+}
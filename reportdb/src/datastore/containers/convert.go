@@ -0,0 +1,409 @@
+package containers
+
+import (
+	. "datastore/storage"
+	. "datastore/utils"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+var ErrNotConvertible = errors.New("value not convertible")
+
+// ConvertValue converts a deserialized or polled value to the value DeserializeBatch gives for dataType. Floats
+// converted to integers are truncated, values out of the range of dataType and strings not holding a number are not
+// convertible. Numbers are true bools when not zero, bools are numbers 1 and 0.
+func ConvertValue(value interface{}, dataType string) (interface{}, error) {
+
+	value = widenInteger(value)
+
+	switch dataType {
+
+	case "float64":
+
+		return toFloat64(value)
+
+	case "float32":
+
+		floatValue, err := toFloat64(value)
+
+		if err == nil && math.Abs(floatValue) > math.MaxFloat32 && !math.IsInf(floatValue, 0) {
+
+			return nil, fmt.Errorf("%w: %v out of float32 range", ErrNotConvertible, value)
+
+		}
+
+		return float32(floatValue), err
+
+	case "int64", "int":
+
+		return toInt64(value)
+
+	case "int32":
+
+		intValue, err := toInt64(value)
+
+		if err == nil && (intValue < math.MinInt32 || intValue > math.MaxInt32) {
+
+			return nil, fmt.Errorf("%w: %v out of int32 range", ErrNotConvertible, value)
+
+		}
+
+		return int32(intValue), err
+
+	case "uint64", "uint":
+
+		return toUint64(value)
+
+	case "uint32":
+
+		uintValue, err := toUint64(value)
+
+		if err == nil && uintValue > math.MaxUint32 {
+
+			return nil, fmt.Errorf("%w: %v out of uint32 range", ErrNotConvertible, value)
+
+		}
+
+		return uint32(uintValue), err
+
+	case "string", "enum":
+
+		switch value := value.(type) {
+
+		case string:
+
+			return value, nil
+
+		case bool:
+
+			return strconv.FormatBool(value), nil
+
+		case float64:
+
+			return strconv.FormatFloat(value, 'f', -1, 64), nil
+
+		case float32:
+
+			return strconv.FormatFloat(float64(value), 'f', -1, 32), nil
+
+		case int64, int32, uint64, uint32:
+
+			return fmt.Sprint(value), nil
+
+		}
+
+	case "bool":
+
+		return toBool(value)
+
+	case "histogram":
+
+		return toHistogram(value)
+
+	default:
+
+		return nil, fmt.Errorf("unsupported data type: %s", dataType)
+
+	}
+
+	return nil, fmt.Errorf("%w: %v (%T)", ErrNotConvertible, value, value)
+
+}
+
+// ConvertDataPoints converts the values of the dataPoints in place, see ConvertValue.
+func ConvertDataPoints(dataPoints []DataPoint, dataType string) error {
+
+	for index := range dataPoints {
+
+		value, err := ConvertValue(dataPoints[index].Value, dataType)
+
+		if err != nil {
+
+			return err
+
+		}
+
+		dataPoints[index].Value = value
+
+	}
+
+	return nil
+
+}
+
+// DeserializeStorageBatch deserializes data read from the storage with the storage's recorded dataType and precision,
+// then converts it to the counter's dataType and to the precision. Storages created before the dataType was recorded
+// hold the counter's dataType.
+func DeserializeStorageBatch(data []byte, storage *Storage, dataType string, precision string) ([]DataPoint, error) {
+
+	storageDataType := storage.DataType()
+
+	if storageDataType == "" {
+
+		storageDataType = dataType
+
+	}
+
+	dataPoints, err := DeserializeBatch(data, storageDataType, storage.Precision())
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	ConvertTimestamps(dataPoints, storage.Precision(), precision)
+
+	if storageDataType == dataType {
+
+		return dataPoints, nil
+
+	}
+
+	return dataPoints, ConvertDataPoints(dataPoints, dataType)
+
+}
+
+// ConvertTimestamps converts the timestamps of the dataPoints in place from a precision to another.
+func ConvertTimestamps(dataPoints []DataPoint, from string, to string) {
+
+	if NormalizePrecision(from) == NormalizePrecision(to) {
+
+		return
+
+	}
+
+	for index := range dataPoints {
+
+		dataPoints[index].Timestamp = ConvertTimestamp(dataPoints[index].Timestamp, from, to)
+
+	}
+
+}
+
+// widenInteger gives int64 or uint64 for the other integers, like the compact ones msgpack decodes.
+func widenInteger(value interface{}) interface{} {
+
+	switch value := value.(type) {
+
+	case int:
+
+		return int64(value)
+
+	case int8:
+
+		return int64(value)
+
+	case int16:
+
+		return int64(value)
+
+	case uint:
+
+		return uint64(value)
+
+	case uint8:
+
+		return uint64(value)
+
+	case uint16:
+
+		return uint64(value)
+
+	}
+
+	return value
+
+}
+
+func toFloat64(value interface{}) (float64, error) {
+
+	switch value := value.(type) {
+
+	case float64:
+
+		return value, nil
+
+	case float32:
+
+		return float64(value), nil
+
+	case int64:
+
+		return float64(value), nil
+
+	case int32:
+
+		return float64(value), nil
+
+	case uint64:
+
+		return float64(value), nil
+
+	case uint32:
+
+		return float64(value), nil
+
+	case bool:
+
+		if value {
+
+			return 1, nil
+
+		}
+
+		return 0, nil
+
+	case string:
+
+		floatValue, err := strconv.ParseFloat(value, 64)
+
+		if err != nil {
+
+			return 0, fmt.Errorf("%w: %q is not a number", ErrNotConvertible, value)
+
+		}
+
+		return floatValue, nil
+
+	}
+
+	return 0, fmt.Errorf("%w: %v (%T)", ErrNotConvertible, value, value)
+
+}
+
+func toInt64(value interface{}) (int64, error) {
+
+	switch value := value.(type) {
+
+	case int64:
+
+		return value, nil
+
+	case int32:
+
+		return int64(value), nil
+
+	case uint32:
+
+		return int64(value), nil
+
+	case uint64:
+
+		if value > math.MaxInt64 {
+
+			return 0, fmt.Errorf("%w: %v out of int64 range", ErrNotConvertible, value)
+
+		}
+
+		return int64(value), nil
+
+	case string:
+
+		if intValue, err := strconv.ParseInt(value, 10, 64); err == nil {
+
+			return intValue, nil
+
+		}
+
+	}
+
+	floatValue, err := toFloat64(value)
+
+	if err != nil {
+
+		return 0, err
+
+	}
+
+	if math.IsNaN(floatValue) || floatValue < math.MinInt64 || floatValue >= math.MaxInt64 {
+
+		return 0, fmt.Errorf("%w: %v out of int64 range", ErrNotConvertible, value)
+
+	}
+
+	return int64(floatValue), nil
+
+}
+
+func toUint64(value interface{}) (uint64, error) {
+
+	switch value := value.(type) {
+
+	case uint64:
+
+		return value, nil
+
+	case uint32:
+
+		return uint64(value), nil
+
+	case int64, int32:
+
+		intValue, _ := toInt64(value)
+
+		if intValue < 0 {
+
+			return 0, fmt.Errorf("%w: %v is negative", ErrNotConvertible, value)
+
+		}
+
+		return uint64(intValue), nil
+
+	case string:
+
+		if uintValue, err := strconv.ParseUint(value, 10, 64); err == nil {
+
+			return uintValue, nil
+
+		}
+
+	}
+
+	floatValue, err := toFloat64(value)
+
+	if err != nil {
+
+		return 0, err
+
+	}
+
+	if math.IsNaN(floatValue) || floatValue < 0 || floatValue >= math.MaxUint64 {
+
+		return 0, fmt.Errorf("%w: %v out of uint64 range", ErrNotConvertible, value)
+
+	}
+
+	return uint64(floatValue), nil
+
+}
+
+func toBool(value interface{}) (bool, error) {
+
+	switch value := value.(type) {
+
+	case bool:
+
+		return value, nil
+
+	case string:
+
+		boolValue, err := strconv.ParseBool(value)
+
+		if err != nil {
+
+			return false, fmt.Errorf("%w: %q is not a bool", ErrNotConvertible, value)
+
+		}
+
+		return boolValue, nil
+
+	}
+
+	floatValue, err := toFloat64(value)
+
+	return floatValue != 0, err
+
+}
@@ -0,0 +1,52 @@
+package containers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConvertValue(t *testing.T) {
+
+	conversions := []struct {
+		value    interface{}
+		dataType string
+		expected interface{}
+	}{
+		{uint64(42), "float64", 42.0},
+		{2.9, "uint64", uint64(2)},
+		{-2.9, "int64", int64(-2)},
+		{int64(7), "string", "7"},
+		{1.5, "string", "1.5"},
+		{"12", "uint32", uint32(12)},
+		{"2.5", "float32", float32(2.5)},
+		{uint8(3), "int32", int32(3)},
+		{true, "float64", 1.0},
+		{"false", "bool", false},
+		{2.0, "bool", true},
+		{true, "enum", "true"},
+	}
+
+	for _, conversion := range conversions {
+
+		if value, err := ConvertValue(conversion.value, conversion.dataType); err != nil || value != conversion.expected {
+
+			t.Errorf("%v to %s: expected %v, got %v, %v", conversion.value, conversion.dataType, conversion.expected, value, err)
+
+		}
+
+	}
+
+	for _, conversion := range []struct {
+		value    interface{}
+		dataType string
+	}{{"up", "float64"}, {-1.0, "uint64"}, {int64(1 << 40), "int32"}, {uint64(1 << 63), "int64"}} {
+
+		if _, err := ConvertValue(conversion.value, conversion.dataType); !errors.Is(err, ErrNotConvertible) {
+
+			t.Errorf("%v to %s: expected %v, got %v", conversion.value, conversion.dataType, ErrNotConvertible, err)
+
+		}
+
+	}
+
+}
@@ -1,19 +1,75 @@
 package containers
 
 import (
+	. "datastore/utils"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
+	"reflect"
 )
 
+// DataPoint timestamps are in the precision of their counter, or of their query once read.
 type DataPoint struct {
-	Timestamp uint32 `json:"timestamp" msgpack:"timestamp"`
+	Timestamp uint64 `json:"timestamp" msgpack:"timestamp"`
 
 	Value interface{} `json:"value" msgpack:"value"`
 }
 
-func SerializeBatch(data []DataPoint, dataContainer *[]byte, dataType string) error {
+// timestampSize is the size of the stored timestamps of the precision. Seconds keep the 4 bytes layout of the days
+// stored before precisions existed, milliseconds take 8 bytes.
+func timestampSize(precision string) int {
+
+	if NormalizePrecision(precision) == PrecisionMilliseconds {
+
+		return 8
+
+	}
+
+	return 4
+
+}
+
+func putTimestamp(buffer []byte, timestamp uint64, size int) {
+
+	if size == 8 {
+
+		binary.LittleEndian.PutUint64(buffer, timestamp)
+
+	} else {
+
+		binary.LittleEndian.PutUint32(buffer, uint32(timestamp))
+
+	}
+
+}
+
+func appendTimestamp(buffer []byte, timestamp uint64, size int) []byte {
+
+	if size == 8 {
+
+		return binary.LittleEndian.AppendUint64(buffer, timestamp)
+
+	}
+
+	return binary.LittleEndian.AppendUint32(buffer, uint32(timestamp))
+
+}
+
+func readTimestamp(buffer []byte, size int) uint64 {
+
+	if size == 8 {
+
+		return binary.LittleEndian.Uint64(buffer)
+
+	}
+
+	return uint64(binary.LittleEndian.Uint32(buffer))
+
+}
+
+// SerializeBatch serializes the dataPoints of a counter of the dataType, with timestamps of the precision.
+func SerializeBatch(data []DataPoint, dataContainer *[]byte, dataType string, precision string) error {
 
 	if len(data) == 0 {
 
@@ -21,135 +77,169 @@ func SerializeBatch(data []DataPoint, dataContainer *[]byte, dataType string) er
 
 	}
 
+	size := timestampSize(precision)
+
 	switch dataType {
 
 	case "float64":
 
-		serializeFloat64(data, dataContainer)
+		serializeFloat64(data, dataContainer, size)
 
 		return nil
 
 	case "float32":
 
-		serializeFloat32(data, dataContainer)
+		serializeFloat32(data, dataContainer, size)
 
 		return nil
 
 	case "uint64", "uint", "int64", "int":
 
-		serializeUint64(data, dataContainer)
+		serializeUint64(data, dataContainer, size)
 
 		return nil
 
 	case "uint32", "int32":
 
-		serializeUint32(data, dataContainer)
+		serializeUint32(data, dataContainer, size)
 
 		return nil
 
 	case "string":
 
-		serializeStrings(data, dataContainer)
+		serializeStrings(data, dataContainer, size)
+
+		return nil
+
+	case "bool":
+
+		serializeBools(data, dataContainer, size)
 
 		return nil
+
+	case "enum":
+
+		return serializeEnums(data, dataContainer, size)
+
+	case "histogram":
+
+		return serializeHistograms(data, dataContainer, size)
+
 	default:
 		return fmt.Errorf("unsupported data type: %s", dataType)
 	}
 }
 
-func serializeFloat64(data []DataPoint, dataContainer *[]byte) {
+func serializeFloat64(data []DataPoint, dataContainer *[]byte, timestampSize int) {
+
+	pointSize := timestampSize + 8
 
-	if cap(*dataContainer) < len(data)*12 {
+	if cap(*dataContainer) < len(data)*pointSize {
 
-		*dataContainer = make([]byte, len(data)*12)
+		*dataContainer = make([]byte, len(data)*pointSize)
 
 	} else {
 
-		*dataContainer = (*dataContainer)[:len(data)*12]
+		*dataContainer = (*dataContainer)[:len(data)*pointSize]
 
 	}
 
 	for index, dataPoint := range data {
 
-		binary.LittleEndian.PutUint32((*dataContainer)[index*12:index*12+4], dataPoint.Timestamp)
+		offset := index * pointSize
 
-		binary.LittleEndian.PutUint64((*dataContainer)[index*12+4:index*12+12], math.Float64bits(dataPoint.Value.(float64)))
+		putTimestamp((*dataContainer)[offset:], dataPoint.Timestamp, timestampSize)
+
+		binary.LittleEndian.PutUint64((*dataContainer)[offset+timestampSize:offset+pointSize], math.Float64bits(dataPoint.Value.(float64)))
 
 	}
 }
 
-func serializeFloat32(data []DataPoint, dataContainer *[]byte) {
+func serializeFloat32(data []DataPoint, dataContainer *[]byte, timestampSize int) {
+
+	pointSize := timestampSize + 4
 
-	if cap(*dataContainer) < len(data)*8 {
+	if cap(*dataContainer) < len(data)*pointSize {
 
-		*dataContainer = make([]byte, len(data)*8)
+		*dataContainer = make([]byte, len(data)*pointSize)
 
 	} else {
 
-		*dataContainer = (*dataContainer)[:len(data)*8]
+		*dataContainer = (*dataContainer)[:len(data)*pointSize]
 
 	}
 
 	for index, dataPoint := range data {
 
-		binary.LittleEndian.PutUint32((*dataContainer)[index*8:index*8+4], dataPoint.Timestamp)
+		offset := index * pointSize
 
-		binary.LittleEndian.PutUint32((*dataContainer)[index*8+4:index*8+8], math.Float32bits(dataPoint.Value.(float32)))
+		putTimestamp((*dataContainer)[offset:], dataPoint.Timestamp, timestampSize)
+
+		binary.LittleEndian.PutUint32((*dataContainer)[offset+timestampSize:offset+pointSize], math.Float32bits(float32Value(dataPoint.Value)))
 
 	}
 }
 
-func serializeUint64(data []DataPoint, dataContainer *[]byte) {
+func serializeUint64(data []DataPoint, dataContainer *[]byte, timestampSize int) {
+
+	pointSize := timestampSize + 8
 
-	if cap(*dataContainer) < len(data)*12 {
+	if cap(*dataContainer) < len(data)*pointSize {
 
-		*dataContainer = make([]byte, len(data)*12)
+		*dataContainer = make([]byte, len(data)*pointSize)
 
 	} else {
 
-		*dataContainer = (*dataContainer)[:len(data)*12]
+		*dataContainer = (*dataContainer)[:len(data)*pointSize]
 
 	}
 
 	for index, dataPoint := range data {
 
-		binary.LittleEndian.PutUint32((*dataContainer)[index*12:index*12+4], dataPoint.Timestamp)
+		offset := index * pointSize
+
+		putTimestamp((*dataContainer)[offset:], dataPoint.Timestamp, timestampSize)
 
-		binary.LittleEndian.PutUint64((*dataContainer)[index*12+4:index*12+12], uint64(dataPoint.Value.(float64)))
+		binary.LittleEndian.PutUint64((*dataContainer)[offset+timestampSize:offset+pointSize], uint64Bits(dataPoint.Value))
 
 	}
 }
 
-func serializeUint32(data []DataPoint, dataContainer *[]byte) {
+func serializeUint32(data []DataPoint, dataContainer *[]byte, timestampSize int) {
 
-	if cap(*dataContainer) < len(data)*8 {
+	pointSize := timestampSize + 4
 
-		*dataContainer = make([]byte, len(data)*8)
+	if cap(*dataContainer) < len(data)*pointSize {
+
+		*dataContainer = make([]byte, len(data)*pointSize)
 
 	} else {
 
-		*dataContainer = (*dataContainer)[:len(data)*8]
+		*dataContainer = (*dataContainer)[:len(data)*pointSize]
 
 	}
 
 	for index, dataPoint := range data {
 
-		binary.LittleEndian.PutUint32((*dataContainer)[index*8:index*8+4], dataPoint.Timestamp)
+		offset := index * pointSize
 
-		binary.LittleEndian.PutUint32((*dataContainer)[index*8+4:index*8+8], uint32(dataPoint.Value.(float64)))
+		putTimestamp((*dataContainer)[offset:], dataPoint.Timestamp, timestampSize)
 
-	}
+		binary.LittleEndian.PutUint32((*dataContainer)[offset+timestampSize:offset+pointSize], uint32Bits(dataPoint.Value))
 
+	}
 }
 
-func serializeStrings(data []DataPoint, dataContainer *[]byte) {
+func serializeStrings(data []DataPoint, dataContainer *[]byte, timestampSize int) {
 	// Serialize string
 
+	headerSize := timestampSize + 4
+
 	bufferSize := 0
 
 	for _, value := range data {
 
-		bufferSize += len(value.Value.(string)) + 8
+		bufferSize += len(value.Value.(string)) + headerSize
 
 	}
 
@@ -166,49 +256,245 @@ func serializeStrings(data []DataPoint, dataContainer *[]byte) {
 	offset := 0
 	for _, value := range data {
 
-		binary.LittleEndian.PutUint32((*dataContainer)[offset:offset+4], value.Timestamp)
+		putTimestamp((*dataContainer)[offset:], value.Timestamp, timestampSize)
 
 		val := value.Value.(string)
 
-		binary.LittleEndian.PutUint32((*dataContainer)[offset+4:offset+8], uint32(len(val)))
+		binary.LittleEndian.PutUint32((*dataContainer)[offset+timestampSize:offset+headerSize], uint32(len(val)))
+
+		copy((*dataContainer)[offset+headerSize:offset+headerSize+len(val)], val)
+
+		offset += headerSize + len(val)
+
+	}
+}
+
+// Bools, enums and histograms are written in frames, a frame per serialized batch, as every batch is appended to the
+// series. A frame of bools holds its count, the timestamps then the values packed 8 per byte. A frame of enums holds
+// its count, its dictionary of distinct values then the timestamps each followed by the index of its value.
+
+const frameHeaderSize = 4
+
+func serializeBools(data []DataPoint, dataContainer *[]byte, timestampSize int) {
+
+	frameSize := frameHeaderSize + len(data)*timestampSize + (len(data)+7)/8
+
+	if cap(*dataContainer) < frameSize {
+
+		*dataContainer = make([]byte, frameSize)
+
+	} else {
+
+		*dataContainer = (*dataContainer)[:frameSize]
+
+	}
+
+	binary.LittleEndian.PutUint32(*dataContainer, uint32(len(data)))
+
+	bits := (*dataContainer)[frameHeaderSize+len(data)*timestampSize:]
+
+	clear(bits)
+
+	for index, dataPoint := range data {
+
+		putTimestamp((*dataContainer)[frameHeaderSize+index*timestampSize:], dataPoint.Timestamp, timestampSize)
+
+		if dataPoint.Value.(bool) {
+
+			bits[index/8] |= 1 << (index % 8)
+
+		}
+
+	}
+
+}
+
+func serializeEnums(data []DataPoint, dataContainer *[]byte, timestampSize int) error {
+
+	indexes := make(map[string]uint16)
+
+	var dictionary []string
+
+	for _, dataPoint := range data {
+
+		value := dataPoint.Value.(string)
+
+		if _, ok := indexes[value]; ok {
+
+			continue
+
+		}
+
+		if len(dictionary) == math.MaxUint16 || len(value) > math.MaxUint16 {
+
+			return errors.New("enum batch exceeds the dictionary limits")
+
+		}
+
+		indexes[value] = uint16(len(dictionary))
+
+		dictionary = append(dictionary, value)
+
+	}
+
+	frame := binary.LittleEndian.AppendUint32((*dataContainer)[:0], uint32(len(data)))
+
+	frame = binary.LittleEndian.AppendUint16(frame, uint16(len(dictionary)))
+
+	for _, value := range dictionary {
+
+		frame = binary.LittleEndian.AppendUint16(frame, uint16(len(value)))
+
+		frame = append(frame, value...)
+
+	}
+
+	for _, dataPoint := range data {
+
+		frame = appendTimestamp(frame, dataPoint.Timestamp, timestampSize)
+
+		frame = binary.LittleEndian.AppendUint16(frame, indexes[dataPoint.Value.(string)])
 
-		copy((*dataContainer)[offset+8:offset+8+len(val)], val)
+	}
+
+	*dataContainer = frame
+
+	return nil
+
+}
+
+// Every histogram is written as its timestamp, its number of buckets, its bounds then its counts.
+func serializeHistograms(data []DataPoint, dataContainer *[]byte, timestampSize int) error {
+
+	buffer := (*dataContainer)[:0]
+
+	for _, dataPoint := range data {
+
+		histogram := dataPoint.Value.(Histogram)
+
+		if err := histogram.validate(); err != nil {
+
+			return err
+
+		}
+
+		buffer = appendTimestamp(buffer, dataPoint.Timestamp, timestampSize)
+
+		buffer = binary.LittleEndian.AppendUint16(buffer, uint16(len(histogram.Bounds)))
+
+		for _, bound := range histogram.Bounds {
+
+			buffer = binary.LittleEndian.AppendUint64(buffer, math.Float64bits(bound))
+
+		}
 
-		offset += 8 + len(val)
+		for _, count := range histogram.Counts {
+
+			buffer = binary.LittleEndian.AppendUint64(buffer, count)
+
+		}
+
+	}
+
+	*dataContainer = buffer
+
+	return nil
+
+}
+
+// Polled values are float64, converted values keep the type given by DeserializeBatch
+
+func float32Value(value interface{}) float32 {
+
+	if floatValue, ok := value.(float32); ok {
+
+		return floatValue
 
 	}
+
+	return float32(value.(float64))
+
+}
+
+func uint64Bits(value interface{}) uint64 {
+
+	switch value := value.(type) {
+
+	case uint64:
+
+		return value
+
+	case int64:
+
+		return uint64(value)
+
+	}
+
+	return uint64(value.(float64))
+
+}
+
+func uint32Bits(value interface{}) uint32 {
+
+	switch value := value.(type) {
+
+	case uint32:
+
+		return value
+
+	case int32:
+
+		return uint32(value)
+
+	}
+
+	return uint32(value.(float64))
+
 }
 
 // --------------- Deserialize-------------
 
-func DeserializeBatch(data []byte, dataType string) ([]DataPoint, error) {
+// DeserializeBatch deserializes the dataPoints of a counter of the dataType, stored with timestamps of the precision.
+// The days stored before precisions existed are of PrecisionSeconds.
+func DeserializeBatch(data []byte, dataType string, precision string) ([]DataPoint, error) {
 
 	if len(data) == 0 {
 		return nil, nil
 	}
 
+	size := timestampSize(precision)
+
 	switch dataType {
 
 	case "float64":
-		return deserializeFloat64(data)
+		return deserializeFloat64(data, size)
 
 	case "float32":
-		return deserializeFloat32(data)
+		return deserializeFloat32(data, size)
 
 	case "int64", "int":
-		return deserializeInt64(data)
+		return deserializeInt64(data, size)
 
 	case "int32":
-		return deserializeInt32(data)
+		return deserializeInt32(data, size)
 
 	case "uint64", "uint":
-		return deserializeUint64(data)
+		return deserializeUint64(data, size)
 
 	case "uint32":
-		return deserializeUint32(data)
+		return deserializeUint32(data, size)
 
 	case "string":
-		return deserializeStrings(data)
+		return deserializeStrings(data, size)
+
+	case "bool":
+		return deserializeBools(data, size)
+
+	case "enum":
+		return deserializeEnums(data, size)
+
+	case "histogram":
+		return deserializeHistograms(data, size)
 
 	default:
 		return nil, fmt.Errorf("unsupported data type: %s", dataType)
@@ -217,21 +503,23 @@ func DeserializeBatch(data []byte, dataType string) ([]DataPoint, error) {
 
 }
 
-func deserializeFloat64(data []byte) ([]DataPoint, error) {
+func deserializeFloat64(data []byte, timestampSize int) ([]DataPoint, error) {
+
+	pointSize := timestampSize + 8
 
-	if len(data)%12 != 0 {
+	if len(data)%pointSize != 0 {
 		return nil, errors.New("invalid data length for float64")
 	}
 
-	count := len(data) / 12
+	count := len(data) / pointSize
 
 	points := make([]DataPoint, count)
 
 	for i := 0; i < count; i++ {
 
-		timestamp := binary.LittleEndian.Uint32(data[i*12 : i*12+4])
+		timestamp := readTimestamp(data[i*pointSize:], timestampSize)
 
-		valueBits := binary.LittleEndian.Uint64(data[i*12+4 : i*12+12])
+		valueBits := binary.LittleEndian.Uint64(data[i*pointSize+timestampSize : (i+1)*pointSize])
 
 		points[i] = DataPoint{
 
@@ -246,21 +534,23 @@ func deserializeFloat64(data []byte) ([]DataPoint, error) {
 
 }
 
-func deserializeFloat32(data []byte) ([]DataPoint, error) {
+func deserializeFloat32(data []byte, timestampSize int) ([]DataPoint, error) {
 
-	if len(data)%8 != 0 {
+	pointSize := timestampSize + 4
+
+	if len(data)%pointSize != 0 {
 		return nil, errors.New("invalid data length for float32")
 	}
 
-	count := len(data) / 8
+	count := len(data) / pointSize
 
 	points := make([]DataPoint, count)
 
 	for i := 0; i < count; i++ {
 
-		timestamp := binary.LittleEndian.Uint32(data[i*8 : i*8+4])
+		timestamp := readTimestamp(data[i*pointSize:], timestampSize)
 
-		valueBits := binary.LittleEndian.Uint32(data[i*8+4 : i*8+8])
+		valueBits := binary.LittleEndian.Uint32(data[i*pointSize+timestampSize : (i+1)*pointSize])
 
 		points[i] = DataPoint{
 
@@ -275,21 +565,23 @@ func deserializeFloat32(data []byte) ([]DataPoint, error) {
 
 }
 
-func deserializeInt64(data []byte) ([]DataPoint, error) {
+func deserializeInt64(data []byte, timestampSize int) ([]DataPoint, error) {
+
+	pointSize := timestampSize + 8
 
-	if len(data)%12 != 0 {
+	if len(data)%pointSize != 0 {
 		return nil, errors.New("invalid data length for int64")
 	}
 
-	count := len(data) / 12
+	count := len(data) / pointSize
 
 	points := make([]DataPoint, count)
 
 	for i := 0; i < count; i++ {
 
-		timestamp := binary.LittleEndian.Uint32(data[i*12 : i*12+4])
+		timestamp := readTimestamp(data[i*pointSize:], timestampSize)
 
-		value := int64(binary.LittleEndian.Uint64(data[i*12+4 : i*12+12]))
+		value := int64(binary.LittleEndian.Uint64(data[i*pointSize+timestampSize : (i+1)*pointSize]))
 
 		points[i] = DataPoint{Timestamp: timestamp, Value: value}
 
@@ -299,21 +591,23 @@ func deserializeInt64(data []byte) ([]DataPoint, error) {
 
 }
 
-func deserializeInt32(data []byte) ([]DataPoint, error) {
+func deserializeInt32(data []byte, timestampSize int) ([]DataPoint, error) {
 
-	if len(data)%8 != 0 {
+	pointSize := timestampSize + 4
+
+	if len(data)%pointSize != 0 {
 		return nil, errors.New("invalid data length for int32")
 	}
 
-	count := len(data) / 8
+	count := len(data) / pointSize
 
 	points := make([]DataPoint, count)
 
 	for i := 0; i < count; i++ {
 
-		timestamp := binary.LittleEndian.Uint32(data[i*8 : i*8+4])
+		timestamp := readTimestamp(data[i*pointSize:], timestampSize)
 
-		value := int32(binary.LittleEndian.Uint32(data[i*8+4 : i*8+8]))
+		value := int32(binary.LittleEndian.Uint32(data[i*pointSize+timestampSize : (i+1)*pointSize]))
 
 		points[i] = DataPoint{Timestamp: timestamp, Value: value}
 
@@ -323,21 +617,23 @@ func deserializeInt32(data []byte) ([]DataPoint, error) {
 
 }
 
-func deserializeUint64(data []byte) ([]DataPoint, error) {
+func deserializeUint64(data []byte, timestampSize int) ([]DataPoint, error) {
+
+	pointSize := timestampSize + 8
 
-	if len(data)%12 != 0 {
+	if len(data)%pointSize != 0 {
 		return nil, errors.New("invalid data length for uint64")
 	}
 
-	count := len(data) / 12
+	count := len(data) / pointSize
 
 	points := make([]DataPoint, count)
 
 	for i := 0; i < count; i++ {
 
-		timestamp := binary.LittleEndian.Uint32(data[i*12 : i*12+4])
+		timestamp := readTimestamp(data[i*pointSize:], timestampSize)
 
-		value := binary.LittleEndian.Uint64(data[i*12+4 : i*12+12])
+		value := binary.LittleEndian.Uint64(data[i*pointSize+timestampSize : (i+1)*pointSize])
 
 		points[i] = DataPoint{Timestamp: timestamp, Value: value}
 
@@ -347,21 +643,23 @@ func deserializeUint64(data []byte) ([]DataPoint, error) {
 
 }
 
-func deserializeUint32(data []byte) ([]DataPoint, error) {
+func deserializeUint32(data []byte, timestampSize int) ([]DataPoint, error) {
 
-	if len(data)%8 != 0 {
+	pointSize := timestampSize + 4
+
+	if len(data)%pointSize != 0 {
 		return nil, errors.New("invalid data length for uint32")
 	}
 
-	count := len(data) / 8
+	count := len(data) / pointSize
 
 	points := make([]DataPoint, count)
 
 	for i := 0; i < count; i++ {
 
-		timestamp := binary.LittleEndian.Uint32(data[i*8 : i*8+4])
+		timestamp := readTimestamp(data[i*pointSize:], timestampSize)
 
-		value := binary.LittleEndian.Uint32(data[i*8+4 : i*8+8])
+		value := binary.LittleEndian.Uint32(data[i*pointSize+timestampSize : (i+1)*pointSize])
 
 		points[i] = DataPoint{Timestamp: timestamp, Value: value}
 
@@ -371,27 +669,29 @@ func deserializeUint32(data []byte) ([]DataPoint, error) {
 
 }
 
-func deserializeStrings(data []byte) ([]DataPoint, error) {
+func deserializeStrings(data []byte, timestampSize int) ([]DataPoint, error) {
 
 	var points []DataPoint
 
+	headerSize := timestampSize + 4
+
 	offset := 0
 
 	for offset < len(data) {
 
-		if offset+8 > len(data) {
+		if offset+headerSize > len(data) {
 			return nil, errors.New("unexpected end of data")
 		}
 
-		timestamp := binary.LittleEndian.Uint32(data[offset : offset+4])
+		timestamp := readTimestamp(data[offset:], timestampSize)
 
-		length := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+		length := binary.LittleEndian.Uint32(data[offset+timestampSize : offset+headerSize])
 
-		if offset+8+int(length) > len(data) {
+		if offset+headerSize+int(length) > len(data) {
 			return nil, errors.New("string length goes out of bounds")
 		}
 
-		value := string(data[offset+8 : offset+8+int(length)])
+		value := string(data[offset+headerSize : offset+headerSize+int(length)])
 
 		points = append(points, DataPoint{
 
@@ -400,9 +700,203 @@ func deserializeStrings(data []byte) ([]DataPoint, error) {
 			Value: value,
 		})
 
-		offset += 8 + int(length)
+		offset += headerSize + int(length)
+
+	}
+
+	return points, nil
+}
+
+func deserializeBools(data []byte, timestampSize int) ([]DataPoint, error) {
+
+	var points []DataPoint
+
+	for offset := 0; offset < len(data); {
+
+		if offset+frameHeaderSize > len(data) {
+			return nil, errors.New("unexpected end of data")
+		}
+
+		count := int(binary.LittleEndian.Uint32(data[offset:]))
+
+		timestamps := offset + frameHeaderSize
+
+		bits := timestamps + count*timestampSize
+
+		if bits+(count+7)/8 > len(data) {
+			return nil, errors.New("bool frame goes out of bounds")
+		}
+
+		for index := range count {
+
+			points = append(points, DataPoint{
+
+				Timestamp: readTimestamp(data[timestamps+index*timestampSize:], timestampSize),
+
+				Value: data[bits+index/8]&(1<<(index%8)) != 0,
+			})
+
+		}
+
+		offset = bits + (count+7)/8
 
 	}
 
 	return points, nil
+
+}
+
+func deserializeEnums(data []byte, timestampSize int) ([]DataPoint, error) {
+
+	var points []DataPoint
+
+	for offset := 0; offset < len(data); {
+
+		if offset+frameHeaderSize+2 > len(data) {
+			return nil, errors.New("unexpected end of data")
+		}
+
+		count := int(binary.LittleEndian.Uint32(data[offset:]))
+
+		dictionary := make([]string, binary.LittleEndian.Uint16(data[offset+frameHeaderSize:]))
+
+		offset += frameHeaderSize + 2
+
+		for index := range dictionary {
+
+			if offset+2 > len(data) {
+				return nil, errors.New("unexpected end of data")
+			}
+
+			length := int(binary.LittleEndian.Uint16(data[offset:]))
+
+			if offset+2+length > len(data) {
+				return nil, errors.New("enum value goes out of bounds")
+			}
+
+			dictionary[index] = string(data[offset+2 : offset+2+length])
+
+			offset += 2 + length
+
+		}
+
+		pointSize := timestampSize + 2
+
+		if offset+count*pointSize > len(data) {
+			return nil, errors.New("enum frame goes out of bounds")
+		}
+
+		for range count {
+
+			valueIndex := int(binary.LittleEndian.Uint16(data[offset+timestampSize:]))
+
+			if valueIndex >= len(dictionary) {
+				return nil, errors.New("enum value index goes out of the dictionary")
+			}
+
+			points = append(points, DataPoint{
+
+				Timestamp: readTimestamp(data[offset:], timestampSize),
+
+				Value: dictionary[valueIndex],
+			})
+
+			offset += pointSize
+
+		}
+
+	}
+
+	return points, nil
+
+}
+
+func deserializeHistograms(data []byte, timestampSize int) ([]DataPoint, error) {
+
+	var points []DataPoint
+
+	headerSize := timestampSize + 2
+
+	for offset := 0; offset < len(data); {
+
+		if offset+headerSize > len(data) {
+			return nil, errors.New("unexpected end of data")
+		}
+
+		buckets := int(binary.LittleEndian.Uint16(data[offset+timestampSize:]))
+
+		if offset+headerSize+buckets*16 > len(data) {
+			return nil, errors.New("histogram goes out of bounds")
+		}
+
+		histogram := Histogram{Bounds: make([]float64, buckets), Counts: make([]uint64, buckets)}
+
+		bounds, counts := offset+headerSize, offset+headerSize+buckets*8
+
+		for index := range buckets {
+
+			histogram.Bounds[index] = math.Float64frombits(binary.LittleEndian.Uint64(data[bounds+index*8:]))
+
+			histogram.Counts[index] = binary.LittleEndian.Uint64(data[counts+index*8:])
+
+		}
+
+		points = append(points, DataPoint{
+
+			Timestamp: readTimestamp(data[offset:], timestampSize),
+
+			Value: histogram,
+		})
+
+		offset += headerSize + buckets*16
+
+	}
+
+	return points, nil
+
+}
+
+// NumericValue returns the value of a numeric or bool dataPoint as a float64, bools counting as 1 and 0. Unlike
+// ConvertValue, strings are not parsed.
+func NumericValue(value interface{}) (float64, bool) {
+
+	if value == nil {
+
+		return 0, false
+
+	}
+
+	reflectValue := reflect.ValueOf(value)
+
+	switch reflectValue.Kind() {
+
+	case reflect.Float32, reflect.Float64:
+
+		return reflectValue.Float(), true
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+
+		return float64(reflectValue.Int()), true
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+
+		return float64(reflectValue.Uint()), true
+
+	case reflect.Bool:
+
+		// Bools aggregate as 1 and 0, their average being the uptime ratio
+		if reflectValue.Bool() {
+
+			return 1, true
+
+		}
+
+		return 0, true
+
+	default:
+
+		return 0, false
+
+	}
+
 }
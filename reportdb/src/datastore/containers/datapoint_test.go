@@ -1,7 +1,11 @@
 package containers
 
 import (
+	. "datastore/utils"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -18,11 +22,11 @@ func TestDeserializeBatch(t *testing.T) {
 
 	serialData := make([]byte, 0)
 
-	_ = SerializeBatch(data, &serialData, "int64")
+	_ = SerializeBatch(data, &serialData, "int64", PrecisionSeconds)
 
 	fmt.Println(serialData)
 
-	newData, err := DeserializeBatch(serialData, "int64")
+	newData, err := DeserializeBatch(serialData, "int64", PrecisionSeconds)
 
 	if err != nil {
 
@@ -33,3 +37,125 @@ func TestDeserializeBatch(t *testing.T) {
 	fmt.Println(newData)
 
 }
+
+func TestSerializeBatchPrecision(t *testing.T) {
+
+	data := []DataPoint{{1744089990123, 1.5}, {1744089991456, 2.5}}
+
+	var serialData []byte
+
+	if err := SerializeBatch(data, &serialData, "float64", PrecisionMilliseconds); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	newData, err := DeserializeBatch(serialData, "float64", PrecisionMilliseconds)
+
+	if err != nil || !reflect.DeepEqual(newData, data) {
+
+		t.Errorf("unexpected milliseconds dataPoints %v, %v", newData, err)
+
+	}
+
+	// Days stored before precisions existed hold 4 bytes timestamps followed by the value
+	legacyData := binary.LittleEndian.AppendUint32(nil, 1744089990)
+
+	legacyData = binary.LittleEndian.AppendUint64(legacyData, 7)
+
+	newData, err = DeserializeBatch(legacyData, "uint64", PrecisionSeconds)
+
+	if err != nil || len(newData) != 1 || newData[0].Timestamp != 1744089990 || newData[0].Value != uint64(7) {
+
+		t.Errorf("unexpected seconds dataPoints %v, %v", newData, err)
+
+	}
+
+	serialData = serialData[:0]
+
+	if err = SerializeBatch(newData, &serialData, "uint64", ""); err != nil || !reflect.DeepEqual(serialData, legacyData) {
+
+		t.Errorf("seconds layout changed: %v, %v", serialData, err)
+
+	}
+
+}
+
+func TestSerializeBatchTypes(t *testing.T) {
+
+	batches := map[string][][]DataPoint{
+		"bool": {{{1744089990, true}, {1744089991, false}, {1744089992, true}}, {{1744089993, false}}},
+		"enum": {{{1744089990, "up"}, {1744089991, "down"}, {1744089992, "up"}}, {{1744089993, "testing"}}},
+		"histogram": {
+			{{1744089990, Histogram{Bounds: []float64{10, 100}, Counts: []uint64{3, 1}}}},
+			{{1744089991, Histogram{Bounds: []float64{-5, 5, 50}, Counts: []uint64{0, 2, 7}}}},
+		},
+	}
+
+	for dataType, dataBatches := range batches {
+
+		var serialData, batchData []byte
+
+		var expected []DataPoint
+
+		// Batches are appended to the day as they are flushed
+		for _, data := range dataBatches {
+
+			if err := SerializeBatch(data, &batchData, dataType, PrecisionSeconds); err != nil {
+
+				t.Fatal(dataType, err)
+
+			}
+
+			serialData = append(serialData, batchData...)
+
+			expected = append(expected, data...)
+
+		}
+
+		newData, err := DeserializeBatch(serialData, dataType, PrecisionSeconds)
+
+		if err != nil || !reflect.DeepEqual(newData, expected) {
+
+			t.Errorf("unexpected %s dataPoints %v, %v", dataType, newData, err)
+
+		}
+
+	}
+
+	var serialData []byte
+
+	if err := SerializeBatch([]DataPoint{{1744089990, Histogram{Bounds: []float64{10, 5}, Counts: []uint64{1, 1}}}}, &serialData, "histogram", PrecisionSeconds); !errors.Is(err, ErrInvalidHistogram) {
+
+		t.Errorf("expected %v, got %v", ErrInvalidHistogram, err)
+
+	}
+
+}
+
+func TestNumericValue(t *testing.T) {
+
+	for _, test := range []struct {
+		value interface{}
+
+		expected float64
+
+		ok bool
+	}{
+		{value: 2.5, expected: 2.5, ok: true},
+		{value: int32(-3), expected: -3, ok: true},
+		{value: uint8(7), expected: 7, ok: true},
+		{value: true, expected: 1, ok: true},
+		{value: "1.5"},
+		{value: nil},
+	} {
+
+		if value, ok := NumericValue(test.value); value != test.expected || ok != test.ok {
+
+			t.Errorf("%v: expected %v %v, got %v %v", test.value, test.expected, test.ok, value, ok)
+
+		}
+
+	}
+
+}
@@ -0,0 +1,205 @@
+package containers
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"os"
+	"slices"
+	"syscall"
+	"time"
+)
+
+var ErrDiskFull = errors.New("free disk space below MinFreeDiskSpaceInMB, ingest rejected")
+
+// CheckDiskSpace measures the free space of the storage directory, running the emergency retention and rejecting
+// ingest below the configured thresholds. Stored days are kept as they are, and reads keep working.
+func (storagePool *StoragePool) CheckDiskSpace() {
+
+	free, err := freeDiskSpace(storagePool.Config.StorageDirectory)
+
+	if err != nil {
+
+		storagePool.Config.Logger.Error("error measuring free disk space", zap.String("directory", storagePool.Config.StorageDirectory), zap.Error(err))
+
+		return
+
+	}
+
+	if retention := uint64(storagePool.Config.RetentionFreeDiskSpaceInMB) << 20; free < retention {
+
+		free = storagePool.emergencyRetention(free, retention)
+
+	}
+
+	storagePool.freeDiskSpace.Store(free)
+
+	rejected := free < uint64(storagePool.Config.MinFreeDiskSpaceInMB)<<20
+
+	if storagePool.ingestRejected.Swap(rejected) == rejected {
+
+		return
+
+	}
+
+	if rejected {
+
+		storagePool.Config.Logger.Warn("free disk space below the minimum, rejecting ingest", zap.Uint64("freeBytes", free), zap.Int64("minFreeDiskSpaceInMB", storagePool.Config.MinFreeDiskSpaceInMB))
+
+	} else {
+
+		storagePool.Config.Logger.Info("free disk space back above the minimum, accepting ingest", zap.Uint64("freeBytes", free))
+
+	}
+
+}
+
+// IngestAllowed returns ErrDiskFull while the free disk space is below MinFreeDiskSpaceInMB.
+func (storagePool *StoragePool) IngestAllowed() error {
+
+	if storagePool.ingestRejected.Load() {
+
+		return ErrDiskFull
+
+	}
+
+	return nil
+
+}
+
+// FreeDiskSpace returns the free bytes of the storage directory at the last check.
+func (storagePool *StoragePool) FreeDiskSpace() uint64 {
+
+	return storagePool.freeDiskSpace.Load()
+
+}
+
+func freeDiskSpace(directory string) (uint64, error) {
+
+	var stats syscall.Statfs_t
+
+	if err := syscall.Statfs(directory, &stats); err != nil {
+
+		return 0, err
+
+	}
+
+	return stats.Bavail * uint64(stats.Bsize), nil
+
+}
+
+// emergencyRetention deletes the oldest days, never today, till the free disk space is back to the retention
+// threshold. It returns the free disk space left.
+func (storagePool *StoragePool) emergencyRetention(free uint64, retention uint64) uint64 {
+
+	storagePool.maintenanceLock.Lock()
+
+	defer storagePool.maintenanceLock.Unlock()
+
+	keys := storagePool.storagesBefore(UnixToDate(time.Now().Unix()))
+
+	slices.SortFunc(keys, func(key, other StoragePoolKey) int {
+
+		if key.Date.Before(other.Date) {
+
+			return -1
+
+		}
+
+		if other.Date.Before(key.Date) {
+
+			return 1
+
+		}
+
+		return 0
+
+	})
+
+	for len(keys) > 0 && free < retention {
+
+		date := keys[0].Date
+
+		dayKeys := keys[:1]
+
+		for len(dayKeys) < len(keys) && keys[len(dayKeys)].Date == date {
+
+			dayKeys = keys[:len(dayKeys)+1]
+
+		}
+
+		keys = keys[len(dayKeys):]
+
+		if err := storagePool.deleteDay(date, dayKeys); err != nil {
+
+			storagePool.Config.Logger.Error("error deleting day", zap.String("date", date.Format()), zap.Error(err))
+
+			break
+
+		}
+
+		var err error
+
+		if free, err = freeDiskSpace(storagePool.Config.StorageDirectory); err != nil {
+
+			break
+
+		}
+
+		storagePool.Config.Logger.Warn("emergency retention deleted day", zap.String("date", date.Format()), zap.Int("counters", len(dayKeys)), zap.Uint64("freeBytes", free))
+
+	}
+
+	return free
+
+}
+
+// deleteDay closes the storages of the day and removes it from disk, along with its cached points and results.
+func (storagePool *StoragePool) deleteDay(date Date, keys []StoragePoolKey) error {
+
+	storagePool.lock.Lock()
+
+	defer storagePool.lock.Unlock()
+
+	for _, key := range keys {
+
+		if storage, ok := storagePool.pool[key]; ok {
+
+			storage.ClearStorage()
+
+			delete(storagePool.accessCount, key)
+
+			delete(storagePool.pool, key)
+
+		}
+
+		storagePool.Caches.InvalidateQueryResults(key)
+
+	}
+
+	storagePool.Caches.DataPoints.Clear()
+
+	return os.RemoveAll(storagePool.Config.StorageDirectory + "/" + date.Format())
+
+}
+
+func storagePoolDiskCheck(storagePool *StoragePool, diskCheckTicker *time.Ticker) {
+
+	defer diskCheckTicker.Stop()
+
+	for {
+
+		select {
+
+		case <-storagePool.cleanupShutdown:
+
+			return
+
+		case <-diskCheckTicker.C:
+
+			storagePool.CheckDiskSpace()
+
+		}
+
+	}
+
+}
@@ -0,0 +1,205 @@
+package containers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+var ErrInvalidHistogram = errors.New("invalid histogram, it must have as many counts as increasing finite bounds")
+
+// Histogram is the value of the histogram counters, like a latency distribution. Counts[i] values fell above
+// Bounds[i-1] and up to Bounds[i]. Bounds are finite, so that histograms are JSON encodable too.
+type Histogram struct {
+	Bounds []float64 `json:"bounds" msgpack:"bounds"`
+
+	Counts []uint64 `json:"counts" msgpack:"counts"`
+}
+
+func (histogram Histogram) validate() error {
+
+	if len(histogram.Bounds) == 0 || len(histogram.Bounds) != len(histogram.Counts) || len(histogram.Bounds) > math.MaxUint16 {
+
+		return ErrInvalidHistogram
+
+	}
+
+	for index, bound := range histogram.Bounds {
+
+		if math.IsInf(bound, 0) || math.IsNaN(bound) || index > 0 && !(histogram.Bounds[index-1] < bound) {
+
+			return ErrInvalidHistogram
+
+		}
+
+	}
+
+	return nil
+
+}
+
+// Total returns the number of values counted by the histogram.
+func (histogram Histogram) Total() uint64 {
+
+	var total uint64
+
+	for _, count := range histogram.Counts {
+
+		total += count
+
+	}
+
+	return total
+
+}
+
+// Percentile estimates the value below which the percentile (0-100) of the counted values fall, interpolating
+// linearly within the bucket. The lower edge of the first bucket is taken as 0, or as its bound when negative. ok is
+// false for an empty histogram.
+func (histogram Histogram) Percentile(percentile float64) (value float64, ok bool) {
+
+	total := histogram.Total()
+
+	if total == 0 {
+
+		return 0, false
+
+	}
+
+	rank := percentile / 100 * float64(total)
+
+	var cumulative uint64
+
+	for index, count := range histogram.Counts {
+
+		if count == 0 || float64(cumulative+count) < rank {
+
+			cumulative += count
+
+			continue
+
+		}
+
+		lower := min(0, histogram.Bounds[0])
+
+		if index > 0 {
+
+			lower = histogram.Bounds[index-1]
+
+		}
+
+		upper := histogram.Bounds[index]
+
+		return lower + (upper-lower)*max(0, rank-float64(cumulative))/float64(count), true
+
+	}
+
+	return histogram.Bounds[len(histogram.Bounds)-1], true
+
+}
+
+// MergeHistograms adds up the histograms over the union of their bounds, the counts of every bucket staying at its
+// upper bound. Histograms sharing their bounds merge exactly.
+func MergeHistograms(histograms []Histogram) Histogram {
+
+	var bounds []float64
+
+	for _, histogram := range histograms {
+
+		bounds = append(bounds, histogram.Bounds...)
+
+	}
+
+	slices.Sort(bounds)
+
+	merged := Histogram{Bounds: slices.Compact(bounds)}
+
+	merged.Counts = make([]uint64, len(merged.Bounds))
+
+	for _, histogram := range histograms {
+
+		for index, bound := range histogram.Bounds {
+
+			mergedIndex, _ := slices.BinarySearch(merged.Bounds, bound)
+
+			merged.Counts[mergedIndex] += histogram.Counts[index]
+
+		}
+
+	}
+
+	return merged
+
+}
+
+// toHistogram gives the Histogram of a histogram value, like the map a histogram is decoded to from msgpack or JSON,
+// or its JSON text.
+func toHistogram(value interface{}) (Histogram, error) {
+
+	var histogram Histogram
+
+	switch value := value.(type) {
+
+	case Histogram:
+
+		histogram = value
+
+	case *Histogram:
+
+		histogram = *value
+
+	case string:
+
+		if err := json.Unmarshal([]byte(value), &histogram); err != nil {
+
+			return histogram, fmt.Errorf("%w: %w", ErrInvalidHistogram, err)
+
+		}
+
+	case map[string]interface{}:
+
+		bounds, _ := value["bounds"].([]interface{})
+
+		counts, _ := value["counts"].([]interface{})
+
+		if len(bounds) != len(counts) {
+
+			return histogram, ErrInvalidHistogram
+
+		}
+
+		histogram = Histogram{Bounds: make([]float64, len(bounds)), Counts: make([]uint64, len(counts))}
+
+		for index := range bounds {
+
+			bound, err := toFloat64(widenInteger(bounds[index]))
+
+			if err != nil {
+
+				return histogram, fmt.Errorf("%w: %w", ErrInvalidHistogram, err)
+
+			}
+
+			count, err := toUint64(widenInteger(counts[index]))
+
+			if err != nil {
+
+				return histogram, fmt.Errorf("%w: %w", ErrInvalidHistogram, err)
+
+			}
+
+			histogram.Bounds[index], histogram.Counts[index] = bound, count
+
+		}
+
+	default:
+
+		return histogram, fmt.Errorf("%w: %v (%T)", ErrNotConvertible, value, value)
+
+	}
+
+	return histogram, histogram.validate()
+
+}
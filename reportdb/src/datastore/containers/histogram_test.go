@@ -0,0 +1,55 @@
+package containers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+
+	merged := MergeHistograms([]Histogram{
+		{Bounds: []float64{10, 20, 40}, Counts: []uint64{2, 4, 2}},
+		{Bounds: []float64{20, 30}, Counts: []uint64{1, 1}},
+	})
+
+	expected := Histogram{Bounds: []float64{10, 20, 30, 40}, Counts: []uint64{2, 5, 1, 2}}
+
+	if !reflect.DeepEqual(merged, expected) {
+
+		t.Errorf("expected %v, got %v", expected, merged)
+
+	}
+
+	percentiles := map[float64]float64{0: 0, 10: 5, 50: 16, 100: 40}
+
+	for percentile, expected := range percentiles {
+
+		if value, ok := merged.Percentile(percentile); !ok || value != expected {
+
+			t.Errorf("p%v: expected %v, got %v", percentile, expected, value)
+
+		}
+
+	}
+
+	if _, ok := (Histogram{Bounds: []float64{1}, Counts: []uint64{0}}).Percentile(50); ok {
+
+		t.Error("expected no percentile for an empty histogram")
+
+	}
+
+	histogram, err := ConvertValue(map[string]interface{}{"bounds": []interface{}{1.5, int8(3)}, "counts": []interface{}{uint8(1), uint16(300)}}, "histogram")
+
+	if err != nil || !reflect.DeepEqual(histogram, Histogram{Bounds: []float64{1.5, 3}, Counts: []uint64{1, 300}}) {
+
+		t.Errorf("unexpected histogram %v, %v", histogram, err)
+
+	}
+
+	if _, err = ConvertValue(`{"bounds":[3,1],"counts":[1,1]}`, "histogram"); err == nil {
+
+		t.Error("expected an error for decreasing bounds")
+
+	}
+
+}
@@ -0,0 +1,85 @@
+package containers
+
+import (
+	. "datastore/storage"
+	"sync"
+)
+
+// InstanceKeys keys the instance series in a query's result. Their storage keys differ from one day-storage to the
+// other, these keys are the same over the days of the query. Every query gets its own, so they only grow with the
+// series it reads, and the keys are neither stable across queries nor across restarts: clients identify the series
+// by the result's Instances.
+type InstanceKeys struct {
+	instances []SeriesInstance
+
+	keys map[SeriesInstance]uint32
+
+	lock sync.RWMutex
+}
+
+func NewInstanceKeys() *InstanceKeys {
+
+	return &InstanceKeys{
+
+		keys: make(map[SeriesInstance]uint32),
+	}
+
+}
+
+// Key returns the key of the object's instance series, the objectId for the series without instance.
+func (instanceKeys *InstanceKeys) Key(objectId uint32, instance string) uint32 {
+
+	if instance == "" {
+
+		return objectId
+
+	}
+
+	seriesInstance := SeriesInstance{ObjectId: objectId, Instance: instance}
+
+	instanceKeys.lock.RLock()
+
+	key, ok := instanceKeys.keys[seriesInstance]
+
+	instanceKeys.lock.RUnlock()
+
+	if ok {
+
+		return key
+
+	}
+
+	instanceKeys.lock.Lock()
+
+	defer instanceKeys.lock.Unlock()
+
+	if key, ok = instanceKeys.keys[seriesInstance]; !ok {
+
+		key = InstanceKeyBase + uint32(len(instanceKeys.instances))
+
+		instanceKeys.instances = append(instanceKeys.instances, seriesInstance)
+
+		instanceKeys.keys[seriesInstance] = key
+
+	}
+
+	return key
+
+}
+
+// Lookup returns the instance series of the key, the series without instance of the objectId for the other keys.
+func (instanceKeys *InstanceKeys) Lookup(key uint32) SeriesInstance {
+
+	instanceKeys.lock.RLock()
+
+	defer instanceKeys.lock.RUnlock()
+
+	if key < InstanceKeyBase || key-InstanceKeyBase >= uint32(len(instanceKeys.instances)) {
+
+		return SeriesInstance{ObjectId: key}
+
+	}
+
+	return instanceKeys.instances[key-InstanceKeyBase]
+
+}
@@ -0,0 +1,353 @@
+package containers
+
+import (
+	. "datastore/storage"
+	. "datastore/utils"
+	"errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.uber.org/zap"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const latestValuesFileName = "latest-values.msgpack"
+
+// LatestValues holds the most recent dataPoint of every (counter, object, instance), for current status lookups
+// without reading the day's storage. It is kept by the writer and persisted periodically, the values older than
+// LatestValuesRetentionInDays are dropped on persist.
+type LatestValues struct {
+	// values by counter, object and instance, "" standing for the object's own series
+	values map[uint16]map[uint32]map[string]DataPoint
+
+	changed bool
+
+	path string
+
+	config *Config
+
+	lock sync.RWMutex
+}
+
+func LoadLatestValues(config *Config) (*LatestValues, error) {
+
+	latestValues := &LatestValues{
+
+		values: make(map[uint16]map[uint32]map[string]DataPoint),
+
+		path: config.StorageDirectory + "/" + latestValuesFileName,
+
+		config: config,
+	}
+
+	valuesBytes, err := os.ReadFile(latestValues.path)
+
+	if errors.Is(err, os.ErrNotExist) {
+
+		return latestValues, nil
+
+	}
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	if err = msgpack.Unmarshal(valuesBytes, &latestValues.values); err == nil {
+
+		return latestValues, nil
+
+	}
+
+	// Files persisted before the instances were kept hold the objects' own series only
+	var objectValues map[uint16]map[uint32]DataPoint
+
+	if msgpack.Unmarshal(valuesBytes, &objectValues) != nil {
+
+		return nil, err
+
+	}
+
+	latestValues.values = make(map[uint16]map[uint32]map[string]DataPoint, len(objectValues))
+
+	for counterId, counterValues := range objectValues {
+
+		latestValues.values[counterId] = make(map[uint32]map[string]DataPoint, len(counterValues))
+
+		for objectId, dataPoint := range counterValues {
+
+			latestValues.values[counterId][objectId] = map[string]DataPoint{"": dataPoint}
+
+		}
+
+	}
+
+	return latestValues, nil
+
+}
+
+// Update keeps the dataPoint if it is more recent than the latest one of the object's instance.
+func (latestValues *LatestValues) Update(counterId uint16, objectId uint32, instance string, dataPoint DataPoint) {
+
+	latestValues.lock.Lock()
+
+	defer latestValues.lock.Unlock()
+
+	counterValues, ok := latestValues.values[counterId]
+
+	if !ok {
+
+		counterValues = make(map[uint32]map[string]DataPoint)
+
+		latestValues.values[counterId] = counterValues
+
+	}
+
+	objectValues, ok := counterValues[objectId]
+
+	if !ok {
+
+		objectValues = make(map[string]DataPoint)
+
+		counterValues[objectId] = objectValues
+
+	}
+
+	if latest, ok := objectValues[instance]; ok && latest.Timestamp > dataPoint.Timestamp {
+
+		return
+
+	}
+
+	objectValues[instance] = dataPoint
+
+	latestValues.changed = true
+
+}
+
+// Get returns the latest dataPoint of the series having one, of every object of the counter if objectIds is empty.
+// With instances, only the series of these instances are returned, "" standing for the objects' own.
+func (latestValues *LatestValues) Get(counterId uint16, objectIds []uint32, instances []string) map[SeriesInstance]DataPoint {
+
+	latestValues.lock.RLock()
+
+	defer latestValues.lock.RUnlock()
+
+	counterValues := latestValues.values[counterId]
+
+	seriesValues := make(map[SeriesInstance]DataPoint)
+
+	collect := func(objectId uint32) {
+
+		for instance, dataPoint := range counterValues[objectId] {
+
+			if len(instances) == 0 || slices.Contains(instances, instance) {
+
+				seriesValues[SeriesInstance{ObjectId: objectId, Instance: instance}] = dataPoint
+
+			}
+
+		}
+
+	}
+
+	if len(objectIds) == 0 {
+
+		for objectId := range counterValues {
+
+			collect(objectId)
+
+		}
+
+	} else {
+
+		for _, objectId := range objectIds {
+
+			collect(objectId)
+
+		}
+
+	}
+
+	return seriesValues
+
+}
+
+// ConvertCounter converts the latest values of the counter to the dataType, see ConvertValue. The values not
+// convertible are dropped, their count is returned.
+func (latestValues *LatestValues) ConvertCounter(counterId uint16, dataType string) int {
+
+	latestValues.lock.Lock()
+
+	defer latestValues.lock.Unlock()
+
+	dropped := 0
+
+	for objectId, objectValues := range latestValues.values[counterId] {
+
+		for instance, dataPoint := range objectValues {
+
+			value, err := ConvertValue(dataPoint.Value, dataType)
+
+			if err != nil {
+
+				delete(objectValues, instance)
+
+				dropped++
+
+			} else {
+
+				objectValues[instance] = DataPoint{Timestamp: dataPoint.Timestamp, Value: value}
+
+			}
+
+			latestValues.changed = true
+
+		}
+
+		if len(objectValues) == 0 {
+
+			delete(latestValues.values[counterId], objectId)
+
+		}
+
+	}
+
+	return dropped
+
+}
+
+// expire drops the values older than LatestValuesRetentionInDays, never when 0. The lock must be held.
+func (latestValues *LatestValues) expire(now time.Time) {
+
+	if latestValues.config.LatestValuesRetentionInDays <= 0 {
+
+		return
+
+	}
+
+	cutoff := uint64(now.Add(-time.Duration(latestValues.config.LatestValuesRetentionInDays) * 24 * time.Hour).Unix())
+
+	for counterId, counterValues := range latestValues.values {
+
+		counterCutoff := ConvertTimestamp(cutoff, PrecisionSeconds, latestValues.config.CounterPrecision(counterId))
+
+		for objectId, objectValues := range counterValues {
+
+			for instance, dataPoint := range objectValues {
+
+				if dataPoint.Timestamp < counterCutoff {
+
+					delete(objectValues, instance)
+
+					latestValues.changed = true
+
+				}
+
+			}
+
+			if len(objectValues) == 0 {
+
+				delete(counterValues, objectId)
+
+			}
+
+		}
+
+		if len(counterValues) == 0 {
+
+			delete(latestValues.values, counterId)
+
+		}
+
+	}
+
+}
+
+// Persist drops the expired latest values, and writes them if they changed since the last persist.
+func (latestValues *LatestValues) Persist() error {
+
+	latestValues.lock.Lock()
+
+	latestValues.expire(time.Now())
+
+	if !latestValues.changed {
+
+		latestValues.lock.Unlock()
+
+		return nil
+
+	}
+
+	valuesBytes, err := msgpack.Marshal(latestValues.values)
+
+	latestValues.changed = false
+
+	latestValues.lock.Unlock()
+
+	if err == nil {
+
+		// Write and rename, so that a crash never leaves a partial file behind
+		temporaryPath := latestValues.path + ".tmp"
+
+		if err = os.WriteFile(temporaryPath, valuesBytes, 0644); err == nil {
+
+			err = os.Rename(temporaryPath, latestValues.path)
+
+		}
+
+	}
+
+	if err != nil {
+
+		// Retry on the next persist
+		latestValues.lock.Lock()
+
+		latestValues.changed = true
+
+		latestValues.lock.Unlock()
+
+	}
+
+	return err
+
+}
+
+// LatestValuesPersistRoutine persists the latest values every LatestValuesPersistInterval seconds, and once more on shutdown.
+func LatestValuesPersistRoutine(latestValues *LatestValues, shutdown chan bool) {
+
+	persistTicker := time.NewTicker(time.Second * time.Duration(latestValues.config.LatestValuesPersistInterval))
+
+	defer persistTicker.Stop()
+
+	for {
+
+		select {
+
+		case <-shutdown:
+
+			if err := latestValues.Persist(); err != nil {
+
+				latestValues.config.Logger.Error("Error persisting latest values", zap.Error(err))
+
+			}
+
+			shutdown <- true
+
+			return
+
+		case <-persistTicker.C:
+
+			if err := latestValues.Persist(); err != nil {
+
+				latestValues.config.Logger.Error("Error persisting latest values", zap.Error(err))
+
+			}
+
+		}
+
+	}
+
+}
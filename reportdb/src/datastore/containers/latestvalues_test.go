@@ -0,0 +1,112 @@
+package containers
+
+import (
+	. "datastore/storage"
+	. "datastore/utils"
+	"github.com/vmihailenco/msgpack/v5"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLatestValues(t *testing.T) {
+
+	config := DefaultConfig()
+
+	config.StorageDirectory = t.TempDir()
+
+	latestValues, err := LoadLatestValues(config)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	now := uint64(time.Now().Unix())
+
+	latestValues.Update(1, 7, "", DataPoint{Timestamp: now, Value: 2.5})
+
+	// Out of order dataPoints don't replace a more recent one
+	latestValues.Update(1, 7, "", DataPoint{Timestamp: now - 10, Value: 1.5})
+
+	latestValues.Update(1, 7, "eth0", DataPoint{Timestamp: now - 10, Value: 4.5})
+
+	latestValues.Update(1, 8, "", DataPoint{Timestamp: now - 5, Value: 3.5})
+
+	// Past the retention window
+	latestValues.Update(1, 9, "", DataPoint{Timestamp: now - 40*86400, Value: 5.5})
+
+	if values := latestValues.Get(1, []uint32{7, 10}, []string{""}); len(values) != 1 || values[SeriesInstance{ObjectId: 7}].Value != 2.5 {
+
+		t.Errorf("unexpected latest values %v", values)
+
+	}
+
+	if err = latestValues.Persist(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	reloaded, err := LoadLatestValues(config)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	values := reloaded.Get(1, nil, nil)
+
+	if len(values) != 3 || values[SeriesInstance{ObjectId: 7}].Timestamp != now || values[SeriesInstance{ObjectId: 8}].Value != 3.5 {
+
+		t.Errorf("unexpected reloaded latest values %v", values)
+
+	}
+
+	if dataPoint := values[SeriesInstance{ObjectId: 7, Instance: "eth0"}]; dataPoint.Value != 4.5 {
+
+		t.Errorf("unexpected latest value of the instance %v", dataPoint)
+
+	}
+
+}
+
+func TestLatestValuesLegacyFile(t *testing.T) {
+
+	config := DefaultConfig()
+
+	config.StorageDirectory = t.TempDir()
+
+	now := uint64(time.Now().Unix())
+
+	valuesBytes, err := msgpack.Marshal(map[uint16]map[uint32]DataPoint{1: {7: {Timestamp: now, Value: 2.5}}})
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if err = os.WriteFile(config.StorageDirectory+"/"+latestValuesFileName, valuesBytes, 0644); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	latestValues, err := LoadLatestValues(config)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if values := latestValues.Get(1, nil, nil); len(values) != 1 || values[SeriesInstance{ObjectId: 7}].Value != 2.5 {
+
+		t.Errorf("unexpected latest values %v", values)
+
+	}
+
+}
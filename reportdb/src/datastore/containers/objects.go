@@ -0,0 +1,245 @@
+package containers
+
+import (
+	. "datastore/storage"
+	. "datastore/utils"
+	"encoding/binary"
+	"errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+)
+
+const objectsFileName = "objects.msgpack"
+
+// ObjectKeyBase is the first objectId assigned by the object registry. IPv4 objects keep their address as objectId,
+// and the 224.0.0.0/4 multicast range the registry takes its objectIds from is never the address of a polled object.
+const ObjectKeyBase uint32 = 0xE0000000
+
+var (
+	ErrObjectIdsExhausted = errors.New("no objectId left in the object registry")
+
+	ErrInvalidObject = errors.New("invalid object, it must not be empty")
+)
+
+// ObjectRegistry assigns stable objectIds to the objects not identified by an IPv4 address, like IPv6 devices,
+// hostnames or virtual objects such as clusters and services. The objectId ObjectKeyBase+i is the registered object
+// with index i, the registry is persisted on every registration.
+type ObjectRegistry struct {
+	objects []string
+
+	objectIds map[string]uint32
+
+	path string
+
+	lock sync.RWMutex
+}
+
+func LoadObjectRegistry(config *Config) (*ObjectRegistry, error) {
+
+	registry := &ObjectRegistry{
+
+		objectIds: make(map[string]uint32),
+
+		path: config.StorageDirectory + "/" + objectsFileName,
+	}
+
+	objectsBytes, err := os.ReadFile(registry.path)
+
+	if errors.Is(err, os.ErrNotExist) {
+
+		return registry, nil
+
+	}
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	if err = msgpack.Unmarshal(objectsBytes, &registry.objects); err != nil {
+
+		return nil, err
+
+	}
+
+	for index, object := range registry.objects {
+
+		registry.objectIds[object] = ObjectKeyBase + uint32(index)
+
+	}
+
+	return registry, nil
+
+}
+
+// Register returns the objectId of the object, registering the object if it has none yet.
+func (registry *ObjectRegistry) Register(object string) (uint32, error) {
+
+	object = normalizeObject(object)
+
+	if object == "" {
+
+		return 0, ErrInvalidObject
+
+	}
+
+	if objectId, ok := ipv4ObjectId(object); ok {
+
+		return objectId, nil
+
+	}
+
+	registry.lock.RLock()
+
+	objectId, ok := registry.objectIds[object]
+
+	registry.lock.RUnlock()
+
+	if ok {
+
+		return objectId, nil
+
+	}
+
+	registry.lock.Lock()
+
+	defer registry.lock.Unlock()
+
+	if objectId, ok = registry.objectIds[object]; ok {
+
+		return objectId, nil
+
+	}
+
+	if uint32(len(registry.objects)) >= InstanceKeyBase-ObjectKeyBase {
+
+		return 0, ErrObjectIdsExhausted
+
+	}
+
+	objects := append(registry.objects, object)
+
+	if err := registry.persist(objects); err != nil {
+
+		return 0, err
+
+	}
+
+	objectId = ObjectKeyBase + uint32(len(registry.objects))
+
+	registry.objects = objects
+
+	registry.objectIds[object] = objectId
+
+	return objectId, nil
+
+}
+
+// Resolve returns the objectId of the object without registering it, ok is false for an unknown object.
+func (registry *ObjectRegistry) Resolve(object string) (objectId uint32, ok bool) {
+
+	object = normalizeObject(object)
+
+	if objectId, ok = ipv4ObjectId(object); ok {
+
+		return objectId, true
+
+	}
+
+	registry.lock.RLock()
+
+	defer registry.lock.RUnlock()
+
+	objectId, ok = registry.objectIds[object]
+
+	return objectId, ok
+
+}
+
+// Object returns the object of the objectId, its IPv4 address for the objectIds not assigned by the registry.
+func (registry *ObjectRegistry) Object(objectId uint32) string {
+
+	registry.lock.RLock()
+
+	defer registry.lock.RUnlock()
+
+	if objectId >= ObjectKeyBase && objectId-ObjectKeyBase < uint32(len(registry.objects)) {
+
+		return registry.objects[objectId-ObjectKeyBase]
+
+	}
+
+	return netip.AddrFrom4([4]byte(binary.BigEndian.AppendUint32(nil, objectId))).String()
+
+}
+
+// IsRegistered reports whether the objectId was assigned by the registry.
+func (registry *ObjectRegistry) IsRegistered(objectId uint32) bool {
+
+	registry.lock.RLock()
+
+	defer registry.lock.RUnlock()
+
+	return objectId >= ObjectKeyBase && objectId-ObjectKeyBase < uint32(len(registry.objects))
+
+}
+
+func (registry *ObjectRegistry) persist(objects []string) error {
+
+	objectsBytes, err := msgpack.Marshal(objects)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	// Write and rename, so that a crash never leaves a partial file behind
+	temporaryPath := registry.path + ".tmp"
+
+	if err = os.WriteFile(temporaryPath, objectsBytes, 0644); err != nil {
+
+		return err
+
+	}
+
+	return os.Rename(temporaryPath, registry.path)
+
+}
+
+// normalizeObject gives IP addresses their canonical form, so that every spelling of an address is the same object.
+func normalizeObject(object string) string {
+
+	object = strings.TrimSpace(object)
+
+	if address, err := netip.ParseAddr(object); err == nil {
+
+		return address.Unmap().String()
+
+	}
+
+	return object
+
+}
+
+// ipv4ObjectId returns the address as objectId for the IPv4 objects out of the ranges reserved for registry and
+// instance keys.
+func ipv4ObjectId(object string) (uint32, bool) {
+
+	address, err := netip.ParseAddr(object)
+
+	if err != nil || !address.Is4() {
+
+		return 0, false
+
+	}
+
+	objectId := binary.BigEndian.Uint32(address.AsSlice())
+
+	return objectId, objectId < ObjectKeyBase
+
+}
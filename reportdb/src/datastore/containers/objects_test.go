@@ -0,0 +1,83 @@
+package containers
+
+import (
+	. "datastore/utils"
+	"testing"
+)
+
+func TestObjectRegistry(t *testing.T) {
+
+	config := DefaultConfig()
+
+	config.StorageDirectory = t.TempDir()
+
+	registry, err := LoadObjectRegistry(config)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	// IPv4 objects keep their address as objectId
+	if objectId, err := registry.Register("10.0.0.1"); err != nil || objectId != 167772161 || registry.IsRegistered(objectId) {
+
+		t.Errorf("unexpected objectId %d of an IPv4 object, error: %v", objectId, err)
+
+	}
+
+	ipv6ObjectId, err := registry.Register("2001:DB8::1")
+
+	if err != nil || ipv6ObjectId != ObjectKeyBase {
+
+		t.Fatalf("unexpected objectId %d of an IPv6 object, error: %v", ipv6ObjectId, err)
+
+	}
+
+	hostObjectId, _ := registry.Register("web-01")
+
+	// Every spelling of an address is the same object
+	if objectId, ok := registry.Resolve("2001:db8:0::1"); !ok || objectId != ipv6ObjectId {
+
+		t.Errorf("expected objectId %d, got %d", ipv6ObjectId, objectId)
+
+	}
+
+	if _, ok := registry.Resolve("web-02"); ok {
+
+		t.Error("unknown object resolved")
+
+	}
+
+	if _, err = registry.Register(" "); err != ErrInvalidObject {
+
+		t.Errorf("expected %v, got %v", ErrInvalidObject, err)
+
+	}
+
+	// Objects are stable across restarts
+	reloaded, err := LoadObjectRegistry(config)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if objectId, ok := reloaded.Resolve("web-01"); !ok || objectId != hostObjectId {
+
+		t.Errorf("expected objectId %d, got %d", hostObjectId, objectId)
+
+	}
+
+	for objectId, object := range map[uint32]string{ipv6ObjectId: "2001:db8::1", hostObjectId: "web-01", 167772161: "10.0.0.1"} {
+
+		if reloaded.Object(objectId) != object {
+
+			t.Errorf("expected object %q of %d, got %q", object, objectId, reloaded.Object(objectId))
+
+		}
+
+	}
+
+}
@@ -0,0 +1,186 @@
+package containers
+
+import (
+	"go.uber.org/zap"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScrubColdDays verifies the block checksums of the days before today, which are rarely read and whose corruption
+// would otherwise go unnoticed till queried. It returns the number of corrupt blocks found.
+func (storagePool *StoragePool) ScrubColdDays() int {
+
+	var corrupt int
+
+	for _, key := range storagePool.storagesBefore(UnixToDate(time.Now().Unix())) {
+
+		storageCorrupt, ok := storagePool.scrub(key)
+
+		if !ok {
+
+			return corrupt
+
+		}
+
+		corrupt += storageCorrupt
+
+	}
+
+	storagePool.Config.Logger.Info("Scrubbed cold days", zap.Int("corruptBlocks", corrupt))
+
+	return corrupt
+
+}
+
+// storagesBefore returns the keys of the day-storages on disk of the days before the date.
+func (storagePool *StoragePool) storagesBefore(date Date) []StoragePoolKey {
+
+	storagePaths, _ := filepath.Glob(storagePool.Config.StorageDirectory + "/*/*/*/*")
+
+	var keys []StoragePoolKey
+
+	for _, storagePath := range storagePaths {
+
+		key, ok := storagePoolKey(strings.TrimPrefix(storagePath, storagePool.Config.StorageDirectory+"/"))
+
+		if ok && key.Date.Before(date) {
+
+			keys = append(keys, key)
+
+		}
+
+	}
+
+	return keys
+
+}
+
+// scrub verifies the storage of the key, ok is false once the pool is closed.
+func (storagePool *StoragePool) scrub(key StoragePoolKey) (corrupt int, ok bool) {
+
+	storagePool.maintenanceLock.Lock()
+
+	defer storagePool.maintenanceLock.Unlock()
+
+	select {
+
+	case <-storagePool.cleanupShutdown:
+
+		return 0, false
+
+	default:
+
+	}
+
+	storagePath := storagePool.storagePath(key)
+
+	pooled := storagePool.IsPooled(key)
+
+	storage, err := storagePool.GetStorage(key, false)
+
+	if err != nil {
+
+		storagePool.Config.Logger.Error("error opening storage to scrub", zap.String("storagePath", storagePath), zap.Error(err))
+
+		return 0, true
+
+	}
+
+	corrupt, err = storage.Verify()
+
+	if err != nil {
+
+		storagePool.Config.Logger.Error("error scrubbing storage", zap.String("storagePath", storagePath), zap.Error(err))
+
+	}
+
+	// Storages are only kept open for the scrub when nothing else opened them meanwhile
+	if !pooled {
+
+		storagePool.release(key)
+
+	}
+
+	return corrupt, true
+
+}
+
+// storagePoolKey parses the year/month/day/counterId path of a storage.
+func storagePoolKey(storagePath string) (StoragePoolKey, bool) {
+
+	parts := strings.Split(storagePath, "/")
+
+	if len(parts) != 4 {
+
+		return StoragePoolKey{}, false
+
+	}
+
+	var values [4]int
+
+	for index, part := range parts {
+
+		value, err := strconv.Atoi(part)
+
+		if err != nil {
+
+			return StoragePoolKey{}, false
+
+		}
+
+		values[index] = value
+
+	}
+
+	if values[3] < 0 || values[3] > 0xFFFF {
+
+		return StoragePoolKey{}, false
+
+	}
+
+	return StoragePoolKey{Date: Date{Year: values[0], Month: values[1], Day: values[2]}, CounterId: uint16(values[3])}, true
+
+}
+
+// release closes the storage when the pool's only access to it was the caller's.
+func (storagePool *StoragePool) release(key StoragePoolKey) {
+
+	storagePool.lock.Lock()
+
+	defer storagePool.lock.Unlock()
+
+	if storage, ok := storagePool.pool[key]; ok && storagePool.accessCount[key] <= 1 {
+
+		storage.ClearStorage()
+
+		delete(storagePool.accessCount, key)
+
+		delete(storagePool.pool, key)
+
+	}
+
+}
+
+func storagePoolScrub(storagePool *StoragePool, scrubTicker *time.Ticker) {
+
+	defer scrubTicker.Stop()
+
+	for {
+
+		select {
+
+		case <-storagePool.cleanupShutdown:
+
+			return
+
+		case <-scrubTicker.C:
+
+			storagePool.ScrubColdDays()
+
+		}
+
+	}
+
+}
@@ -4,8 +4,10 @@ import (
 	. "datastore/storage"
 	. "datastore/utils"
 	"go.uber.org/zap"
+	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,29 +17,102 @@ type StoragePoolKey struct {
 	CounterId uint16
 }
 
+// StoragePool holds the open storages of a datastore, along with its config and caches for the components reading
+// and writing them.
 type StoragePool struct {
+	Config *Config
+
+	Caches *Caches
+
+	Objects *ObjectRegistry
+
 	pool map[StoragePoolKey]*Storage
 
 	accessCount map[StoragePoolKey]int
 
 	cleanupTicker *time.Ticker
 
+	cleanupShutdown chan struct{}
+
+	// Days being compacted, GetStorage waits for the channel to be closed
+	compacting map[StoragePoolKey]chan struct{}
+
+	// Held while a storage is scrubbed or compacted, so that ClosePool waits for it
+	maintenanceLock sync.Mutex
+
+	// Free bytes of the storage directory at the last disk check, ingest being rejected below MinFreeDiskSpaceInMB
+	freeDiskSpace atomic.Uint64
+
+	ingestRejected atomic.Bool
+
+	unregisterMetrics func()
+
 	lock sync.Mutex
 }
 
-func InitStoragePool() *StoragePool {
+func InitStoragePool(config *Config) (*StoragePool, error) {
+
+	objects, err := LoadObjectRegistry(config)
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	caches, err := NewCaches(config)
+
+	if err != nil {
+
+		return nil, err
+
+	}
 
 	storagePool := &StoragePool{
+		Config: config,
+
+		Caches: caches,
+
+		Objects: objects,
+
 		pool: make(map[StoragePoolKey]*Storage),
 
 		accessCount: make(map[StoragePoolKey]int),
 
-		cleanupTicker: time.NewTicker(time.Second * time.Duration(StorageCleanupInterval)),
+		compacting: make(map[StoragePoolKey]chan struct{}),
+
+		cleanupTicker: time.NewTicker(time.Second * time.Duration(config.StorageCleanupInterval)),
+
+		cleanupShutdown: make(chan struct{}),
 	}
 
+	storagePool.unregisterMetrics = storagePool.registerMetrics()
+
 	go storagePoolCleanup(storagePool)
 
-	return storagePool
+	// Cold days are scrubbed every ScrubInterval seconds, never when 0
+	if config.ScrubInterval > 0 {
+
+		go storagePoolScrub(storagePool, time.NewTicker(time.Second*time.Duration(config.ScrubInterval)))
+
+	}
+
+	// The disk is checked before any write
+	if config.DiskCheckInterval > 0 {
+
+		storagePool.CheckDiskSpace()
+
+		go storagePoolDiskCheck(storagePool, time.NewTicker(time.Second*time.Duration(config.DiskCheckInterval)))
+
+	}
+
+	if config.CompactionInterval > 0 {
+
+		go storagePoolCompaction(storagePool, time.NewTicker(time.Second*time.Duration(config.CompactionInterval)))
+
+	}
+
+	return storagePool, nil
 
 }
 
@@ -47,7 +122,41 @@ func (storagePool *StoragePool) GetStorage(key StoragePoolKey, createIfNotExist
 
 	defer storagePool.lock.Unlock()
 
-	if storage, ok := storagePool.pool[key]; ok {
+	// The day is opened once compacted
+	for compacted, ok := storagePool.compacting[key]; ok; compacted, ok = storagePool.compacting[key] {
+
+		storagePool.lock.Unlock()
+
+		<-compacted
+
+		storagePool.lock.Lock()
+
+	}
+
+	storagePath := storagePool.storagePath(key)
+
+	storage, ok := storagePool.pool[key]
+
+	// Points written late to a compacted day reopen it writable, the day is compacted again with the next cold days
+	if createIfNotExist && (ok && storage.Compacted() || !ok && IsSegment(storagePath)) {
+
+		if ok {
+
+			storage.ClearStorage()
+
+			delete(storagePool.accessCount, key)
+
+			delete(storagePool.pool, key)
+
+		}
+
+		if err := storagePool.expand(key, storagePath); err != nil {
+
+			return nil, err
+
+		}
+
+	} else if ok {
 
 		storagePool.accessCount[key]++
 
@@ -57,11 +166,11 @@ func (storagePool *StoragePool) GetStorage(key StoragePoolKey, createIfNotExist
 
 	// Storage not in pool. Get new storage.
 
-	// First clear the storage
+	dataType, _ := storagePool.Config.CounterDataType(key.CounterId)
 
-	storagePath := StorageDirectory + "/" + key.Date.Format() + "/" + strconv.Itoa(int(key.CounterId))
+	metadata := Metadata{DataType: dataType, Precision: storagePool.Config.CounterPrecision(key.CounterId)}
 
-	newStorage, err := NewStorage(storagePath, Partitions, BlockSize, createIfNotExist)
+	newStorage, err := NewStorage(storagePath, metadata, storagePool.Config, createIfNotExist)
 
 	if err != nil {
 
@@ -73,12 +182,68 @@ func (storagePool *StoragePool) GetStorage(key StoragePoolKey, createIfNotExist
 
 	storagePool.accessCount[key]++
 
-	Logger.Info("Loaded new storage in pool", zap.Any("Key", key))
+	storagePool.Config.Logger.Info("Loaded new storage in pool", zap.Any("Key", key))
 
 	return newStorage, nil
 
 }
 
+// expand turns the segment of the key back into a mutable storage. Must be called holding the lock, which is released
+// meanwhile: the day is not opened till it is expanded, like while it is compacted.
+func (storagePool *StoragePool) expand(key StoragePoolKey, storagePath string) error {
+
+	done := make(chan struct{})
+
+	storagePool.compacting[key] = done
+
+	storagePool.lock.Unlock()
+
+	err := ExpandSegment(storagePath, storagePool.Config)
+
+	storagePool.lock.Lock()
+
+	delete(storagePool.compacting, key)
+
+	close(done)
+
+	if err != nil {
+
+		storagePool.Config.Logger.Error("error expanding compacted storage", zap.String("storagePath", storagePath), zap.Error(err))
+
+	}
+
+	return err
+
+}
+
+func (storagePool *StoragePool) storagePath(key StoragePoolKey) string {
+
+	return storagePool.Config.StorageDirectory + "/" + key.Date.Format() + "/" + strconv.Itoa(int(key.CounterId))
+
+}
+
+// IsPooled reports whether the storage is open in the pool, without loading it.
+func (storagePool *StoragePool) IsPooled(key StoragePoolKey) bool {
+
+	storagePool.lock.Lock()
+
+	defer storagePool.lock.Unlock()
+
+	_, ok := storagePool.pool[key]
+
+	return ok
+
+}
+
+// CounterHasData reports whether any day-storage of the counter exists on disk.
+func (storagePool *StoragePool) CounterHasData(counterId uint16) bool {
+
+	storagePaths, _ := filepath.Glob(storagePool.Config.StorageDirectory + "/*/*/*/" + strconv.Itoa(int(counterId)))
+
+	return len(storagePaths) > 0
+
+}
+
 func (storagePool *StoragePool) CleanPool() {
 
 	storagePool.lock.Lock()
@@ -95,7 +260,7 @@ func (storagePool *StoragePool) CleanPool() {
 
 			delete(storagePool.pool, key)
 
-			Logger.Info("Closed storage", zap.Any("Key", key))
+			storagePool.Config.Logger.Info("Closed storage", zap.Any("Key", key))
 
 		} else {
 
@@ -109,6 +274,19 @@ func (storagePool *StoragePool) CleanPool() {
 
 func (storagePool *StoragePool) ClosePool() {
 
+	close(storagePool.cleanupShutdown)
+
+	// Unregistered before taking the lock, which the pooled storages gauge takes while the metrics are written
+	storagePool.unregisterMetrics()
+
+	storagePool.maintenanceLock.Lock()
+
+	defer storagePool.maintenanceLock.Unlock()
+
+	storagePool.lock.Lock()
+
+	defer storagePool.lock.Unlock()
+
 	for _, storage := range storagePool.pool {
 
 		storage.ClearStorage()
@@ -117,19 +295,75 @@ func (storagePool *StoragePool) ClosePool() {
 
 	clear(storagePool.pool)
 
+	storagePool.Caches.Close()
+
+}
+
+// PooledStorages returns the count of the open storages.
+func (storagePool *StoragePool) PooledStorages() int {
+
+	storagePool.lock.Lock()
+
+	defer storagePool.lock.Unlock()
+
+	return len(storagePool.pool)
+
+}
+
+func (storagePool *StoragePool) registerMetrics() (unregister func()) {
+
+	metrics := storagePool.Config.Metrics
+
+	unregisterFuncs := []func(){
+
+		metrics.GaugeFunc("reportdb_pooled_storages", "Storages open in the storage pool.", func() float64 {
+
+			return float64(storagePool.PooledStorages())
+
+		}),
+
+		metrics.GaugeFunc("reportdb_free_disk_space_bytes", "Free bytes of the storage directory at the last disk check.", func() float64 {
+
+			return float64(storagePool.FreeDiskSpace())
+
+		}),
+
+		metrics.GaugeFunc("reportdb_ingest_rejected", "1 while ingest is rejected for low disk space.", func() float64 {
+
+			if storagePool.IngestAllowed() != nil {
+
+				return 1
+
+			}
+
+			return 0
+
+		}),
+	}
+
+	return func() {
+
+		for _, unregister := range unregisterFuncs {
+
+			unregister()
+
+		}
+
+	}
+
 }
 
 func storagePoolCleanup(storagePool *StoragePool) {
 
+	defer storagePool.cleanupTicker.Stop()
+
 	for {
 
 		select {
 
-		//case <-shutdownChannel:
-		//
-		//	storagePool.ClosePool()
-		//
-		//	return
+		case <-storagePool.cleanupShutdown:
+
+			return
 
 		case <-storagePool.cleanupTicker.C:
 			storagePool.CleanPool()
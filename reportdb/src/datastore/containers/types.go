@@ -7,12 +7,22 @@ import (
 )
 
 type PolledDataPoint struct {
-	Timestamp uint32 `json:"timestamp" msgpack:"timestamp"`
+	// Timestamp in the precision of the counter
+	Timestamp uint64 `json:"timestamp" msgpack:"timestamp"`
 
 	CounterId uint16 `json:"counter_id" msgpack:"counter_id"`
 
+	// ObjectId is the IPv4 address of the object, the objectIds from ObjectKeyBase on are refused: the registry assigns
+	// them
 	ObjectId uint32 `json:"object_id" msgpack:"object_id"`
 
+	// Object identifies the object when set, like an IP address, a hostname or a cluster name. It replaces ObjectId
+	// by the objectId the object registry assigns it.
+	Object string `json:"object,omitempty" msgpack:"object,omitempty"`
+
+	// Instance of the object the dataPoint belongs to, like eth0 or /var. Empty for the object's own series.
+	Instance string `json:"instance,omitempty" msgpack:"instance,omitempty"`
+
 	Value interface{} `json:"value" msgpack:"value"`
 }
 
@@ -30,7 +40,26 @@ func (date Date) Format() string {
 
 }
 
-func UnixToDate[T uint32 | int64](unix T) Date {
+// Before reports whether the date is a day before the other.
+func (date Date) Before(other Date) bool {
+
+	if date.Year != other.Year {
+
+		return date.Year < other.Year
+
+	}
+
+	if date.Month != other.Month {
+
+		return date.Month < other.Month
+
+	}
+
+	return date.Day < other.Day
+
+}
+
+func UnixToDate[T uint32 | uint64 | int64](unix T) Date {
 
 	t := time.Unix(int64(unix), 0)
 
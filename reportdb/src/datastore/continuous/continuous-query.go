@@ -0,0 +1,171 @@
+package continuous
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrEmptyName = errors.New("continuous query name is required")
+
+	ErrDuplicateName = errors.New("continuous query with the same name already exists")
+
+	ErrInvalidAggregation = errors.New("invalid aggregation, it must be either 'avg', 'sum', 'min', 'max', 'count' or 'uptime'")
+
+	ErrInvalidInterval = errors.New("continuous query interval must be greater than 0")
+
+	ErrUnknownSourceCounter = errors.New("unknown source counter")
+
+	ErrStringSourceCounter = errors.New("continuous queries over string, enum and histogram counters are not supported")
+)
+
+// ContinuousQuery aggregates every Interval seconds the points of the source counter across the objects,
+// and stores the result as a derived counter at objectId 0.
+type ContinuousQuery struct {
+	Name string `json:"name" msgpack:"name"`
+
+	SourceCounterId uint16 `json:"source_counter_id" msgpack:"source_counter_id"`
+
+	// Objects to aggregate, all the objects when empty
+	ObjectIds []uint32 `json:"object_ids" msgpack:"object_ids"`
+
+	// Objects are identified like in queries, they are resolved into ObjectIds by the object registry on registration
+	Objects []string `json:"objects,omitempty" msgpack:"objects,omitempty"`
+
+	Aggregation string `json:"aggregation" msgpack:"aggregation"`
+
+	Interval uint32 `json:"interval" msgpack:"interval"`
+
+	// CounterId of the derived counter, it is queried like any other counter
+	CounterId uint16 `json:"counter_id" msgpack:"counter_id"`
+
+	// Watermark is the end of the last interval written to the derived counter, evaluation resumes from it after a restart
+	Watermark uint64 `json:"watermark" msgpack:"watermark"`
+}
+
+func (continuousQuery *ContinuousQuery) validate() error {
+
+	if continuousQuery.Name == "" {
+
+		return ErrEmptyName
+
+	}
+
+	switch continuousQuery.Aggregation {
+
+	case "avg", "sum", "min", "max", "count", "uptime":
+
+	default:
+
+		return ErrInvalidAggregation
+
+	}
+
+	if continuousQuery.Interval == 0 {
+
+		return ErrInvalidInterval
+
+	}
+
+	return nil
+
+}
+
+func (continuousQuery *ContinuousQuery) selects(objectId uint32) bool {
+
+	if len(continuousQuery.ObjectIds) == 0 {
+
+		return true
+
+	}
+
+	for _, selectedObjectId := range continuousQuery.ObjectIds {
+
+		if selectedObjectId == objectId {
+
+			return true
+
+		}
+
+	}
+
+	return false
+
+}
+
+func (continuousQuery *ContinuousQuery) String() string {
+
+	return fmt.Sprintf("%s(c%d) every %ds as c%d", continuousQuery.Aggregation, continuousQuery.SourceCounterId, continuousQuery.Interval, continuousQuery.CounterId)
+
+}
+
+// accumulator holds the running aggregation of a single interval.
+type accumulator struct {
+	sum float64
+
+	count float64
+
+	min float64
+
+	max float64
+
+	// values not zero, the true ones of bool counters
+	nonZero float64
+}
+
+func newAccumulator() *accumulator {
+
+	return &accumulator{min: math.Inf(1), max: math.Inf(-1)}
+
+}
+
+func (accumulator *accumulator) add(value float64) {
+
+	accumulator.sum += value
+
+	accumulator.count++
+
+	accumulator.min = min(accumulator.min, value)
+
+	accumulator.max = max(accumulator.max, value)
+
+	if value != 0 {
+
+		accumulator.nonZero++
+
+	}
+
+}
+
+func (accumulator *accumulator) value(aggregation string) float64 {
+
+	switch aggregation {
+
+	case "sum":
+
+		return accumulator.sum
+
+	case "min":
+
+		return accumulator.min
+
+	case "max":
+
+		return accumulator.max
+
+	case "count":
+
+		return accumulator.count
+
+	case "uptime":
+
+		return accumulator.nonZero / accumulator.count
+
+	default:
+
+		return accumulator.sum / accumulator.count
+
+	}
+
+}
@@ -0,0 +1,471 @@
+package continuous
+
+import (
+	. "datastore/containers"
+	. "datastore/storage"
+	. "datastore/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const definitionsFileName = "continuous-queries.json"
+
+// Derived counters hold the aggregated values
+const derivedCounterDataType = "float64"
+
+type evaluation struct {
+	query *ContinuousQuery
+
+	// Start of the first interval not written yet, points of earlier intervals arriving late are dropped
+	emittedUpTo uint64
+
+	// Interval start -> running aggregation
+	pending map[uint64]*accumulator
+}
+
+// accumulate adds the object's dataPoints, with timestamps of the precision, to their intervals.
+func (evaluation *evaluation) accumulate(objectId uint32, dataPoints []DataPoint, precision string) (dropped int) {
+
+	if !evaluation.query.selects(objectId) {
+
+		return 0
+
+	}
+
+	interval := uint64(evaluation.query.Interval)
+
+	for _, dataPoint := range dataPoints {
+
+		timestamp := TimestampSeconds(dataPoint.Timestamp, precision)
+
+		intervalStart := timestamp - timestamp%interval
+
+		if intervalStart < evaluation.emittedUpTo {
+
+			dropped++
+
+			continue
+
+		}
+
+		value, ok := NumericValue(dataPoint.Value)
+
+		if !ok {
+
+			continue
+
+		}
+
+		if _, ok = evaluation.pending[intervalStart]; !ok {
+
+			evaluation.pending[intervalStart] = newAccumulator()
+
+		}
+
+		evaluation.pending[intervalStart].add(value)
+
+	}
+
+	return dropped
+
+}
+
+// ContinuousQueries evaluates the registered continuous queries incrementally, as the writers flush the source counters.
+// An interval is written to the derived counter once ContinuousQueryGracePeriod has passed after its end.
+type ContinuousQueries struct {
+	evaluations map[string]*evaluation
+
+	definitionsPath string
+
+	config *Config
+
+	lock sync.Mutex
+}
+
+// LoadContinuousQueries reloads the persisted continuous queries and rebuilds their pending intervals from the
+// source storages. It must be called before the writers start.
+func LoadContinuousQueries(storagePool *StoragePool) (*ContinuousQueries, error) {
+
+	continuousQueries := &ContinuousQueries{
+
+		evaluations: make(map[string]*evaluation),
+
+		definitionsPath: storagePool.Config.StorageDirectory + "/" + definitionsFileName,
+
+		config: storagePool.Config,
+	}
+
+	definitionsBytes, err := os.ReadFile(continuousQueries.definitionsPath)
+
+	if errors.Is(err, os.ErrNotExist) {
+
+		return continuousQueries, nil
+
+	}
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	var definitions []*ContinuousQuery
+
+	if err = json.Unmarshal(definitionsBytes, &definitions); err != nil {
+
+		return nil, err
+
+	}
+
+	now := uint64(time.Now().Unix())
+
+	for _, definition := range definitions {
+
+		if err = addDerivedCounter(continuousQueries.config, definition.CounterId); err != nil {
+
+			return nil, err
+
+		}
+
+		evaluation := &evaluation{
+
+			query: definition,
+
+			emittedUpTo: definition.Watermark,
+
+			pending: make(map[uint64]*accumulator),
+		}
+
+		backfill(evaluation, storagePool, now)
+
+		continuousQueries.evaluations[definition.Name] = evaluation
+
+		continuousQueries.config.Logger.Info("Continuous query loaded", zap.String("name", definition.Name), zap.Stringer("query", definition), zap.Int("pendingIntervals", len(evaluation.pending)))
+
+	}
+
+	return continuousQueries, nil
+
+}
+
+// Register starts evaluating the continuous query from its next interval.
+func (continuousQueries *ContinuousQueries) Register(continuousQuery ContinuousQuery) error {
+
+	if err := continuousQuery.validate(); err != nil {
+
+		return err
+
+	}
+
+	dataType, ok := continuousQueries.config.CounterDataType(continuousQuery.SourceCounterId)
+
+	if !ok {
+
+		return ErrUnknownSourceCounter
+
+	}
+
+	// The accumulators hold numbers, bools counting as 1 and 0
+	if dataType == "string" || dataType == "enum" || dataType == "histogram" {
+
+		return ErrStringSourceCounter
+
+	}
+
+	continuousQueries.lock.Lock()
+
+	defer continuousQueries.lock.Unlock()
+
+	if _, ok = continuousQueries.evaluations[continuousQuery.Name]; ok {
+
+		return ErrDuplicateName
+
+	}
+
+	if err := continuousQueries.config.AddCounter(continuousQuery.CounterId, derivedCounterDataType); err != nil {
+
+		return fmt.Errorf("derived counter c%d: %w", continuousQuery.CounterId, err)
+
+	}
+
+	now, interval := uint64(time.Now().Unix()), uint64(continuousQuery.Interval)
+
+	// The current interval is partly flushed already, start with the next one
+	continuousQuery.Watermark = now - now%interval + interval
+
+	continuousQueries.evaluations[continuousQuery.Name] = &evaluation{
+
+		query: &continuousQuery,
+
+		emittedUpTo: continuousQuery.Watermark,
+
+		pending: make(map[uint64]*accumulator),
+	}
+
+	continuousQueries.config.Logger.Info("Continuous query registered", zap.String("name", continuousQuery.Name), zap.Stringer("query", &continuousQuery))
+
+	return continuousQueries.persist()
+
+}
+
+// List returns the registered continuous queries, ordered by name.
+func (continuousQueries *ContinuousQueries) List() []ContinuousQuery {
+
+	continuousQueries.lock.Lock()
+
+	defer continuousQueries.lock.Unlock()
+
+	definitions := make([]ContinuousQuery, 0, len(continuousQueries.evaluations))
+
+	for _, evaluation := range continuousQueries.evaluations {
+
+		definitions = append(definitions, *evaluation.query)
+
+	}
+
+	sort.Slice(definitions, func(i, j int) bool {
+
+		return definitions[i].Name < definitions[j].Name
+
+	})
+
+	return definitions
+
+}
+
+// Written feeds the object's batch, just written to storage by a writer, to the continuous queries over its counter.
+// The batch timestamps are of the counter's precision. Batches of the derived counters move the watermarks of their
+// continuous queries.
+func (continuousQueries *ContinuousQueries) Written(storageKey StoragePoolKey, objectId uint32, dataPoints []DataPoint) {
+
+	continuousQueries.lock.Lock()
+
+	defer continuousQueries.lock.Unlock()
+
+	watermarkMoved := false
+
+	precision := continuousQueries.config.CounterPrecision(storageKey.CounterId)
+
+	for _, evaluation := range continuousQueries.evaluations {
+
+		if evaluation.query.SourceCounterId == storageKey.CounterId {
+
+			if dropped := evaluation.accumulate(objectId, dataPoints, precision); dropped > 0 {
+
+				continuousQueries.config.Logger.Info("Late dataPoints dropped by continuous query", zap.String("name", evaluation.query.Name), zap.Uint32("objectId", objectId), zap.Int("dropped", dropped))
+
+			}
+
+		}
+
+		if evaluation.query.CounterId == storageKey.CounterId {
+
+			for _, dataPoint := range dataPoints {
+
+				if intervalEnd := dataPoint.Timestamp + uint64(evaluation.query.Interval); intervalEnd > evaluation.query.Watermark {
+
+					evaluation.query.Watermark = intervalEnd
+
+					watermarkMoved = true
+
+				}
+
+			}
+
+		}
+
+	}
+
+	if watermarkMoved {
+
+		if err := continuousQueries.persist(); err != nil {
+
+			continuousQueries.config.Logger.Error("Error persisting continuous queries", zap.Error(err))
+
+		}
+
+	}
+
+}
+
+// Due returns the derived dataPoints of the intervals closed by now, to be written by the writers.
+func (continuousQueries *ContinuousQueries) Due(now uint64) []PolledDataPoint {
+
+	continuousQueries.lock.Lock()
+
+	defer continuousQueries.lock.Unlock()
+
+	var dataPoints []PolledDataPoint
+
+	for _, evaluation := range continuousQueries.evaluations {
+
+		for intervalStart, accumulator := range evaluation.pending {
+
+			intervalEnd := intervalStart + uint64(evaluation.query.Interval)
+
+			if intervalEnd+uint64(continuousQueries.config.ContinuousQueryGracePeriod) > now {
+
+				continue
+
+			}
+
+			dataPoints = append(dataPoints, PolledDataPoint{
+
+				Timestamp: intervalStart,
+
+				CounterId: evaluation.query.CounterId,
+
+				ObjectId: 0,
+
+				Value: accumulator.value(evaluation.query.Aggregation),
+			})
+
+			evaluation.emittedUpTo = max(evaluation.emittedUpTo, intervalEnd)
+
+			delete(evaluation.pending, intervalStart)
+
+		}
+
+	}
+
+	return dataPoints
+
+}
+
+// persist writes the definitions, with their watermarks. Must be called holding the lock.
+func (continuousQueries *ContinuousQueries) persist() error {
+
+	definitions := make([]*ContinuousQuery, 0, len(continuousQueries.evaluations))
+
+	for _, evaluation := range continuousQueries.evaluations {
+
+		definitions = append(definitions, evaluation.query)
+
+	}
+
+	definitionsBytes, err := json.MarshalIndent(definitions, "", "  ")
+
+	if err != nil {
+
+		return err
+
+	}
+
+	// Write and rename, so that a crash never leaves a partial file behind
+	temporaryPath := continuousQueries.definitionsPath + ".tmp"
+
+	if err = os.WriteFile(temporaryPath, definitionsBytes, 0644); err != nil {
+
+		return err
+
+	}
+
+	return os.Rename(temporaryPath, continuousQueries.definitionsPath)
+
+}
+
+func addDerivedCounter(config *Config, counterId uint16) error {
+
+	err := config.AddCounter(counterId, derivedCounterDataType)
+
+	if errors.Is(err, ErrCounterExists) {
+
+		// Also present in the counter config, acceptable as long as it holds the aggregated values
+		if dataType, _ := config.CounterDataType(counterId); dataType == derivedCounterDataType {
+
+			return nil
+
+		}
+
+		return fmt.Errorf("derived counter c%d: %w with another dataType", counterId, err)
+
+	}
+
+	return err
+
+}
+
+// backfill rebuilds the pending intervals after the watermark from the source counter's storages.
+func backfill(evaluation *evaluation, storagePool *StoragePool, now uint64) {
+
+	dataType, ok := storagePool.Config.CounterDataType(evaluation.query.SourceCounterId)
+
+	if !ok {
+
+		storagePool.Config.Logger.Error("Source counter of continuous query not configured", zap.String("name", evaluation.query.Name))
+
+		return
+
+	}
+
+	for date := evaluation.emittedUpTo - evaluation.emittedUpTo%86400; date <= now; date += 86400 {
+
+		storageKey := StoragePoolKey{
+
+			Date: UnixToDate(date),
+
+			CounterId: evaluation.query.SourceCounterId,
+		}
+
+		storageEngine, err := storagePool.GetStorage(storageKey, false)
+
+		if err != nil {
+
+			continue
+
+		}
+
+		objectIds := evaluation.query.ObjectIds
+
+		if len(objectIds) == 0 {
+
+			if objectIds, err = storageEngine.GetAllKeys(); err != nil {
+
+				storagePool.Config.Logger.Error("Error getting all storage keys", zap.Error(err))
+
+				continue
+
+			}
+
+		}
+
+		for _, objectId := range objectIds {
+
+			if objectId >= InstanceKeyBase {
+
+				// Continuous queries are evaluated over the objects' own series
+				continue
+
+			}
+
+			data, err := storageEngine.Get(objectId)
+
+			if err != nil {
+
+				continue
+
+			}
+
+			dataPoints, err := DeserializeStorageBatch(data, storageEngine, dataType, PrecisionSeconds)
+
+			if err != nil {
+
+				storagePool.Config.Logger.Info("Error deserializing dataPoints for continuous query", zap.Uint32("ObjectId", objectId), zap.Error(err))
+
+				continue
+
+			}
+
+			evaluation.accumulate(objectId, dataPoints, PrecisionSeconds)
+
+		}
+
+	}
+
+}
@@ -0,0 +1,132 @@
+package continuous
+
+import (
+	. "datastore/containers"
+	. "datastore/utils"
+	"testing"
+)
+
+func TestContinuousQueries(t *testing.T) {
+
+	config := DefaultConfig()
+
+	config.StorageDirectory = t.TempDir()
+
+	config.ContinuousQueryGracePeriod = 10
+
+	_ = config.AddCounter(1, "uint64")
+
+	_ = config.AddCounter(3, "string")
+
+	storagePool, err := InitStoragePool(config)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer storagePool.ClosePool()
+
+	continuousQueries, err := LoadContinuousQueries(storagePool)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if err = continuousQueries.Register(ContinuousQuery{Name: "fleet", SourceCounterId: 3, Aggregation: "avg", Interval: 60, CounterId: 100}); err != ErrStringSourceCounter {
+
+		t.Errorf("expected %v, got %v", ErrStringSourceCounter, err)
+
+	}
+
+	if err = continuousQueries.Register(ContinuousQuery{Name: "fleet", SourceCounterId: 1, Aggregation: "avg", Interval: 60, CounterId: 100}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if dataType, ok := config.CounterDataType(100); !ok || dataType != "float64" {
+
+		t.Errorf("derived counter not added, got %q", dataType)
+
+	}
+
+	if err = continuousQueries.Register(ContinuousQuery{Name: "fleet", SourceCounterId: 1, Aggregation: "sum", Interval: 60, CounterId: 101}); err != ErrDuplicateName {
+
+		t.Errorf("expected %v, got %v", ErrDuplicateName, err)
+
+	}
+
+	start := continuousQueries.List()[0].Watermark
+
+	continuousQueries.Written(StoragePoolKey{CounterId: 1}, 7, []DataPoint{{Timestamp: start, Value: uint64(2)}, {Timestamp: start + 59, Value: uint64(4)}})
+
+	continuousQueries.Written(StoragePoolKey{CounterId: 1}, 8, []DataPoint{{Timestamp: start + 30, Value: uint64(6)}, {Timestamp: start + 60, Value: uint64(10)}})
+
+	if dataPoints := continuousQueries.Due(start + 60); len(dataPoints) != 0 {
+
+		t.Errorf("interval written before the grace period, got %v", dataPoints)
+
+	}
+
+	dataPoints := continuousQueries.Due(start + 70)
+
+	if len(dataPoints) != 1 || dataPoints[0].Timestamp != start || dataPoints[0].CounterId != 100 || dataPoints[0].Value != 4.0 {
+
+		t.Fatalf("unexpected derived dataPoints %v", dataPoints)
+
+	}
+
+	// Late points of the written interval are dropped
+	continuousQueries.Written(StoragePoolKey{CounterId: 1}, 7, []DataPoint{{Timestamp: start + 1, Value: uint64(100)}})
+
+	if dataPoints = continuousQueries.Due(start + 200); len(dataPoints) != 1 || dataPoints[0].Value != 10.0 {
+
+		t.Errorf("unexpected derived dataPoints %v", dataPoints)
+
+	}
+
+	// The flushed derived counter moves the persisted watermark
+	continuousQueries.Written(StoragePoolKey{CounterId: 100}, 0, []DataPoint{{Timestamp: start, Value: 4.0}})
+
+	// Restarted with the counter config only
+	reloadedConfig := DefaultConfig()
+
+	reloadedConfig.StorageDirectory = config.StorageDirectory
+
+	_ = reloadedConfig.AddCounter(1, "uint64")
+
+	reloadedStoragePool, err := InitStoragePool(reloadedConfig)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer reloadedStoragePool.ClosePool()
+
+	reloaded, err := LoadContinuousQueries(reloadedStoragePool)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if definitions := reloaded.List(); len(definitions) != 1 || definitions[0].Watermark != start+60 || definitions[0].Aggregation != "avg" {
+
+		t.Errorf("unexpected reloaded definitions %+v", definitions)
+
+	}
+
+	if _, ok := reloadedConfig.CounterDataType(100); !ok {
+
+		t.Errorf("derived counter not added on reload")
+
+	}
+
+}
@@ -1,62 +1,549 @@
 package db
 
 import (
+	"context"
 	. "datastore/containers"
+	. "datastore/continuous"
 	. "datastore/query"
 	. "datastore/utils"
 	. "datastore/writer"
+	"errors"
+	"fmt"
 	"go.uber.org/zap"
+	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
+var ErrClosed = errors.New("database is closed")
+
+// Options of the database.
+type Options struct {
+	// Config of the database, DefaultConfig when nil. It belongs to the database once opened.
+	Config *Config
+
+	// Counters maps counterIds to their dataType, in addition to the config's counters
+	Counters map[uint16]string
+
+	// Logger replaces the config's logger
+	Logger *zap.Logger
+}
+
+// ReportDB is the datastore, usable as a library. The server is a wrapper over it, exposing Write and Query over ZMQ.
 type ReportDB struct {
 	storagePool *StoragePool
 
 	dataWriteChannel chan []PolledDataPoint
+
+	queryReceiveChannel chan Query
+
+	queryResultChannel chan Result
+
+	activeQueries *ActiveQueries
+
+	// QueryId given to the engine -> caller waiting for the result chunks
+	pendingQueries map[uint64]*pendingQuery
+
+	pendingQueriesLock sync.Mutex
+
+	lastQueryId atomic.Uint64
+
+	// Held for writing while closing, so that Write and Query never send on the closed channels
+	closeLock sync.RWMutex
+
+	closed bool
+
+	unlockStorageDirectory func()
+
+	unregisterMetrics func()
+
+	// Serves the metrics, nil when disabled
+	metricsServer *http.Server
+
+	shutdownWaitGroup sync.WaitGroup
 }
 
-func InitDB(dataWriteChannel <-chan []PolledDataPoint, queryReceiveChannel <-chan Query, queryResultChannel chan<- Result, globalShutdown <-chan bool, globalShutdownWaitGroup *sync.WaitGroup) {
+// Open starts the datastore over the directory, which stays locked till Close. The metrics are served on the config's
+// MetricsPort till Close too.
+func Open(directory string, options Options) (*ReportDB, error) {
+
+	config := options.Config
+
+	if config == nil {
+
+		config = DefaultConfig()
+
+	}
+
+	if options.Logger != nil {
+
+		config.Logger = options.Logger
+
+	}
+
+	config.StorageDirectory = directory
+
+	for counterId, dataType := range options.Counters {
+
+		err := config.AddCounter(counterId, dataType)
+
+		if errors.Is(err, ErrCounterExists) {
+
+			if configured, _ := config.CounterDataType(counterId); configured == dataType {
+
+				continue
+
+			}
 
-	defer globalShutdownWaitGroup.Done()
+		}
 
-	defer Logger.Info("database closed")
+		if err != nil {
 
-	// Ensure storage directory is created.
-	//err := os.MkdirAll(filepath.Dir(filepath.Dir(CurrentWorkingDirectory))+"/data", 0777)
+			return nil, fmt.Errorf("counter c%d: %w", counterId, err)
 
-	if err := os.MkdirAll(StorageDirectory, 0777); err != nil {
+		}
 
-		Logger.Error("error creating data directory:" + err.Error())
+	}
+
+	if err := os.MkdirAll(directory, 0777); err != nil {
 
-		return
+		return nil, err
+
+	}
+
+	unlockStorageDirectory, err := LockStorageDirectory(directory)
+
+	if err != nil {
+
+		return nil, err
 
 	}
 
 	// Initialize Containers
 
-	storagePool := InitStoragePool()
+	storagePool, err := InitStoragePool(config)
+
+	if err != nil {
+
+		unlockStorageDirectory()
+
+		return nil, err
+
+	}
+
+	// Continuous queries catch up on the flushed data before the writers start
+	continuousQueries, err := LoadContinuousQueries(storagePool)
+
+	if err == nil {
+
+		var latestValues *LatestValues
+
+		if latestValues, err = LoadLatestValues(config); err == nil {
+
+			return start(storagePool, continuousQueries, latestValues, unlockStorageDirectory), nil
+
+		}
+
+	}
+
+	storagePool.ClosePool()
+
+	unlockStorageDirectory()
+
+	return nil, err
+
+}
+
+func start(storagePool *StoragePool, continuousQueries *ContinuousQueries, latestValues *LatestValues, unlockStorageDirectory func()) *ReportDB {
+
+	reportDB := &ReportDB{
+
+		storagePool: storagePool,
+
+		dataWriteChannel: make(chan []PolledDataPoint, storagePool.Config.DataWriteChannelSize),
+
+		queryReceiveChannel: make(chan Query, storagePool.Config.QueryChannelSize),
+
+		queryResultChannel: make(chan Result, storagePool.Config.QueryChannelSize),
+
+		activeQueries: NewActiveQueries(storagePool.Config),
+
+		pendingQueries: make(map[uint64]*pendingQuery),
+
+		unlockStorageDirectory: unlockStorageDirectory,
+	}
+
+	reportDB.unregisterMetrics = storagePool.Config.Metrics.GaugeFunc("reportdb_data_write_channel_depth", "Batches of data points waiting for the writers.", func() float64 {
+
+		return float64(len(reportDB.dataWriteChannel))
+
+	})
+
+	reportDB.metricsServer = InitMetrics(storagePool.Config)
+
+	reportDB.shutdownWaitGroup.Add(3)
+
+	go InitWriteHandler(reportDB.dataWriteChannel, storagePool, continuousQueries, latestValues, &reportDB.shutdownWaitGroup)
+
+	go InitQueryEngine(reportDB.queryReceiveChannel, reportDB.queryResultChannel, storagePool, reportDB.activeQueries, continuousQueries, latestValues, &reportDB.shutdownWaitGroup)
+
+	go reportDB.dispatchResults()
+
+	return reportDB
+
+}
+
+// Config returns the database's config.
+func (reportDB *ReportDB) Config() *Config {
+
+	return reportDB.storagePool.Config
+
+}
+
+// Write hands the dataPoints over to the writers, they are flushed to storage within the config's FlushDuration.
+// It returns ErrDiskFull while the free disk space is below the config's MinFreeDiskSpaceInMB.
+func (reportDB *ReportDB) Write(dataPoints []PolledDataPoint) error {
+
+	reportDB.closeLock.RLock()
+
+	defer reportDB.closeLock.RUnlock()
+
+	if reportDB.closed {
+
+		return ErrClosed
+
+	}
+
+	// Rejected upfront rather than dropped by the writers once the disk is full
+	if err := reportDB.storagePool.IngestAllowed(); err != nil {
+
+		reportDB.storagePool.Config.Metrics.RejectedPoints.Add(uint64(len(dataPoints)))
+
+		return err
+
+	}
+
+	reportDB.dataWriteChannel <- dataPoints
+
+	reportDB.storagePool.Config.Metrics.IngestedPoints.Add(uint64(len(dataPoints)))
+
+	return nil
+
+}
+
+// pendingQuery is a query waiting for the chunks of its result. The chunks are queued, so that the dispatcher never
+// waits for a caller slow to handle them, and the other queries' chunks are not held behind.
+type pendingQuery struct {
+	chunks []Result
+
+	// Set once the engine stopped without answering
+	closed bool
+
+	// Signals the caller that chunks were queued, or the query closed
+	ready chan struct{}
+
+	lock sync.Mutex
+}
+
+func (pending *pendingQuery) push(chunk Result) {
+
+	pending.lock.Lock()
+
+	pending.chunks = append(pending.chunks, chunk)
+
+	pending.lock.Unlock()
+
+	pending.signal()
+
+}
+
+func (pending *pendingQuery) close() {
+
+	pending.lock.Lock()
+
+	pending.closed = true
+
+	pending.lock.Unlock()
+
+	pending.signal()
+
+}
+
+func (pending *pendingQuery) signal() {
+
+	select {
+
+	case pending.ready <- struct{}{}:
+
+	default:
+
+	}
+
+}
+
+// take returns the queued chunks, and whether the query was closed.
+func (pending *pendingQuery) take() ([]Result, bool) {
+
+	pending.lock.Lock()
+
+	defer pending.lock.Unlock()
 
-	if err := InitDataPointsCache(); err != nil {
+	chunks := pending.chunks
 
-		Logger.Error("Error initializing data points cache", zap.Error(err))
+	pending.chunks = nil
+
+	return chunks, pending.closed
+
+}
+
+// Query runs the query and waits for its result, the query is cancelled if ctx is done first. The error of a failed
+// query is also set in the returned Result.
+func (reportDB *ReportDB) Query(ctx context.Context, query Query) (Result, error) {
+
+	var result Result
+
+	err := reportDB.QueryStream(ctx, query, func(chunk Result) {
+
+		AppendResultChunk(&result, chunk)
+
+	})
+
+	if err != nil && result.Error == "" {
+
+		result = Result{QueryId: query.QueryId, Error: err.Error()}
+
+	}
+
+	return result, err
+
+}
+
+// QueryStream runs the query like Query, handing the chunks of its result to handle in sequence order, as the engine
+// produces them. Every chunk but the last is Partial, the last one carrying the error of a failed query.
+func (reportDB *ReportDB) QueryStream(ctx context.Context, query Query, handle func(chunk Result)) error {
+
+	queryId := query.QueryId
+
+	// Callers may reuse QueryIds, the engine gets unique ones
+	query.QueryId = reportDB.lastQueryId.Add(1)
+
+	pending := &pendingQuery{ready: make(chan struct{}, 1)}
+
+	reportDB.pendingQueriesLock.Lock()
+
+	reportDB.pendingQueries[query.QueryId] = pending
+
+	reportDB.pendingQueriesLock.Unlock()
+
+	if err := reportDB.submit(ctx, query); err != nil {
+
+		reportDB.forget(query.QueryId)
+
+		return err
+
+	}
+
+	for {
+
+		select {
+
+		case <-pending.ready:
+
+			chunks, closed := pending.take()
+
+			for _, chunk := range chunks {
+
+				chunk.QueryId = queryId
+
+				handle(chunk)
+
+				if chunk.Partial {
+
+					continue
+
+				}
+
+				if chunk.Error != "" {
+
+					return errors.New(chunk.Error)
+
+				}
+
+				return nil
+
+			}
+
+			if closed {
+
+				return ErrClosed
+
+			}
+
+		case <-ctx.Done():
+
+			reportDB.forget(query.QueryId)
+
+			reportDB.activeQueries.Cancel(query.QueryId, query.Timeout)
+
+			return ctx.Err()
+
+		}
 
 	}
 
-	var dbShutdownWaitGroup sync.WaitGroup
+}
+
+// ReloadCounters registers the counters of the config's counters file, new counters are written and queried right
+// away. The result lists the counters once reloaded.
+func (reportDB *ReportDB) ReloadCounters(ctx context.Context) (Result, error) {
+
+	return reportDB.Query(ctx, Query{ReloadCounters: true})
+
+}
+
+// RegisterCounters adds or updates the counters, see ReloadCounters.
+func (reportDB *ReportDB) RegisterCounters(ctx context.Context, counters map[uint16]string) (Result, error) {
+
+	query := Query{RegisterCounters: make([]CounterMetadata, 0, len(counters))}
+
+	for counterId, dataType := range counters {
 
-	dbShutdownWaitGroup.Add(2)
+		query.RegisterCounters = append(query.RegisterCounters, CounterMetadata{CounterId: counterId, DataType: dataType})
 
-	go InitWriteHandler(dataWriteChannel, storagePool, &dbShutdownWaitGroup)
+	}
+
+	return reportDB.Query(ctx, query)
+
+}
 
-	go InitQueryEngine(queryReceiveChannel, queryResultChannel, storagePool, &dbShutdownWaitGroup)
+func (reportDB *ReportDB) submit(ctx context.Context, query Query) error {
 
-	<-globalShutdown
+	reportDB.closeLock.RLock()
+
+	defer reportDB.closeLock.RUnlock()
+
+	if reportDB.closed {
+
+		return ErrClosed
+
+	}
+
+	if err := ctx.Err(); err != nil {
+
+		return err
+
+	}
+
+	select {
+
+	case reportDB.queryReceiveChannel <- query:
+
+		return nil
+
+	case <-ctx.Done():
+
+		return ctx.Err()
+
+	}
+
+}
+
+func (reportDB *ReportDB) forget(queryId uint64) {
+
+	reportDB.pendingQueriesLock.Lock()
+
+	defer reportDB.pendingQueriesLock.Unlock()
+
+	delete(reportDB.pendingQueries, queryId)
+
+}
+
+// dispatchResults hands the chunks of the engine's results over to the callers waiting for them.
+func (reportDB *ReportDB) dispatchResults() {
+
+	defer reportDB.shutdownWaitGroup.Done()
+
+	for chunk := range reportDB.queryResultChannel {
+
+		reportDB.pendingQueriesLock.Lock()
+
+		pending, ok := reportDB.pendingQueries[chunk.QueryId]
+
+		if !chunk.Partial {
+
+			delete(reportDB.pendingQueries, chunk.QueryId)
+
+		}
+
+		reportDB.pendingQueriesLock.Unlock()
+
+		if ok {
+
+			pending.push(chunk)
+
+		}
+
+	}
+
+	// Engine stopped, the remaining queries will never be answered
+	reportDB.pendingQueriesLock.Lock()
+
+	defer reportDB.pendingQueriesLock.Unlock()
+
+	for queryId, pending := range reportDB.pendingQueries {
+
+		pending.close()
+
+		delete(reportDB.pendingQueries, queryId)
+
+	}
+
+}
+
+// Close flushes the buffered dataPoints, waits for the queries being processed and releases the directory.
+func (reportDB *ReportDB) Close() error {
+
+	reportDB.closeLock.Lock()
+
+	if reportDB.closed {
+
+		reportDB.closeLock.Unlock()
+
+		return ErrClosed
+
+	}
+
+	reportDB.closed = true
+
+	close(reportDB.dataWriteChannel)
+
+	close(reportDB.queryReceiveChannel)
+
+	reportDB.closeLock.Unlock()
 
 	// Wait for writer Reader to shut down
-	dbShutdownWaitGroup.Wait()
+	reportDB.shutdownWaitGroup.Wait()
+
+	reportDB.unregisterMetrics()
+
+	if reportDB.metricsServer != nil {
+
+		if err := reportDB.metricsServer.Shutdown(context.Background()); err != nil {
+
+			reportDB.storagePool.Config.Logger.Error("error shutting down metrics server", zap.Error(err))
+
+		}
+
+	}
 
 	// Close the storagePool
-	storagePool.ClosePool()
+	reportDB.storagePool.ClosePool()
+
+	reportDB.unlockStorageDirectory()
+
+	reportDB.storagePool.Config.Logger.Info("database closed")
+
+	return nil
 
 }
@@ -0,0 +1,1147 @@
+package db
+
+import (
+	"context"
+	. "datastore/containers"
+	. "datastore/continuous"
+	. "datastore/query"
+	. "datastore/storage"
+	. "datastore/utils"
+	"errors"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportDB(t *testing.T) {
+
+	directory := t.TempDir()
+
+	options := Options{Counters: map[uint16]string{1: "float64"}, Logger: zap.NewNop()}
+
+	reportDB, err := Open(directory, options)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if _, err = Open(directory, options); !errors.Is(err, ErrStorageDirectoryLocked) {
+
+		t.Fatalf("expected %v, got %v", ErrStorageDirectoryLocked, err)
+
+	}
+
+	day := uint64(time.Now().Unix()) - uint64(time.Now().Unix())%86400 - 86400
+
+	if err = reportDB.Write([]PolledDataPoint{{Timestamp: day + 10, CounterId: 1, ObjectId: 7, Value: 1.5}, {Timestamp: day + 20, CounterId: 1, ObjectId: 7, Value: 2.5}}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	// Closing flushes the buffered dataPoints
+	if err = reportDB.Close(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if err = reportDB.Write(nil); !errors.Is(err, ErrClosed) {
+
+		t.Errorf("expected %v, got %v", ErrClosed, err)
+
+	}
+
+	if reportDB, err = Open(directory, options); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer reportDB.Close()
+
+	query := Query{QueryId: 42, From: day, To: day + 86399, CounterId: 1, ObjectIds: []uint32{7}, ObjectWiseAggregation: "none", TimestampAggregation: "none"}
+
+	result, err := reportDB.Query(context.Background(), query)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if result.QueryId != 42 || len(result.Data[7]) != 2 || result.Data[7][1].Value != 2.5 {
+
+		t.Errorf("unexpected result %+v", result)
+
+	}
+
+	query.CounterId = 2
+
+	if _, err = reportDB.Query(context.Background(), query); err == nil || err.Error() != ErrUnknownCounter.Error() {
+
+		t.Errorf("expected %v, got %v", ErrUnknownCounter, err)
+
+	}
+
+	cancelledContext, cancel := context.WithCancel(context.Background())
+
+	cancel()
+
+	if _, err = reportDB.Query(cancelledContext, query); !errors.Is(err, context.Canceled) {
+
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+
+	}
+
+}
+
+func TestReportDBsSideBySide(t *testing.T) {
+
+	numeric, err := Open(t.TempDir(), Options{Counters: map[uint16]string{1: "float64"}})
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer numeric.Close()
+
+	// Same counter with another dataType in a second datastore of the process
+	strings, err := Open(t.TempDir(), Options{Counters: map[uint16]string{1: "string"}})
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer strings.Close()
+
+	if dataType, _ := numeric.Config().CounterDataType(1); dataType != "float64" {
+
+		t.Errorf("expected float64, got %q", dataType)
+
+	}
+
+	if dataType, _ := strings.Config().CounterDataType(1); dataType != "string" {
+
+		t.Errorf("expected string, got %q", dataType)
+
+	}
+
+	now := uint64(time.Now().Unix())
+
+	if err = numeric.Write([]PolledDataPoint{{Timestamp: now, CounterId: 1, ObjectId: 1, Value: 1.0}}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if err = strings.Write([]PolledDataPoint{{Timestamp: now, CounterId: 1, ObjectId: 1, Value: "up"}}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	result, err := strings.Query(context.Background(), Query{CounterId: 1, Latest: true})
+
+	if err != nil || len(result.Data[1]) != 1 || result.Data[1][0].Value != "up" {
+
+		t.Errorf("unexpected result %+v, %v", result, err)
+
+	}
+
+}
+
+func TestCounterRegistry(t *testing.T) {
+
+	directory := t.TempDir()
+
+	config := DefaultConfig()
+
+	config.CountersFile = directory + "/counters.json"
+
+	reportDB, err := Open(directory+"/data", Options{Config: config, Counters: map[uint16]string{1: "float64"}})
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer reportDB.Close()
+
+	ctx := context.Background()
+
+	result, err := reportDB.RegisterCounters(ctx, map[uint16]string{2: "float64", 3: "uint64"})
+
+	if err != nil || len(result.Counters) != 3 {
+
+		t.Fatalf("unexpected result %+v, %v", result, err)
+
+	}
+
+	// Registered counters are written right away
+	if err = reportDB.Write([]PolledDataPoint{{Timestamp: uint64(time.Now().Unix()), CounterId: 2, ObjectId: 1, Value: 1.0}}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+
+		if result, err = reportDB.Query(ctx, Query{CounterId: 2, Latest: true}); err == nil && len(result.Data[1]) == 1 {
+
+			break
+
+		}
+
+		if time.Now().After(deadline) {
+
+			t.Fatalf("dataPoint of the registered counter not written, %+v, %v", result, err)
+
+		}
+
+	}
+
+	if _, err = reportDB.RegisterCounters(ctx, map[uint16]string{2: "string"}); err == nil || !strings.Contains(err.Error(), ErrCounterDataTypeChange.Error()) {
+
+		t.Errorf("expected %v, got %v", ErrCounterDataTypeChange, err)
+
+	}
+
+	// Same encoding, or no data yet
+	if _, err = reportDB.RegisterCounters(ctx, map[uint16]string{2: "float64", 3: "string"}); err != nil {
+
+		t.Error(err)
+
+	}
+
+	if _, err = reportDB.RegisterCounters(ctx, map[uint16]string{4: "decimal"}); err == nil || !strings.Contains(err.Error(), ErrUnsupportedDataType.Error()) {
+
+		t.Errorf("expected %v, got %v", ErrUnsupportedDataType, err)
+
+	}
+
+	if err = os.WriteFile(config.CountersFile, []byte(`{"5": {"dataType": "int32"}}`), 0644); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if result, err = reportDB.ReloadCounters(ctx); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	// Counters absent from the file are kept
+	if len(result.Counters) != 4 || result.Counters[3] != (CounterMetadata{CounterId: 5, DataType: "int32", Precision: PrecisionSeconds}) {
+
+		t.Errorf("unexpected counters %+v", result.Counters)
+
+	}
+
+}
+
+func TestInstances(t *testing.T) {
+
+	directory := t.TempDir()
+
+	options := Options{Counters: map[uint16]string{1: "float64"}}
+
+	reportDB, err := Open(directory, options)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	day := uint64(time.Now().Unix()) - uint64(time.Now().Unix())%86400 - 86400
+
+	if err = reportDB.Write([]PolledDataPoint{
+		{Timestamp: day + 10, CounterId: 1, ObjectId: 7, Value: 5.0},
+		{Timestamp: day + 10, CounterId: 1, ObjectId: 7, Instance: "eth0", Value: 1.0},
+		{Timestamp: day + 10, CounterId: 1, ObjectId: 7, Instance: "eth1", Value: 3.0},
+		{Timestamp: day + 10, CounterId: 1, ObjectId: 8, Instance: "eth0", Value: 10.0},
+	}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	// The instance dictionaries of the days are reloaded on reopening
+	if err = reportDB.Close(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if reportDB, err = Open(directory, options); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer reportDB.Close()
+
+	query := Query{From: day, To: day + 86399, CounterId: 1, ObjectWiseAggregation: "none", TimestampAggregation: "none"}
+
+	result, err := reportDB.Query(context.Background(), query)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	values := make(map[SeriesInstance]interface{})
+
+	for seriesKey, points := range result.Data {
+
+		series, ok := result.Instances[seriesKey]
+
+		if !ok {
+
+			series = SeriesInstance{ObjectId: seriesKey}
+
+		}
+
+		values[series] = points[0].Value
+
+	}
+
+	expected := map[SeriesInstance]interface{}{
+
+		{ObjectId: 7}: 5.0,
+
+		{ObjectId: 7, Instance: "eth0"}: 1.0,
+
+		{ObjectId: 7, Instance: "eth1"}: 3.0,
+
+		{ObjectId: 8, Instance: "eth0"}: 10.0,
+	}
+
+	if len(values) != len(expected) || len(result.Instances) != 3 {
+
+		t.Fatalf("expected %v, got %v", expected, values)
+
+	}
+
+	for series, value := range expected {
+
+		if values[series] != value {
+
+			t.Errorf("%v: expected %v, got %v", series, value, values[series])
+
+		}
+
+	}
+
+	// Aggregated by instance over the objects
+	query.Instances, query.ObjectWiseAggregation = []string{"eth0"}, "sum"
+
+	if result, err = reportDB.Query(context.Background(), query); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if len(result.Data) != 1 {
+
+		t.Errorf("expected the eth0 aggregate only, got %+v", result.Data)
+
+	}
+
+	for seriesKey, points := range result.Data {
+
+		if result.Instances[seriesKey] != (SeriesInstance{Instance: "eth0"}) || points[0].Value != 11.0 {
+
+			t.Errorf("unexpected series %d %v: %+v", seriesKey, result.Instances[seriesKey], points)
+
+		}
+
+	}
+
+	// Aggregated over the instances of the object
+	query.ObjectIds, query.Instances, query.InstanceAggregation, query.ObjectWiseAggregation = []uint32{7}, []string{"eth0", "eth1"}, "sum", "none"
+
+	if result, err = reportDB.Query(context.Background(), query); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if len(result.Data) != 1 || len(result.Data[7]) != 1 || result.Data[7][0].Value != 4.0 || result.Instances != nil {
+
+		t.Errorf("unexpected result %+v", result)
+
+	}
+
+	query.InstanceAggregation = "median"
+
+	if _, err = reportDB.Query(context.Background(), query); err == nil || err.Error() != ErrInvalidInstanceAggregation.Error() {
+
+		t.Errorf("expected %v, got %v", ErrInvalidInstanceAggregation, err)
+
+	}
+
+	// Instance series have their latest values too
+	result, err = reportDB.Query(context.Background(), Query{CounterId: 1, ObjectIds: []uint32{7}, Instances: []string{"eth1"}, Latest: true})
+
+	if err != nil || len(result.Data) != 1 {
+
+		t.Fatalf("unexpected latest result %+v, %v", result, err)
+
+	}
+
+	for seriesKey, points := range result.Data {
+
+		if result.Instances[seriesKey] != (SeriesInstance{ObjectId: 7, Instance: "eth1"}) || points[0].Value != 3.0 {
+
+			t.Errorf("unexpected latest series %d %v: %+v", seriesKey, result.Instances[seriesKey], points)
+
+		}
+
+	}
+
+}
+
+func TestObjectRegistry(t *testing.T) {
+
+	directory := t.TempDir()
+
+	options := Options{Counters: map[uint16]string{1: "float64"}}
+
+	reportDB, err := Open(directory, options)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	day := uint64(time.Now().Unix()) - uint64(time.Now().Unix())%86400 - 86400
+
+	if err = reportDB.Write([]PolledDataPoint{
+		{Timestamp: day + 10, CounterId: 1, Object: "2001:db8::1", Value: 1.0},
+		{Timestamp: day + 10, CounterId: 1, Object: "web-01", Value: 2.0},
+		{Timestamp: day + 10, CounterId: 1, Object: "10.0.0.1", Value: 3.0},
+		// Raw objectIds in the registry's range are refused, they would be written under the registered objects
+		{Timestamp: day + 20, CounterId: 1, ObjectId: ObjectKeyBase + 1, Value: 9.0},
+	}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	// Objects keep their objectIds on reopening
+	if err = reportDB.Close(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if reportDB, err = Open(directory, options); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer reportDB.Close()
+
+	query := Query{From: day, To: day + 86399, CounterId: 1, Objects: []string{"2001:DB8::1", "web-01", "10.0.0.1", "web-02"}, ObjectWiseAggregation: "none", TimestampAggregation: "none"}
+
+	result, err := reportDB.Query(context.Background(), query)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	values := make(map[string]interface{})
+
+	for objectId, points := range result.Data {
+
+		object, ok := result.ObjectIdentifiers[objectId]
+
+		if !ok {
+
+			object = strconv.FormatUint(uint64(objectId), 10)
+
+		}
+
+		if len(points) != 1 {
+
+			t.Errorf("expected a single point of %s, got %v", object, points)
+
+		}
+
+		values[object] = points[0].Value
+
+	}
+
+	expected := map[string]interface{}{"2001:db8::1": 1.0, "web-01": 2.0, "167772161": 3.0}
+
+	if !reflect.DeepEqual(values, expected) {
+
+		t.Errorf("expected %v, got %v", expected, values)
+
+	}
+
+	// Unknown objects have no data, rather than selecting every object
+	query.Objects = []string{"web-02"}
+
+	if result, err = reportDB.Query(context.Background(), query); err != nil || len(result.Data) != 0 {
+
+		t.Errorf("expected no data, got %v, error: %v", result.Data, err)
+
+	}
+
+	// Continuous queries resolve their objects through the registry too
+	result, err = reportDB.Query(context.Background(), Query{RegisterContinuousQuery: &ContinuousQuery{Name: "web", SourceCounterId: 1, Objects: []string{"web-01", "10.0.0.1"}, Aggregation: "avg", Interval: 300, CounterId: 100}})
+
+	if err != nil || len(result.ContinuousQueries) != 1 {
+
+		t.Fatalf("unexpected result %+v, error: %v", result, err)
+
+	}
+
+	objectIds := result.ContinuousQueries[0].ObjectIds
+
+	if len(objectIds) != 2 || result.ObjectIdentifiers[objectIds[0]] != "web-01" || objectIds[1] != 167772161 {
+
+		t.Errorf("unexpected continuous query objects %v, %v", objectIds, result.ObjectIdentifiers)
+
+	}
+
+}
+
+func TestMillisecondPrecision(t *testing.T) {
+
+	directory := t.TempDir()
+
+	newOptions := func() Options {
+
+		config := DefaultConfig()
+
+		counters := map[uint16]CounterConfig{1: {DataType: "float64", Precision: PrecisionMilliseconds}, 2: {DataType: "float64"}}
+
+		if _, err := config.RegisterCounters(counters, func(uint16) bool { return false }); err != nil {
+
+			t.Fatal(err)
+
+		}
+
+		return Options{Config: config, Logger: zap.NewNop()}
+
+	}
+
+	reportDB, err := Open(directory, newOptions())
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	day := uint64(time.Now().Unix()) - uint64(time.Now().Unix())%86400 - 86400
+
+	if err = reportDB.Write([]PolledDataPoint{
+		{Timestamp: day*1000 + 10250, CounterId: 1, ObjectId: 7, Value: 1.0},
+		{Timestamp: day*1000 + 10750, CounterId: 1, ObjectId: 7, Value: 3.0},
+		{Timestamp: day + 10, CounterId: 2, ObjectId: 7, Value: 5.0},
+	}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if err = reportDB.Close(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if reportDB, err = Open(directory, newOptions()); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer reportDB.Close()
+
+	timestamps := func(query Query) []uint64 {
+
+		result, err := reportDB.Query(context.Background(), query)
+
+		if err != nil {
+
+			t.Fatal(err)
+
+		}
+
+		var timestamps []uint64
+
+		for _, point := range result.Data[7] {
+
+			timestamps = append(timestamps, point.Timestamp)
+
+		}
+
+		return timestamps
+
+	}
+
+	query := Query{From: day * 1000, To: day*1000 + 86399999, Precision: PrecisionMilliseconds, CounterId: 1, ObjectWiseAggregation: "none", TimestampAggregation: "none"}
+
+	if got := timestamps(query); !reflect.DeepEqual(got, []uint64{day*1000 + 10250, day*1000 + 10750}) {
+
+		t.Errorf("unexpected milliseconds %v", got)
+
+	}
+
+	// Seconds counters are widened, milliseconds counters truncated
+	query.CounterId = 2
+
+	if got := timestamps(query); !reflect.DeepEqual(got, []uint64{day*1000 + 10000}) {
+
+		t.Errorf("unexpected widened seconds %v", got)
+
+	}
+
+	query = Query{From: day, To: day + 86399, CounterId: 1, ObjectWiseAggregation: "none", TimestampAggregation: "none"}
+
+	if got := timestamps(query); !reflect.DeepEqual(got, []uint64{day + 10, day + 10}) {
+
+		t.Errorf("unexpected truncated milliseconds %v", got)
+
+	}
+
+	query = Query{From: day * 1000, To: day*1000 + 86399999, Precision: PrecisionMilliseconds, Interval: 500, CounterId: 1, ObjectWiseAggregation: "none", TimestampAggregation: "avg"}
+
+	if got := timestamps(query); !reflect.DeepEqual(got, []uint64{day*1000 + 10000, day*1000 + 10500}) {
+
+		t.Errorf("unexpected milliseconds intervals %v", got)
+
+	}
+
+	query.Precision = "us"
+
+	if _, err = reportDB.Query(context.Background(), query); err == nil || err.Error() != ErrUnsupportedPrecision.Error() {
+
+		t.Errorf("expected %v, got %v", ErrUnsupportedPrecision, err)
+
+	}
+
+}
+
+func TestCompaction(t *testing.T) {
+
+	directory := t.TempDir()
+
+	options := Options{Counters: map[uint16]string{1: "float64"}, Logger: zap.NewNop()}
+
+	reportDB, err := Open(directory, options)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	day := uint64(time.Now().Unix()) - uint64(time.Now().Unix())%86400 - 3*86400
+
+	if err = reportDB.Write([]PolledDataPoint{
+		{Timestamp: day + 10, CounterId: 1, ObjectId: 7, Value: 1.5},
+		{Timestamp: day + 20, CounterId: 1, ObjectId: 7, Value: 2.5},
+		{Timestamp: day + 10, CounterId: 1, ObjectId: 7, Instance: "eth0", Value: 4.0},
+	}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if err = reportDB.Close(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if reportDB, err = Open(directory, options); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if compacted := reportDB.storagePool.CompactColdDays(); compacted != 1 {
+
+		t.Fatalf("expected 1 day compacted, got %d", compacted)
+
+	}
+
+	if !IsSegment(directory + "/" + UnixToDate(day+10).Format() + "/1") {
+
+		t.Fatal("expected the day compacted into a segment")
+
+	}
+
+	// The compacted day is read through the storage pool like any other
+	query := Query{From: day, To: day + 86399, CounterId: 1, ObjectIds: []uint32{7}, Instances: []string{"", "eth0"}, ObjectWiseAggregation: "none", TimestampAggregation: "none"}
+
+	result, err := reportDB.Query(context.Background(), query)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if len(result.Data[7]) != 2 || result.Data[7][1].Value != 2.5 || len(result.Instances) != 1 {
+
+		t.Errorf("unexpected result %+v", result)
+
+	}
+
+	// A late write expands the day back into a mutable storage, keeping the compacted points
+	if err = reportDB.Write([]PolledDataPoint{{Timestamp: day + 30, CounterId: 1, ObjectId: 7, Value: 3.5}}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if err = reportDB.Close(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if reportDB, err = Open(directory, options); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer reportDB.Close()
+
+	if IsSegment(directory + "/" + UnixToDate(day+10).Format() + "/1") {
+
+		t.Error("expected the day expanded by the late write")
+
+	}
+
+	if result, err = reportDB.Query(context.Background(), query); err != nil || len(result.Data[7]) != 3 || result.Data[7][2].Value != 3.5 || len(result.Instances) != 1 {
+
+		t.Errorf("unexpected result after the late write %+v, %v", result, err)
+
+	}
+
+}
+
+func TestDiskSpaceGuardrails(t *testing.T) {
+
+	directory := t.TempDir()
+
+	newOptions := func(minFreeDiskSpaceInMB, retentionFreeDiskSpaceInMB int64) Options {
+
+		config := DefaultConfig()
+
+		config.MinFreeDiskSpaceInMB, config.RetentionFreeDiskSpaceInMB = minFreeDiskSpaceInMB, retentionFreeDiskSpaceInMB
+
+		return Options{Config: config, Counters: map[uint16]string{1: "float64"}, Logger: zap.NewNop()}
+
+	}
+
+	reportDB, err := Open(directory, newOptions(0, 0))
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	today := uint64(time.Now().Unix())
+
+	oldDay := today - 3*86400
+
+	if err = reportDB.Write([]PolledDataPoint{{Timestamp: oldDay, CounterId: 1, ObjectId: 7, Value: 1.0}, {Timestamp: today, CounterId: 1, ObjectId: 7, Value: 2.0}}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if err = reportDB.Close(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	// No disk is that large, ingest is rejected while the stored days stay readable
+	if reportDB, err = Open(directory, newOptions(1<<40, 0)); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if err = reportDB.Write([]PolledDataPoint{{Timestamp: today, CounterId: 1, ObjectId: 7, Value: 3.0}}); !errors.Is(err, ErrDiskFull) {
+
+		t.Errorf("expected %v, got %v", ErrDiskFull, err)
+
+	}
+
+	query := Query{From: oldDay, To: today, CounterId: 1, ObjectIds: []uint32{7}, ObjectWiseAggregation: "none", TimestampAggregation: "none"}
+
+	if result, err := reportDB.Query(context.Background(), query); err != nil || len(result.Data[7]) != 2 {
+
+		t.Errorf("unexpected result %+v, %v", result, err)
+
+	}
+
+	if err = reportDB.Close(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	// The emergency retention deletes every day but today
+	if reportDB, err = Open(directory, newOptions(0, 1<<40)); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer reportDB.Close()
+
+	if _, err = os.Stat(directory + "/" + UnixToDate(oldDay).Format()); !errors.Is(err, os.ErrNotExist) {
+
+		t.Errorf("expected the old day deleted, got %v", err)
+
+	}
+
+	if result, err := reportDB.Query(context.Background(), query); err != nil || len(result.Data[7]) != 1 || result.Data[7][0].Value != 2.0 {
+
+		t.Errorf("unexpected result %+v, %v", result, err)
+
+	}
+
+}
+
+func TestMetrics(t *testing.T) {
+
+	directory := t.TempDir()
+
+	config := DefaultConfig()
+
+	// Any free port, the database serves the metrics on it till closed
+	listener, err := net.Listen("tcp", "localhost:0")
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	config.MetricsPort = strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	_ = listener.Close()
+
+	metricsURL := "http://localhost:" + config.MetricsPort + "/metrics"
+
+	options := Options{Config: config, Counters: map[uint16]string{1: "float64"}, Logger: zap.NewNop()}
+
+	reportDB, err := Open(directory, options)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	today := uint64(time.Now().Unix())
+
+	if err = reportDB.Write([]PolledDataPoint{{Timestamp: today, CounterId: 1, ObjectId: 7, Value: 1.0}, {Timestamp: today, CounterId: 1, ObjectId: 8, Value: 2.0}}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	query := Query{From: today - 60, To: today, CounterId: 1, ObjectIds: []uint32{7, 8}, ObjectWiseAggregation: "none", TimestampAggregation: "none"}
+
+	// Closing flushes the written points to storage
+	if err = reportDB.Close(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if reportDB, err = Open(directory, options); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if _, err = reportDB.Query(context.Background(), query); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	metrics := config.Metrics
+
+	if metrics.IngestedPoints.Value() != 2 || metrics.FlushDuration.Count() == 0 || metrics.StoragePutDuration.Count() != 2 || metrics.QueryDuration.Count() != 1 || metrics.CacheMisses.Value() != 2 {
+
+		t.Errorf("unexpected metrics %+v", metrics)
+
+	}
+
+	var exposition strings.Builder
+
+	if _, err = metrics.WriteTo(&exposition); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	for _, expected := range []string{
+		"# TYPE reportdb_ingested_points_total counter\nreportdb_ingested_points_total 2\n",
+		"reportdb_query_duration_seconds_bucket{le=\"+Inf\"} 1\n",
+		"reportdb_query_duration_seconds_count 1\n",
+		"# TYPE reportdb_data_write_channel_depth gauge\nreportdb_data_write_channel_depth 0\n",
+		"reportdb_reader_request_channel_depth 0\n",
+		"reportdb_pooled_storages 1\n",
+	} {
+
+		if !strings.Contains(exposition.String(), expected) {
+
+			t.Errorf("expected %q in the exposition:\n%s", expected, exposition.String())
+
+		}
+
+	}
+
+	// The reopened database serves the metrics again, its port released by the first close
+	response, err := http.Get(metricsURL)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	_ = response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+
+		t.Errorf("unexpected metrics response %s", response.Status)
+
+	}
+
+	if err = reportDB.Close(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	// The closed database's gauges are gone, along with its mappings
+	exposition.Reset()
+
+	_, _ = metrics.WriteTo(&exposition)
+
+	if strings.Contains(exposition.String(), "reportdb_pooled_storages") || metrics.MappedBytes.Value() != 0 {
+
+		t.Errorf("unexpected exposition after close:\n%s", exposition.String())
+
+	}
+
+	if _, err = http.Get(metricsURL); err == nil {
+
+		t.Error("expected the metrics server shut down by close")
+
+	}
+
+}
+
+func TestQueryStream(t *testing.T) {
+
+	config := DefaultConfig()
+
+	config.ResultChunkSize = 2
+
+	directory := t.TempDir()
+
+	options := Options{Config: config, Counters: map[uint16]string{1: "float64"}, Logger: zap.NewNop()}
+
+	reportDB, err := Open(directory, options)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	today := uint64(time.Now().Unix()) - 60
+
+	var dataPoints []PolledDataPoint
+
+	for objectId := range uint32(3) {
+
+		dataPoints = append(dataPoints, PolledDataPoint{Timestamp: today, CounterId: 1, ObjectId: objectId, Value: 1.0}, PolledDataPoint{Timestamp: today + 10, CounterId: 1, ObjectId: objectId, Value: 2.0})
+
+	}
+
+	if err = reportDB.Write(dataPoints); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	// Closing flushes the written points to storage
+	if err = reportDB.Close(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if reportDB, err = Open(directory, options); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer reportDB.Close()
+
+	query := Query{QueryId: 42, From: today, To: today + 10, CounterId: 1, Explain: true, ObjectWiseAggregation: "none", TimestampAggregation: "none"}
+
+	var chunks []Result
+
+	if err = reportDB.QueryStream(context.Background(), query, func(chunk Result) {
+
+		chunks = append(chunks, chunk)
+
+	}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	// Each object's drilldown fills a chunk, the stats come with the last one
+	if len(chunks) != 3 || chunks[2].Partial || chunks[2].Stats == nil || chunks[0].QueryId != 42 || len(chunks[1].Data[1]) != 2 {
+
+		t.Errorf("unexpected chunks %+v", chunks)
+
+	}
+
+	if result, err := reportDB.Query(context.Background(), query); err != nil || len(result.Data) != 3 || len(result.Data[2]) != 2 {
+
+		t.Errorf("unexpected merged result %+v, %v", result, err)
+
+	}
+
+	// A caller slow to handle its chunks doesn't hold the other queries' results
+	release, slowDone := make(chan struct{}), make(chan error)
+
+	go func() {
+
+		slowDone <- reportDB.QueryStream(context.Background(), query, func(chunk Result) { <-release })
+
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+	defer cancel()
+
+	if result, err := reportDB.Query(ctx, query); err != nil || len(result.Data) != 3 {
+
+		t.Errorf("query held behind a slow caller %+v, %v", result, err)
+
+	}
+
+	close(release)
+
+	if err = <-slowDone; err != nil {
+
+		t.Error(err)
+
+	}
+
+}
+
+func TestListObjects(t *testing.T) {
+
+	directory := t.TempDir()
+
+	options := Options{Counters: map[uint16]string{1: "float64"}, Logger: zap.NewNop()}
+
+	reportDB, err := Open(directory, options)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	day := uint64(time.Now().Unix()) - uint64(time.Now().Unix())%86400 - 3*86400
+
+	if err = reportDB.Write([]PolledDataPoint{
+		{Timestamp: day + 10, CounterId: 1, ObjectId: 1, Value: 1.0},
+		{Timestamp: day + 50000, CounterId: 1, ObjectId: 2, Value: 1.0},
+		{Timestamp: day + 86400 + 10, CounterId: 1, ObjectId: 3, Value: 1.0},
+		{Timestamp: day + 86400 + 50000, CounterId: 1, ObjectId: 4, Instance: "eth0", Value: 1.0},
+	}); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	// Closing flushes the written points to storage
+	if err = reportDB.Close(); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if reportDB, err = Open(directory, options); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer reportDB.Close()
+
+	for _, test := range []struct {
+		from, to  uint64
+		objectIds []uint32
+		expected  []uint32
+	}{
+		{day, day + 2*86400 - 1, nil, []uint32{1, 2, 3, 4}},
+		// The first and last days are bounded within the day
+		{day + 100, day + 86400 + 100, nil, []uint32{2, 3}},
+		{day, day + 86400 + 100, []uint32{2, 3, 9}, []uint32{2, 3}},
+		{day + 100, day + 2*86400 - 1, []uint32{4}, []uint32{4}},
+	} {
+
+		result, err := reportDB.Query(context.Background(), Query{From: test.from, To: test.to, CounterId: 1, ObjectIds: test.objectIds, Metadata: MetadataObjects})
+
+		if err != nil || !reflect.DeepEqual(result.Objects, test.expected) {
+
+			t.Errorf("objects from %d to %d among %v: expected %v, got %v, %v", test.from-day, test.to-day, test.objectIds, test.expected, result.Objects, err)
+
+		}
+
+	}
+
+}
@@ -1,59 +1,81 @@
 package main
 
 import (
-	. "datastore/containers"
+	"context"
 	. "datastore/db"
 	. "datastore/query"
 	. "datastore/server"
 	. "datastore/utils"
+	"go.uber.org/zap"
 	"log"
 	"sync"
 )
 
 func main() {
 
-	if err := LoadConfig(); err != nil {
+	config, err := LoadConfig()
+
+	if err != nil {
 
 		log.Fatal("error loading config:", err)
 
 	}
 
-	if err := InitLogger(); err != nil {
+	if err = InitLogger(config); err != nil {
 
 		log.Fatal("error initializing logger", err)
 
 	}
 
-	go InitProfiling()
+	go InitProfiling(config)
 
-	globalShutdown := InitShutdownHandler(4)
+	reportDB, err := Open(config.StorageDirectory, Options{Config: config})
 
-	var globalShutdownWaitGroup sync.WaitGroup
+	if err != nil {
 
-	dataWriteChannel := make(chan []PolledDataPoint, DataWriteChannelSize)
+		log.Fatal("error opening database:", err)
 
-	queryReceiveChannel := make(chan Query, QueryChannelSize)
+	}
 
-	queryResultChannel := make(chan Result, QueryChannelSize)
+	globalShutdown := InitShutdownHandler(3, config.Logger)
 
-	globalShutdownWaitGroup.Add(4)
+	reloadShutdown := make(chan struct{})
 
-	go InitDB(dataWriteChannel, queryReceiveChannel, queryResultChannel, globalShutdown, &globalShutdownWaitGroup)
+	go InitReloadHandler(func() {
 
-	go InitPollListener(dataWriteChannel, globalShutdown, &globalShutdownWaitGroup)
+		if _, err := reportDB.ReloadCounters(context.Background()); err != nil {
 
-	go InitQueryListener(queryReceiveChannel, globalShutdown, &globalShutdownWaitGroup)
+			config.Logger.Error("error reloading counters", zap.Error(err))
 
-	go InitQueryResultSender(queryResultChannel, &globalShutdownWaitGroup)
+		}
 
-	<-globalShutdown
+	}, reloadShutdown, config.Logger)
+
+	var globalShutdownWaitGroup sync.WaitGroup
+
+	queryResultChannel := make(chan Result, config.QueryChannelSize)
+
+	globalShutdownWaitGroup.Add(3)
 
-	Logger.Info("closing dataWrite and queryReceive channel")
+	go InitPollListener(reportDB, globalShutdown, &globalShutdownWaitGroup)
 
-	close(dataWriteChannel)
+	go InitQueryListener(reportDB, queryResultChannel, globalShutdown, &globalShutdownWaitGroup)
 
-	Logger.Info("main waiting for globalShutdownWaitGroup to finish")
+	go InitQueryResultSender(queryResultChannel, config, &globalShutdownWaitGroup)
 
+	<-globalShutdown
+
+	close(reloadShutdown)
+
+	config.Logger.Info("main waiting for globalShutdownWaitGroup to finish")
+
+	// Listeners stop first, the database closes once nothing writes or queries it anymore
 	globalShutdownWaitGroup.Wait()
 
+	if err = reportDB.Close(); err != nil {
+
+		config.Logger.Error("error closing database", zap.Error(err))
+
+	}
+
 }
@@ -0,0 +1,294 @@
+package query
+
+import (
+	"context"
+	. "datastore/utils"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	PriorityInteractive = "interactive"
+
+	PriorityBatch = "batch"
+)
+
+var (
+	ErrInvalidPriority = errors.New("invalid priority, it must be either 'interactive' or 'batch'")
+
+	ErrAdmissionQueueFull = errors.New("query rejected, admission queue is full")
+
+	ErrAdmissionTimeout = errors.New("query rejected, timed out waiting for admission")
+)
+
+type admissionWaiter struct {
+	weight int
+
+	admitted chan struct{}
+}
+
+// admissionClass is the day-storage scan budget of a single priority class.
+type admissionClass struct {
+	name string
+
+	budget int
+
+	inUse int
+
+	queueSize int
+
+	waiters []*admissionWaiter
+}
+
+// AdmissionController limits the number of day-storages concurrently scanned by the queries of each priority class.
+// Queries over the budget are queued in arrival order, and rejected once the class queue is full.
+type AdmissionController struct {
+	classes map[string]*admissionClass
+
+	lock sync.Mutex
+}
+
+// admittedQuery is a validated query holding the budget of its priority class, ready to be executed by any parser.
+type admittedQuery struct {
+	query Query
+
+	expressions []compiledExpression
+
+	counterIds []uint16
+
+	timeoutContext context.Context
+
+	cancel context.CancelFunc
+
+	release func()
+
+	receivedTime time.Time
+}
+
+// AdmissionQueue waits for the admission of the queries apart from the parsers and hands the admitted ones to whichever
+// parser is free, so that a batch query waiting for budget doesn't hold a parser.
+type AdmissionQueue struct {
+	controller *AdmissionController
+
+	admitted chan admittedQuery
+
+	// Parsers still receiving queries
+	receivers sync.WaitGroup
+
+	// Queries waiting for admission, or admitted and not yet taken by a parser
+	waiting sync.WaitGroup
+}
+
+// NewAdmissionQueue returns the admission queue of the given number of parsers. Its channel is closed once every parser
+// stopped receiving queries and the last waiting one was taken.
+func NewAdmissionQueue(config *Config, parsers int) *AdmissionQueue {
+
+	queue := &AdmissionQueue{
+
+		controller: NewAdmissionController(config),
+
+		admitted: make(chan admittedQuery),
+	}
+
+	queue.receivers.Add(parsers)
+
+	go func() {
+
+		queue.receivers.Wait()
+
+		queue.waiting.Wait()
+
+		close(queue.admitted)
+
+	}()
+
+	return queue
+
+}
+
+func NewAdmissionController(config *Config) *AdmissionController {
+
+	return &AdmissionController{
+
+		classes: map[string]*admissionClass{
+
+			PriorityInteractive: {
+
+				name: PriorityInteractive,
+
+				budget: config.InteractiveDayScanBudget,
+
+				queueSize: config.InteractiveAdmissionQueueSize,
+			},
+
+			PriorityBatch: {
+
+				name: PriorityBatch,
+
+				budget: config.BatchDayScanBudget,
+
+				queueSize: config.BatchAdmissionQueueSize,
+			},
+		},
+	}
+
+}
+
+func NormalizePriority(priority string) (string, error) {
+
+	switch priority {
+
+	case "":
+
+		return PriorityInteractive, nil
+
+	case PriorityInteractive, PriorityBatch:
+
+		return priority, nil
+
+	default:
+
+		return "", ErrInvalidPriority
+
+	}
+
+}
+
+// Admit waits till the class has budget for scanning the given number of day-storages and returns the function releasing it.
+// A query scanning more days than the whole budget takes the whole budget.
+func (controller *AdmissionController) Admit(queryContext context.Context, priority string, days int) (func(), error) {
+
+	controller.lock.Lock()
+
+	class := controller.classes[priority]
+
+	if class.budget <= 0 {
+
+		// No budget configured, the class is unlimited
+		controller.lock.Unlock()
+
+		return func() {}, nil
+
+	}
+
+	weight := min(days, class.budget)
+
+	if len(class.waiters) == 0 && class.inUse+weight <= class.budget {
+
+		class.inUse += weight
+
+		controller.lock.Unlock()
+
+		return controller.releaseFunction(class, weight), nil
+
+	}
+
+	if len(class.waiters) >= class.queueSize {
+
+		controller.lock.Unlock()
+
+		return nil, fmt.Errorf("%w: %d %s queries already waiting", ErrAdmissionQueueFull, len(class.waiters), class.name)
+
+	}
+
+	waiter := &admissionWaiter{
+
+		weight: weight,
+
+		admitted: make(chan struct{}),
+	}
+
+	class.waiters = append(class.waiters, waiter)
+
+	controller.lock.Unlock()
+
+	select {
+
+	case <-waiter.admitted:
+
+		return controller.releaseFunction(class, weight), nil
+
+	case <-queryContext.Done():
+
+		controller.lock.Lock()
+
+		defer controller.lock.Unlock()
+
+		select {
+
+		case <-waiter.admitted:
+
+			// Admitted while giving up, hand the budget over to the next waiters
+			class.inUse -= weight
+
+			controller.admitWaiters(class)
+
+		default:
+
+			for index, queuedWaiter := range class.waiters {
+
+				if queuedWaiter == waiter {
+
+					class.waiters = append(class.waiters[:index], class.waiters[index+1:]...)
+
+					break
+
+				}
+
+			}
+
+			// Waiters behind this one might fit now
+			controller.admitWaiters(class)
+
+		}
+
+		if errors.Is(queryContext.Err(), context.Canceled) {
+
+			return nil, queryContext.Err()
+
+		}
+
+		return nil, fmt.Errorf("%w: %s query scanning %d days", ErrAdmissionTimeout, class.name, days)
+
+	}
+
+}
+
+func (controller *AdmissionController) releaseFunction(class *admissionClass, weight int) func() {
+
+	var once sync.Once
+
+	return func() {
+
+		once.Do(func() {
+
+			controller.lock.Lock()
+
+			defer controller.lock.Unlock()
+
+			class.inUse -= weight
+
+			controller.admitWaiters(class)
+
+		})
+
+	}
+
+}
+
+// admitWaiters admits the queued waiters in order, as long as they fit in the budget. Must be called holding the lock.
+func (controller *AdmissionController) admitWaiters(class *admissionClass) {
+
+	for len(class.waiters) > 0 && class.inUse+class.waiters[0].weight <= class.budget {
+
+		class.inUse += class.waiters[0].weight
+
+		close(class.waiters[0].admitted)
+
+		class.waiters = class.waiters[1:]
+
+	}
+
+}
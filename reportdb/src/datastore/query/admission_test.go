@@ -0,0 +1,92 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdmissionController(t *testing.T) {
+
+	controller := &AdmissionController{
+		classes: map[string]*admissionClass{
+			PriorityBatch: {name: PriorityBatch, budget: 10, queueSize: 1},
+		},
+	}
+
+	// Over budget queries take the whole budget
+	release, err := controller.Admit(context.Background(), PriorityBatch, 90)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	admitted := make(chan func())
+
+	go func() {
+
+		queuedRelease, err := controller.Admit(context.Background(), PriorityBatch, 2)
+
+		if err != nil {
+
+			t.Error(err)
+
+		}
+
+		admitted <- queuedRelease
+
+	}()
+
+	// Wait for the second query to be queued
+	for {
+
+		controller.lock.Lock()
+
+		queued := len(controller.classes[PriorityBatch].waiters)
+
+		controller.lock.Unlock()
+
+		if queued == 1 {
+
+			break
+
+		}
+
+		time.Sleep(time.Millisecond)
+
+	}
+
+	if _, err = controller.Admit(context.Background(), PriorityBatch, 1); !errors.Is(err, ErrAdmissionQueueFull) {
+
+		t.Errorf("expected queue full error, got %v", err)
+
+	}
+
+	release()
+
+	(<-admitted)()
+
+	timeoutContext, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+
+	defer cancel()
+
+	release, _ = controller.Admit(context.Background(), PriorityBatch, 10)
+
+	if _, err = controller.Admit(timeoutContext, PriorityBatch, 1); !errors.Is(err, ErrAdmissionTimeout) {
+
+		t.Errorf("expected admission timeout, got %v", err)
+
+	}
+
+	release()
+
+	if controller.classes[PriorityBatch].inUse != 0 || len(controller.classes[PriorityBatch].waiters) != 0 {
+
+		t.Errorf("budget leaked: %+v", controller.classes[PriorityBatch])
+
+	}
+
+}
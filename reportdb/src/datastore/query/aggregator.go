@@ -3,10 +3,13 @@ package query
 import (
 	"context"
 	. "datastore/containers"
-	. "datastore/utils"
+	"errors"
+	"fmt"
 	"go.uber.org/zap"
 	"reflect"
+	"slices"
 	"sort"
+	"strconv"
 	"sync"
 )
 
@@ -15,7 +18,160 @@ const (
 	SingleDayAggregators = 10
 )
 
-func ObjectWiseAggregator(daysData []map[uint32][]DataPoint, aggregation string, queryTimeoutContext context.Context) {
+// AggregationUptime is the ratio of the true values of bool counters, or of the values not zero. The percentile
+// aggregations are named after their percentile, like p99 or p99.9.
+const AggregationUptime = "uptime"
+
+// Aggregate applies the aggregation to the batch of values of a timestamp, a group or an object.
+func Aggregate(aggregation string, batch []interface{}, logger *zap.Logger) interface{} {
+
+	switch aggregation {
+
+	case "avg":
+		return Avg(batch, logger)
+
+	case "sum":
+		return Sum(batch, logger)
+
+	case "min":
+		return Min(batch, logger)
+
+	case "max":
+		return Max(batch, logger)
+
+	case "count":
+		return len(batch)
+
+	case AggregationUptime:
+		return Uptime(batch, logger)
+
+	}
+
+	if percentile, ok := percentileAggregation(aggregation); ok {
+
+		return Percentile(batch, percentile, logger)
+
+	}
+
+	logger.Error("aggregation not supported", zap.String("aggregation", aggregation))
+
+	return nil
+
+}
+
+var (
+	ErrUnknownAggregation = errors.New("invalid aggregation, it must be either 'none', 'avg', 'sum', 'min', 'max', 'count', 'uptime' or a percentile like 'p99'")
+
+	ErrAggregationNotSupported = errors.New("aggregation not supported for the counter's datatype")
+)
+
+// validAggregation reports whether the aggregation is one of the supported ones.
+func validAggregation(aggregation string) bool {
+
+	switch aggregation {
+
+	case "avg", "sum", "min", "max", "count", AggregationUptime:
+
+		return true
+
+	}
+
+	_, ok := percentileAggregation(aggregation)
+
+	return ok
+
+}
+
+// validateAggregation checks the aggregation against the datatype of the counter. Histograms only aggregate by
+// merging, into their sum, count or percentiles.
+func validateAggregation(aggregation string, dataType string) error {
+
+	if aggregation == "" || aggregation == "none" {
+
+		return nil
+
+	}
+
+	if !validAggregation(aggregation) {
+
+		return ErrUnknownAggregation
+
+	}
+
+	if dataType == "histogram" {
+
+		switch aggregation {
+
+		case "avg", "min", "max", AggregationUptime:
+
+			return ErrAggregationNotSupported
+
+		}
+
+	}
+
+	return nil
+
+}
+
+// aggregatable reports whether the values of the datatype can be aggregated, strings and enums are only drilled down.
+func aggregatable(dataType string) bool {
+
+	return dataType != "string" && dataType != "enum"
+
+}
+
+// percentileAggregation returns the percentile of the pNN aggregations.
+func percentileAggregation(aggregation string) (float64, bool) {
+
+	if len(aggregation) < 2 || aggregation[0] != 'p' {
+
+		return 0, false
+
+	}
+
+	percentile, err := strconv.ParseFloat(aggregation[1:], 64)
+
+	return percentile, err == nil && percentile >= 0 && percentile <= 100
+
+}
+
+// ObjectWiseAggregator aggregates the objects of every day into the objectId 0, by instance when the days hold
+// instance series: the series of an instance over all the objects are aggregated into the key of the instance.
+func ObjectWiseAggregator(daysData []map[uint32][]DataPoint, aggregation string, instanceKeys *InstanceKeys, queryTimeoutContext context.Context, logger *zap.Logger) {
+
+	groupAggregator(daysData, aggregation, func(seriesKey uint32) uint32 {
+
+		return instanceKeys.Key(0, instanceKeys.Lookup(seriesKey).Instance)
+
+	}, queryTimeoutContext, logger)
+
+	// Days without any object still give the aggregate, empty
+	for _, day := range daysData {
+
+		if day != nil && len(day) == 0 {
+
+			day[0] = make([]DataPoint, 0)
+
+		}
+
+	}
+
+}
+
+// InstanceAggregator aggregates the instance series of every object of the days into the object's series.
+func InstanceAggregator(daysData []map[uint32][]DataPoint, aggregation string, instanceKeys *InstanceKeys, queryTimeoutContext context.Context, logger *zap.Logger) {
+
+	groupAggregator(daysData, aggregation, func(seriesKey uint32) uint32 {
+
+		return instanceKeys.Lookup(seriesKey).ObjectId
+
+	}, queryTimeoutContext, logger)
+
+}
+
+// groupAggregator aggregates the series of every day sharing a group, by timestamp, into the series keyed by the group.
+func groupAggregator(daysData []map[uint32][]DataPoint, aggregation string, group func(seriesKey uint32) uint32, queryTimeoutContext context.Context, logger *zap.Logger) {
 
 	for dayIndex := 0; dayIndex < len(daysData); {
 
@@ -43,7 +199,7 @@ func ObjectWiseAggregator(daysData []map[uint32][]DataPoint, aggregation string,
 
 				completionWg.Add(1)
 
-				go objectWiseSingleDayAggregator(daysData[dayIndex], aggregation, &completionWg)
+				go groupSingleDayAggregator(daysData[dayIndex], aggregation, group, logger, &completionWg)
 
 				dayIndex++
 
@@ -57,13 +213,25 @@ func ObjectWiseAggregator(daysData []map[uint32][]DataPoint, aggregation string,
 
 }
 
-func objectWiseSingleDayAggregator(day map[uint32][]DataPoint, aggregation string, completionWg *sync.WaitGroup) {
+func groupSingleDayAggregator(day map[uint32][]DataPoint, aggregation string, group func(seriesKey uint32) uint32, logger *zap.Logger, completionWg *sync.WaitGroup) {
 
 	defer completionWg.Done()
 
-	timeIndexedBatchedData := make(map[uint32][]interface{})
+	groupTimeIndexedBatchedData := make(map[uint32]map[uint64][]interface{})
 
-	for objectId, points := range day {
+	for seriesKey, points := range day {
+
+		groupKey := group(seriesKey)
+
+		timeIndexedBatchedData, ok := groupTimeIndexedBatchedData[groupKey]
+
+		if !ok {
+
+			timeIndexedBatchedData = make(map[uint64][]interface{})
+
+			groupTimeIndexedBatchedData[groupKey] = timeIndexedBatchedData
+
+		}
 
 		for _, point := range points {
 
@@ -71,45 +239,30 @@ func objectWiseSingleDayAggregator(day map[uint32][]DataPoint, aggregation strin
 
 		}
 
-		delete(day, objectId)
+		delete(day, seriesKey)
 
 	}
 
-	// Using 0 as the final objectId which is aggregate of all the objectIds
-	day[0] = make([]DataPoint, 0)
+	for groupKey, timeIndexedBatchedData := range groupTimeIndexedBatchedData {
 
-	for timestamp, batch := range timeIndexedBatchedData {
+		day[groupKey] = make([]DataPoint, 0, len(timeIndexedBatchedData))
 
-		var aggregatedValue interface{}
+		for timestamp, batch := range timeIndexedBatchedData {
 
-		switch aggregation {
-		case "avg":
-			aggregatedValue = Avg(batch)
-		case "sum":
-			aggregatedValue = Sum(batch)
-		case "min":
-			aggregatedValue = Min(batch)
-		case "max":
-			aggregatedValue = Max(batch)
-		case "count":
-			aggregatedValue = len(batch)
-		default:
-			Logger.Warn("aggregation not supported", zap.String("aggregation", aggregation), zap.Uint32("timestamp", timestamp), zap.Any("batch", batch))
+			day[groupKey] = append(day[groupKey], DataPoint{
+				Timestamp: timestamp,
 
+				Value: Aggregate(aggregation, batch, logger),
+			})
 		}
 
-		day[0] = append(day[0], DataPoint{
-			Timestamp: timestamp,
-
-			Value: aggregatedValue,
-		})
 	}
 
 }
 
-func TimestampAggregator(daysData []map[uint32][]DataPoint, aggregation string, interval uint32, from uint32, finalData map[uint32][]DataPoint, queryTimeoutContext context.Context) {
+func TimestampAggregator(daysData []map[uint32][]DataPoint, aggregation string, interval uint64, from uint64, emit func(objectId uint32, dataPoints []DataPoint), queryTimeoutContext context.Context, logger *zap.Logger) {
 
-	objectWiseTimeIndexedBatchedData := make(map[uint32]map[uint32][]interface{})
+	objectWiseTimeIndexedBatchedData := make(map[uint32]map[uint64][]interface{})
 
 	// Batching
 	for _, day := range daysData {
@@ -132,7 +285,7 @@ func TimestampAggregator(daysData []map[uint32][]DataPoint, aggregation string,
 
 				if _, exist := objectWiseTimeIndexedBatchedData[objectId]; !exist {
 
-					objectWiseTimeIndexedBatchedData[objectId] = make(map[uint32][]interface{})
+					objectWiseTimeIndexedBatchedData[objectId] = make(map[uint64][]interface{})
 
 				}
 
@@ -167,7 +320,12 @@ func TimestampAggregator(daysData []map[uint32][]DataPoint, aggregation string,
 	// Reslice the days array, now object-wise data will be represented in single stream
 	daysData = daysData[:]
 
-	for objectId, timeIndexedBatch := range objectWiseTimeIndexedBatchedData {
+	// Objects are emitted in the order of their objectIds, their batches released once aggregated
+	for _, objectId := range sortedObjectIds(objectWiseTimeIndexedBatchedData) {
+
+		timeIndexedBatch := objectWiseTimeIndexedBatchedData[objectId]
+
+		delete(objectWiseTimeIndexedBatchedData, objectId)
 
 		dataPoints := make([]DataPoint, 0)
 
@@ -181,33 +339,9 @@ func TimestampAggregator(daysData []map[uint32][]DataPoint, aggregation string,
 
 			default:
 
-				var aggregatedValue interface{}
-
-				switch aggregation {
-
-				case "avg":
-					aggregatedValue = Avg(batch)
-
-				case "sum":
-					aggregatedValue = Sum(batch)
-
-				case "min":
-					aggregatedValue = Min(batch)
-
-				case "max":
-					aggregatedValue = Max(batch)
-
-				case "count":
-					aggregatedValue = len(batch)
-
-				default:
-					Logger.Error("aggregation not supported", zap.String("aggregation", aggregation))
-
-				}
-
 				dataPoints = append(dataPoints, DataPoint{
 					Timestamp: timestamp,
-					Value:     aggregatedValue,
+					Value:     Aggregate(aggregation, batch, logger),
 				})
 
 			}
@@ -221,13 +355,20 @@ func TimestampAggregator(daysData []map[uint32][]DataPoint, aggregation string,
 
 		})
 
-		finalData[objectId] = dataPoints
+		emit(objectId, dataPoints)
 
 	}
 
 }
 
-func Max(values []interface{}) interface{} {
+func Max(values []interface{}, logger *zap.Logger) interface{} {
+
+	if _, ok := values[0].(bool); ok {
+
+		// Up at any time
+		return slices.ContainsFunc(values, func(value interface{}) bool { return value.(bool) })
+
+	}
 
 	switch dataType := reflect.TypeOf(values[0]).Kind(); dataType {
 
@@ -281,14 +422,21 @@ func Max(values []interface{}) interface{} {
 
 	default:
 
-		Logger.Error(dataTypeNotSupported, zap.Any("datatype", dataType))
+		logger.Error(dataTypeNotSupported, zap.Any("datatype", dataType))
 
 	}
 
 	return nil
 }
 
-func Min(values []interface{}) interface{} {
+func Min(values []interface{}, logger *zap.Logger) interface{} {
+
+	if _, ok := values[0].(bool); ok {
+
+		// Up all the time
+		return !slices.ContainsFunc(values, func(value interface{}) bool { return !value.(bool) })
+
+	}
 
 	switch dataType := reflect.TypeOf(values[0]).Kind(); dataType {
 
@@ -341,14 +489,39 @@ func Min(values []interface{}) interface{} {
 
 	default:
 
-		Logger.Error(dataTypeNotSupported, zap.Any("datatype", dataType))
+		logger.Error(dataTypeNotSupported, zap.Any("datatype", dataType))
 
 	}
 
 	return nil
 }
 
-func Sum(values []interface{}) interface{} {
+// Sum adds up the values, the histograms are merged and the true bools counted.
+func Sum(values []interface{}, logger *zap.Logger) interface{} {
+
+	switch values[0].(type) {
+
+	case bool:
+
+		var sum uint64
+
+		for _, value := range values {
+
+			if value.(bool) {
+
+				sum++
+
+			}
+
+		}
+
+		return sum
+
+	case Histogram:
+
+		return MergeHistograms(histograms(values))
+
+	}
 
 	switch dataType := reflect.TypeOf(values[0]).Kind(); dataType {
 
@@ -387,7 +560,7 @@ func Sum(values []interface{}) interface{} {
 
 	default:
 
-		Logger.Error(dataTypeNotSupported, zap.Any("datatype", dataType))
+		logger.Error(dataTypeNotSupported, zap.Any("datatype", dataType))
 
 	}
 
@@ -395,9 +568,15 @@ func Sum(values []interface{}) interface{} {
 
 }
 
-func Avg(values []interface{}) interface{} {
+func Avg(values []interface{}, logger *zap.Logger) interface{} {
+
+	if _, ok := values[0].(bool); ok {
 
-	sum := Sum(values)
+		return Uptime(values, logger)
+
+	}
+
+	sum := Sum(values, logger)
 
 	switch dataType := reflect.TypeOf(values[0]).Kind(); dataType {
 
@@ -414,10 +593,107 @@ func Avg(values []interface{}) interface{} {
 
 	default:
 
-		Logger.Error(dataTypeNotSupported, zap.Any("datatype", dataType))
+		logger.Error(dataTypeNotSupported, zap.Any("datatype", dataType))
 
 	}
 
 	return nil
 
 }
+
+// Uptime returns the ratio of the true bools, or of the values not zero.
+func Uptime(values []interface{}, logger *zap.Logger) interface{} {
+
+	var up int
+
+	for _, value := range values {
+
+		floatValue, ok := NumericValue(value)
+
+		if !ok {
+
+			logger.Error(dataTypeNotSupported, zap.String("aggregation", AggregationUptime), zap.String("datatype", fmt.Sprintf("%T", value)))
+
+			return nil
+
+		}
+
+		if floatValue != 0 {
+
+			up++
+
+		}
+
+	}
+
+	return float64(up) / float64(len(values))
+
+}
+
+// Percentile returns the percentile (0-100) of the values, interpolating between the closest ones. The histograms are
+// merged, their percentile estimated from the merged buckets.
+func Percentile(values []interface{}, percentile float64, logger *zap.Logger) interface{} {
+
+	if _, ok := values[0].(Histogram); ok {
+
+		if value, ok := MergeHistograms(histograms(values)).Percentile(percentile); ok {
+
+			return value
+
+		}
+
+		return nil
+
+	}
+
+	sortedValues := make([]float64, len(values))
+
+	for index, value := range values {
+
+		floatValue, ok := NumericValue(value)
+
+		if !ok {
+
+			logger.Error(dataTypeNotSupported, zap.String("aggregation", "percentile"), zap.String("datatype", fmt.Sprintf("%T", value)))
+
+			return nil
+
+		}
+
+		sortedValues[index] = floatValue
+
+	}
+
+	slices.Sort(sortedValues)
+
+	rank := percentile / 100 * float64(len(sortedValues)-1)
+
+	lower := int(rank)
+
+	if lower == len(sortedValues)-1 {
+
+		return sortedValues[lower]
+
+	}
+
+	return sortedValues[lower] + (sortedValues[lower+1]-sortedValues[lower])*(rank-float64(lower))
+
+}
+
+func histograms(values []interface{}) []Histogram {
+
+	histograms := make([]Histogram, 0, len(values))
+
+	for _, value := range values {
+
+		if histogram, ok := value.(Histogram); ok {
+
+			histograms = append(histograms, histogram)
+
+		}
+
+	}
+
+	return histograms
+
+}
@@ -0,0 +1,65 @@
+package query
+
+import (
+	. "datastore/containers"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+
+	logger := zap.NewNop()
+
+	bools := []interface{}{true, false, true, true}
+
+	numbers := []interface{}{4.0, 1.0, 3.0, 2.0, 5.0}
+
+	histograms := []interface{}{
+		Histogram{Bounds: []float64{10, 20}, Counts: []uint64{1, 1}},
+		Histogram{Bounds: []float64{10, 20}, Counts: []uint64{1, 1}},
+	}
+
+	aggregations := []struct {
+		aggregation string
+		values      []interface{}
+		expected    interface{}
+	}{
+		{AggregationUptime, bools, 0.75},
+		{"avg", bools, 0.75},
+		{"sum", bools, uint64(3)},
+		{"min", bools, false},
+		{"max", bools, true},
+		{"p50", numbers, 3.0},
+		{"p75", numbers, 4.0},
+		{"p12.5", numbers, 1.5},
+		{"p75", histograms, 15.0},
+		{"count", histograms, 2},
+	}
+
+	for _, aggregation := range aggregations {
+
+		if value := Aggregate(aggregation.aggregation, aggregation.values, logger); value != aggregation.expected {
+
+			t.Errorf("%s of %v: expected %v, got %v", aggregation.aggregation, aggregation.values, aggregation.expected, value)
+
+		}
+
+	}
+
+	for _, invalid := range []struct{ aggregation, dataType string }{{"median", "float64"}, {"p101", "float64"}, {"avg", "histogram"}, {AggregationUptime, "histogram"}} {
+
+		if validateAggregation(invalid.aggregation, invalid.dataType) == nil {
+
+			t.Errorf("expected %s of %s to be rejected", invalid.aggregation, invalid.dataType)
+
+		}
+
+	}
+
+	if err := validateAggregation("p99.9", "histogram"); err != nil {
+
+		t.Errorf("unexpected error %v", err)
+
+	}
+
+}
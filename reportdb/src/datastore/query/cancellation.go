@@ -0,0 +1,110 @@
+package query
+
+import (
+	"context"
+	. "datastore/utils"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// ActiveQueries tracks the cancel functions of the queries being processed, so that clients can cancel them by QueryId.
+type ActiveQueries struct {
+	cancels map[uint64]context.CancelFunc
+
+	// Cancellations received before the query was picked up by a parser, till the query could have timed out
+	cancelled map[uint64]time.Time
+
+	config *Config
+
+	lock sync.Mutex
+}
+
+func NewActiveQueries(config *Config) *ActiveQueries {
+
+	return &ActiveQueries{
+
+		cancels: make(map[uint64]context.CancelFunc),
+
+		cancelled: make(map[uint64]time.Time),
+
+		config: config,
+	}
+
+}
+
+// Register tracks the query's cancel function, it returns false if the query was already cancelled.
+func (activeQueries *ActiveQueries) Register(queryId uint64, cancel context.CancelFunc) bool {
+
+	activeQueries.lock.Lock()
+
+	defer activeQueries.lock.Unlock()
+
+	if _, cancelled := activeQueries.cancelled[queryId]; cancelled {
+
+		delete(activeQueries.cancelled, queryId)
+
+		return false
+
+	}
+
+	activeQueries.cancels[queryId] = cancel
+
+	return true
+
+}
+
+func (activeQueries *ActiveQueries) Deregister(queryId uint64) {
+
+	activeQueries.lock.Lock()
+
+	defer activeQueries.lock.Unlock()
+
+	delete(activeQueries.cancels, queryId)
+
+}
+
+// Cancel cancels the query, timeout being the query's own, in seconds, QueryTimeoutTime when 0.
+func (activeQueries *ActiveQueries) Cancel(queryId uint64, timeout uint32) {
+
+	activeQueries.lock.Lock()
+
+	defer activeQueries.lock.Unlock()
+
+	if cancel, ok := activeQueries.cancels[queryId]; ok {
+
+		activeQueries.config.Logger.Info("Cancelling query", zap.Uint64("queryId", queryId))
+
+		cancel()
+
+		delete(activeQueries.cancels, queryId)
+
+		return
+
+	}
+
+	// Query not picked up yet, or already completed. Remember the cancellation till the query could have timed out.
+
+	now := time.Now()
+
+	for cancelledQueryId, deadline := range activeQueries.cancelled {
+
+		if now.After(deadline) {
+
+			delete(activeQueries.cancelled, cancelledQueryId)
+
+		}
+
+	}
+
+	queryTimeout := time.Duration(activeQueries.config.QueryTimeoutTime) * time.Second
+
+	if timeout > 0 {
+
+		queryTimeout = time.Duration(timeout) * time.Second
+
+	}
+
+	activeQueries.cancelled[queryId] = now.Add(queryTimeout)
+
+}
@@ -0,0 +1,64 @@
+package query
+
+import (
+	"context"
+	"datastore/utils"
+	"testing"
+	"time"
+)
+
+func TestActiveQueries(t *testing.T) {
+
+	activeQueries := NewActiveQueries(utils.DefaultConfig())
+
+	queryContext, cancel := context.WithCancel(context.Background())
+
+	if !activeQueries.Register(1, cancel) {
+
+		t.Fatal("query should be registered")
+
+	}
+
+	activeQueries.Cancel(1, 0)
+
+	if queryContext.Err() == nil {
+
+		t.Error("query context should be cancelled")
+
+	}
+
+	// Cancellation arriving before the query is picked up
+	activeQueries.Cancel(2, 0)
+
+	if activeQueries.Register(2, func() {}) {
+
+		t.Error("query cancelled beforehand should not be registered")
+
+	}
+
+	// Cancellations are remembered for the query's own timeout, and dropped past it
+	activeQueries.Cancel(3, 3600)
+
+	if deadline := activeQueries.cancelled[3]; time.Until(deadline) < 3500*time.Second {
+
+		t.Errorf("cancellation kept till %v only", deadline)
+
+	}
+
+	activeQueries.cancelled[4] = time.Now().Add(-time.Second)
+
+	activeQueries.Cancel(5, 0)
+
+	if _, ok := activeQueries.cancelled[4]; ok {
+
+		t.Error("expired cancellation not dropped")
+
+	}
+
+	if _, ok := activeQueries.cancelled[3]; !ok {
+
+		t.Error("cancellation dropped before the query's timeout")
+
+	}
+
+}
@@ -0,0 +1,509 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+// Expression is a named arithmetic expression over the counters of a multi-counter query.
+// Counters are referred as c<counterId>, for example "c1 / (c1 + c4) * 100".
+type Expression struct {
+	Name string `json:"name" msgpack:"name"`
+
+	Expression string `json:"expression" msgpack:"expression"`
+}
+
+type ExpressionError struct {
+	Position int
+
+	Message string
+}
+
+func (err *ExpressionError) Error() string {
+
+	return fmt.Sprintf("expression error at position %d: %s", err.Position, err.Message)
+
+}
+
+type expressionFunction struct {
+	arguments int
+
+	apply func(arguments []float64) float64
+}
+
+var expressionFunctions = map[string]expressionFunction{
+	"abs":   {1, func(arguments []float64) float64 { return math.Abs(arguments[0]) }},
+	"sqrt":  {1, func(arguments []float64) float64 { return math.Sqrt(arguments[0]) }},
+	"ceil":  {1, func(arguments []float64) float64 { return math.Ceil(arguments[0]) }},
+	"floor": {1, func(arguments []float64) float64 { return math.Floor(arguments[0]) }},
+	"round": {1, func(arguments []float64) float64 { return math.Round(arguments[0]) }},
+	"min":   {2, func(arguments []float64) float64 { return math.Min(arguments[0], arguments[1]) }},
+	"max":   {2, func(arguments []float64) float64 { return math.Max(arguments[0], arguments[1]) }},
+	"pow":   {2, func(arguments []float64) float64 { return math.Pow(arguments[0], arguments[1]) }},
+}
+
+// ExpressionNode is a compiled expression, evaluated against the values of the counters at a single bucket.
+type ExpressionNode interface {
+	Evaluate(counterValues map[uint16]float64) (float64, bool)
+}
+
+type constantNode struct {
+	value float64
+}
+
+func (node constantNode) Evaluate(map[uint16]float64) (float64, bool) {
+
+	return node.value, true
+
+}
+
+type counterNode struct {
+	counterId uint16
+}
+
+func (node counterNode) Evaluate(counterValues map[uint16]float64) (float64, bool) {
+
+	value, ok := counterValues[node.counterId]
+
+	return value, ok
+
+}
+
+type negateNode struct {
+	operand ExpressionNode
+}
+
+func (node negateNode) Evaluate(counterValues map[uint16]float64) (float64, bool) {
+
+	value, ok := node.operand.Evaluate(counterValues)
+
+	return -value, ok
+
+}
+
+type binaryNode struct {
+	operator byte
+
+	left ExpressionNode
+
+	right ExpressionNode
+}
+
+func (node binaryNode) Evaluate(counterValues map[uint16]float64) (float64, bool) {
+
+	left, ok := node.left.Evaluate(counterValues)
+
+	if !ok {
+
+		return 0, false
+
+	}
+
+	right, ok := node.right.Evaluate(counterValues)
+
+	if !ok {
+
+		return 0, false
+
+	}
+
+	switch node.operator {
+
+	case '+':
+		return left + right, true
+
+	case '-':
+		return left - right, true
+
+	case '*':
+		return left * right, true
+
+	case '/':
+
+		if right == 0 {
+
+			// Division by zero, no meaningful value for this bucket
+			return 0, false
+
+		}
+
+		return left / right, true
+
+	}
+
+	return 0, false
+
+}
+
+type functionNode struct {
+	function expressionFunction
+
+	arguments []ExpressionNode
+}
+
+func (node functionNode) Evaluate(counterValues map[uint16]float64) (float64, bool) {
+
+	arguments := make([]float64, len(node.arguments))
+
+	for index, argument := range node.arguments {
+
+		value, ok := argument.Evaluate(counterValues)
+
+		if !ok {
+
+			return 0, false
+
+		}
+
+		arguments[index] = value
+
+	}
+
+	value := node.function.apply(arguments)
+
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+
+		return 0, false
+
+	}
+
+	return value, true
+
+}
+
+// CompileExpression parses the expression and returns the compiled expression along with the counters it refers.
+func CompileExpression(expression string) (ExpressionNode, []uint16, error) {
+
+	parser := expressionParser{
+
+		input: expression,
+
+		counters: make(map[uint16]struct{}),
+	}
+
+	node, err := parser.parseSum()
+
+	if err != nil {
+
+		return nil, nil, err
+
+	}
+
+	parser.skipSpaces()
+
+	if parser.position < len(parser.input) {
+
+		return nil, nil, parser.errorf("unexpected '%c'", parser.input[parser.position])
+
+	}
+
+	counterIds := make([]uint16, 0, len(parser.counters))
+
+	for counterId := range parser.counters {
+
+		counterIds = append(counterIds, counterId)
+
+	}
+
+	return node, counterIds, nil
+
+}
+
+// expressionParser is a recursive descent parser for the grammar:
+//
+//	sum     := product (('+' | '-') product)*
+//	product := unary (('*' | '/') unary)*
+//	unary   := '-' unary | primary
+//	primary := number | c<counterId> | function '(' sum (',' sum)* ')' | '(' sum ')'
+type expressionParser struct {
+	input string
+
+	position int
+
+	counters map[uint16]struct{}
+}
+
+func (parser *expressionParser) errorf(format string, arguments ...interface{}) error {
+
+	return &ExpressionError{
+
+		Position: parser.position + 1,
+
+		Message: fmt.Sprintf(format, arguments...),
+	}
+
+}
+
+func (parser *expressionParser) skipSpaces() {
+
+	for parser.position < len(parser.input) && unicode.IsSpace(rune(parser.input[parser.position])) {
+
+		parser.position++
+
+	}
+
+}
+
+func (parser *expressionParser) peek() byte {
+
+	parser.skipSpaces()
+
+	if parser.position >= len(parser.input) {
+
+		return 0
+
+	}
+
+	return parser.input[parser.position]
+
+}
+
+func (parser *expressionParser) parseSum() (ExpressionNode, error) {
+
+	left, err := parser.parseProduct()
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	for operator := parser.peek(); operator == '+' || operator == '-'; operator = parser.peek() {
+
+		parser.position++
+
+		right, err := parser.parseProduct()
+
+		if err != nil {
+
+			return nil, err
+
+		}
+
+		left = binaryNode{operator, left, right}
+
+	}
+
+	return left, nil
+
+}
+
+func (parser *expressionParser) parseProduct() (ExpressionNode, error) {
+
+	left, err := parser.parseUnary()
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	for operator := parser.peek(); operator == '*' || operator == '/'; operator = parser.peek() {
+
+		parser.position++
+
+		right, err := parser.parseUnary()
+
+		if err != nil {
+
+			return nil, err
+
+		}
+
+		left = binaryNode{operator, left, right}
+
+	}
+
+	return left, nil
+
+}
+
+func (parser *expressionParser) parseUnary() (ExpressionNode, error) {
+
+	if parser.peek() == '-' {
+
+		parser.position++
+
+		operand, err := parser.parseUnary()
+
+		if err != nil {
+
+			return nil, err
+
+		}
+
+		return negateNode{operand}, nil
+
+	}
+
+	return parser.parsePrimary()
+
+}
+
+func (parser *expressionParser) parsePrimary() (ExpressionNode, error) {
+
+	character := parser.peek()
+
+	switch {
+
+	case character == 0:
+
+		return nil, parser.errorf("unexpected end of expression")
+
+	case character == '(':
+
+		parser.position++
+
+		node, err := parser.parseSum()
+
+		if err != nil {
+
+			return nil, err
+
+		}
+
+		if parser.peek() != ')' {
+
+			return nil, parser.errorf("expected ')'")
+
+		}
+
+		parser.position++
+
+		return node, nil
+
+	case isDigit(character) || character == '.':
+
+		start := parser.position
+
+		for parser.position < len(parser.input) && (isDigit(parser.input[parser.position]) || parser.input[parser.position] == '.') {
+
+			parser.position++
+
+		}
+
+		literal := parser.input[start:parser.position]
+
+		value, err := strconv.ParseFloat(literal, 64)
+
+		if err != nil {
+
+			parser.position = start
+
+			return nil, parser.errorf("invalid number '%s'", literal)
+
+		}
+
+		return constantNode{value}, nil
+
+	case isLetter(character):
+
+		start := parser.position
+
+		for parser.position < len(parser.input) && (isLetter(parser.input[parser.position]) || isDigit(parser.input[parser.position])) {
+
+			parser.position++
+
+		}
+
+		identifier := parser.input[start:parser.position]
+
+		if function, ok := expressionFunctions[identifier]; ok {
+
+			return parser.parseFunction(identifier, function, start)
+
+		}
+
+		if identifier[0] == 'c' && len(identifier) > 1 {
+
+			counterId, err := strconv.ParseUint(identifier[1:], 10, 16)
+
+			if err == nil {
+
+				parser.counters[uint16(counterId)] = struct{}{}
+
+				return counterNode{uint16(counterId)}, nil
+
+			}
+
+		}
+
+		parser.position = start
+
+		return nil, parser.errorf("unknown identifier '%s'", identifier)
+
+	default:
+
+		return nil, parser.errorf("unexpected '%c'", character)
+
+	}
+
+}
+
+func (parser *expressionParser) parseFunction(name string, function expressionFunction, start int) (ExpressionNode, error) {
+
+	if parser.peek() != '(' {
+
+		return nil, parser.errorf("expected '(' after function '%s'", name)
+
+	}
+
+	parser.position++
+
+	var arguments []ExpressionNode
+
+	for {
+
+		argument, err := parser.parseSum()
+
+		if err != nil {
+
+			return nil, err
+
+		}
+
+		arguments = append(arguments, argument)
+
+		if parser.peek() != ',' {
+
+			break
+
+		}
+
+		parser.position++
+
+	}
+
+	if parser.peek() != ')' {
+
+		return nil, parser.errorf("expected ')'")
+
+	}
+
+	parser.position++
+
+	if len(arguments) != function.arguments {
+
+		parser.position = start
+
+		return nil, parser.errorf("function '%s' takes %d argument(s), got %d", name, function.arguments, len(arguments))
+
+	}
+
+	return functionNode{function, arguments}, nil
+
+}
+
+func isDigit(character byte) bool {
+
+	return character >= '0' && character <= '9'
+
+}
+
+func isLetter(character byte) bool {
+
+	return (character >= 'a' && character <= 'z') || (character >= 'A' && character <= 'Z') || character == '_'
+
+}
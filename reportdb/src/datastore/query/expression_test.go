@@ -0,0 +1,87 @@
+package query
+
+import (
+	. "datastore/containers"
+	"errors"
+	"testing"
+)
+
+func TestCompileExpression(t *testing.T) {
+
+	node, counterIds, err := CompileExpression("-c1 / (c1 + c4) * 100 + max(c4, 2)")
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if len(counterIds) != 2 {
+
+		t.Errorf("expected 2 referred counters, got %v", counterIds)
+
+	}
+
+	value, ok := node.Evaluate(map[uint16]float64{1: 25, 4: 75})
+
+	if !ok || value != 50 {
+
+		t.Errorf("expected 50, got %v (ok: %v)", value, ok)
+
+	}
+
+	if _, ok = node.Evaluate(map[uint16]float64{1: 25}); ok {
+
+		t.Errorf("evaluation should fail when a counter value is missing")
+
+	}
+
+	for expression, position := range map[string]int{
+		"c1 +":       5,
+		"c1 * (c2":   9,
+		"2 $ 3":      3,
+		"foo(c1)":    1,
+		"sqrt(1, 2)": 1,
+	} {
+
+		_, _, err = CompileExpression(expression)
+
+		var expressionError *ExpressionError
+
+		if !errors.As(err, &expressionError) || expressionError.Position != position {
+
+			t.Errorf("%q: expected error at position %d, got %v", expression, position, err)
+
+		}
+
+	}
+
+}
+
+func TestEvaluateExpressions(t *testing.T) {
+
+	node, counterIds, err := CompileExpression("c1 / c2 * 100")
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	countersData := map[uint16]map[uint32][]DataPoint{
+		1: {7: {{Timestamp: 10, Value: uint64(25)}, {Timestamp: 20, Value: uint64(50)}}},
+		2: {7: {{Timestamp: 10, Value: 100.0}, {Timestamp: 20, Value: 0.0}}},
+	}
+
+	series := EvaluateExpressions(countersData, []compiledExpression{{"used_percent", node, counterIds}})
+
+	points := series["used_percent"][7]
+
+	// The second bucket divides by zero and is skipped
+	if len(points) != 1 || points[0].Timestamp != 10 || points[0].Value != 25.0 {
+
+		t.Errorf("unexpected series: %v", points)
+
+	}
+
+}
@@ -0,0 +1,85 @@
+package query
+
+import (
+	. "datastore/containers"
+	. "datastore/storage"
+	"errors"
+)
+
+var ErrInvalidInstanceAggregation = errors.New("invalid instance aggregation, it must be either 'none', 'avg', 'sum', 'min', 'max', 'count', 'uptime' or a percentile like 'p99'")
+
+// IsInstanceAggregationQuery reports whether the instances of every object are aggregated into the object's series.
+func IsInstanceAggregationQuery(query Query) bool {
+
+	return query.InstanceAggregation != "" && query.InstanceAggregation != "none"
+
+}
+
+func validateInstanceAggregation(query Query) error {
+
+	if query.InstanceAggregation == "" || query.InstanceAggregation == "none" || validAggregation(query.InstanceAggregation) {
+
+		return nil
+
+	}
+
+	return ErrInvalidInstanceAggregation
+
+}
+
+// describeInstances sets the instance series of the keys found in the result.
+func describeInstances(result *Result, instanceKeys *InstanceKeys) {
+
+	describe := func(seriesKey uint32) {
+
+		if seriesKey < InstanceKeyBase {
+
+			return
+
+		}
+
+		if result.Instances == nil {
+
+			result.Instances = make(map[uint32]SeriesInstance)
+
+		}
+
+		result.Instances[seriesKey] = instanceKeys.Lookup(seriesKey)
+
+	}
+
+	for seriesKey := range result.Data {
+
+		describe(seriesKey)
+
+	}
+
+	for _, data := range result.Series {
+
+		for seriesKey := range data {
+
+			describe(seriesKey)
+
+		}
+
+	}
+
+	for _, rankedObject := range result.Ranking {
+
+		describe(rankedObject.ObjectId)
+
+	}
+
+	for seriesKey := range result.DistinctValues {
+
+		describe(seriesKey)
+
+	}
+
+	for seriesKey := range result.Changes {
+
+		describe(seriesKey)
+
+	}
+
+}
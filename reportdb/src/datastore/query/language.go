@@ -0,0 +1,634 @@
+package query
+
+import (
+	. "datastore/containers"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Text query statements compile to a Query. The grammar is:
+//
+//	statement   := [rank] selection clause*
+//	rank        := ('top' | 'bottom') N
+//	selection   := aggregation '(' counter ')' | counter
+//	aggregation := 'avg' | 'sum' | 'min' | 'max' | 'count' | 'uptime' | 'p' percentile
+//	counter     := 'counter' N
+//	clause      := 'by' 'object'
+//	             | 'every' duration
+//	             | 'from' time
+//	             | 'to' time
+//	             | 'where' 'object' 'in' '(' object (',' object)* ')'
+//	object      := IP address | N | '"' identifier '"'
+//	time        := 'now' | '-' duration | unix timestamp
+//	duration    := N ('s' | 'm' | 'h' | 'd' | 'w')
+//
+// for example "avg(counter 2) by object every 5m from -1h where object in (10.0.0.1, "web-01")".
+// Without 'by object' the aggregation is applied across objects as well, without an aggregation
+// the statement is a drilldown. 'to' defaults to now. Times and durations are in seconds, whatever the precision of
+// the query.
+
+type SyntaxError struct {
+	Position int
+
+	Message string
+}
+
+func (err *SyntaxError) Error() string {
+
+	return fmt.Sprintf("syntax error at position %d: %s", err.Position, err.Message)
+
+}
+
+type statementToken struct {
+	text string
+
+	position int
+
+	// quoted tokens keep their case and may hold any character but '"'
+	quoted bool
+}
+
+var durationUnits = map[byte]uint64{
+	's': 1,
+	'm': 60,
+	'h': 3600,
+	'd': 86400,
+	'w': 7 * 86400,
+}
+
+// CompileStatement compiles the text statement into a Query, relative times are resolved against now.
+func CompileStatement(statement string, now uint64) (Query, error) {
+
+	compiler := statementCompiler{
+
+		tokens: tokenizeStatement(statement),
+
+		end: len(statement) + 1,
+
+		now: now,
+	}
+
+	return compiler.compile()
+
+}
+
+func tokenizeStatement(statement string) []statementToken {
+
+	var tokens []statementToken
+
+	for position := 0; position < len(statement); {
+
+		character := statement[position]
+
+		switch {
+
+		case character == ' ' || character == '\t' || character == '\n' || character == '\r':
+
+			position++
+
+		case character == '"':
+
+			start := position + 1
+
+			position = start
+
+			for position < len(statement) && statement[position] != '"' {
+
+				position++
+
+			}
+
+			tokens = append(tokens, statementToken{statement[start:position], start, true})
+
+			// Closing quote
+			position++
+
+		case character == '(' || character == ')' || character == ',' || character == '-':
+
+			tokens = append(tokens, statementToken{string(character), position + 1, false})
+
+			position++
+
+		default:
+
+			start := position
+
+			for position < len(statement) && !strings.ContainsRune(" \t\n\r(),-", rune(statement[position])) {
+
+				position++
+
+			}
+
+			tokens = append(tokens, statementToken{strings.ToLower(statement[start:position]), start + 1, false})
+
+		}
+
+	}
+
+	return tokens
+
+}
+
+type statementCompiler struct {
+	tokens []statementToken
+
+	current int
+
+	end int
+
+	now uint64
+}
+
+func (compiler *statementCompiler) errorf(position int, format string, arguments ...interface{}) error {
+
+	return &SyntaxError{
+
+		Position: position,
+
+		Message: fmt.Sprintf(format, arguments...),
+	}
+
+}
+
+func (compiler *statementCompiler) peek() statementToken {
+
+	if compiler.current >= len(compiler.tokens) {
+
+		return statementToken{"", compiler.end, false}
+
+	}
+
+	return compiler.tokens[compiler.current]
+
+}
+
+func (compiler *statementCompiler) next() statementToken {
+
+	token := compiler.peek()
+
+	if compiler.current < len(compiler.tokens) {
+
+		compiler.current++
+
+	}
+
+	return token
+
+}
+
+func (compiler *statementCompiler) expect(text string) error {
+
+	token := compiler.next()
+
+	if token.text != text {
+
+		return compiler.errorf(token.position, "expected '%s', found %s", text, describeToken(token))
+
+	}
+
+	return nil
+
+}
+
+func (compiler *statementCompiler) number(description string) (uint32, statementToken, error) {
+
+	token := compiler.next()
+
+	value, err := strconv.ParseUint(token.text, 10, 32)
+
+	if err != nil {
+
+		return 0, token, compiler.errorf(token.position, "expected %s, found %s", description, describeToken(token))
+
+	}
+
+	return uint32(value), token, nil
+
+}
+
+func (compiler *statementCompiler) compile() (Query, error) {
+
+	query := Query{
+
+		ObjectWiseAggregation: "none",
+
+		TimestampAggregation: "none",
+	}
+
+	// Ranking
+	if token := compiler.peek(); token.text == RankOrderTop || token.text == RankOrderBottom {
+
+		compiler.next()
+
+		limit, limitToken, err := compiler.number("rank limit")
+
+		if err != nil {
+
+			return query, err
+
+		}
+
+		if limit == 0 {
+
+			return query, compiler.errorf(limitToken.position, "rank limit must be greater than 0")
+
+		}
+
+		query.RankOrder = token.text
+
+		query.RankLimit = limit
+
+	}
+
+	// Selection
+	aggregation := ""
+
+	token := compiler.next()
+
+	switch {
+
+	case !token.quoted && token.text != "counter" && validAggregation(token.text):
+
+		aggregation = token.text
+
+		if err := compiler.expect("("); err != nil {
+
+			return query, err
+
+		}
+
+		if err := compiler.counter(&query); err != nil {
+
+			return query, err
+
+		}
+
+		if err := compiler.expect(")"); err != nil {
+
+			return query, err
+
+		}
+
+	case token.text == "counter" && !token.quoted:
+
+		compiler.current--
+
+		if err := compiler.counter(&query); err != nil {
+
+			return query, err
+
+		}
+
+	default:
+
+		return query, compiler.errorf(token.position, "expected an aggregation or 'counter', found %s", describeToken(token))
+
+	}
+
+	if query.RankLimit > 0 && aggregation == "" {
+
+		return query, compiler.errorf(token.position, "ranking requires an aggregation")
+
+	}
+
+	// Clauses
+	groupByObject, hasFrom, hasTo := false, false, false
+
+	seenClauses := make(map[string]struct{})
+
+	for compiler.peek().text != "" {
+
+		clause := compiler.next()
+
+		if _, seen := seenClauses[clause.text]; seen {
+
+			return query, compiler.errorf(clause.position, "duplicate '%s' clause", clause.text)
+
+		}
+
+		seenClauses[clause.text] = struct{}{}
+
+		switch clause.text {
+
+		case "by":
+
+			if err := compiler.expect("object"); err != nil {
+
+				return query, err
+
+			}
+
+			groupByObject = true
+
+		case "every":
+
+			interval, err := compiler.duration()
+
+			if err != nil {
+
+				return query, err
+
+			}
+
+			query.Interval = interval
+
+		case "from":
+
+			from, err := compiler.time()
+
+			if err != nil {
+
+				return query, err
+
+			}
+
+			query.From, hasFrom = from, true
+
+		case "to":
+
+			to, err := compiler.time()
+
+			if err != nil {
+
+				return query, err
+
+			}
+
+			query.To, hasTo = to, true
+
+		case "where":
+
+			if err := compiler.objects(&query); err != nil {
+
+				return query, err
+
+			}
+
+		default:
+
+			return query, compiler.errorf(clause.position, "expected 'by', 'every', 'from', 'to' or 'where', found %s", describeToken(clause))
+
+		}
+
+	}
+
+	if !hasFrom {
+
+		return query, compiler.errorf(compiler.end, "missing 'from' clause")
+
+	}
+
+	if !hasTo {
+
+		query.To = compiler.now
+
+	}
+
+	if query.From > query.To {
+
+		return query, compiler.errorf(compiler.end, "'from' must be before 'to'")
+
+	}
+
+	if aggregation == "" {
+
+		if query.Interval != 0 {
+
+			return query, compiler.errorf(compiler.end, "'every' requires an aggregation")
+
+		}
+
+		return query, nil
+
+	}
+
+	query.TimestampAggregation = aggregation
+
+	if query.RankLimit > 0 {
+
+		// Ranked objects are returned individually, with their series when an interval is asked for
+
+		query.RankAggregation = aggregation
+
+		query.RankWithSeries = query.Interval != 0
+
+	} else if !groupByObject {
+
+		query.ObjectWiseAggregation = aggregation
+
+	}
+
+	return query, nil
+
+}
+
+func (compiler *statementCompiler) counter(query *Query) error {
+
+	if err := compiler.expect("counter"); err != nil {
+
+		return err
+
+	}
+
+	counterId, token, err := compiler.number("counter id")
+
+	if err != nil {
+
+		return err
+
+	}
+
+	if counterId > 0xFFFF {
+
+		return compiler.errorf(token.position, "counter id %d out of range", counterId)
+
+	}
+
+	query.CounterId = uint16(counterId)
+
+	return nil
+
+}
+
+func (compiler *statementCompiler) duration() (uint64, error) {
+
+	token := compiler.next()
+
+	if len(token.text) < 2 {
+
+		return 0, compiler.errorf(token.position, "expected a duration like 5m, found %s", describeToken(token))
+
+	}
+
+	unit, ok := durationUnits[token.text[len(token.text)-1]]
+
+	value, err := strconv.ParseUint(token.text[:len(token.text)-1], 10, 32)
+
+	if !ok || err != nil || value == 0 {
+
+		return 0, compiler.errorf(token.position, "expected a duration like 5m, found %s", describeToken(token))
+
+	}
+
+	return value * unit, nil
+
+}
+
+func (compiler *statementCompiler) time() (uint64, error) {
+
+	token := compiler.peek()
+
+	switch token.text {
+
+	case "now":
+
+		compiler.next()
+
+		return compiler.now, nil
+
+	case "-":
+
+		compiler.next()
+
+		duration, err := compiler.duration()
+
+		if err != nil {
+
+			return 0, err
+
+		}
+
+		if duration > compiler.now {
+
+			return 0, compiler.errorf(token.position, "relative time goes before epoch")
+
+		}
+
+		return compiler.now - duration, nil
+
+	default:
+
+		timestamp, _, err := compiler.number("'now', a relative time like -1h or a unix timestamp")
+
+		return uint64(timestamp), err
+
+	}
+
+}
+
+func (compiler *statementCompiler) objects(query *Query) error {
+
+	if err := compiler.expect("object"); err != nil {
+
+		return err
+
+	}
+
+	if err := compiler.expect("in"); err != nil {
+
+		return err
+
+	}
+
+	if err := compiler.expect("("); err != nil {
+
+		return err
+
+	}
+
+	for {
+
+		token := compiler.next()
+
+		if objectId, err := parseObjectId(token.text); err == nil && !token.quoted && (objectId < ObjectKeyBase || !strings.Contains(token.text, ".")) {
+
+			query.ObjectIds = append(query.ObjectIds, objectId)
+
+		} else if _, err = netip.ParseAddr(token.text); (err == nil || token.quoted) && token.text != "" {
+
+			// IPv6 addresses, quoted identifiers and the IPv4 addresses in the range of the registry's objectIds are
+			// resolved by the object registry
+			query.Objects = append(query.Objects, token.text)
+
+		} else {
+
+			return compiler.errorf(token.position, "expected an IP address, object id or quoted object, found %s", describeToken(token))
+
+		}
+
+		separator := compiler.next()
+
+		if separator.text == ")" {
+
+			return nil
+
+		}
+
+		if separator.text != "," {
+
+			return compiler.errorf(separator.position, "expected ',' or ')', found %s", describeToken(separator))
+
+		}
+
+	}
+
+}
+
+// parseObjectId accepts either an IPv4 address or a numeric objectId.
+func parseObjectId(text string) (uint32, error) {
+
+	if !strings.Contains(text, ".") {
+
+		objectId, err := strconv.ParseUint(text, 10, 32)
+
+		return uint32(objectId), err
+
+	}
+
+	octets := strings.Split(text, ".")
+
+	if len(octets) != 4 {
+
+		return 0, fmt.Errorf("invalid IPv4 address %s", text)
+
+	}
+
+	var objectId uint32
+
+	for _, octet := range octets {
+
+		value, err := strconv.ParseUint(octet, 10, 8)
+
+		if err != nil {
+
+			return 0, err
+
+		}
+
+		objectId = objectId<<8 | uint32(value)
+
+	}
+
+	return objectId, nil
+
+}
+
+func describeToken(token statementToken) string {
+
+	if token.quoted {
+
+		return strconv.Quote(token.text)
+
+	}
+
+	if token.text == "" {
+
+		return "end of statement"
+
+	}
+
+	return "'" + token.text + "'"
+
+}
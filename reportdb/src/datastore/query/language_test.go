@@ -0,0 +1,108 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCompileStatement(t *testing.T) {
+
+	const now = 1747146600
+
+	query, err := CompileStatement("avg(counter 2) by object every 5m from -1h where object in (10.0.0.1, 10.0.0.2)", now)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	expected := Query{
+		From:                  now - 3600,
+		To:                    now,
+		ObjectIds:             []uint32{167772161, 167772162},
+		CounterId:             2,
+		ObjectWiseAggregation: "none",
+		TimestampAggregation:  "avg",
+		Interval:              300,
+	}
+
+	if !reflect.DeepEqual(query, expected) {
+
+		t.Errorf("expected %+v, got %+v", expected, query)
+
+	}
+
+	query, err = CompileStatement("top 10 max(counter 1) from 1747100000 to now", now)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if query.RankOrder != RankOrderTop || query.RankLimit != 10 || query.RankAggregation != "max" || query.ObjectWiseAggregation != "none" || query.From != 1747100000 {
+
+		t.Errorf("unexpected ranking query %+v", query)
+
+	}
+
+	query, err = CompileStatement("counter 3 from -1d", now)
+
+	if err != nil || query.TimestampAggregation != "none" || query.ObjectWiseAggregation != "none" {
+
+		t.Errorf("unexpected drilldown query %+v, error: %v", query, err)
+
+	}
+
+	query, err = CompileStatement("uptime(counter 1) by object from -1h", now)
+
+	if err != nil || query.TimestampAggregation != AggregationUptime || query.ObjectWiseAggregation != "none" {
+
+		t.Errorf("unexpected uptime query %+v, error: %v", query, err)
+
+	}
+
+	query, err = CompileStatement("p99(counter 2) from -1h", now)
+
+	if err != nil || query.ObjectWiseAggregation != "p99" {
+
+		t.Errorf("unexpected percentile query %+v, error: %v", query, err)
+
+	}
+
+	// Addresses in the range of the registry's objectIds are resolved by the registry too
+	query, err = CompileStatement(`counter 3 from -1d where object in ("Web-01", 2001:db8::1, 10.0.0.1, 224.0.0.5)`, now)
+
+	if err != nil || !reflect.DeepEqual(query.Objects, []string{"Web-01", "2001:db8::1", "224.0.0.5"}) || !reflect.DeepEqual(query.ObjectIds, []uint32{167772161}) {
+
+		t.Errorf("unexpected objects in %+v, error: %v", query, err)
+
+	}
+
+	for statement, position := range map[string]int{
+		"avg(counter x) from -1h":                     13,
+		"avg(counter 2 from -1h":                      15,
+		"avg(counter 2) every 5q from -1h":            22,
+		"avg(counter 2)":                              15,
+		"avg(counter 2) from -1h where object in (":   42,
+		"counter 2 from -1h where object in (web-01)": 37,
+		"avg(counter 2) from -1h from -2h":            25,
+		"median(counter 2) from -1h":                  1,
+		"p101(counter 2) from -1h":                    1,
+	} {
+
+		_, err = CompileStatement(statement, now)
+
+		var syntaxError *SyntaxError
+
+		if !errors.As(err, &syntaxError) || syntaxError.Position != position {
+
+			t.Errorf("%q: expected syntax error at position %d, got %v", statement, position, err)
+
+		}
+
+	}
+
+}
@@ -0,0 +1,89 @@
+package query
+
+import (
+	. "datastore/containers"
+	. "datastore/storage"
+	. "datastore/utils"
+	"fmt"
+	"strconv"
+)
+
+// latestValuesResult answers a Latest query from the latest values store, without reading any storage.
+// The series' latest dataPoints are in Data for a single counter, or in a series per counter for multiple counters,
+// the instance series keyed like in the other results.
+func latestValuesResult(query Query, latestValues *LatestValues, config *Config) Result {
+
+	result := Result{
+
+		QueryId: query.QueryId,
+	}
+
+	instanceKeys := NewInstanceKeys()
+
+	if !SupportedPrecision(query.Precision) {
+
+		result.Error = ErrUnsupportedPrecision.Error()
+
+		return result
+
+	}
+
+	if len(query.CounterIds) == 0 {
+
+		if _, ok := config.CounterDataType(query.CounterId); !ok {
+
+			result.Error = ErrUnknownCounter.Error()
+
+			return result
+
+		}
+
+		result.Data = latestData(latestValues.Get(query.CounterId, query.ObjectIds, query.Instances), instanceKeys, config.CounterPrecision(query.CounterId), query.Precision)
+
+	} else {
+
+		result.Series = make(map[string]map[uint32][]DataPoint, len(query.CounterIds))
+
+		for _, counterId := range query.CounterIds {
+
+			if _, ok := config.CounterDataType(counterId); !ok {
+
+				return Result{
+
+					QueryId: query.QueryId,
+
+					Error: fmt.Sprintf("unknown counter c%d", counterId),
+				}
+
+			}
+
+			result.Series["c"+strconv.Itoa(int(counterId))] = latestData(latestValues.Get(counterId, query.ObjectIds, query.Instances), instanceKeys, config.CounterPrecision(counterId), query.Precision)
+
+		}
+
+	}
+
+	PaginateResult(&result, query.Limit, query.Offset)
+
+	describeInstances(&result, instanceKeys)
+
+	return result
+
+}
+
+// latestData converts the latest dataPoints, in the precision of the counter, to the precision of the query.
+func latestData(seriesValues map[SeriesInstance]DataPoint, instanceKeys *InstanceKeys, counterPrecision string, precision string) map[uint32][]DataPoint {
+
+	data := make(map[uint32][]DataPoint, len(seriesValues))
+
+	for series, dataPoint := range seriesValues {
+
+		dataPoint.Timestamp = ConvertTimestamp(dataPoint.Timestamp, counterPrecision, precision)
+
+		data[instanceKeys.Key(series.ObjectId, series.Instance)] = []DataPoint{dataPoint}
+
+	}
+
+	return data
+
+}
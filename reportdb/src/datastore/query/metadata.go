@@ -0,0 +1,312 @@
+package query
+
+import (
+	"context"
+	. "datastore/containers"
+	. "datastore/storage"
+	. "datastore/utils"
+	"errors"
+	"go.uber.org/zap"
+	"slices"
+	"sort"
+)
+
+// Metadata query types
+const (
+	// MetadataObjects lists the objects having data for the counter in the range
+	MetadataObjects = "objects"
+
+	// MetadataSeries describes the series of every object for the counter in the range
+	MetadataSeries = "series"
+
+	// MetadataCounters lists the configured counters
+	MetadataCounters = "counters"
+)
+
+var ErrInvalidMetadata = errors.New("invalid metadata query, it must be either 'objects', 'series' or 'counters'")
+
+type SeriesMetadata struct {
+	ObjectId uint32 `json:"object_id" msgpack:"object_id"`
+
+	Instance string `json:"instance,omitempty" msgpack:"instance,omitempty"`
+
+	FirstTimestamp uint64 `json:"first_timestamp" msgpack:"first_timestamp"`
+
+	LastTimestamp uint64 `json:"last_timestamp" msgpack:"last_timestamp"`
+
+	Points uint32 `json:"points" msgpack:"points"`
+}
+
+type CounterMetadata struct {
+	CounterId uint16 `json:"counter_id" msgpack:"counter_id"`
+
+	DataType string `json:"data_type" msgpack:"data_type"`
+
+	// Precision of the counter's timestamps, s when empty on registration
+	Precision string `json:"precision,omitempty" msgpack:"precision,omitempty"`
+}
+
+func IsMetadataQuery(query Query) bool {
+
+	return query.Metadata != ""
+
+}
+
+func validateMetadataQuery(query Query, config *Config) error {
+
+	switch query.Metadata {
+
+	case MetadataObjects, MetadataSeries:
+
+		if _, ok := config.CounterDataType(query.CounterId); !ok {
+
+			return ErrUnknownCounter
+
+		}
+
+		return nil
+
+	case MetadataCounters:
+
+		return nil
+
+	default:
+
+		return ErrInvalidMetadata
+
+	}
+
+}
+
+// listCounters returns the configured counters ordered by counterId.
+func listCounters(config *Config) []CounterMetadata {
+
+	counters := make([]CounterMetadata, 0)
+
+	for counterId, dataType := range config.CounterDataTypes() {
+
+		counters = append(counters, CounterMetadata{counterId, dataType, config.CounterPrecision(counterId)})
+
+	}
+
+	sort.Slice(counters, func(i, j int) bool {
+
+		return counters[i].CounterId < counters[j].CounterId
+
+	})
+
+	return counters
+
+}
+
+// registerCounters applies the counters of the query, or of the counters file when reloading, to the counter config.
+// The result lists the counters once registered.
+func registerCounters(query Query, storagePool *StoragePool, latestValues *LatestValues) Result {
+
+	config := storagePool.Config
+
+	result := Result{QueryId: query.QueryId}
+
+	// Unflushed dataPoints are already in the latest values
+	hasData := func(counterId uint16) bool {
+
+		return len(latestValues.Get(counterId, nil, nil)) > 0 || storagePool.CounterHasData(counterId)
+
+	}
+
+	var added []uint16
+
+	var err error
+
+	if query.ReloadCounters {
+
+		added, err = config.ReloadCounters(hasData)
+
+	} else {
+
+		counters := make(map[uint16]CounterConfig, len(query.RegisterCounters))
+
+		for _, counter := range query.RegisterCounters {
+
+			counters[counter.CounterId] = CounterConfig{DataType: counter.DataType, Precision: counter.Precision}
+
+		}
+
+		added, err = config.RegisterCounters(counters, hasData)
+
+	}
+
+	if err != nil {
+
+		config.Logger.Error("error registering counters", zap.Error(err))
+
+		result.Error = err.Error()
+
+		return result
+
+	}
+
+	config.Logger.Info("counters registered", zap.Uint16s("added", added))
+
+	result.Counters = listCounters(config)
+
+	return result
+
+}
+
+// listObjects returns the objects present in the counter's day-storages of the range, among the query's objects when it
+// selects some. The days fully in the range are listed from their indexes only, the first and last days are read when
+// the range covers part of them.
+func listObjects(query Query, storagePool *StoragePool, queryTimeoutContext context.Context, stats *QueryStats) []uint32 {
+
+	objects := make(map[uint32]struct{})
+
+	startDate, endDate := queryDates(query)
+
+	for date := startDate; date <= endDate && queryTimeoutContext.Err() == nil; date += 86400 {
+
+		storageKey := StoragePoolKey{
+			Date:      UnixToDate(date),
+			CounterId: query.CounterId,
+		}
+
+		stats.DaysScanned++
+
+		storageEngine, err := storagePool.GetStorage(storageKey, false)
+
+		if err != nil {
+
+			if !errors.Is(err, ErrStorageDoesNotExist) {
+
+				storagePool.Config.Logger.Error("Error getting storage", zap.Any("storageKey", storageKey), zap.Error(err))
+
+			}
+
+			stats.StoragesMissing++
+
+			continue
+
+		}
+
+		var objectIds []uint32
+
+		dayFrom, dayTo := ConvertTimestamp(date, PrecisionSeconds, query.Precision), ConvertTimestamp(date+86400, PrecisionSeconds, query.Precision)-1
+
+		if query.From > dayFrom || query.To < dayTo {
+
+			instanceKeys := NewInstanceKeys()
+
+			var data map[uint32][]DataPoint
+
+			data, err = readSingleDay(storagePool, storageEngine, storageKey, query.ObjectIds, nil, instanceKeys, query.From, query.To, query.Precision, queryTimeoutContext, stats)
+
+			for seriesKey := range data {
+
+				objectIds = append(objectIds, instanceKeys.Lookup(seriesKey).ObjectId)
+
+			}
+
+		} else {
+
+			objectIds, err = storageEngine.GetAllKeys()
+
+		}
+
+		if err != nil {
+
+			storagePool.Config.Logger.Error("Error getting all storage keys", zap.Error(err))
+
+			continue
+
+		}
+
+		for _, objectId := range objectIds {
+
+			// Objects having only instance series are listed too
+			if series, ok := storageEngine.Instance(objectId); ok {
+
+				objectId = series.ObjectId
+
+			}
+
+			if len(query.ObjectIds) > 0 && !slices.Contains(query.ObjectIds, objectId) {
+
+				continue
+
+			}
+
+			objects[objectId] = struct{}{}
+
+		}
+
+	}
+
+	objectIds := make([]uint32, 0, len(objects))
+
+	for objectId := range objects {
+
+		objectIds = append(objectIds, objectId)
+
+	}
+
+	slices.Sort(objectIds)
+
+	return objectIds
+
+}
+
+// describeSeries summarizes the drilldown of every object and instance.
+func describeSeries(data map[uint32][]DataPoint, instanceKeys *InstanceKeys) []SeriesMetadata {
+
+	series := make([]SeriesMetadata, 0, len(data))
+
+	for seriesKey, points := range data {
+
+		if len(points) == 0 {
+
+			continue
+
+		}
+
+		seriesInstance := instanceKeys.Lookup(seriesKey)
+
+		metadata := SeriesMetadata{
+
+			ObjectId: seriesInstance.ObjectId,
+
+			Instance: seriesInstance.Instance,
+
+			FirstTimestamp: points[0].Timestamp,
+
+			LastTimestamp: points[0].Timestamp,
+
+			Points: uint32(len(points)),
+		}
+
+		for _, point := range points {
+
+			metadata.FirstTimestamp = min(metadata.FirstTimestamp, point.Timestamp)
+
+			metadata.LastTimestamp = max(metadata.LastTimestamp, point.Timestamp)
+
+		}
+
+		series = append(series, metadata)
+
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+
+		if series[i].ObjectId != series[j].ObjectId {
+
+			return series[i].ObjectId < series[j].ObjectId
+
+		}
+
+		return series[i].Instance < series[j].Instance
+
+	})
+
+	return series
+
+}
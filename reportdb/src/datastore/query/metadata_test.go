@@ -0,0 +1,60 @@
+package query
+
+import (
+	. "datastore/containers"
+	. "datastore/utils"
+	"testing"
+)
+
+func TestValidateMetadataQuery(t *testing.T) {
+
+	config := DefaultConfig()
+
+	_ = config.AddCounter(1, "uint64")
+
+	_ = config.AddCounter(3, "string")
+
+	if err := validateMetadataQuery(Query{Metadata: MetadataObjects, CounterId: 2}, config); err != ErrUnknownCounter {
+
+		t.Errorf("expected %v, got %v", ErrUnknownCounter, err)
+
+	}
+
+	if err := validateMetadataQuery(Query{Metadata: "keys", CounterId: 1}, config); err != ErrInvalidMetadata {
+
+		t.Errorf("expected %v, got %v", ErrInvalidMetadata, err)
+
+	}
+
+	if counters := listCounters(config); len(counters) != 2 || counters[1] != (CounterMetadata{3, "string", PrecisionSeconds}) {
+
+		t.Errorf("unexpected counters %v", counters)
+
+	}
+
+}
+
+func TestDescribeSeries(t *testing.T) {
+
+	instanceKeys := NewInstanceKeys()
+
+	series := describeSeries(map[uint32][]DataPoint{
+
+		9: {{Timestamp: 30}, {Timestamp: 10}, {Timestamp: 20}},
+
+		4: {{Timestamp: 5}},
+
+		instanceKeys.Key(4, "eth0"): {{Timestamp: 7}},
+
+		6: {},
+	}, instanceKeys)
+
+	expected := []SeriesMetadata{{4, "", 5, 5, 1}, {4, "eth0", 7, 7, 1}, {9, "", 10, 30, 3}}
+
+	if len(series) != len(expected) || series[0] != expected[0] || series[1] != expected[1] || series[2] != expected[2] {
+
+		t.Errorf("expected %v, got %v", expected, series)
+
+	}
+
+}
@@ -0,0 +1,236 @@
+package query
+
+import (
+	. "datastore/containers"
+	. "datastore/utils"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrRankingWithMultipleCounters = errors.New("ranking not supported for multi counter queries")
+
+	ErrEmptyExpressionName = errors.New("expression name can not be empty")
+)
+
+type compiledExpression struct {
+	name string
+
+	node ExpressionNode
+
+	counterIds []uint16
+}
+
+func IsMultiCounterQuery(query Query) bool {
+
+	return len(query.CounterIds) > 0 || len(query.Expressions) > 0
+
+}
+
+// compileQueryExpressions compiles the expressions of a multi-counter query and returns them along with
+// every counter to be read. Without expressions, each counter is returned as its own series named c<counterId>.
+func compileQueryExpressions(query Query, config *Config) ([]compiledExpression, []uint16, error) {
+
+	counters := make(map[uint16]struct{})
+
+	for _, counterId := range query.CounterIds {
+
+		counters[counterId] = struct{}{}
+
+	}
+
+	expressions := make([]compiledExpression, 0, len(query.Expressions))
+
+	expressionNames := make(map[string]struct{})
+
+	for _, expression := range query.Expressions {
+
+		if expression.Name == "" {
+
+			return nil, nil, ErrEmptyExpressionName
+
+		}
+
+		if _, duplicate := expressionNames[expression.Name]; duplicate {
+
+			return nil, nil, fmt.Errorf("duplicate expression name '%s'", expression.Name)
+
+		}
+
+		expressionNames[expression.Name] = struct{}{}
+
+		node, counterIds, err := CompileExpression(expression.Expression)
+
+		if err != nil {
+
+			return nil, nil, fmt.Errorf("expression '%s': %w", expression.Name, err)
+
+		}
+
+		for _, counterId := range counterIds {
+
+			counters[counterId] = struct{}{}
+
+		}
+
+		expressions = append(expressions, compiledExpression{expression.Name, node, counterIds})
+
+	}
+
+	counterIds := make([]uint16, 0, len(counters))
+
+	for counterId := range counters {
+
+		counterIds = append(counterIds, counterId)
+
+	}
+
+	sort.Slice(counterIds, func(i, j int) bool {
+
+		return counterIds[i] < counterIds[j]
+
+	})
+
+	for _, counterId := range counterIds {
+
+		dataType, ok := config.CounterDataType(counterId)
+
+		if !ok {
+
+			return nil, nil, fmt.Errorf("unknown counter c%d", counterId)
+
+		}
+
+		if len(expressions) > 0 && (!aggregatable(dataType) || dataType == "histogram") {
+
+			return nil, nil, fmt.Errorf("%s counter c%d can not be used in expressions", dataType, counterId)
+
+		}
+
+		for _, aggregation := range []string{query.InstanceAggregation, query.ObjectWiseAggregation, query.TimestampAggregation} {
+
+			if err := validateAggregation(aggregation, dataType); err != nil {
+
+				return nil, nil, fmt.Errorf("counter c%d: %w", counterId, err)
+
+			}
+
+		}
+
+	}
+
+	if len(expressions) == 0 {
+
+		for _, counterId := range counterIds {
+
+			expressions = append(expressions, compiledExpression{
+
+				name: "c" + strconv.Itoa(int(counterId)),
+
+				node: counterNode{counterId},
+
+				counterIds: []uint16{counterId},
+			})
+
+		}
+
+	}
+
+	return expressions, counterIds, nil
+
+}
+
+// EvaluateExpressions joins the counters' series per object and per bucket timestamp and evaluates every expression on them.
+// Buckets missing any of the expression's counters are skipped.
+func EvaluateExpressions(countersData map[uint16]map[uint32][]DataPoint, expressions []compiledExpression) map[string]map[uint32][]DataPoint {
+
+	series := make(map[string]map[uint32][]DataPoint, len(expressions))
+
+	for _, expression := range expressions {
+
+		// Raw counter series are passed through without conversion to keep their type
+		if node, ok := expression.node.(counterNode); ok {
+
+			series[expression.name] = countersData[node.counterId]
+
+			continue
+
+		}
+
+		objectWiseData := make(map[uint32][]DataPoint)
+
+		// Join the counter values object-wise and timestamp-wise
+		joinedValues := make(map[uint32]map[uint64]map[uint16]float64)
+
+		for _, counterId := range expression.counterIds {
+
+			for objectId, points := range countersData[counterId] {
+
+				if _, ok := joinedValues[objectId]; !ok {
+
+					joinedValues[objectId] = make(map[uint64]map[uint16]float64)
+
+				}
+
+				for _, point := range points {
+
+					value, ok := NumericValue(point.Value)
+
+					if !ok {
+
+						continue
+
+					}
+
+					if _, ok = joinedValues[objectId][point.Timestamp]; !ok {
+
+						joinedValues[objectId][point.Timestamp] = make(map[uint16]float64)
+
+					}
+
+					joinedValues[objectId][point.Timestamp][counterId] = value
+
+				}
+
+			}
+
+		}
+
+		for objectId, timeIndexedValues := range joinedValues {
+
+			dataPoints := make([]DataPoint, 0, len(timeIndexedValues))
+
+			for timestamp, counterValues := range timeIndexedValues {
+
+				if value, ok := expression.node.Evaluate(counterValues); ok {
+
+					dataPoints = append(dataPoints, DataPoint{
+
+						Timestamp: timestamp,
+
+						Value: value,
+					})
+
+				}
+
+			}
+
+			sort.Slice(dataPoints, func(i, j int) bool {
+
+				return dataPoints[i].Timestamp < dataPoints[j].Timestamp
+
+			})
+
+			objectWiseData[objectId] = dataPoints
+
+		}
+
+		series[expression.name] = objectWiseData
+
+	}
+
+	return series
+
+}
@@ -0,0 +1,144 @@
+package query
+
+import (
+	. "datastore/containers"
+	. "datastore/continuous"
+	"fmt"
+)
+
+// resolveObjects adds the objectIds of the query's objects to its ObjectIds. It returns false when the query selects
+// objects and none of them is known, the query then has no data.
+func resolveObjects(query *Query, objects *ObjectRegistry) bool {
+
+	if len(query.Objects) == 0 {
+
+		return true
+
+	}
+
+	for _, object := range query.Objects {
+
+		if objectId, ok := objects.Resolve(object); ok {
+
+			query.ObjectIds = append(query.ObjectIds, objectId)
+
+		}
+
+	}
+
+	query.Objects = nil
+
+	return len(query.ObjectIds) > 0
+
+}
+
+// describeObjects sets the identifier of the objectIds found in the result that were assigned by the object registry.
+func describeObjects(result *Result, objects *ObjectRegistry) {
+
+	describe := func(seriesKey uint32) {
+
+		objectId := seriesKey
+
+		if series, ok := result.Instances[seriesKey]; ok {
+
+			objectId = series.ObjectId
+
+		}
+
+		if !objects.IsRegistered(objectId) {
+
+			return
+
+		}
+
+		if result.ObjectIdentifiers == nil {
+
+			result.ObjectIdentifiers = make(map[uint32]string)
+
+		}
+
+		result.ObjectIdentifiers[objectId] = objects.Object(objectId)
+
+	}
+
+	for seriesKey := range result.Data {
+
+		describe(seriesKey)
+
+	}
+
+	for _, data := range result.Series {
+
+		for seriesKey := range data {
+
+			describe(seriesKey)
+
+		}
+
+	}
+
+	for _, rankedObject := range result.Ranking {
+
+		describe(rankedObject.ObjectId)
+
+	}
+
+	for seriesKey := range result.DistinctValues {
+
+		describe(seriesKey)
+
+	}
+
+	for seriesKey := range result.Changes {
+
+		describe(seriesKey)
+
+	}
+
+	for _, objectId := range result.Objects {
+
+		describe(objectId)
+
+	}
+
+	for _, series := range result.SeriesMetadata {
+
+		describe(series.ObjectId)
+
+	}
+
+	for _, continuousQuery := range result.ContinuousQueries {
+
+		for _, objectId := range continuousQuery.ObjectIds {
+
+			describe(objectId)
+
+		}
+
+	}
+
+}
+
+// registerContinuousQueryObjects adds the objectIds of the continuous query's objects to its ObjectIds, registering
+// the objects not written yet so that their data is aggregated once it is.
+func registerContinuousQueryObjects(continuousQuery *ContinuousQuery, objects *ObjectRegistry) error {
+
+	for _, object := range continuousQuery.Objects {
+
+		objectId, err := objects.Register(object)
+
+		if err != nil {
+
+			return fmt.Errorf("object %s: %w", object, err)
+
+		}
+
+		continuousQuery.ObjectIds = append(continuousQuery.ObjectIds, objectId)
+
+	}
+
+	continuousQuery.Objects = nil
+
+	return nil
+
+}
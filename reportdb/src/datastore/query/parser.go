@@ -3,176 +3,504 @@ package query
 import (
 	"context"
 	. "datastore/containers"
+	. "datastore/continuous"
 	. "datastore/utils"
+	"errors"
 	"go.uber.org/zap"
 	"sync"
 	"time"
 )
 
-func Parser(queryReceiveChannel <-chan Query, queryResultChannel chan<- Result, storagePool *StoragePool, parsersWaitGroup *sync.WaitGroup) {
+var ErrUnknownCounter = errors.New("unknown counterId")
+
+func Parser(queryReceiveChannel <-chan Query, queryResultChannel chan<- Result, storagePool *StoragePool, activeQueries *ActiveQueries, continuousQueries *ContinuousQueries, latestValues *LatestValues, admissionQueue *AdmissionQueue, parsersWaitGroup *sync.WaitGroup) {
 
 	defer parsersWaitGroup.Done()
 
+	config := storagePool.Config
+
 	// Initialize Readers
 
-	readerRequestChannel := make(chan ReaderRequest, ReaderRequestChannelSize)
+	readerRequestChannel := make(chan ReaderRequest, config.ReaderRequestChannelSize)
+
+	readerResponseChannel := make(chan ReaderResponse, config.ReaderResponseChannelSize)
+
+	unregisterMetrics := config.Metrics.GaugeFunc("reportdb_reader_request_channel_depth", "Reader requests waiting for the readers, over every query parser.", func() float64 {
 
-	readerResponseChannel := make(chan ReaderResponse, ReaderResponseChannelSize)
+		return float64(len(readerRequestChannel))
+
+	})
+
+	defer unregisterMetrics()
 
 	var readersWaitGroup sync.WaitGroup
 
-	readersWaitGroup.Add(Readers)
+	readersWaitGroup.Add(config.Readers)
 
-	for range Readers {
+	for range config.Readers {
 
 		go Reader(readerRequestChannel, readerResponseChannel, storagePool, &readersWaitGroup)
 
 	}
 
-	// Listen for query
+	// Every batch of reader requests gets its own id, so that late responses of a cancelled or timed out batch are discarded
+	var batchId uint64
 
-	for query := range queryReceiveChannel {
+	// Listen for query, and for the queries admitted after waiting for admission
+loop:
+	for {
 
-		Logger.Info("Query received: ", zap.Any("query", query))
+		var admitted admittedQuery
 
-		benchmarkTime := time.Now()
+		var ok bool
 
-		queryTimeoutContext, queryTimeoutContextCancel := context.WithTimeout(context.Background(), time.Duration(QueryTimeoutTime)*time.Second)
+		select {
 
-		startDate := query.From - (query.From % 86400)
+		case query, ok := <-queryReceiveChannel:
 
-		endDate := query.To - (query.To % 86400)
+			if !ok {
 
-		dataType := CounterConfig[query.CounterId][DataType].(string)
+				// Keep executing the queries still waiting for admission till the last one is admitted or rejected
+				queryReceiveChannel = nil
 
-		// Total number of days will be: (endDate-startDate)/86400+1
-		daysData := make([]map[uint32][]DataPoint, (endDate-startDate)/86400+1)
+				admissionQueue.receivers.Done()
 
-		requestIndex := 0
+				continue
 
-		for date := startDate; date <= endDate; date += 86400 {
+			}
 
-			select {
+			config.Logger.Info("Query received: ", zap.Any("query", query))
 
-			case <-queryTimeoutContext.Done():
+			benchmarkTime := time.Now()
 
-				break
+			if query.RegisterContinuousQuery != nil {
+
+				result := Result{QueryId: query.QueryId}
+
+				continuousQuery := *query.RegisterContinuousQuery
+
+				err := registerContinuousQueryObjects(&continuousQuery, storagePool.Objects)
+
+				if err == nil {
 
-			case readerRequestChannel <- ReaderRequest{
+					err = continuousQueries.Register(continuousQuery)
 
-				RequestIndex: requestIndex,
+				}
+
+				if err != nil {
 
-				StorageKey: StoragePoolKey{
-					Date:      UnixToDate(date),
-					CounterId: query.CounterId,
-				},
+					result.Error = err.Error()
 
-				From: query.From,
+				} else {
 
-				To: query.To,
+					result.ContinuousQueries = continuousQueries.List()
 
-				ObjectIds: query.ObjectIds,
+					describeObjects(&result, storagePool.Objects)
+
+				}
 
-				TimeoutContext: queryTimeoutContext,
-			}:
+				queryResultChannel <- result
 
-				requestIndex++
+				continue
 
 			}
 
-		}
+			if query.ListContinuousQueries {
 
-		// Listen for response from reader
-		for range len(daysData) {
+				result := Result{
 
-			select {
+					QueryId: query.QueryId,
 
-			case <-queryTimeoutContext.Done():
+					ContinuousQueries: continuousQueries.List(),
+				}
 
-				break
+				describeObjects(&result, storagePool.Objects)
+
+				queryResultChannel <- result
+
+				continue
+
+			}
+
+			if len(query.RegisterCounters) > 0 || query.ReloadCounters {
+
+				queryResultChannel <- registerCounters(query, storagePool, latestValues)
 
-			case response := <-readerResponseChannel:
+				continue
 
-				if response.Error == nil {
+			}
+
+			if query.Metadata == MetadataCounters {
 
-					daysData[response.RequestIndex] = response.Data
+				queryResultChannel <- Result{
 
+					QueryId: query.QueryId,
+
+					Counters: listCounters(config),
 				}
 
+				continue
+
 			}
 
-		}
+			if !resolveObjects(&query, storagePool.Objects) {
 
-		// If the datatype is string, there is no point of aggregation. Hence for string queries, just normalize the days and send the drilldown.
+				// None of the selected objects was ever written
+				queryResultChannel <- Result{QueryId: query.QueryId}
 
-		// Vertical aggregation
+				continue
 
-		if query.ObjectWiseAggregation != "none" && dataType != "string" {
+			}
 
-			ObjectWiseAggregator(daysData, query.ObjectWiseAggregation, queryTimeoutContext)
+			if query.Latest {
 
-		}
+				result := latestValuesResult(query, latestValues, config)
 
-		// Necessary structures initialization
+				describeObjects(&result, storagePool.Objects)
 
-		normalizedDataPoints := make(map[uint32][]DataPoint)
+				queryResultChannel <- result
 
-		if query.TimestampAggregation != "none" && dataType != "string" {
+				continue
 
-			TimestampAggregator(daysData, query.TimestampAggregation, query.Interval, query.From, normalizedDataPoints, queryTimeoutContext)
+			}
 
-		} else {
+			if query.Statement != "" {
 
-			// Drilldown, Just normalize the days to object wise single slice of dataPoints
-			for _, day := range daysData {
+				statementQuery, err := CompileStatement(query.Statement, uint64(time.Now().Unix()))
+
+				if err != nil {
+
+					queryResultChannel <- Result{
+
+						QueryId: query.QueryId,
+
+						Error: err.Error(),
+					}
+
+					continue
+
+				}
+
+				statementQuery.QueryId = query.QueryId
+
+				statementQuery.Timeout = query.Timeout
+
+				statementQuery.Priority = query.Priority
+
+				statementQuery.Limit = query.Limit
+
+				statementQuery.Offset = query.Offset
+
+				statementQuery.Explain = query.Explain
+
+				statementQuery.Metadata = query.Metadata
+
+				statementQuery.StringMode = query.StringMode
+
+				statementQuery.Instances = query.Instances
+
+				statementQuery.InstanceAggregation = query.InstanceAggregation
+
+				statementQuery.Precision = query.Precision
+
+				statementQuery.From = ConvertTimestamp(statementQuery.From, PrecisionSeconds, query.Precision)
+
+				statementQuery.To = ConvertTimestamp(statementQuery.To, PrecisionSeconds, query.Precision)
+
+				statementQuery.Interval = ConvertTimestamp(statementQuery.Interval, PrecisionSeconds, query.Precision)
+
+				query = statementQuery
+
+				if !resolveObjects(&query, storagePool.Objects) {
+
+					queryResultChannel <- Result{QueryId: query.QueryId}
+
+					continue
+
+				}
+
+			}
+
+			var expressions []compiledExpression
+
+			var counterIds []uint16
+
+			var err error
+
+			query.Priority, err = NormalizePriority(query.Priority)
+
+			if err == nil && !SupportedPrecision(query.Precision) {
+
+				err = ErrUnsupportedPrecision
+
+			}
+
+			if err == nil {
+
+				err = validateInstanceAggregation(query)
+
+			}
+
+			if err == nil && IsMetadataQuery(query) {
+
+				err = validateMetadataQuery(query, config)
+
+			} else if err == nil && IsMultiCounterQuery(query) {
+
+				if IsRankingQuery(query) {
+
+					err = ErrRankingWithMultipleCounters
+
+				} else if IsStringModeQuery(query) {
+
+					err = ErrStringModeWithMultipleCounters
+
+				} else {
+
+					expressions, counterIds, err = compileQueryExpressions(query, config)
+
+				}
+
+			} else if err == nil {
+
+				err = validateQuery(query, config)
+
+			}
+
+			if err != nil {
+
+				queryResultChannel <- Result{
+
+					QueryId: query.QueryId,
+
+					Error: err.Error(),
+				}
+
+				continue
+
+			}
+
+			// Queries may carry their own timeout, otherwise the configured one applies
+			queryTimeout := time.Duration(config.QueryTimeoutTime) * time.Second
+
+			if query.Timeout > 0 {
 
-				select {
+				queryTimeout = time.Duration(query.Timeout) * time.Second
 
-				case <-queryTimeoutContext.Done():
+			}
+
+			queryTimeoutContext, queryTimeoutContextCancel := context.WithTimeout(context.Background(), queryTimeout)
+
+			if !activeQueries.Register(query.QueryId, queryTimeoutContextCancel) {
+
+				config.Logger.Info("Query cancelled before processing", zap.Uint64("queryId", query.QueryId))
+
+				queryTimeoutContextCancel()
+
+				continue
+
+			}
+
+			// The query waits for its priority class to have budget for scanning its day-storages apart, the parser
+			// moves on to the next query meanwhile
+			admissionQueue.waiting.Add(1)
+
+			go func(admitted admittedQuery, days int) {
+
+				defer admissionQueue.waiting.Done()
+
+				releaseAdmission, err := admissionQueue.controller.Admit(admitted.timeoutContext, admitted.query.Priority, days)
+
+				if err != nil {
 
-					break
+					activeQueries.Deregister(admitted.query.QueryId)
 
-				default:
+					admitted.cancel()
 
-					for objectId, points := range day {
+					if errors.Is(err, context.Canceled) {
 
-						normalizedDataPoints[objectId] = append(normalizedDataPoints[objectId], points...)
+						config.Logger.Info("Query cancelled while waiting for admission", zap.Uint64("queryId", admitted.query.QueryId))
+
+						return
 
 					}
 
+					config.Logger.Info("Query not admitted", zap.Uint64("queryId", admitted.query.QueryId), zap.Error(err))
+
+					if errors.Is(err, ErrAdmissionTimeout) {
+
+						config.Metrics.QueryTimeouts.Add(1)
+
+					}
+
+					queryResultChannel <- Result{
+
+						QueryId: admitted.query.QueryId,
+
+						Error: err.Error(),
+					}
+
+					return
+
 				}
 
+				admitted.release = releaseAdmission
+
+				admissionQueue.admitted <- admitted
+
+			}(admittedQuery{
+
+				query: query,
+
+				expressions: expressions,
+
+				counterIds: counterIds,
+
+				timeoutContext: queryTimeoutContext,
+
+				cancel: queryTimeoutContextCancel,
+
+				receivedTime: benchmarkTime,
+			}, queryDays(query)*max(len(counterIds), 1))
+
+			continue
+
+		case admitted, ok = <-admissionQueue.admitted:
+
+			if !ok {
+
+				break loop
+
 			}
 
 		}
 
+		query, expressions, counterIds := admitted.query, admitted.expressions, admitted.counterIds
+
+		queryTimeoutContext, queryTimeoutContextCancel, releaseAdmission, benchmarkTime := admitted.timeoutContext, admitted.cancel, admitted.release, admitted.receivedTime
+
+		// Instance series are keyed for this query only, the keys are described in the result
+		instanceKeys := NewInstanceKeys()
+
+		// The result is streamed in chunks as the objects' series are aggregated, the last chunk completing it
+		stream := NewResultStream(query.QueryId, config.ResultChunkSize, query.Limit, query.Offset, func(chunk Result) {
+
+			queryResultChannel <- chunk
+
+		}, func(chunk *Result) {
+
+			describeInstances(chunk, instanceKeys)
+
+			describeObjects(chunk, storagePool.Objects)
+
+		})
+
+		var last Result
+
+		var stats QueryStats
+
+		if query.Metadata == MetadataObjects {
+
+			last.Objects = listObjects(query, storagePool, queryTimeoutContext, &stats)
+
+		} else if query.Metadata == MetadataSeries {
+
+			batchId++
+
+			// Series are described from the object's drilldown
+			drilldownQuery := query
+
+			drilldownQuery.ObjectWiseAggregation, drilldownQuery.TimestampAggregation, drilldownQuery.RankLimit = "none", "none", 0
+
+			data := make(map[uint32][]DataPoint)
+
+			executeCounterQuery(drilldownQuery, query.CounterId, batchId, storagePool, instanceKeys, readerRequestChannel, readerResponseChannel, queryTimeoutContext, &stats, collect(data))
+
+			last.SeriesMetadata = describeSeries(data, instanceKeys)
+
+		} else if IsMultiCounterQuery(query) {
+
+			countersData := make(map[uint16]map[uint32][]DataPoint, len(counterIds))
+
+			for _, counterId := range counterIds {
+
+				batchId++
+
+				countersData[counterId] = make(map[uint32][]DataPoint)
+
+				executeCounterQuery(query, counterId, batchId, storagePool, instanceKeys, readerRequestChannel, readerResponseChannel, queryTimeoutContext, &stats, collect(countersData[counterId]))
+
+			}
+
+			// Expressions need every counter's series of an object
+			stream.AddResult(&Result{Series: EvaluateExpressions(countersData, expressions)})
+
+		} else {
+
+			batchId++
+
+			emit := stream.AddData
+
+			if IsStringModeQuery(query) {
+
+				// String counters are never aggregated, the string modes are computed from the drilldown
+				emit = func(objectId uint32, dataPoints []DataPoint) {
+
+					var objectResult Result
+
+					applyStringMode(&objectResult, query.StringMode, map[uint32][]DataPoint{objectId: dataPoints})
+
+					stream.AddResult(&objectResult)
+
+				}
+
+			}
+
+			last.Ranking = executeCounterQuery(query, query.CounterId, batchId, storagePool, instanceKeys, readerRequestChannel, readerResponseChannel, queryTimeoutContext, &stats, emit)
+
+		}
+
+		releaseAdmission()
+
+		activeQueries.Deregister(query.QueryId)
+
+		stats.TotalTime = time.Since(benchmarkTime).Microseconds()
+
+		config.Metrics.QueryDuration.ObserveSince(benchmarkTime)
+
+		logSlowQuery(config, query, stats)
+
 		select {
 		case <-queryTimeoutContext.Done():
 
-			Logger.Info("Query timed out.", zap.Uint64("queryId", query.QueryId))
-
-			queryResultChannel <- Result{
+			if errors.Is(queryTimeoutContext.Err(), context.Canceled) {
 
-				query.QueryId,
+				// Client is no longer waiting for the result
+				config.Logger.Info("Query cancelled.", zap.Uint64("queryId", query.QueryId))
 
-				nil,
+				break
 
-				"query timed out",
 			}
 
-		default:
+			config.Logger.Info("Query timed out.", zap.Uint64("queryId", query.QueryId))
 
-			Logger.Info("Query result successful in ", zap.Any("ProcessingTime", time.Since(benchmarkTime)), zap.Uint64("queryId", query.QueryId))
+			config.Metrics.QueryTimeouts.Add(1)
 
-			queryResultChannel <- Result{
+			// The chunks already sent are completed by the error
+			stream.Close(Result{Error: "query timed out"})
 
-				query.QueryId,
+		default:
 
-				normalizedDataPoints,
+			config.Logger.Info("Query result successful in ", zap.Any("ProcessingTime", time.Since(benchmarkTime)), zap.Uint64("queryId", query.QueryId))
+
+			if query.Explain {
+
+				last.Stats = &stats
 
-				"",
 			}
 
+			stream.Close(last)
+
 		}
 
 		queryTimeoutContextCancel()
@@ -184,3 +512,349 @@ func Parser(queryReceiveChannel <-chan Query, queryResultChannel chan<- Result,
 	readersWaitGroup.Wait()
 
 }
+
+// executeCounterQuery reads the queried days of a single counter and applies the ranking and aggregations of the query on
+// them. The series of every object is handed to emit as soon as it is aggregated, in the order of the objectIds.
+func executeCounterQuery(query Query, counterId uint16, batchId uint64, storagePool *StoragePool, instanceKeys *InstanceKeys, readerRequestChannel chan<- ReaderRequest, readerResponseChannel <-chan ReaderResponse, queryTimeoutContext context.Context, stats *QueryStats, emit func(objectId uint32, dataPoints []DataPoint)) []RankedObject {
+
+	dataType, _ := storagePool.Config.CounterDataType(counterId)
+
+	startDate, endDate := queryDates(query)
+
+	// Total number of days will be: (endDate-startDate)/86400+1
+	daysData := make([]map[uint32][]DataPoint, (endDate-startDate)/86400+1)
+
+	// Days fully in the past are served from the result cache, the writer invalidates them if they are written again
+	now := uint64(time.Now().Unix())
+
+	cacheKeys := make([]string, len(daysData))
+
+	cachedDays := make([]bool, len(daysData))
+
+	sentRequests := 0
+
+	readStartTime := time.Now()
+
+	for dayIndex, date := 0, startDate; date <= endDate; dayIndex, date = dayIndex+1, date+86400 {
+
+		storageKey := StoragePoolKey{
+			Date:      UnixToDate(date),
+			CounterId: counterId,
+		}
+
+		if date+86400 <= now {
+
+			cacheKeys[dayIndex] = storagePool.Caches.CreateQueryResultCacheKey(storageKey, daySignature(query, storagePool.Config.CounterPrecision(counterId), max(query.From, ConvertTimestamp(date, PrecisionSeconds, query.Precision)), min(query.To, ConvertTimestamp(date+86400, PrecisionSeconds, query.Precision)-1)))
+
+			if day, hit := getCachedDay(storagePool.Caches, cacheKeys[dayIndex], instanceKeys); hit {
+
+				daysData[dayIndex] = day
+
+				cachedDays[dayIndex] = true
+
+				stats.ResultCacheHits++
+
+				continue
+
+			}
+
+			stats.ResultCacheMisses++
+
+		}
+
+		select {
+
+		case <-queryTimeoutContext.Done():
+
+			break
+
+		case readerRequestChannel <- ReaderRequest{
+
+			BatchId: batchId,
+
+			RequestIndex: dayIndex,
+
+			StorageKey: storageKey,
+
+			From: query.From,
+
+			To: query.To,
+
+			Precision: query.Precision,
+
+			ObjectIds: query.ObjectIds,
+
+			Instances: query.Instances,
+
+			InstanceKeys: instanceKeys,
+
+			TimeoutContext: queryTimeoutContext,
+		}:
+
+			sentRequests++
+
+			stats.DaysScanned++
+
+		}
+
+	}
+
+	// Listen for response from reader
+	for pendingResponses := sentRequests; pendingResponses > 0; {
+
+		select {
+
+		case <-queryTimeoutContext.Done():
+
+			pendingResponses = 0
+
+		case response := <-readerResponseChannel:
+
+			if response.BatchId != batchId {
+
+				// Stale response of an earlier cancelled or timed out batch
+				continue
+
+			}
+
+			pendingResponses--
+
+			stats.add(response.Stats)
+
+			if response.Error == nil {
+
+				daysData[response.RequestIndex] = response.Data
+
+			}
+
+		}
+
+	}
+
+	stats.ReadTime += time.Since(readStartTime).Microseconds()
+
+	// If the datatype is string or enum, there is no point of aggregation. Hence for such queries, just normalize the days and send the drilldown.
+
+	// Vertical aggregations, cached days are already aggregated
+
+	readDays := make([]map[uint32][]DataPoint, len(daysData))
+
+	for dayIndex, day := range daysData {
+
+		if !cachedDays[dayIndex] {
+
+			readDays[dayIndex] = day
+
+		}
+
+	}
+
+	objectWiseStartTime := time.Now()
+
+	if IsInstanceAggregationQuery(query) && aggregatable(dataType) {
+
+		InstanceAggregator(readDays, query.InstanceAggregation, instanceKeys, queryTimeoutContext, storagePool.Config.Logger)
+
+	}
+
+	if query.ObjectWiseAggregation != "none" && aggregatable(dataType) {
+
+		ObjectWiseAggregator(readDays, query.ObjectWiseAggregation, instanceKeys, queryTimeoutContext, storagePool.Config.Logger)
+
+	}
+
+	stats.ObjectWiseAggregationTime += time.Since(objectWiseStartTime).Microseconds()
+
+	if queryTimeoutContext.Err() == nil {
+
+		for dayIndex, day := range daysData {
+
+			if cacheKeys[dayIndex] != "" && !cachedDays[dayIndex] && day != nil {
+
+				setCachedDay(storagePool.Caches, cacheKeys[dayIndex], day, instanceKeys)
+
+			}
+
+		}
+
+	}
+
+	// Ranking, keep only the top/bottom N objects. Ranking queries are never aggregated object-wise.
+
+	var ranking []RankedObject
+
+	if IsRankingQuery(query) {
+
+		ranking = RankObjects(daysData, query.RankAggregation, query.RankOrder, query.RankLimit, queryTimeoutContext, storagePool.Config.Logger)
+
+		if query.RankWithSeries {
+
+			KeepRankedObjects(daysData, ranking)
+
+		} else {
+
+			// Only the ranking is asked for, skip the series
+			daysData = daysData[:0]
+
+		}
+
+	}
+
+	timestampStartTime := time.Now()
+
+	if query.TimestampAggregation != "none" && aggregatable(dataType) {
+
+		TimestampAggregator(daysData, query.TimestampAggregation, query.Interval, query.From, emit, queryTimeoutContext, storagePool.Config.Logger)
+
+	} else {
+
+		// Drilldown, Just normalize the days to object wise single slice of dataPoints
+		objects := make(map[uint32]struct{})
+
+		for _, day := range daysData {
+
+			for objectId := range day {
+
+				objects[objectId] = struct{}{}
+
+			}
+
+		}
+
+		for _, objectId := range sortedObjectIds(objects) {
+
+			if queryTimeoutContext.Err() != nil {
+
+				break
+
+			}
+
+			var dataPoints []DataPoint
+
+			for _, day := range daysData {
+
+				if points, ok := day[objectId]; ok {
+
+					dataPoints = append(dataPoints, points...)
+
+				}
+
+			}
+
+			emit(objectId, dataPoints)
+
+		}
+
+	}
+
+	stats.TimestampAggregationTime += time.Since(timestampStartTime).Microseconds()
+
+	return ranking
+
+}
+
+// collect returns an emit function of executeCounterQuery gathering the objects' series into data.
+func collect(data map[uint32][]DataPoint) func(objectId uint32, dataPoints []DataPoint) {
+
+	return func(objectId uint32, dataPoints []DataPoint) {
+
+		data[objectId] = dataPoints
+
+	}
+
+}
+
+// queryDays returns the number of day-storages spanned by the query's range.
+func queryDays(query Query) int {
+
+	startDate, endDate := queryDates(query)
+
+	return int((endDate-startDate)/86400) + 1
+
+}
+
+// queryDates returns the unix time of the first and the last day of the query's range.
+func queryDates(query Query) (startDate uint64, endDate uint64) {
+
+	from, to := TimestampSeconds(query.From, query.Precision), TimestampSeconds(query.To, query.Precision)
+
+	return from - (from % 86400), to - (to % 86400)
+
+}
+
+func validateQuery(query Query, config *Config) error {
+
+	dataType, ok := config.CounterDataType(query.CounterId)
+
+	if !ok {
+
+		return ErrUnknownCounter
+
+	}
+
+	for _, aggregation := range []string{query.InstanceAggregation, query.ObjectWiseAggregation, query.TimestampAggregation} {
+
+		if err := validateAggregation(aggregation, dataType); err != nil {
+
+			return err
+
+		}
+
+	}
+
+	if IsStringModeQuery(query) {
+
+		return validateStringModeQuery(query, dataType)
+
+	}
+
+	if IsRankingQuery(query) {
+
+		return validateRankingQuery(query, dataType)
+
+	}
+
+	return nil
+
+}
+
+func validateRankingQuery(query Query, dataType string) error {
+
+	if !aggregatable(dataType) {
+
+		return ErrRankingNotSupported
+
+	}
+
+	if query.ObjectWiseAggregation != "none" {
+
+		return ErrRankingWithObjectWiseAggregation
+
+	}
+
+	if !validAggregation(query.RankAggregation) {
+
+		return ErrInvalidRankAggregation
+
+	}
+
+	// Objects rank by a number, merged histograms are not one
+	if err := validateAggregation(query.RankAggregation, dataType); err != nil || dataType == "histogram" && query.RankAggregation == "sum" {
+
+		return ErrAggregationNotSupported
+
+	}
+
+	switch query.RankOrder {
+
+	case RankOrderTop, RankOrderBottom:
+
+	default:
+
+		return ErrInvalidRankOrder
+
+	}
+
+	return nil
+
+}
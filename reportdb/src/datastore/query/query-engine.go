@@ -2,26 +2,96 @@ package query
 
 import (
 	. "datastore/containers"
-	. "datastore/utils"
+	. "datastore/continuous"
+	. "datastore/storage"
 	"sync"
 )
 
 type Query struct {
 	QueryId uint64 `json:"query_id" msgpack:"query_id"`
 
-	From uint32 `json:"from" msgpack:"from"`
+	// Statement is a text query, when present it is compiled and replaces the rest of the fields.
+	Statement string `json:"statement" msgpack:"statement"`
 
-	To uint32 `json:"to" msgpack:"to"`
+	// From, To and Interval are in the precision of the query, the timestamps of the result too
+	From uint64 `json:"from" msgpack:"from"`
+
+	To uint64 `json:"to" msgpack:"to"`
+
+	// Precision of the query's timestamps, either s (default) or ms. Counters of the other precision are converted,
+	// milliseconds being truncated to seconds.
+	Precision string `json:"precision" msgpack:"precision"`
 
 	ObjectIds []uint32 `json:"object_ids" msgpack:"object_ids"`
 
+	// Objects selects objects by identifier, like IP addresses or hostnames, in addition to ObjectIds. Unknown objects
+	// have no data.
+	Objects []string `json:"objects" msgpack:"objects"`
+
+	// Instances selects the instance series of the objects, "" standing for the objects' own series. Every series is
+	// read when empty.
+	Instances []string `json:"instances" msgpack:"instances"`
+
+	// InstanceAggregation aggregates the instances of every object into the object's series, either avg, sum, min, max
+	// or count. Instances are kept apart when empty or none, the object-wise aggregation then aggregates by instance.
+	InstanceAggregation string `json:"instance_aggregation" msgpack:"instance_aggregation"`
+
 	CounterId uint16 `json:"counter_id" msgpack:"counter_id"`
 
+	CounterIds []uint16 `json:"counter_ids" msgpack:"counter_ids"`
+
+	Expressions []Expression `json:"expressions" msgpack:"expressions"`
+
 	ObjectWiseAggregation string `json:"object_wise_aggregation" msgpack:"object_wise_aggregation"`
 
 	TimestampAggregation string `json:"timestamp_aggregation" msgpack:"timestamp_aggregation"`
 
-	Interval uint32 `json:"interval" msgpack:"interval"`
+	Interval uint64 `json:"interval" msgpack:"interval"`
+
+	RankAggregation string `json:"rank_aggregation" msgpack:"rank_aggregation"`
+
+	RankOrder string `json:"rank_order" msgpack:"rank_order"`
+
+	RankLimit uint32 `json:"rank_limit" msgpack:"rank_limit"`
+
+	RankWithSeries bool `json:"rank_with_series" msgpack:"rank_with_series"`
+
+	// Cancel marks the message as a cancellation of the query with the same QueryId, Timeout being the query's
+	Cancel bool `json:"cancel" msgpack:"cancel"`
+
+	// Timeout in seconds, QueryTimeoutTime is used when absent
+	Timeout uint32 `json:"timeout" msgpack:"timeout"`
+
+	// Priority class of the query, either interactive (default) or batch
+	Priority string `json:"priority" msgpack:"priority"`
+
+	Limit uint32 `json:"limit" msgpack:"limit"`
+
+	Offset uint32 `json:"offset" msgpack:"offset"`
+
+	// StringMode asks for the distinct values, value changes or current value of a string counter, see StringModeDistinct
+	StringMode string `json:"string_mode" msgpack:"string_mode"`
+
+	// Latest asks for the latest dataPoint of the objects instead of the range, From and To are ignored
+	Latest bool `json:"latest" msgpack:"latest"`
+
+	// Metadata asks for the objects, series or counters metadata instead of the dataPoints, see MetadataObjects
+	Metadata string `json:"metadata" msgpack:"metadata"`
+
+	// Explain asks for the execution statistics of the query in the Result
+	Explain bool `json:"explain" msgpack:"explain"`
+
+	// RegisterContinuousQuery registers the continuous query, instead of running a query
+	RegisterContinuousQuery *ContinuousQuery `json:"register_continuous_query" msgpack:"register_continuous_query"`
+
+	// ListContinuousQueries returns the registered continuous queries, instead of running a query
+	ListContinuousQueries bool `json:"list_continuous_queries" msgpack:"list_continuous_queries"`
+
+	// RegisterCounters adds or updates the counters, instead of running a query
+	RegisterCounters []CounterMetadata `json:"register_counters" msgpack:"register_counters"`
+
+	// ReloadCounters registers the counters of the config's counters file again, instead of running a query
+	ReloadCounters bool `json:"reload_counters" msgpack:"reload_counters"`
 }
 
 type Result struct {
@@ -29,22 +99,61 @@ type Result struct {
 
 	Data map[uint32][]DataPoint `json:"data" msgpack:"data"`
 
+	Ranking []RankedObject `json:"ranking" msgpack:"ranking"`
+
+	Series map[string]map[uint32][]DataPoint `json:"series" msgpack:"series"`
+
+	// Instances describes the keys of the instance series found in Data, Series, Ranking and the string modes
+	Instances map[uint32]SeriesInstance `json:"instances,omitempty" msgpack:"instances,omitempty"`
+
+	// ObjectIdentifiers gives the identifier of the objectIds of the result assigned by the object registry, the other
+	// objectIds are IPv4 addresses
+	ObjectIdentifiers map[uint32]string `json:"object_identifiers,omitempty" msgpack:"object_identifiers,omitempty"`
+
+	TotalObjects uint32 `json:"total_objects" msgpack:"total_objects"`
+
+	NextOffset uint32 `json:"next_offset" msgpack:"next_offset"`
+
 	Error string `json:"error" msgpack:"error"`
+
+	Stats *QueryStats `json:"stats,omitempty" msgpack:"stats,omitempty"`
+
+	ContinuousQueries []ContinuousQuery `json:"continuous_queries,omitempty" msgpack:"continuous_queries,omitempty"`
+
+	DistinctValues map[uint32][]DistinctValue `json:"distinct_values,omitempty" msgpack:"distinct_values,omitempty"`
+
+	Changes map[uint32][]ValueChange `json:"changes,omitempty" msgpack:"changes,omitempty"`
+
+	Objects []uint32 `json:"objects,omitempty" msgpack:"objects,omitempty"`
+
+	SeriesMetadata []SeriesMetadata `json:"series_metadata,omitempty" msgpack:"series_metadata,omitempty"`
+
+	Counters []CounterMetadata `json:"counters,omitempty" msgpack:"counters,omitempty"`
+
+	Sequence uint32 `json:"sequence" msgpack:"sequence"`
+
+	Final bool `json:"final" msgpack:"final"`
+
+	// Partial chunks of a streamed result are followed by more, see ResultStream
+	Partial bool `json:"-" msgpack:"-"`
 }
 
-func InitQueryEngine(queryReceiveChannel <-chan Query, queryResultChannel chan<- Result, storagePool *StoragePool, shutdownWaitGroup *sync.WaitGroup) {
+func InitQueryEngine(queryReceiveChannel <-chan Query, queryResultChannel chan<- Result, storagePool *StoragePool, activeQueries *ActiveQueries, continuousQueries *ContinuousQueries, latestValues *LatestValues, shutdownWaitGroup *sync.WaitGroup) {
 
 	defer shutdownWaitGroup.Done()
 
+	// Admission is shared by all the parsers
+	admissionQueue := NewAdmissionQueue(storagePool.Config, storagePool.Config.QueryParsers)
+
 	// Spawn Query Parsers
 
 	var parsersWaitGroup sync.WaitGroup
 
-	parsersWaitGroup.Add(QueryParsers)
+	parsersWaitGroup.Add(storagePool.Config.QueryParsers)
 
-	for range QueryParsers {
+	for range storagePool.Config.QueryParsers {
 
-		go Parser(queryReceiveChannel, queryResultChannel, storagePool, &parsersWaitGroup)
+		go Parser(queryReceiveChannel, queryResultChannel, storagePool, activeQueries, continuousQueries, latestValues, admissionQueue, &parsersWaitGroup)
 
 	}
 
@@ -2,6 +2,7 @@ package query
 
 import (
 	"datastore/containers"
+	"datastore/continuous"
 	"datastore/utils"
 	"fmt"
 	"sync"
@@ -10,21 +11,37 @@ import (
 
 func TestQueryEngine(t *testing.T) {
 
-	_ = utils.LoadConfig()
+	config, err := utils.LoadConfig()
 
-	_ = utils.InitLogger()
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	_ = utils.InitLogger(config)
 
 	queryReceiveChannel := make(chan Query, 10)
 
 	queryResultChannel := make(chan Result, 10)
 
-	storagePool := containers.InitStoragePool()
+	storagePool, err := containers.InitStoragePool(config)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	continuousQueries, _ := continuous.LoadContinuousQueries(storagePool)
+
+	latestValues, _ := containers.LoadLatestValues(config)
 
 	var shutdownWaitGroup sync.WaitGroup
 
 	shutdownWaitGroup.Add(1)
 
-	go InitQueryEngine(queryReceiveChannel, queryResultChannel, storagePool, &shutdownWaitGroup)
+	go InitQueryEngine(queryReceiveChannel, queryResultChannel, storagePool, NewActiveQueries(config), continuousQueries, latestValues, &shutdownWaitGroup)
 
 	query := Query{
 		QueryId:               10,
@@ -47,21 +64,37 @@ func TestQueryEngine(t *testing.T) {
 
 func TestQueryEngine2(t *testing.T) {
 
-	_ = utils.LoadConfig()
+	config, err := utils.LoadConfig()
 
-	_ = utils.InitLogger()
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	_ = utils.InitLogger(config)
 
 	queryReceiveChannel := make(chan Query, 10)
 
 	queryResultChannel := make(chan Result, 10)
 
-	storagePool := containers.InitStoragePool()
+	storagePool, err := containers.InitStoragePool(config)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	continuousQueries, _ := continuous.LoadContinuousQueries(storagePool)
+
+	latestValues, _ := containers.LoadLatestValues(config)
 
 	var shutdownWaitGroup sync.WaitGroup
 
 	shutdownWaitGroup.Add(1)
 
-	go InitQueryEngine(queryReceiveChannel, queryResultChannel, storagePool, &shutdownWaitGroup)
+	go InitQueryEngine(queryReceiveChannel, queryResultChannel, storagePool, NewActiveQueries(config), continuousQueries, latestValues, &shutdownWaitGroup)
 
 	query := Query{
 		QueryId:               1,
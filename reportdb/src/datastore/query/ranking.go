@@ -0,0 +1,150 @@
+package query
+
+import (
+	"context"
+	. "datastore/containers"
+	"errors"
+	"go.uber.org/zap"
+	"sort"
+)
+
+const (
+	RankOrderTop = "top"
+
+	RankOrderBottom = "bottom"
+)
+
+var (
+	ErrRankingNotSupported = errors.New("ranking not supported for string and enum counters")
+
+	ErrRankingWithObjectWiseAggregation = errors.New("ranking can not be combined with object wise aggregation")
+
+	ErrInvalidRankAggregation = errors.New("invalid rank aggregation, it must be either 'avg', 'sum', 'min', 'max', 'count', 'uptime' or a percentile like 'p99'")
+
+	ErrInvalidRankOrder = errors.New("invalid rank order, it must be either 'top' or 'bottom'")
+)
+
+type RankedObject struct {
+	ObjectId uint32 `json:"object_id" msgpack:"object_id"`
+
+	Value float64 `json:"value" msgpack:"value"`
+}
+
+func IsRankingQuery(query Query) bool {
+
+	return query.RankLimit > 0 && query.RankAggregation != "" && query.RankAggregation != "none"
+
+}
+
+// RankObjects aggregates every object's points over the whole queried range and returns
+// the top or bottom rankLimit objects ordered by the aggregated value.
+func RankObjects(daysData []map[uint32][]DataPoint, aggregation string, order string, rankLimit uint32, queryTimeoutContext context.Context, logger *zap.Logger) []RankedObject {
+
+	objectWiseBatchedData := make(map[uint32][]interface{})
+
+	for _, day := range daysData {
+
+		select {
+
+		case <-queryTimeoutContext.Done():
+
+			return nil
+
+		default:
+
+			for objectId, points := range day {
+
+				for _, point := range points {
+
+					objectWiseBatchedData[objectId] = append(objectWiseBatchedData[objectId], point.Value)
+
+				}
+
+			}
+
+		}
+
+	}
+
+	ranking := make([]RankedObject, 0, len(objectWiseBatchedData))
+
+	for objectId, batch := range objectWiseBatchedData {
+
+		if len(batch) == 0 {
+
+			continue
+
+		}
+
+		value, ok := NumericValue(Aggregate(aggregation, batch, logger))
+
+		if !ok {
+
+			continue
+
+		}
+
+		ranking = append(ranking, RankedObject{
+
+			ObjectId: objectId,
+
+			Value: value,
+		})
+
+	}
+
+	// Sort by value, ties are broken by objectId to keep the ranking stable across runs
+	sort.Slice(ranking, func(i, j int) bool {
+
+		if ranking[i].Value == ranking[j].Value {
+
+			return ranking[i].ObjectId < ranking[j].ObjectId
+
+		}
+
+		if order == RankOrderBottom {
+
+			return ranking[i].Value < ranking[j].Value
+
+		}
+
+		return ranking[i].Value > ranking[j].Value
+
+	})
+
+	if uint32(len(ranking)) > rankLimit {
+
+		ranking = ranking[:rankLimit]
+
+	}
+
+	return ranking
+
+}
+
+// KeepRankedObjects drops every object which didn't make it into the ranking from the days data.
+func KeepRankedObjects(daysData []map[uint32][]DataPoint, ranking []RankedObject) {
+
+	rankedObjects := make(map[uint32]struct{}, len(ranking))
+
+	for _, rankedObject := range ranking {
+
+		rankedObjects[rankedObject.ObjectId] = struct{}{}
+
+	}
+
+	for _, day := range daysData {
+
+		for objectId := range day {
+
+			if _, ranked := rankedObjects[objectId]; !ranked {
+
+				delete(day, objectId)
+
+			}
+
+		}
+
+	}
+
+}
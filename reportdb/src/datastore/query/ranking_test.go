@@ -0,0 +1,63 @@
+package query
+
+import (
+	"context"
+	. "datastore/containers"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestRankObjects(t *testing.T) {
+
+	daysData := []map[uint32][]DataPoint{
+		{
+			1: {{Timestamp: 10, Value: 5.0}, {Timestamp: 20, Value: 7.0}},
+			2: {{Timestamp: 10, Value: 50.0}},
+			3: {{Timestamp: 10, Value: 1.0}},
+		},
+		nil,
+		{
+			2: {{Timestamp: 86410, Value: 10.0}},
+			3: {{Timestamp: 86410, Value: 2.0}},
+		},
+	}
+
+	ranking := RankObjects(daysData, "avg", RankOrderTop, 2, context.Background(), zap.NewNop())
+
+	if len(ranking) != 2 || ranking[0].ObjectId != 2 || ranking[1].ObjectId != 1 {
+
+		t.Fatalf("unexpected top ranking: %v", ranking)
+
+	}
+
+	if ranking[0].Value != 30 {
+
+		t.Errorf("expected average 30 for object 2, got %v", ranking[0].Value)
+
+	}
+
+	ranking = RankObjects(daysData, "max", RankOrderBottom, 1, context.Background(), zap.NewNop())
+
+	if len(ranking) != 1 || ranking[0].ObjectId != 3 {
+
+		t.Fatalf("unexpected bottom ranking: %v", ranking)
+
+	}
+
+	KeepRankedObjects(daysData, ranking)
+
+	for _, day := range daysData {
+
+		for objectId := range day {
+
+			if objectId != 3 {
+
+				t.Errorf("object %d should have been dropped", objectId)
+
+			}
+
+		}
+
+	}
+
+}
@@ -7,72 +7,131 @@ import (
 	. "datastore/utils"
 	"errors"
 	"go.uber.org/zap"
+	"slices"
 	"sync"
 )
 
 type ReaderRequest struct {
+	BatchId uint64
+
 	RequestIndex int
 
 	StorageKey StoragePoolKey
 
-	From uint32
+	// From and To are in the precision of the query, the dataPoints of the response too
+	From uint64
+
+	To uint64
 
-	To uint32
+	Precision string
 
 	ObjectIds []uint32
 
+	Instances []string
+
+	// Keys of the query's instance series
+	InstanceKeys *InstanceKeys
+
 	TimeoutContext context.Context
 }
 
 type ReaderResponse struct {
+	BatchId uint64
+
 	RequestIndex int
 
 	Data map[uint32][]DataPoint
 
 	Error error
+
+	Stats QueryStats
 }
 
 func Reader(readerRequestChannel <-chan ReaderRequest, readerResponseChannel chan ReaderResponse, storagePool *StoragePool, readersWaitGroup *sync.WaitGroup) {
 
 	defer readersWaitGroup.Done()
 
+	config := storagePool.Config
+
 	for request := range readerRequestChannel {
 
+		if err := request.TimeoutContext.Err(); err != nil {
+
+			// Query cancelled or timed out while the request was queued, skip reading the day
+			readerResponseChannel <- ReaderResponse{
+
+				request.BatchId,
+
+				request.RequestIndex,
+
+				nil,
+
+				err,
+
+				QueryStats{},
+			}
+
+			continue
+
+		}
+
+		var stats QueryStats
+
+		if storagePool.IsPooled(request.StorageKey) {
+
+			stats.StoragesPooled++
+
+		} else {
+
+			stats.StoragesLoaded++
+
+		}
+
 		storageEngine, err := storagePool.GetStorage(request.StorageKey, false)
 
 		if err != nil {
 
 			if errors.Is(err, ErrStorageDoesNotExist) {
 
-				Logger.Info("Storage not present for", zap.Any("storageKey", request.StorageKey))
+				config.Logger.Info("Storage not present for", zap.Any("storageKey", request.StorageKey))
+
+				stats = QueryStats{StoragesMissing: 1}
 
 			}
 
 			// send response with empty data
 			readerResponseChannel <- ReaderResponse{
 
+				request.BatchId,
+
 				request.RequestIndex,
 
 				nil,
 
 				err,
+
+				stats,
 			}
 
 			continue
 
 		}
 
-		data, err := readSingleDay(storageEngine, request.StorageKey, request.ObjectIds, request.From, request.To)
+		data, err := readSingleDay(storagePool, storageEngine, request.StorageKey, request.ObjectIds, request.Instances, request.InstanceKeys, request.From, request.To, request.Precision, request.TimeoutContext, &stats)
 
 		if err != nil {
 
 			readerResponseChannel <- ReaderResponse{
 
+				request.BatchId,
+
 				request.RequestIndex,
 
 				nil,
 
 				err,
+
+				stats,
 			}
 
 		} else {
@@ -81,11 +140,15 @@ func Reader(readerRequestChannel <-chan ReaderRequest, readerResponseChannel cha
 
 			readerResponseChannel <- ReaderResponse{
 
+				request.BatchId,
+
 				request.RequestIndex,
 
 				data,
 
 				nil,
+
+				stats,
 			}
 
 		}
@@ -94,78 +157,104 @@ func Reader(readerRequestChannel <-chan ReaderRequest, readerResponseChannel cha
 
 	// channel closed, shutdown is called
 
-	Logger.Info("Reader exiting.")
+	config.Logger.Info("Reader exiting.")
 
 }
 
-func readSingleDay(storageEngine *Storage, storageKey StoragePoolKey, objectIds []uint32, from uint32, to uint32) (map[uint32][]DataPoint, error) {
+func readSingleDay(storagePool *StoragePool, storageEngine *Storage, storageKey StoragePoolKey, objectIds []uint32, instances []string, instanceKeys *InstanceKeys, from uint64, to uint64, precision string, queryTimeoutContext context.Context, stats *QueryStats) (map[uint32][]DataPoint, error) {
 
-	if len(objectIds) == 0 {
+	seriesKeys, err := daySeriesKeys(storageEngine, objectIds, instances)
 
-		// No objectIds mentioned, hence get all objectIds
+	if err != nil {
 
-		var err error
+		storagePool.Config.Logger.Error("Error getting all storage keys", zap.Error(err))
 
-		objectIds, err = storageEngine.GetAllKeys()
+		return nil, err
 
-		if err != nil {
+	}
 
-			Logger.Error("Error getting all storage keys", zap.Error(err))
+	finalDataPoints := make(map[uint32][]DataPoint)
 
-			return nil, err
+	dataType, _ := storagePool.Config.CounterDataType(storageKey.CounterId)
 
-		}
+	// Cached dataPoints keep the precision of the day
+	dayPrecision := storageEngine.Precision()
 
-	}
+	for _, seriesKey := range seriesKeys {
 
-	finalDataPoints := make(map[uint32][]DataPoint)
+		if err := queryTimeoutContext.Err(); err != nil {
 
-	for _, objectId := range objectIds {
+			return nil, err
+
+		}
 
 		var dataPoints []DataPoint
 
-		data, hit := DataPointsCache.Get(CreateCacheKey(storageKey, objectId))
+		data, hit := storagePool.Caches.DataPoints.Get(CreateCacheKey(storageKey, seriesKey))
 
 		if !hit {
 
-			data, err := storageEngine.Get(objectId)
+			stats.CacheMisses++
+
+			storagePool.Config.Metrics.CacheMisses.Add(1)
+
+			data, err := storageEngine.Get(seriesKey)
 
 			if err != nil {
 
-				Logger.Info("Error getting dataPoint ", zap.Uint32("ObjectId", objectId), zap.String("Date", storageKey.Date.Format()), zap.Error(err))
+				storagePool.Config.Logger.Info("Error getting dataPoint ", zap.Uint32("SeriesKey", seriesKey), zap.String("Date", storageKey.Date.Format()), zap.Error(err))
 
 				continue
 
 			}
 
-			dataPoints, err = DeserializeBatch(data, CounterConfig[storageKey.CounterId][DataType].(string))
+			stats.BytesRead += uint64(len(data))
+
+			dataPoints, err = DeserializeStorageBatch(data, storageEngine, dataType, dayPrecision)
 
 			if err != nil {
 
-				Logger.Info("Error deserializing dataPoint for objectId: ", zap.Uint32("ObjectId", objectId), zap.String("Date", storageKey.Date.Format()), zap.Error(err))
+				storagePool.Config.Logger.Info("Error deserializing dataPoint for objectId: ", zap.Uint32("SeriesKey", seriesKey), zap.String("Date", storageKey.Date.Format()), zap.Error(err))
 
 				continue
 
 			}
 
-			if success := DataPointsCache.Set(CreateCacheKey(storageKey, objectId), dataPoints, 0); !success {
+			stats.PointsDecoded += uint64(len(dataPoints))
+
+			if success := storagePool.Caches.DataPoints.Set(CreateCacheKey(storageKey, seriesKey), dataPoints, 0); !success {
 
-				Logger.Info("Fail to set cache for:", zap.Uint32("ObjectId", objectId), zap.String("Date", storageKey.Date.Format()))
+				storagePool.Config.Logger.Info("Fail to set cache for:", zap.Uint32("SeriesKey", seriesKey), zap.String("Date", storageKey.Date.Format()))
 
 			}
 
 		} else {
 
-			Logger.Debug("Cache hit for:", zap.Uint32("ObjectId", objectId), zap.String("Date", storageKey.Date.Format()))
+			storagePool.Config.Logger.Debug("Cache hit for:", zap.Uint32("SeriesKey", seriesKey), zap.String("Date", storageKey.Date.Format()))
+
+			stats.CacheHits++
+
+			storagePool.Config.Metrics.CacheHits.Add(1)
 
 			dataPoints = data.([]DataPoint)
 
 		}
 
+		// Instance series are reported by their key over all the days
+		objectId := seriesKey
+
+		if series, ok := storageEngine.Instance(seriesKey); ok {
+
+			objectId = instanceKeys.Key(series.ObjectId, series.Instance)
+
+		}
+
 		// Append dataPoints if they lie between from and to
 
 		for _, dataPoint := range dataPoints {
 
+			dataPoint.Timestamp = ConvertTimestamp(dataPoint.Timestamp, dayPrecision, precision)
+
 			if dataPoint.Timestamp >= from && dataPoint.Timestamp <= to {
 
 				finalDataPoints[objectId] = append(finalDataPoints[objectId], dataPoint)
@@ -178,3 +267,51 @@ func readSingleDay(storageEngine *Storage, storageKey StoragePoolKey, objectIds
 
 	return finalDataPoints, nil
 }
+
+// daySeriesKeys returns the storage keys of the objects' series and of their instances, of all the objects when
+// objectIds is empty. With instances, only the series of these instances are kept, "" standing for the objects' own.
+func daySeriesKeys(storageEngine *Storage, objectIds []uint32, instances []string) ([]uint32, error) {
+
+	var seriesKeys []uint32
+
+	if len(objectIds) == 0 {
+
+		var err error
+
+		if seriesKeys, err = storageEngine.GetAllKeys(); err != nil {
+
+			return nil, err
+
+		}
+
+	}
+
+	for _, objectId := range objectIds {
+
+		seriesKeys = append(append(seriesKeys, objectId), storageEngine.ObjectInstanceKeys(objectId)...)
+
+	}
+
+	if len(instances) == 0 {
+
+		return seriesKeys, nil
+
+	}
+
+	selectedKeys := seriesKeys[:0]
+
+	for _, seriesKey := range seriesKeys {
+
+		series, _ := storageEngine.Instance(seriesKey)
+
+		if slices.Contains(instances, series.Instance) {
+
+			selectedKeys = append(selectedKeys, seriesKey)
+
+		}
+
+	}
+
+	return selectedKeys, nil
+
+}
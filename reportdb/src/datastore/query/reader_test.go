@@ -1,6 +1,7 @@
 package query
 
 import (
+	"context"
 	. "datastore/containers"
 	"datastore/utils"
 	"fmt"
@@ -10,11 +11,11 @@ import (
 
 func TestReader(t *testing.T) {
 
-	err := utils.LoadConfig()
+	config, err := utils.LoadConfig()
 
 	if err != nil {
 
-		t.Error(err)
+		t.Fatal(err)
 
 	}
 
@@ -22,7 +23,13 @@ func TestReader(t *testing.T) {
 
 	readerResponseChannel := make(chan ReaderResponse, 10)
 
-	storagePool := InitStoragePool()
+	storagePool, err := InitStoragePool(config)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
 
 	var readersWaitGroup sync.WaitGroup
 
@@ -30,9 +37,9 @@ func TestReader(t *testing.T) {
 
 	go Reader(readerRequestChannel, readerResponseChannel, storagePool, &readersWaitGroup)
 
-	from := uint32(1747107000)
+	from := uint64(1747107000)
 
-	to := uint32(1747146600)
+	to := uint64(1747146600)
 
 	for requestIndex := range 10 {
 		request := ReaderRequest{
@@ -45,6 +52,8 @@ func TestReader(t *testing.T) {
 			To:   to,
 			//ObjectIds: []uint32{169093219, 169093224, 2130706433},
 			ObjectIds: []uint32{2886731972},
+
+			TimeoutContext: context.Background(),
 		}
 
 		readerRequestChannel <- request
@@ -0,0 +1,159 @@
+package query
+
+import (
+	. "datastore/containers"
+	. "datastore/storage"
+	. "datastore/utils"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Approximate in-memory size of a dataPoint, used as the cache cost
+const dataPointCost = 32
+
+// daySignature identifies everything a single day's partial result depends on, apart from the storage itself:
+// the queried range within the day and its precision, the counter's precision, the objects and the aggregations.
+func daySignature(query Query, counterPrecision string, dayFrom uint64, dayTo uint64) string {
+
+	objectIds := slices.Clone(query.ObjectIds)
+
+	slices.Sort(objectIds)
+
+	objectIds = slices.Compact(objectIds)
+
+	instances := slices.Clone(query.Instances)
+
+	slices.Sort(instances)
+
+	instances = slices.Compact(instances)
+
+	var signature strings.Builder
+
+	signature.WriteString(strconv.FormatUint(dayFrom, 10))
+
+	signature.WriteByte('-')
+
+	signature.WriteString(strconv.FormatUint(dayTo, 10))
+
+	signature.WriteByte('/')
+
+	signature.WriteString(NormalizePrecision(query.Precision))
+
+	signature.WriteByte('/')
+
+	signature.WriteString(NormalizePrecision(counterPrecision))
+
+	signature.WriteByte('/')
+
+	signature.WriteString(query.ObjectWiseAggregation)
+
+	signature.WriteByte('/')
+
+	signature.WriteString(query.InstanceAggregation)
+
+	signature.WriteByte('/')
+
+	for _, instance := range instances {
+
+		signature.WriteString(strconv.Quote(instance))
+
+		signature.WriteByte(',')
+
+	}
+
+	signature.WriteByte('/')
+
+	for _, objectId := range objectIds {
+
+		signature.WriteString(strconv.FormatUint(uint64(objectId), 10))
+
+		signature.WriteByte(',')
+
+	}
+
+	return signature.String()
+
+}
+
+// cachedDay is a day's partial result, along with the instance series of its keys. Instance keys are scoped to the
+// query setting the day, they are keyed again for the queries getting it.
+type cachedDay struct {
+	data map[uint32][]DataPoint
+
+	instances map[uint32]SeriesInstance
+}
+
+func getCachedDay(caches *Caches, cacheKey string, instanceKeys *InstanceKeys) (map[uint32][]DataPoint, bool) {
+
+	entry, hit := caches.QueryResults.Get(cacheKey)
+
+	if !hit {
+
+		return nil, false
+
+	}
+
+	cached := entry.(cachedDay)
+
+	day := make(map[uint32][]DataPoint, len(cached.data))
+
+	for seriesKey, points := range cached.data {
+
+		if series, ok := cached.instances[seriesKey]; ok {
+
+			seriesKey = instanceKeys.Key(series.ObjectId, series.Instance)
+
+		}
+
+		day[seriesKey] = points
+
+	}
+
+	return day, true
+
+}
+
+func setCachedDay(caches *Caches, cacheKey string, day map[uint32][]DataPoint, instanceKeys *InstanceKeys) {
+
+	cost := int64(0)
+
+	cached := cachedDay{data: copyDay(day)}
+
+	for seriesKey, points := range day {
+
+		cost += int64(len(points)) * dataPointCost
+
+		if seriesKey >= InstanceKeyBase {
+
+			if cached.instances == nil {
+
+				cached.instances = make(map[uint32]SeriesInstance)
+
+			}
+
+			cached.instances[seriesKey] = instanceKeys.Lookup(seriesKey)
+
+		}
+
+	}
+
+	caches.QueryResults.Set(cacheKey, cached, max(cost, 1))
+
+}
+
+// copyDay copies the day's map, later stages of the query filter objects in place and must not alter the cached day.
+// The points are never modified, so they are shared.
+func copyDay(day map[uint32][]DataPoint) map[uint32][]DataPoint {
+
+	dayCopy := make(map[uint32][]DataPoint, len(day))
+
+	for objectId, points := range day {
+
+		dayCopy[objectId] = points
+
+	}
+
+	return dayCopy
+
+}
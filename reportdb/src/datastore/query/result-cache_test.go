@@ -0,0 +1,97 @@
+package query
+
+import (
+	. "datastore/containers"
+	. "datastore/utils"
+	"testing"
+)
+
+func TestDaySignature(t *testing.T) {
+
+	first := daySignature(Query{ObjectIds: []uint32{3, 1, 3}, ObjectWiseAggregation: "avg"}, PrecisionSeconds, 0, 86399)
+
+	second := daySignature(Query{ObjectIds: []uint32{1, 3}, ObjectWiseAggregation: "avg"}, PrecisionSeconds, 0, 86399)
+
+	if first != second {
+
+		t.Errorf("equivalent queries have different signatures %q, %q", first, second)
+
+	}
+
+	if first == daySignature(Query{ObjectIds: []uint32{1, 3}, ObjectWiseAggregation: "sum"}, PrecisionSeconds, 0, 86399) {
+
+		t.Errorf("aggregation is not part of the signature")
+
+	}
+
+	if first == daySignature(Query{ObjectIds: []uint32{1, 3}, ObjectWiseAggregation: "avg"}, PrecisionMilliseconds, 0, 86399) {
+
+		t.Errorf("counter precision is not part of the signature")
+
+	}
+
+	if first == daySignature(Query{ObjectIds: []uint32{1, 3}, ObjectWiseAggregation: "avg", Precision: PrecisionMilliseconds}, PrecisionSeconds, 0, 86399) {
+
+		t.Errorf("query precision is not part of the signature")
+
+	}
+
+}
+
+func TestQueryResultCache(t *testing.T) {
+
+	config := DefaultConfig()
+
+	config.MaxCacheKeys, config.MaxCacheSizeInMB, config.MaxQueryCacheKeys, config.MaxQueryCacheSizeInMB = 1000, 1, 1000, 1
+
+	caches, err := NewCaches(config)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer caches.Close()
+
+	storageKey := StoragePoolKey{Date: UnixToDate(uint32(0)), CounterId: 1}
+
+	cacheKey := caches.CreateQueryResultCacheKey(storageKey, "signature")
+
+	instanceKeys := NewInstanceKeys()
+
+	setCachedDay(caches, cacheKey, map[uint32][]DataPoint{1: testPoints(2), 2: testPoints(1), instanceKeys.Key(1, "eth0"): testPoints(1)}, instanceKeys)
+
+	caches.QueryResults.Wait()
+
+	// Instance keys are scoped to the query, the cached instance series get the keys of the query getting them
+	instanceKeys = NewInstanceKeys()
+
+	instanceKeys.Key(2, "eth1")
+
+	day, hit := getCachedDay(caches, cacheKey, instanceKeys)
+
+	if !hit || len(day) != 3 || len(day[instanceKeys.Key(1, "eth0")]) != 1 {
+
+		t.Fatalf("expected a cache hit, got %v", day)
+
+	}
+
+	// Filtering the returned day must not alter the cached one
+	delete(day, 1)
+
+	if day, _ = getCachedDay(caches, cacheKey, instanceKeys); len(day) != 3 {
+
+		t.Errorf("cached day altered %v", day)
+
+	}
+
+	caches.InvalidateQueryResults(storageKey)
+
+	if _, hit = getCachedDay(caches, caches.CreateQueryResultCacheKey(storageKey, "signature"), instanceKeys); hit {
+
+		t.Errorf("cache hit after the storage was written")
+
+	}
+
+}
@@ -0,0 +1,501 @@
+package query
+
+import (
+	. "datastore/containers"
+	. "datastore/storage"
+	"sort"
+)
+
+// PaginateResult keeps only the objects in [offset, offset+limit) of the result, objects being ordered by objectId.
+// For multi-counter results, the objects are paginated across all the series together.
+func PaginateResult(result *Result, limit uint32, offset uint32) {
+
+	if limit == 0 && offset == 0 {
+
+		return
+
+	}
+
+	objects := make(map[uint32]struct{})
+
+	for objectId := range result.Data {
+
+		objects[objectId] = struct{}{}
+
+	}
+
+	for _, data := range result.Series {
+
+		for objectId := range data {
+
+			objects[objectId] = struct{}{}
+
+		}
+
+	}
+
+	for objectId := range result.DistinctValues {
+
+		objects[objectId] = struct{}{}
+
+	}
+
+	for objectId := range result.Changes {
+
+		objects[objectId] = struct{}{}
+
+	}
+
+	objectIds := sortedObjectIds(objects)
+
+	result.TotalObjects = uint32(len(objectIds))
+
+	start := min(offset, result.TotalObjects)
+
+	end := result.TotalObjects
+
+	if limit > 0 && start+limit < end {
+
+		end = start + limit
+
+		result.NextOffset = end
+
+	}
+
+	pageObjects := make(map[uint32]struct{}, end-start)
+
+	for _, objectId := range objectIds[start:end] {
+
+		pageObjects[objectId] = struct{}{}
+
+	}
+
+	keepObjects(result.Data, pageObjects)
+
+	for _, data := range result.Series {
+
+		keepObjects(data, pageObjects)
+
+	}
+
+	keepObjects(result.DistinctValues, pageObjects)
+
+	keepObjects(result.Changes, pageObjects)
+
+}
+
+// ResultStream sends a result in chunks of at most chunkSize dataPoints each, as the series of its objects are added,
+// so that the whole result is never held in memory. An object's series may be split across consecutive chunks. Every
+// chunk but the last is Partial, the last one carrying the ranking, pagination, error, stats and metadata of the result.
+type ResultStream struct {
+	chunk Result
+
+	chunkPoints int
+
+	chunkSize int
+
+	// Objects are paginated as they are added, in the order of their objectIds
+	limit, offset uint32
+
+	objects uint32
+
+	send func(chunk Result)
+
+	// describe completes every chunk before it is sent, like with the instances of its series keys
+	describe func(chunk *Result)
+}
+
+func NewResultStream(queryId uint64, chunkSize int, limit uint32, offset uint32, send func(chunk Result), describe func(chunk *Result)) *ResultStream {
+
+	return &ResultStream{
+
+		chunk: Result{QueryId: queryId},
+
+		chunkSize: chunkSize,
+
+		limit: limit,
+
+		offset: offset,
+
+		send: send,
+
+		describe: describe,
+	}
+
+}
+
+// AddData adds the dataPoints of an object, objects must be added in the order of their objectIds.
+func (stream *ResultStream) AddData(objectId uint32, points []DataPoint) {
+
+	if !stream.paginate() {
+
+		return
+
+	}
+
+	addChunkPoints(stream, points, func(chunk *Result, points []DataPoint) {
+
+		if chunk.Data == nil {
+
+			chunk.Data = make(map[uint32][]DataPoint)
+
+		}
+
+		chunk.Data[objectId] = append(chunk.Data[objectId], points...)
+
+	})
+
+}
+
+// AddResult adds the series, distinct values and changes of the result's objects, in the order of their objectIds.
+// The objects must come after those already added.
+func (stream *ResultStream) AddResult(result *Result) {
+
+	objects := make(map[uint32]struct{})
+
+	for objectId := range result.Data {
+
+		objects[objectId] = struct{}{}
+
+	}
+
+	names := make([]string, 0, len(result.Series))
+
+	for name, data := range result.Series {
+
+		names = append(names, name)
+
+		for objectId := range data {
+
+			objects[objectId] = struct{}{}
+
+		}
+
+	}
+
+	sort.Strings(names)
+
+	for objectId := range result.DistinctValues {
+
+		objects[objectId] = struct{}{}
+
+	}
+
+	for objectId := range result.Changes {
+
+		objects[objectId] = struct{}{}
+
+	}
+
+	for _, objectId := range sortedObjectIds(objects) {
+
+		if !stream.paginate() {
+
+			continue
+
+		}
+
+		if points, ok := result.Data[objectId]; ok {
+
+			addChunkPoints(stream, points, func(chunk *Result, points []DataPoint) {
+
+				if chunk.Data == nil {
+
+					chunk.Data = make(map[uint32][]DataPoint)
+
+				}
+
+				chunk.Data[objectId] = append(chunk.Data[objectId], points...)
+
+			})
+
+		}
+
+		for _, name := range names {
+
+			points, ok := result.Series[name][objectId]
+
+			if !ok {
+
+				continue
+
+			}
+
+			addChunkPoints(stream, points, func(chunk *Result, points []DataPoint) {
+
+				if chunk.Series == nil {
+
+					chunk.Series = make(map[string]map[uint32][]DataPoint)
+
+				}
+
+				if chunk.Series[name] == nil {
+
+					chunk.Series[name] = make(map[uint32][]DataPoint)
+
+				}
+
+				chunk.Series[name][objectId] = append(chunk.Series[name][objectId], points...)
+
+			})
+
+		}
+
+		if values, ok := result.DistinctValues[objectId]; ok {
+
+			addChunkPoints(stream, values, func(chunk *Result, values []DistinctValue) {
+
+				if chunk.DistinctValues == nil {
+
+					chunk.DistinctValues = make(map[uint32][]DistinctValue)
+
+				}
+
+				chunk.DistinctValues[objectId] = append(chunk.DistinctValues[objectId], values...)
+
+			})
+
+		}
+
+		if changes, ok := result.Changes[objectId]; ok {
+
+			addChunkPoints(stream, changes, func(chunk *Result, changes []ValueChange) {
+
+				if chunk.Changes == nil {
+
+					chunk.Changes = make(map[uint32][]ValueChange)
+
+				}
+
+				chunk.Changes[objectId] = append(chunk.Changes[objectId], changes...)
+
+			})
+
+		}
+
+	}
+
+}
+
+// Close sends the last chunk, along with the ranking, error, stats and metadata of last.
+func (stream *ResultStream) Close(last Result) {
+
+	chunk := &stream.chunk
+
+	chunk.Ranking, chunk.Error, chunk.Stats = last.Ranking, last.Error, last.Stats
+
+	chunk.ContinuousQueries, chunk.Objects, chunk.SeriesMetadata, chunk.Counters = last.ContinuousQueries, last.Objects, last.SeriesMetadata, last.Counters
+
+	if stream.limit > 0 || stream.offset > 0 {
+
+		chunk.TotalObjects = stream.objects
+
+		if stream.limit > 0 && stream.offset+stream.limit < stream.objects {
+
+			chunk.NextOffset = stream.offset + stream.limit
+
+		}
+
+	}
+
+	stream.describe(chunk)
+
+	stream.send(*chunk)
+
+}
+
+// paginate counts an added object, reporting whether it is in the requested page.
+func (stream *ResultStream) paginate() bool {
+
+	position := stream.objects
+
+	stream.objects++
+
+	return position >= stream.offset && (stream.limit == 0 || position < stream.offset+stream.limit)
+
+}
+
+func (stream *ResultStream) flush() {
+
+	stream.describe(&stream.chunk)
+
+	stream.chunk.Partial = true
+
+	stream.send(stream.chunk)
+
+	stream.chunk = Result{QueryId: stream.chunk.QueryId, Sequence: stream.chunk.Sequence + 1}
+
+	stream.chunkPoints = 0
+
+}
+
+// addChunkPoints adds the points to the chunks, sending every chunk once full.
+func addChunkPoints[T any](stream *ResultStream, points []T, add func(chunk *Result, points []T)) {
+
+	for {
+
+		if stream.chunkSize > 0 && stream.chunkPoints >= stream.chunkSize {
+
+			stream.flush()
+
+		}
+
+		writable := len(points)
+
+		if stream.chunkSize > 0 {
+
+			writable = min(writable, stream.chunkSize-stream.chunkPoints)
+
+		}
+
+		add(&stream.chunk, points[:writable])
+
+		stream.chunkPoints += writable
+
+		points = points[writable:]
+
+		if len(points) == 0 {
+
+			return
+
+		}
+
+	}
+
+}
+
+// AppendResultChunk merges the chunk of a streamed result into the result, chunks being appended in sequence order.
+func AppendResultChunk(result *Result, chunk Result) {
+
+	if chunk.Sequence == 0 {
+
+		*result = chunk
+
+		return
+
+	}
+
+	for objectId, points := range chunk.Data {
+
+		if result.Data == nil {
+
+			result.Data = make(map[uint32][]DataPoint)
+
+		}
+
+		result.Data[objectId] = append(result.Data[objectId], points...)
+
+	}
+
+	for name, data := range chunk.Series {
+
+		if result.Series == nil {
+
+			result.Series = make(map[string]map[uint32][]DataPoint)
+
+		}
+
+		if result.Series[name] == nil {
+
+			result.Series[name] = make(map[uint32][]DataPoint)
+
+		}
+
+		for objectId, points := range data {
+
+			result.Series[name][objectId] = append(result.Series[name][objectId], points...)
+
+		}
+
+	}
+
+	for objectId, values := range chunk.DistinctValues {
+
+		if result.DistinctValues == nil {
+
+			result.DistinctValues = make(map[uint32][]DistinctValue)
+
+		}
+
+		result.DistinctValues[objectId] = append(result.DistinctValues[objectId], values...)
+
+	}
+
+	for objectId, changes := range chunk.Changes {
+
+		if result.Changes == nil {
+
+			result.Changes = make(map[uint32][]ValueChange)
+
+		}
+
+		result.Changes[objectId] = append(result.Changes[objectId], changes...)
+
+	}
+
+	for seriesKey, series := range chunk.Instances {
+
+		if result.Instances == nil {
+
+			result.Instances = make(map[uint32]SeriesInstance)
+
+		}
+
+		result.Instances[seriesKey] = series
+
+	}
+
+	for objectId, object := range chunk.ObjectIdentifiers {
+
+		if result.ObjectIdentifiers == nil {
+
+			result.ObjectIdentifiers = make(map[uint32]string)
+
+		}
+
+		result.ObjectIdentifiers[objectId] = object
+
+	}
+
+	// Set by the last chunk only
+	result.Ranking, result.TotalObjects, result.NextOffset, result.Error, result.Stats = chunk.Ranking, chunk.TotalObjects, chunk.NextOffset, chunk.Error, chunk.Stats
+
+	result.ContinuousQueries, result.Objects, result.SeriesMetadata, result.Counters = chunk.ContinuousQueries, chunk.Objects, chunk.SeriesMetadata, chunk.Counters
+
+	result.Sequence, result.Final, result.Partial = chunk.Sequence, chunk.Final, chunk.Partial
+
+}
+
+func sortedObjectIds[V any](objects map[uint32]V) []uint32 {
+
+	objectIds := make([]uint32, 0, len(objects))
+
+	for objectId := range objects {
+
+		objectIds = append(objectIds, objectId)
+
+	}
+
+	sort.Slice(objectIds, func(i, j int) bool {
+
+		return objectIds[i] < objectIds[j]
+
+	})
+
+	return objectIds
+
+}
+
+func keepObjects[V any](data map[uint32]V, objects map[uint32]struct{}) {
+
+	for objectId := range data {
+
+		if _, ok := objects[objectId]; !ok {
+
+			delete(data, objectId)
+
+		}
+
+	}
+
+}
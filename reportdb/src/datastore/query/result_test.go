@@ -0,0 +1,146 @@
+package query
+
+import (
+	. "datastore/containers"
+	"testing"
+)
+
+func testPoints(count int) []DataPoint {
+
+	points := make([]DataPoint, count)
+
+	for index := range points {
+
+		points[index] = DataPoint{Timestamp: uint64(index), Value: float64(index)}
+
+	}
+
+	return points
+
+}
+
+func TestPaginateResult(t *testing.T) {
+
+	result := Result{
+		Data: map[uint32][]DataPoint{5: testPoints(1), 1: testPoints(1), 3: testPoints(1)},
+	}
+
+	PaginateResult(&result, 2, 1)
+
+	if result.TotalObjects != 3 || result.NextOffset != 0 || len(result.Data) != 2 || result.Data[1] != nil {
+
+		t.Errorf("unexpected last page %+v", result)
+
+	}
+
+	result = Result{
+		Data: map[uint32][]DataPoint{5: testPoints(1), 1: testPoints(1), 3: testPoints(1)},
+	}
+
+	PaginateResult(&result, 1, 0)
+
+	if result.NextOffset != 1 || len(result.Data) != 1 || result.Data[1] == nil {
+
+		t.Errorf("unexpected first page %+v", result)
+
+	}
+
+}
+
+func TestResultStream(t *testing.T) {
+
+	var chunks []Result
+
+	newStream := func(limit, offset uint32) *ResultStream {
+
+		chunks = nil
+
+		return NewResultStream(7, 3, limit, offset, func(chunk Result) {
+
+			chunks = append(chunks, chunk)
+
+		}, func(chunk *Result) {})
+
+	}
+
+	stream := newStream(0, 0)
+
+	stream.AddData(1, testPoints(5))
+
+	stream.AddData(2, testPoints(2))
+
+	stream.AddResult(&Result{
+		Series:         map[string]map[uint32][]DataPoint{"c1": {3: testPoints(4)}},
+		DistinctValues: map[uint32][]DistinctValue{4: {{Value: "up", Count: 2}}},
+	})
+
+	stream.Close(Result{Stats: &QueryStats{DaysScanned: 1}})
+
+	if len(chunks) != 4 {
+
+		t.Fatalf("expected 4 chunks, got %d", len(chunks))
+
+	}
+
+	if chunks[len(chunks)-1].Stats == nil || chunks[0].Stats != nil {
+
+		t.Errorf("stats expected in the last chunk only")
+
+	}
+
+	var result Result
+
+	for sequence, chunk := range chunks {
+
+		if chunk.QueryId != 7 || chunk.Sequence != uint32(sequence) || chunk.Partial != (sequence < len(chunks)-1) {
+
+			t.Errorf("unexpected chunk header %+v", chunk)
+
+		}
+
+		AppendResultChunk(&result, chunk)
+
+	}
+
+	if len(result.Data[1]) != 5 || len(result.Data[2]) != 2 || len(result.Series["c1"][3]) != 4 || len(result.DistinctValues[4]) != 1 {
+
+		t.Errorf("points lost while streaming %+v", result)
+
+	}
+
+	// Objects are paginated as they are added
+	stream = newStream(2, 1)
+
+	for objectId := range uint32(4) {
+
+		stream.AddData(objectId, testPoints(1))
+
+	}
+
+	stream.Close(Result{})
+
+	result = Result{}
+
+	for _, chunk := range chunks {
+
+		AppendResultChunk(&result, chunk)
+
+	}
+
+	if result.TotalObjects != 4 || result.NextOffset != 3 || len(result.Data) != 2 || result.Data[1] == nil || result.Data[2] == nil {
+
+		t.Errorf("unexpected page %+v", result)
+
+	}
+
+	stream = newStream(0, 0)
+
+	stream.Close(Result{Error: "query timed out"})
+
+	if len(chunks) != 1 || chunks[0].Partial || chunks[0].Error == "" {
+
+		t.Errorf("unexpected chunks for an error result %+v", chunks)
+
+	}
+
+}
@@ -0,0 +1,75 @@
+package query
+
+import (
+	. "datastore/utils"
+	"go.uber.org/zap"
+	"time"
+)
+
+// QueryStats describes how a query was executed. It is returned in the Result when the query asks for explain.
+type QueryStats struct {
+	// Day-storages the readers were asked for
+	DaysScanned uint32 `json:"days_scanned" msgpack:"days_scanned"`
+
+	// Day-storages opened from disk versus found open in the storage pool
+	StoragesLoaded uint32 `json:"storages_loaded" msgpack:"storages_loaded"`
+
+	StoragesPooled uint32 `json:"storages_pooled" msgpack:"storages_pooled"`
+
+	StoragesMissing uint32 `json:"storages_missing" msgpack:"storages_missing"`
+
+	// Days served by the query result cache
+	ResultCacheHits uint32 `json:"result_cache_hits" msgpack:"result_cache_hits"`
+
+	ResultCacheMisses uint32 `json:"result_cache_misses" msgpack:"result_cache_misses"`
+
+	// Objects served by the data points cache
+	CacheHits uint32 `json:"cache_hits" msgpack:"cache_hits"`
+
+	CacheMisses uint32 `json:"cache_misses" msgpack:"cache_misses"`
+
+	BytesRead uint64 `json:"bytes_read" msgpack:"bytes_read"`
+
+	PointsDecoded uint64 `json:"points_decoded" msgpack:"points_decoded"`
+
+	// Time spent per phase, in microseconds
+	ReadTime int64 `json:"read_time" msgpack:"read_time"`
+
+	ObjectWiseAggregationTime int64 `json:"object_wise_aggregation_time" msgpack:"object_wise_aggregation_time"`
+
+	TimestampAggregationTime int64 `json:"timestamp_aggregation_time" msgpack:"timestamp_aggregation_time"`
+
+	TotalTime int64 `json:"total_time" msgpack:"total_time"`
+}
+
+// add accumulates the reader's statistics of a single day.
+func (stats *QueryStats) add(dayStats QueryStats) {
+
+	stats.StoragesLoaded += dayStats.StoragesLoaded
+
+	stats.StoragesPooled += dayStats.StoragesPooled
+
+	stats.StoragesMissing += dayStats.StoragesMissing
+
+	stats.CacheHits += dayStats.CacheHits
+
+	stats.CacheMisses += dayStats.CacheMisses
+
+	stats.BytesRead += dayStats.BytesRead
+
+	stats.PointsDecoded += dayStats.PointsDecoded
+
+}
+
+// logSlowQuery records the query in the slow query log if it took longer than the configured threshold.
+func logSlowQuery(config *Config, query Query, stats QueryStats) {
+
+	if config.SlowQueryThresholdInMS <= 0 || time.Duration(stats.TotalTime)*time.Microsecond < time.Duration(config.SlowQueryThresholdInMS)*time.Millisecond {
+
+		return
+
+	}
+
+	config.SlowQueryLogger.Info("Slow query", zap.Any("query", query), zap.Any("stats", stats))
+
+}
@@ -0,0 +1,215 @@
+package query
+
+import (
+	. "datastore/containers"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// String counter query modes
+const (
+	// StringModeDistinct returns the distinct values of every object with their number of occurrences
+	StringModeDistinct = "distinct"
+
+	// StringModeChanges returns the value change events of every object
+	StringModeChanges = "changes"
+
+	// StringModeCurrent returns the last value of every object in the range
+	StringModeCurrent = "current"
+)
+
+var (
+	ErrInvalidStringMode = errors.New("invalid string mode, it must be either 'distinct', 'changes' or 'current'")
+
+	ErrStringModeNotSupported = errors.New("string modes are only supported for string and enum counters")
+
+	ErrStringModeWithMultipleCounters = errors.New("string modes are not supported with multiple counters")
+)
+
+type DistinctValue struct {
+	Value string `json:"value" msgpack:"value"`
+
+	Count uint32 `json:"count" msgpack:"count"`
+}
+
+type ValueChange struct {
+	Timestamp uint64 `json:"timestamp" msgpack:"timestamp"`
+
+	Old string `json:"old" msgpack:"old"`
+
+	New string `json:"new" msgpack:"new"`
+}
+
+func IsStringModeQuery(query Query) bool {
+
+	return query.StringMode != ""
+
+}
+
+func validateStringModeQuery(query Query, dataType string) error {
+
+	switch query.StringMode {
+
+	case StringModeDistinct, StringModeChanges, StringModeCurrent:
+
+	default:
+
+		return ErrInvalidStringMode
+
+	}
+
+	if aggregatable(dataType) {
+
+		return ErrStringModeNotSupported
+
+	}
+
+	if IsRankingQuery(query) {
+
+		return ErrRankingNotSupported
+
+	}
+
+	return nil
+
+}
+
+// applyStringMode computes the string mode of the query from the objects' drilldown.
+func applyStringMode(result *Result, stringMode string, data map[uint32][]DataPoint) {
+
+	switch stringMode {
+
+	case StringModeDistinct:
+
+		result.DistinctValues = make(map[uint32][]DistinctValue, len(data))
+
+		for objectId, points := range data {
+
+			result.DistinctValues[objectId] = distinctValues(points)
+
+		}
+
+	case StringModeChanges:
+
+		result.Changes = make(map[uint32][]ValueChange, len(data))
+
+		for objectId, points := range data {
+
+			if changes := valueChanges(points); len(changes) > 0 {
+
+				result.Changes[objectId] = changes
+
+			}
+
+		}
+
+	case StringModeCurrent:
+
+		result.Data = make(map[uint32][]DataPoint, len(data))
+
+		for objectId, points := range data {
+
+			if len(points) == 0 {
+
+				continue
+
+			}
+
+			current := points[0]
+
+			for _, point := range points[1:] {
+
+				if point.Timestamp >= current.Timestamp {
+
+					current = point
+
+				}
+
+			}
+
+			result.Data[objectId] = []DataPoint{current}
+
+		}
+
+	}
+
+}
+
+// distinctValues counts the occurrences of every value, the most frequent values first.
+func distinctValues(points []DataPoint) []DistinctValue {
+
+	counts := make(map[string]uint32)
+
+	for _, point := range points {
+
+		counts[stringValue(point.Value)]++
+
+	}
+
+	values := make([]DistinctValue, 0, len(counts))
+
+	for value, count := range counts {
+
+		values = append(values, DistinctValue{value, count})
+
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+
+		if values[i].Count != values[j].Count {
+
+			return values[i].Count > values[j].Count
+
+		}
+
+		return values[i].Value < values[j].Value
+
+	})
+
+	return values
+
+}
+
+// valueChanges returns an event for every point whose value differs from the previous point's, in timestamp order.
+func valueChanges(points []DataPoint) []ValueChange {
+
+	sortedPoints := make([]DataPoint, len(points))
+
+	copy(sortedPoints, points)
+
+	sort.SliceStable(sortedPoints, func(i, j int) bool {
+
+		return sortedPoints[i].Timestamp < sortedPoints[j].Timestamp
+
+	})
+
+	var changes []ValueChange
+
+	for index := 1; index < len(sortedPoints); index++ {
+
+		previous, current := stringValue(sortedPoints[index-1].Value), stringValue(sortedPoints[index].Value)
+
+		if previous != current {
+
+			changes = append(changes, ValueChange{sortedPoints[index].Timestamp, previous, current})
+
+		}
+
+	}
+
+	return changes
+
+}
+
+func stringValue(value interface{}) string {
+
+	if stringValue, ok := value.(string); ok {
+
+		return stringValue
+
+	}
+
+	return fmt.Sprint(value)
+
+}
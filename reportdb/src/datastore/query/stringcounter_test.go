@@ -0,0 +1,48 @@
+package query
+
+import (
+	. "datastore/containers"
+	"reflect"
+	"testing"
+)
+
+func TestStringModes(t *testing.T) {
+
+	data := map[uint32][]DataPoint{
+
+		1: {{Timestamp: 30, Value: "root"}, {Timestamp: 10, Value: "admin"}, {Timestamp: 20, Value: "admin"}, {Timestamp: 40, Value: "admin"}},
+	}
+
+	var result Result
+
+	applyStringMode(&result, StringModeDistinct, data)
+
+	if expected := []DistinctValue{{"admin", 3}, {"root", 1}}; !reflect.DeepEqual(result.DistinctValues[1], expected) {
+
+		t.Errorf("expected %v, got %v", expected, result.DistinctValues[1])
+
+	}
+
+	applyStringMode(&result, StringModeChanges, data)
+
+	if expected := []ValueChange{{30, "admin", "root"}, {40, "root", "admin"}}; !reflect.DeepEqual(result.Changes[1], expected) {
+
+		t.Errorf("expected %v, got %v", expected, result.Changes[1])
+
+	}
+
+	applyStringMode(&result, StringModeCurrent, data)
+
+	if current := result.Data[1]; len(current) != 1 || current[0].Timestamp != 40 {
+
+		t.Errorf("unexpected current value %v", current)
+
+	}
+
+	if err := validateStringModeQuery(Query{StringMode: StringModeDistinct}, "float64"); err != ErrStringModeNotSupported {
+
+		t.Errorf("expected %v, got %v", ErrStringModeNotSupported, err)
+
+	}
+
+}
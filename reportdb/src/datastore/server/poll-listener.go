@@ -2,7 +2,7 @@ package server
 
 import (
 	. "datastore/containers"
-	. "datastore/utils"
+	. "datastore/db"
 	"encoding/json"
 	"errors"
 	zmq "github.com/pebbe/zmq4"
@@ -11,17 +11,31 @@ import (
 	"sync"
 )
 
-func InitPollListener(dataChannel chan<- []PolledDataPoint, globalShutdown <-chan bool, globalShutdownWaitGroup *sync.WaitGroup) {
+// Status replied to every batch of poll data, along with the batch's id. Rejected batches may be sent again later, like
+// once disk space is freed, invalid ones never.
+const (
+	PollStatusAccepted = "accepted"
+
+	PollStatusRejected = "rejected"
+
+	PollStatusInvalid = "invalid"
+)
+
+// InitPollListener receives the batches of poll data on a ROUTER socket, each one made of its id and its dataPoints,
+// and replies the batch's status to its sender.
+func InitPollListener(reportDB *ReportDB, globalShutdown <-chan bool, globalShutdownWaitGroup *sync.WaitGroup) {
 
 	defer globalShutdownWaitGroup.Done()
 
-	defer Logger.Info("Poll Listener Exiting")
+	config := reportDB.Config()
+
+	defer config.Logger.Info("Poll Listener Exiting")
 
 	context, err := zmq.NewContext()
 
 	if err != nil {
 
-		Logger.Error("error initializing poll listener context:" + err.Error())
+		config.Logger.Error("error initializing poll listener context:" + err.Error())
 
 		return
 
@@ -29,7 +43,7 @@ func InitPollListener(dataChannel chan<- []PolledDataPoint, globalShutdown <-cha
 
 	shutDown := make(chan bool, 1)
 
-	go pollListener(context, dataChannel, shutDown)
+	go pollListener(context, reportDB, shutDown)
 
 	// Listen for global shutdown
 	<-globalShutdown
@@ -41,7 +55,7 @@ func InitPollListener(dataChannel chan<- []PolledDataPoint, globalShutdown <-cha
 
 	if err != nil {
 
-		Logger.Error("error terminating poll listener context:", zap.Error(err))
+		config.Logger.Error("error terminating poll listener context:", zap.Error(err))
 
 	}
 
@@ -50,9 +64,11 @@ func InitPollListener(dataChannel chan<- []PolledDataPoint, globalShutdown <-cha
 
 }
 
-func pollListener(context *zmq.Context, dataWriteChannel chan<- []PolledDataPoint, shutDown chan bool) {
+func pollListener(context *zmq.Context, reportDB *ReportDB, shutDown chan bool) {
+
+	config := reportDB.Config()
 
-	socket, err := context.NewSocket(zmq.PULL)
+	socket, err := context.NewSocket(zmq.ROUTER)
 
 	if err != nil {
 
@@ -60,7 +76,7 @@ func pollListener(context *zmq.Context, dataWriteChannel chan<- []PolledDataPoin
 
 	}
 
-	err = socket.Bind("tcp://*:" + PollListenerBindPort)
+	err = socket.Bind("tcp://*:" + config.PollListenerBindPort)
 
 	if err != nil {
 
@@ -76,7 +92,7 @@ func pollListener(context *zmq.Context, dataWriteChannel chan<- []PolledDataPoin
 
 			if err := socket.Close(); err != nil {
 
-				Logger.Error("error closing poll listener socket ", zap.Error(err))
+				config.Logger.Error("error closing poll listener socket ", zap.Error(err))
 
 			}
 
@@ -87,17 +103,17 @@ func pollListener(context *zmq.Context, dataWriteChannel chan<- []PolledDataPoin
 
 		default:
 
-			dataBytes, err := socket.RecvBytes(0)
+			frames, err := socket.RecvMessageBytes(0)
 
 			if err != nil {
 
 				if errors.Is(zmq.AsErrno(err), zmq.ETERM) {
 
-					Logger.Info("Poll listener ZMQ-Context terminated, closing the socket")
+					config.Logger.Info("Poll listener ZMQ-Context terminated, closing the socket")
 
 				} else {
 
-					Logger.Error("error receiving poll data", zap.Error(err))
+					config.Logger.Error("error receiving poll data", zap.Error(err))
 
 				}
 
@@ -105,19 +121,58 @@ func pollListener(context *zmq.Context, dataWriteChannel chan<- []PolledDataPoin
 
 			}
 
-			var dataPoints []PolledDataPoint
+			// The sender's identity, the batch id and the dataPoints
+			if len(frames) != 3 {
 
-			if err := json.Unmarshal(dataBytes, &dataPoints); err != nil {
-
-				Logger.Error("error unmarshalling poll data", zap.Error(err))
+				config.Logger.Error("malformed poll data message", zap.Int("frames", len(frames)))
 
 				continue
+
 			}
 
-			dataWriteChannel <- dataPoints
+			status := writePollData(reportDB, frames[2])
+
+			if _, err = socket.SendMessage(frames[0], frames[1], status); err != nil {
+
+				config.Logger.Error("error replying poll data status", zap.String("status", status), zap.Error(err))
+
+			}
 
 		}
 
 	}
 
 }
+
+// writePollData writes the batch's dataPoints, returning its status for the sender.
+func writePollData(reportDB *ReportDB, dataBytes []byte) string {
+
+	config := reportDB.Config()
+
+	var dataPoints []PolledDataPoint
+
+	if err := json.Unmarshal(dataBytes, &dataPoints); err != nil {
+
+		config.Logger.Error("error unmarshalling poll data", zap.Error(err))
+
+		return PollStatusInvalid
+
+	}
+
+	if err := reportDB.Write(dataPoints); errors.Is(err, ErrDiskFull) {
+
+		config.Logger.Warn("poll data rejected, disk space low", zap.Int("dataPoints", len(dataPoints)))
+
+		return PollStatusRejected
+
+	} else if err != nil {
+
+		config.Logger.Error("error writing poll data", zap.Error(err))
+
+		return PollStatusRejected
+
+	}
+
+	return PollStatusAccepted
+
+}
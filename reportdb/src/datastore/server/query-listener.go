@@ -1,8 +1,9 @@
 package server
 
 import (
+	"context"
+	. "datastore/db"
 	. "datastore/query"
-	. "datastore/utils"
 	"errors"
 	zmq "github.com/pebbe/zmq4"
 	"github.com/vmihailenco/msgpack/v5"
@@ -10,15 +11,17 @@ import (
 	"sync"
 )
 
-func InitQueryListener(queryReceiveChannel chan<- Query, globalShutdown <-chan bool, globalShutdownWaitGroup *sync.WaitGroup) {
+func InitQueryListener(reportDB *ReportDB, queryResultChannel chan<- Result, globalShutdown <-chan bool, globalShutdownWaitGroup *sync.WaitGroup) {
 
 	defer globalShutdownWaitGroup.Done()
 
-	context, err := zmq.NewContext()
+	config := reportDB.Config()
+
+	zmqContext, err := zmq.NewContext()
 
 	if err != nil {
 
-		Logger.Error("error initializing query listener context", zap.Error(err))
+		config.Logger.Error("error initializing query listener context", zap.Error(err))
 
 		return
 
@@ -26,7 +29,12 @@ func InitQueryListener(queryReceiveChannel chan<- Query, globalShutdown <-chan b
 
 	queryListenerShutdown := make(chan struct{}, 1)
 
-	go queryListener(context, queryReceiveChannel, queryListenerShutdown)
+	// Queries run concurrently, tracked by the client's QueryId for cancellation
+	activeQueries := NewActiveQueries(config)
+
+	var queriesWaitGroup sync.WaitGroup
+
+	go queryListener(zmqContext, reportDB, queryResultChannel, activeQueries, &queriesWaitGroup, queryListenerShutdown)
 
 	// Listen for global shutdown
 	<-globalShutdown
@@ -34,38 +42,43 @@ func InitQueryListener(queryReceiveChannel chan<- Query, globalShutdown <-chan b
 	// Send shutdown to socket
 	queryListenerShutdown <- struct{}{}
 
-	err = context.Term()
+	err = zmqContext.Term()
 
 	if err != nil {
 
-		Logger.Error("error terminating query listener context", zap.Error(err))
+		config.Logger.Error("error terminating query listener context", zap.Error(err))
 
 	}
 
 	// Wait for socket to close.
 	<-queryListenerShutdown
 
-	close(queryReceiveChannel)
+	// Wait for the results of the queries being processed
+	queriesWaitGroup.Wait()
+
+	close(queryResultChannel)
 
 }
 
-func queryListener(context *zmq.Context, queryReceiveChannel chan<- Query, queryListenerShutdown chan struct{}) {
+func queryListener(zmqContext *zmq.Context, reportDB *ReportDB, queryResultChannel chan<- Result, activeQueries *ActiveQueries, queriesWaitGroup *sync.WaitGroup, queryListenerShutdown chan struct{}) {
+
+	config := reportDB.Config()
 
-	socket, err := context.NewSocket(zmq.PULL)
+	socket, err := zmqContext.NewSocket(zmq.PULL)
 
 	if err != nil {
 
-		Logger.Error("Error initializing query listener socket", zap.Error(err))
+		config.Logger.Error("Error initializing query listener socket", zap.Error(err))
 
 		return
 
 	}
 
-	err = socket.Bind("tcp://*:" + QueryListenerBindPort)
+	err = socket.Bind("tcp://*:" + config.QueryListenerBindPort)
 
 	if err != nil {
 
-		Logger.Error("Error binding query listener socket", zap.String("port", QueryListenerBindPort), zap.Error(err))
+		config.Logger.Error("Error binding query listener socket", zap.String("port", config.QueryListenerBindPort), zap.Error(err))
 
 	}
 
@@ -78,7 +91,7 @@ func queryListener(context *zmq.Context, queryReceiveChannel chan<- Query, query
 
 			if err != nil {
 
-				Logger.Error("error closing query listener socket ", zap.Error(err))
+				config.Logger.Error("error closing query listener socket ", zap.Error(err))
 
 			}
 
@@ -95,11 +108,11 @@ func queryListener(context *zmq.Context, queryReceiveChannel chan<- Query, query
 
 				if errors.Is(zmq.AsErrno(err), zmq.ETERM) {
 
-					Logger.Info("Query Handler's ZMQ-Context terminated, closing the socket")
+					config.Logger.Info("Query Handler's ZMQ-Context terminated, closing the socket")
 
 				} else {
 
-					Logger.Error("error receiving query ", zap.Error(err))
+					config.Logger.Error("error receiving query ", zap.Error(err))
 
 				}
 
@@ -111,13 +124,71 @@ func queryListener(context *zmq.Context, queryReceiveChannel chan<- Query, query
 
 			if err = msgpack.Unmarshal(queryBytes, &query); err != nil {
 
-				Logger.Error("error unmarshalling query ", zap.Error(err))
+				config.Logger.Error("error unmarshalling query ", zap.Error(err))
+
+				continue
 
 			}
 
 			//Logger.Debug("Received query ", zap.Any("query", query))
 
-			queryReceiveChannel <- query
+			if query.Cancel {
+
+				// Cancellations are applied right away instead of being queued behind other queries
+				activeQueries.Cancel(query.QueryId, query.Timeout)
+
+				continue
+
+			}
+
+			queryContext, queryContextCancel := context.WithCancel(context.Background())
+
+			if !activeQueries.Register(query.QueryId, queryContextCancel) {
+
+				config.Logger.Info("Query cancelled before processing", zap.Uint64("queryId", query.QueryId))
+
+				queryContextCancel()
+
+				continue
+
+			}
+
+			queriesWaitGroup.Add(1)
+
+			go func(query Query) {
+
+				defer queriesWaitGroup.Done()
+
+				defer queryContextCancel()
+
+				// Chunks are forwarded to the sender as the engine streams them
+				final, sequence := false, uint32(0)
+
+				err := reportDB.QueryStream(queryContext, query, func(chunk Result) {
+
+					final, sequence = !chunk.Partial, chunk.Sequence+1
+
+					queryResultChannel <- chunk
+
+				})
+
+				activeQueries.Deregister(query.QueryId)
+
+				if errors.Is(err, context.Canceled) {
+
+					// Client is no longer waiting for the result
+					return
+
+				}
+
+				if err != nil && !final {
+
+					// Ends the chunks already forwarded
+					queryResultChannel <- Result{QueryId: query.QueryId, Sequence: sequence, Error: err.Error()}
+
+				}
+
+			}(query)
 
 		}
 
@@ -10,7 +10,7 @@ import (
 	"sync"
 )
 
-func InitQueryResultSender(queryResultChannel <-chan Result, globalShutdownWaitGroup *sync.WaitGroup) {
+func InitQueryResultSender(queryResultChannel <-chan Result, config *Config, globalShutdownWaitGroup *sync.WaitGroup) {
 
 	defer globalShutdownWaitGroup.Done()
 
@@ -18,7 +18,7 @@ func InitQueryResultSender(queryResultChannel <-chan Result, globalShutdownWaitG
 
 	if err != nil {
 
-		Logger.Error("error initializing query result sender context", zap.Error(err))
+		config.Logger.Error("error initializing query result sender context", zap.Error(err))
 
 		return
 
@@ -30,7 +30,7 @@ func InitQueryResultSender(queryResultChannel <-chan Result, globalShutdownWaitG
 
 		if err != nil {
 
-			Logger.Error("Error terminating the result sender zmq context")
+			config.Logger.Error("Error terminating the result sender zmq context")
 
 		}
 
@@ -40,7 +40,7 @@ func InitQueryResultSender(queryResultChannel <-chan Result, globalShutdownWaitG
 
 	if err != nil {
 
-		Logger.Error("error initializing query result sender socket", zap.Error(err))
+		config.Logger.Error("error initializing query result sender socket", zap.Error(err))
 
 		return
 
@@ -49,50 +49,84 @@ func InitQueryResultSender(queryResultChannel <-chan Result, globalShutdownWaitG
 	defer func(socket *zmq.Socket) {
 		err := socket.Close()
 		if err != nil {
-			Logger.Error("Error terminating the result sender zmq socket")
+			config.Logger.Error("Error terminating the result sender zmq socket")
 		}
 	}(socket)
 
-	err = socket.Bind("tcp://*:" + QueryResultBindPort)
+	err = socket.Bind("tcp://*:" + config.QueryResultBindPort)
 
 	if err != nil {
 
-		Logger.Error("error binding query result sender socket", zap.Error(err))
+		config.Logger.Error("error binding query result sender socket", zap.Error(err))
 
 		return
 
 	}
 
-	// Listen for the results
+	// Queries whose stream was ended early by an error chunk, their remaining chunks are dropped
+	endedQueries := make(map[uint64]struct{})
 
-	for result := range queryResultChannel {
+	// Listen for the result chunks, each message is prefixed by the queryId and the final chunk marker
 
-		queryId := [8]byte{}
+	for chunk := range queryResultChannel {
 
-		binary.LittleEndian.PutUint64(queryId[:], result.QueryId)
+		if _, ended := endedQueries[chunk.QueryId]; ended {
 
-		resultBytes, err := msgpack.Marshal(result)
+			if !chunk.Partial {
 
-		if err != nil {
+				delete(endedQueries, chunk.QueryId)
 
-			Logger.Error("error marshalling query result ", zap.Error(err))
+			}
 
 			continue
 
 		}
 
-		message := append(queryId[:], resultBytes...)
+		chunk.Final = !chunk.Partial
 
-		_, err = socket.SendBytes(message, 0)
+		chunkBytes, err := msgpack.Marshal(chunk)
 
 		if err != nil {
 
-			Logger.Error("error sending query result ", zap.Error(err))
+			config.Logger.Error("error marshalling query result ", zap.Uint64("queryId", chunk.QueryId), zap.Error(err))
+
+			// The client would wait for the final chunk till its timeout otherwise
+			if chunk.Partial {
+
+				endedQueries[chunk.QueryId] = struct{}{}
+
+			}
+
+			chunk = Result{QueryId: chunk.QueryId, Sequence: chunk.Sequence, Final: true, Error: "error marshalling query result: " + err.Error()}
+
+			if chunkBytes, err = msgpack.Marshal(chunk); err != nil {
+
+				continue
+
+			}
+
+		}
+
+		header := [9]byte{}
+
+		binary.LittleEndian.PutUint64(header[:8], chunk.QueryId)
+
+		if chunk.Final {
+
+			header[8] = 1
+
+		}
+
+		message := append(header[:], chunkBytes...)
+
+		if _, err = socket.SendBytes(message, 0); err != nil {
+
+			config.Logger.Error("error sending query result ", zap.Error(err))
 
 		}
 
 	}
 
-	Logger.Info("Query result sender shutting down")
+	config.Logger.Info("Query result sender shutting down")
 
 }
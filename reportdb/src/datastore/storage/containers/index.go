@@ -4,17 +4,59 @@ import (
 	. "datastore/utils"
 	"github.com/vmihailenco/msgpack/v5"
 	"go.uber.org/zap"
+	"hash/crc32"
 	"log"
 	"os"
+	"slices"
 	"strconv"
 	"sync"
 	"sync/atomic"
 )
 
+// castagnoli is the CRC32C table of the block checksums.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
 type ObjectBlock struct {
 	Offset uint64 `msgpack:"offset" json:"offset"`
 
 	RemainingCapacity uint32 `msgpack:"remaining_capacity" json:"remaining_capacity"`
+
+	// Checksum is the CRC32C of the block's data, extended as data is appended. Blocks written before checksums
+	// existed are not Checksummed and never verified.
+	Checksum uint32 `msgpack:"checksum" json:"checksum"`
+
+	Checksummed bool `msgpack:"checksummed" json:"checksummed"`
+
+	// BatchOffset and LastBatchOffset are where the first and last batches starting in the block begin, the block
+	// size when none does. Reads drop the batches running into or over from a corrupt block.
+	BatchOffset uint32 `msgpack:"batch_offset" json:"batch_offset"`
+
+	LastBatchOffset uint32 `msgpack:"last_batch_offset" json:"last_batch_offset"`
+}
+
+// NewObjectBlock returns an empty block at the offset, checksummed and without any batch yet.
+func NewObjectBlock(offset uint64, blockSize uint32) ObjectBlock {
+
+	return ObjectBlock{
+
+		Offset: offset,
+
+		RemainingCapacity: blockSize,
+
+		Checksummed: true,
+
+		BatchOffset: blockSize,
+
+		LastBatchOffset: blockSize,
+	}
+
+}
+
+// Verify reports whether the block's data matches its checksum.
+func (block ObjectBlock) Verify(data []byte) bool {
+
+	return !block.Checksummed || crc32.Checksum(data, castagnoli) == block.Checksum
+
 }
 
 type Index struct {
@@ -67,6 +109,8 @@ func loadIndex(partitionId uint32, storagePath string) (*Index, error) {
 	return &index, nil
 }
 
+// GetIndexObjectBlocks returns a copy of the object's blocks, the writers update the last one in place: its capacity
+// and checksum must be read together.
 func (index *Index) GetIndexObjectBlocks(objectId uint32) []ObjectBlock {
 
 	index.mu.RLock()
@@ -79,9 +123,28 @@ func (index *Index) GetIndexObjectBlocks(objectId uint32) []ObjectBlock {
 
 	} else {
 
-		return objectBlocks
+		return slices.Clone(objectBlocks)
+
+	}
+}
+
+// Keys returns the objects of the index.
+func (index *Index) Keys() []uint32 {
+
+	index.mu.RLock()
+
+	defer index.mu.RUnlock()
+
+	keys := make([]uint32, 0, len(index.ObjectIndex))
+
+	for key := range index.ObjectIndex {
+
+		keys = append(keys, key)
 
 	}
+
+	return keys
+
 }
 
 func (index *Index) AppendNewObjectBlock(objectId uint32, objectBlock ObjectBlock) []ObjectBlock {
@@ -120,6 +183,51 @@ func (index *Index) UpdateObjectBlockCapacity(objectId uint32, newBlockCapacity
 
 }
 
+// AppendObjectBlockData records the data written at the end of the object's last block, its new capacity and
+// checksum.
+func (index *Index) AppendObjectBlockData(objectId uint32, newBlockCapacity uint32, data []byte) {
+
+	index.mu.Lock()
+
+	defer index.mu.Unlock()
+
+	block := &index.ObjectIndex[objectId][len(index.ObjectIndex[objectId])-1]
+
+	block.RemainingCapacity = newBlockCapacity
+
+	if block.Checksummed {
+
+		block.Checksum = crc32.Update(block.Checksum, castagnoli, data)
+
+	}
+
+}
+
+// MarkObjectBatchStart records that a batch starts at the end of the object's last block.
+func (index *Index) MarkObjectBatchStart(objectId uint32) {
+
+	index.mu.Lock()
+
+	defer index.mu.Unlock()
+
+	block := &index.ObjectIndex[objectId][len(index.ObjectIndex[objectId])-1]
+
+	if !block.Checksummed {
+
+		return
+
+	}
+
+	block.LastBatchOffset = index.BlockSize - block.RemainingCapacity
+
+	if block.BatchOffset == index.BlockSize {
+
+		block.BatchOffset = block.LastBatchOffset
+
+	}
+
+}
+
 func (index *Index) SyncFile(storagePath string, partitionId uint32) error {
 
 	index.mu.Lock()
@@ -128,17 +236,24 @@ func (index *Index) SyncFile(storagePath string, partitionId uint32) error {
 
 	indexBytes, err := msgpack.Marshal(index)
 
+	if err != nil {
+
+		return err
+
+	}
+
 	indexFilePath := storagePath + "/index_" + strconv.Itoa(int(partitionId)) + ".bin"
 
-	err = os.WriteFile(indexFilePath, indexBytes, 0644)
+	// Written aside then renamed, a full disk never leaves a truncated index
+	if err = os.WriteFile(indexFilePath+".tmp", indexBytes, 0644); err != nil {
 
-	if err != nil {
+		_ = os.Remove(indexFilePath + ".tmp")
 
 		return err
 
 	}
 
-	return nil
+	return os.Rename(indexFilePath+".tmp", indexFilePath)
 }
 
 func (index *Index) GetNextAvailableBlockOffset() uint64 {
@@ -152,14 +267,18 @@ func (index *Index) GetNextAvailableBlockOffset() uint64 {
 type IndexPool struct {
 	pool map[uint32]*Index
 
+	config *Config
+
 	lock sync.Mutex
 }
 
-func NewIndexPool() *IndexPool {
+func NewIndexPool(config *Config) *IndexPool {
 
 	return &IndexPool{
 
 		pool: make(map[uint32]*Index),
+
+		config: config,
 	}
 
 }
@@ -182,7 +301,7 @@ func (indexPool *IndexPool) Get(partitionId uint32, storagePath string) (*Index,
 
 		if err != nil {
 
-			Logger.Error("error opening new index for: ", zap.String("storagePath", storagePath), zap.Uint32("partitionId", partitionId), zap.Error(err))
+			indexPool.config.Logger.Error("error opening new index for: ", zap.String("storagePath", storagePath), zap.Uint32("partitionId", partitionId), zap.Error(err))
 
 			return nil, err
 
@@ -207,7 +326,7 @@ func (indexPool *IndexPool) Close(storagePath string) {
 
 		if err := index.SyncFile(storagePath, partitionId); err != nil {
 
-			Logger.Error("error closing index for: ", zap.String("storagePath", storagePath), zap.Uint32("partitionId", partitionId), zap.Error(err))
+			indexPool.config.Logger.Error("error closing index for: ", zap.String("storagePath", storagePath), zap.Uint32("partitionId", partitionId), zap.Error(err))
 
 		}
 	}
@@ -17,12 +17,14 @@ type FileMapping struct {
 
 	file *os.File
 
+	config *Config
+
 	lock sync.RWMutex
 
 	// Add functionality of access count to periodically unmap less used files.
 }
 
-func loadFileMapping(partitionId uint32, storagePath string) (*FileMapping, error) {
+func loadFileMapping(partitionId uint32, storagePath string, config *Config) (*FileMapping, error) {
 
 	filePath := storagePath + "/data_" + strconv.Itoa(int(partitionId)) + ".bin"
 
@@ -58,28 +60,49 @@ func loadFileMapping(partitionId uint32, storagePath string) (*FileMapping, erro
 
 	if err != nil {
 
-		Logger.Error("error mapping the file for", zap.String("storagePath", storagePath), zap.Uint32("partitionId:", partitionId))
+		config.Logger.Error("error mapping the file for", zap.String("storagePath", storagePath), zap.Uint32("partitionId:", partitionId))
 
 		return nil, err
 
 	}
 
+	config.Metrics.MappedBytes.Add(int64(len(fileMapping)))
+
 	return &FileMapping{
 
 		mapping: fileMapping,
 
 		file: file,
+
+		config: config,
 	}, nil
 
 }
 
+// AllocateFile grows the file by length bytes from offset, allocated rather than sparse. Filesystems without
+// fallocate, like tmpfs, get the file truncated to size instead.
+func AllocateFile(file *os.File, offset, length int64) error {
+
+	err := syscall.Fallocate(int(file.Fd()), 0, offset, length)
+
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+
+		return file.Truncate(offset + length)
+
+	}
+
+	return err
+
+}
+
 func truncateFile(fileMapping *FileMapping) error {
 
-	newSize := int64(len(fileMapping.mapping)) + FileSizeGrowthDelta
+	newSize := int64(len(fileMapping.mapping)) + fileMapping.config.FileSizeGrowthDelta
 
-	if err := os.Truncate(fileMapping.file.Name(), newSize); err != nil {
+	// Allocated rather than sparse, a full disk fails the growth instead of the writes to the mapping
+	if err := AllocateFile(fileMapping.file, int64(len(fileMapping.mapping)), fileMapping.config.FileSizeGrowthDelta); err != nil {
 
-		Logger.Error("error truncating file", zap.String("FileName", fileMapping.file.Name()), zap.Error(err))
+		fileMapping.config.Logger.Error("error truncating file", zap.String("FileName", fileMapping.file.Name()), zap.Error(err))
 
 		return err
 	}
@@ -88,16 +111,18 @@ func truncateFile(fileMapping *FileMapping) error {
 
 	if err := syscall.Munmap(fileMapping.mapping); err != nil {
 
-		Logger.Error(ErrUnmappingFile.Error())
+		fileMapping.config.Logger.Error(ErrUnmappingFile.Error())
 
 		return ErrUnmappingFile
 	}
 
+	fileMapping.config.Metrics.MappedBytes.Add(-int64(len(fileMapping.mapping)))
+
 	newMapping, err := syscall.Mmap(int(fileMapping.file.Fd()), 0, int(newSize), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
 
 	if err != nil {
 
-		Logger.Error("Error creating mapping", zap.String("fileName", fileMapping.file.Name()), zap.Error(err))
+		fileMapping.config.Logger.Error("Error creating mapping", zap.String("fileName", fileMapping.file.Name()), zap.Error(err))
 
 		return err
 
@@ -105,6 +130,8 @@ func truncateFile(fileMapping *FileMapping) error {
 
 	fileMapping.mapping = newMapping
 
+	fileMapping.config.Metrics.MappedBytes.Add(int64(len(newMapping)))
+
 	return nil
 
 }
@@ -116,15 +143,17 @@ func (fileMapping *FileMapping) UnmapFile() error {
 
 	if err := syscall.Munmap(fileMapping.mapping); err != nil {
 
-		Logger.Error(ErrUnmappingFile.Error())
+		fileMapping.config.Logger.Error(ErrUnmappingFile.Error())
 
 		return ErrUnmappingFile
 
 	}
 
+	fileMapping.config.Metrics.MappedBytes.Add(-int64(len(fileMapping.mapping)))
+
 	if err := fileMapping.file.Close(); err != nil {
 
-		Logger.Error("error closing file", zap.Error(err))
+		fileMapping.config.Logger.Error("error closing file", zap.Error(err))
 
 		return err
 
@@ -134,6 +163,27 @@ func (fileMapping *FileMapping) UnmapFile() error {
 
 }
 
+// EnsureSize grows the file till it holds size bytes, so that the writes within never fail midway.
+func (fileMapping *FileMapping) EnsureSize(size uint64) error {
+
+	fileMapping.lock.Lock()
+
+	defer fileMapping.lock.Unlock()
+
+	for uint64(len(fileMapping.mapping)) < size {
+
+		if err := truncateFile(fileMapping); err != nil {
+
+			return err
+
+		}
+
+	}
+
+	return nil
+
+}
+
 func (fileMapping *FileMapping) WriteAt(data []byte, offset uint64) error {
 
 	fileMapping.lock.Lock()
@@ -157,38 +207,121 @@ func (fileMapping *FileMapping) WriteAt(data []byte, offset uint64) error {
 
 }
 
-func (fileMapping *FileMapping) ReadBlocks(objectBlocks []ObjectBlock, blockSize uint32) []byte {
+// ReadBlocks returns the data of the blocks, skipping the corrupt ones. The batches running into or over from a
+// corrupt block are skipped as well, reading resumes at the next batch start.
+func (fileMapping *FileMapping) ReadBlocks(objectBlocks []ObjectBlock, blockSize uint32) (data []byte, corruptBlocks []ObjectBlock) {
 
 	fileMapping.lock.RLock()
 
 	defer fileMapping.lock.RUnlock()
 
-	data := make([]byte, len(objectBlocks)*int(blockSize)) // make the container for the data.
+	data = make([]byte, len(objectBlocks)*int(blockSize)) // make the container for the data.
 
 	var currentIndex = 0
+
+	// Position in data of the last batch start read
+	var batchStart = 0
+
+	skipping := false
+
 	for _, block := range objectBlocks {
 
-		sizeOfBlockData := blockSize - block.RemainingCapacity
+		blockData := fileMapping.blockData(block, blockSize)
+
+		if !block.Verify(blockData) {
+
+			corruptBlocks = append(corruptBlocks, block)
+
+			// Unless the corrupt block starts with a batch, the last batch read runs into it
+			if !skipping && block.BatchOffset != 0 {
 
-		copy(data[currentIndex:], fileMapping.mapping[int(block.Offset):int(block.Offset)+int(sizeOfBlockData)])
+				currentIndex = batchStart
 
-		currentIndex += int(sizeOfBlockData)
+			}
+
+			skipping = true
+
+			continue
+
+		}
+
+		var start uint32
+
+		if skipping {
+
+			if block.BatchOffset >= uint32(len(blockData)) {
+
+				continue
+
+			}
+
+			start, skipping = block.BatchOffset, false
+
+		}
+
+		if block.Checksummed && block.LastBatchOffset < uint32(len(blockData)) {
+
+			batchStart = currentIndex + int(block.LastBatchOffset-start)
+
+		}
+
+		copy(data[currentIndex:], blockData[start:])
+
+		currentIndex += len(blockData) - int(start)
+
+		// Blocks written before checksums don't record their batches, a corrupt block after them only drops its own data
+		if !block.Checksummed {
+
+			batchStart = currentIndex
+
+		}
 
 	}
 
-	return data[:currentIndex]
+	return data[:currentIndex], corruptBlocks
+
+}
+
+// VerifyBlocks returns the corrupt blocks among the blocks, without reading their data out.
+func (fileMapping *FileMapping) VerifyBlocks(objectBlocks []ObjectBlock, blockSize uint32) (corruptBlocks []ObjectBlock) {
+
+	fileMapping.lock.RLock()
+
+	defer fileMapping.lock.RUnlock()
+
+	for _, block := range objectBlocks {
+
+		if !block.Verify(fileMapping.blockData(block, blockSize)) {
+
+			corruptBlocks = append(corruptBlocks, block)
+
+		}
+
+	}
+
+	return corruptBlocks
+
+}
+
+func (fileMapping *FileMapping) blockData(block ObjectBlock, blockSize uint32) []byte {
+
+	sizeOfBlockData := blockSize - block.RemainingCapacity
+
+	return fileMapping.mapping[int(block.Offset) : int(block.Offset)+int(sizeOfBlockData)]
 
 }
 
 type OpenFilesPool struct {
 	pool map[uint32]*FileMapping
 
+	config *Config
+
 	lock sync.Mutex
 }
 
-func NewOpenFilesPool() *OpenFilesPool {
+func NewOpenFilesPool(config *Config) *OpenFilesPool {
 
-	return &OpenFilesPool{pool: make(map[uint32]*FileMapping)}
+	return &OpenFilesPool{pool: make(map[uint32]*FileMapping), config: config}
 
 }
 
@@ -207,11 +340,11 @@ func (pool *OpenFilesPool) GetFileMapping(partitionId uint32, storagePath string
 
 		// Create new
 
-		mapping, err := loadFileMapping(partitionId, storagePath)
+		mapping, err := loadFileMapping(partitionId, storagePath, pool.config)
 
 		if err != nil {
 
-			Logger.Info("error opening new File for: ", zap.Uint32("partitionId:", partitionId), zap.Error(err))
+			pool.config.Logger.Info("error opening new File for: ", zap.Uint32("partitionId:", partitionId), zap.Error(err))
 
 			return nil, err
 
@@ -251,13 +384,17 @@ func (pool *OpenFilesPool) Close() {
 
 		if err := syscall.Munmap(fileMapping.mapping); err != nil {
 
-			Logger.Error("error unmapping file", zap.String("fileName", fileMapping.file.Name()), zap.Error(err))
+			pool.config.Logger.Error("error unmapping file", zap.String("fileName", fileMapping.file.Name()), zap.Error(err))
+
+		} else {
+
+			pool.config.Metrics.MappedBytes.Add(-int64(len(fileMapping.mapping)))
 
 		}
 
 		if err := fileMapping.file.Close(); err != nil {
 
-			Logger.Error("error closing file", zap.String("fileName", fileMapping.file.Name()), zap.Error(err))
+			pool.config.Logger.Error("error closing file", zap.String("fileName", fileMapping.file.Name()), zap.Error(err))
 
 		}
 
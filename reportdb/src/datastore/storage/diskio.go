@@ -22,17 +22,29 @@ func DiskWrite(key uint32, data []byte, file *FileMapping, index *Index) error {
 
 		objectBlocks = index.AppendNewObjectBlock(key,
 
-			ObjectBlock{
+			NewObjectBlock(newBlockOffset, index.BlockSize))
 
-				Offset: newBlockOffset,
+		remainingBlockCapacity = index.BlockSize
+
+	}
 
-				RemainingCapacity: index.BlockSize,
-			})
+	// The file is grown for the whole batch first, a batch is never written in part
+	lastBlockEnd := objectBlocks[len(objectBlocks)-1].Offset + uint64(index.BlockSize)
 
-		remainingBlockCapacity = index.BlockSize
+	if overflow := len(data) - int(remainingBlockCapacity); overflow >= 0 {
+
+		lastBlockEnd = index.NextFreeBlockOffset + uint64(overflow/int(index.BlockSize)+1)*uint64(index.BlockSize)
+
+	}
+
+	if err := file.EnsureSize(lastBlockEnd); err != nil {
+
+		return err
 
 	}
 
+	index.MarkObjectBatchStart(key)
+
 	for len(data) > 0 {
 
 		writableDataBytes := intMin(len(data), int(remainingBlockCapacity))
@@ -48,7 +60,7 @@ func DiskWrite(key uint32, data []byte, file *FileMapping, index *Index) error {
 		// Update the Index Metadata
 		newBlockCapacity := remainingBlockCapacity - uint32(writableDataBytes)
 
-		index.UpdateObjectBlockCapacity(key, newBlockCapacity)
+		index.AppendObjectBlockData(key, newBlockCapacity, data[:writableDataBytes])
 
 		//Re-slice for remaining dataPoints
 		data = data[writableDataBytes:]
@@ -60,12 +72,7 @@ func DiskWrite(key uint32, data []byte, file *FileMapping, index *Index) error {
 
 			objectBlocks = index.AppendNewObjectBlock(key,
 
-				ObjectBlock{
-
-					Offset: newBlockOffset,
-
-					RemainingCapacity: index.BlockSize,
-				})
+				NewObjectBlock(newBlockOffset, index.BlockSize))
 
 			remainingBlockCapacity = index.BlockSize
 
@@ -79,12 +86,7 @@ func DiskWrite(key uint32, data []byte, file *FileMapping, index *Index) error {
 
 				objectBlocks = index.AppendNewObjectBlock(key,
 
-					ObjectBlock{
-
-						Offset: newBlockOffset,
-
-						RemainingCapacity: index.BlockSize,
-					})
+					NewObjectBlock(newBlockOffset, index.BlockSize))
 
 			}
 		}
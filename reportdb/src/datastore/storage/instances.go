@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"os"
+	"sync"
+)
+
+const instancesFileName = "instances.bin"
+
+// InstanceKeyBase is the first key of the instance series. Objects are keyed by their IPv4 address, and the
+// 240.0.0.0/4 range the instance keys are taken from is reserved, so no polled object uses it.
+const InstanceKeyBase uint32 = 0xF0000000
+
+var ErrInstanceKeysExhausted = errors.New("no instance key left in storage")
+
+// SeriesInstance is an instance of an object's series, like a network interface or a disk.
+type SeriesInstance struct {
+	ObjectId uint32 `msgpack:"object_id" json:"object_id"`
+
+	Instance string `msgpack:"instance" json:"instance"`
+}
+
+// instanceDictionary keys the instance series of a storage, the instance with index i has the key InstanceKeyBase+i.
+type instanceDictionary struct {
+	instances []SeriesInstance
+
+	keys map[SeriesInstance]uint32
+
+	// objectId -> keys of the object's instances
+	objectKeys map[uint32][]uint32
+
+	lock sync.RWMutex
+}
+
+func loadInstanceDictionary(storagePath string) (*instanceDictionary, error) {
+
+	var instances []SeriesInstance
+
+	instancesBytes, err := os.ReadFile(storagePath + "/" + instancesFileName)
+
+	if errors.Is(err, os.ErrNotExist) {
+
+		return newInstanceDictionary(nil), nil
+
+	}
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	if err = msgpack.Unmarshal(instancesBytes, &instances); err != nil {
+
+		return nil, err
+
+	}
+
+	return newInstanceDictionary(instances), nil
+
+}
+
+func newInstanceDictionary(instances []SeriesInstance) *instanceDictionary {
+
+	dictionary := &instanceDictionary{
+
+		instances: instances,
+
+		keys: make(map[SeriesInstance]uint32),
+
+		objectKeys: make(map[uint32][]uint32),
+	}
+
+	for index, instance := range dictionary.instances {
+
+		dictionary.keys[instance] = InstanceKeyBase + uint32(index)
+
+		dictionary.objectKeys[instance.ObjectId] = append(dictionary.objectKeys[instance.ObjectId], InstanceKeyBase+uint32(index))
+
+	}
+
+	return dictionary
+
+}
+
+// SeriesKey returns the key of the object's instance series, adding the instance to the storage's dictionary if
+// needed. The series without instance is keyed by the objectId.
+func (storage *Storage) SeriesKey(objectId uint32, instance string) (uint32, error) {
+
+	if instance == "" {
+
+		return objectId, nil
+
+	}
+
+	dictionary := storage.instances
+
+	seriesInstance := SeriesInstance{objectId, instance}
+
+	dictionary.lock.RLock()
+
+	key, ok := dictionary.keys[seriesInstance]
+
+	dictionary.lock.RUnlock()
+
+	if ok {
+
+		return key, nil
+
+	}
+
+	dictionary.lock.Lock()
+
+	defer dictionary.lock.Unlock()
+
+	if key, ok = dictionary.keys[seriesInstance]; ok {
+
+		return key, nil
+
+	}
+
+	if storage.segment != nil {
+
+		return 0, ErrStorageImmutable
+
+	}
+
+	if uint64(len(dictionary.instances)) >= uint64(^uint32(0)-InstanceKeyBase) {
+
+		return 0, ErrInstanceKeysExhausted
+
+	}
+
+	if err := writeInstances(storage.storagePath, append(dictionary.instances, seriesInstance)); err != nil {
+
+		return 0, err
+
+	}
+
+	key = InstanceKeyBase + uint32(len(dictionary.instances))
+
+	dictionary.instances = append(dictionary.instances, seriesInstance)
+
+	dictionary.keys[seriesInstance] = key
+
+	dictionary.objectKeys[objectId] = append(dictionary.objectKeys[objectId], key)
+
+	return key, nil
+
+}
+
+func writeInstances(storagePath string, instances []SeriesInstance) error {
+
+	instancesBytes, err := msgpack.Marshal(instances)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	// Written aside then renamed, like the metadata
+	temporaryPath := storagePath + "/" + instancesFileName + ".tmp"
+
+	if err = os.WriteFile(temporaryPath, instancesBytes, 0644); err != nil {
+
+		return err
+
+	}
+
+	return os.Rename(temporaryPath, storagePath+"/"+instancesFileName)
+
+}
+
+// ObjectInstanceKeys returns the keys of the object's instance series.
+func (storage *Storage) ObjectInstanceKeys(objectId uint32) []uint32 {
+
+	storage.instances.lock.RLock()
+
+	defer storage.instances.lock.RUnlock()
+
+	return storage.instances.objectKeys[objectId]
+
+}
+
+// Instance returns the instance series of the key, ok is false for the keys of objects.
+func (storage *Storage) Instance(key uint32) (instance SeriesInstance, ok bool) {
+
+	if key < InstanceKeyBase {
+
+		return instance, false
+
+	}
+
+	storage.instances.lock.RLock()
+
+	defer storage.instances.lock.RUnlock()
+
+	if index := key - InstanceKeyBase; index < uint32(len(storage.instances.instances)) {
+
+		return storage.instances.instances[index], true
+
+	}
+
+	return instance, false
+
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"os"
+)
+
+const metadataFileName = "metadata.bin"
+
+// Metadata is written along the partitions of a storage at its creation.
+type Metadata struct {
+	// DataType the storage's dataPoints are serialized with
+	DataType string `msgpack:"data_type" json:"data_type"`
+
+	// Precision of the stored timestamps, empty for the storages created before it was recorded, which hold seconds
+	Precision string `msgpack:"precision,omitempty" json:"precision,omitempty"`
+}
+
+// ReadMetadata returns the metadata of the storage, empty for the storages created before the metadata was written.
+func ReadMetadata(storagePath string) (Metadata, error) {
+
+	var metadata Metadata
+
+	metadataBytes, err := os.ReadFile(storagePath + "/" + metadataFileName)
+
+	if errors.Is(err, os.ErrNotExist) {
+
+		return metadata, nil
+
+	}
+
+	if err != nil {
+
+		return metadata, err
+
+	}
+
+	err = msgpack.Unmarshal(metadataBytes, &metadata)
+
+	return metadata, err
+
+}
+
+// WriteMetadata replaces the metadata of the storage.
+func WriteMetadata(storagePath string, metadata Metadata) error {
+
+	metadataBytes, err := msgpack.Marshal(metadata)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	// Written aside then renamed, the storage never has a partial metadata
+	temporaryPath := storagePath + "/" + metadataFileName + ".tmp"
+
+	if err = os.WriteFile(temporaryPath, metadataBytes, 0644); err != nil {
+
+		return err
+
+	}
+
+	return os.Rename(temporaryPath, storagePath+"/"+metadataFileName)
+
+}
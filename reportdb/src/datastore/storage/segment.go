@@ -0,0 +1,567 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	. "datastore/utils"
+	"encoding/binary"
+	"errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.uber.org/zap"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const segmentFileName = "segment.bin"
+
+// segmentMagic opens and closes a segment file, a segment missing its closing magic was not fully written.
+const segmentMagic = "RSEG"
+
+const segmentVersion = 1
+
+// Footer of a segment: index offset u64, index length u32, index CRC32C u32 and the closing magic.
+const segmentFooterSize = 8 + 4 + 4 + len(segmentMagic)
+
+var ErrStorageImmutable = errors.New("storage is a compacted segment, it can not be written")
+
+var ErrInvalidSegment = errors.New("invalid segment file")
+
+var segmentChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// segmentEntry locates the compressed data of a key in the segment.
+type segmentEntry struct {
+	Key uint32 `msgpack:"key"`
+
+	Offset uint64 `msgpack:"offset"`
+
+	// Length of the compressed data, Size of the data
+	Length uint32 `msgpack:"length"`
+
+	Size uint32 `msgpack:"size"`
+
+	// CRC32C of the compressed data
+	Checksum uint32 `msgpack:"checksum"`
+}
+
+// segmentIndex is embedded at the end of the segment, along with what the mutable storage keeps in files of its own.
+type segmentIndex struct {
+	Metadata Metadata `msgpack:"metadata"`
+
+	Instances []SeriesInstance `msgpack:"instances"`
+
+	// Entries sorted by key
+	Entries []segmentEntry `msgpack:"entries"`
+}
+
+// segment is a compacted day-storage: a single immutable file holding the compressed data of every key, sorted by
+// key, followed by its index.
+type segment struct {
+	file *os.File
+
+	index segmentIndex
+}
+
+// IsSegment reports whether the storage at storagePath was compacted into a segment.
+func IsSegment(storagePath string) bool {
+
+	_, err := os.Stat(storagePath + "/" + segmentFileName)
+
+	return err == nil
+
+}
+
+func openSegment(storagePath string) (*segment, error) {
+
+	file, err := os.Open(storagePath + "/" + segmentFileName)
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	segment := &segment{file: file}
+
+	if err = segment.readIndex(); err != nil {
+
+		_ = file.Close()
+
+		return nil, err
+
+	}
+
+	return segment, nil
+
+}
+
+func (segment *segment) readIndex() error {
+
+	fileStats, err := segment.file.Stat()
+
+	if err != nil {
+
+		return err
+
+	}
+
+	if fileStats.Size() < int64(len(segmentMagic)+1+segmentFooterSize) {
+
+		return ErrInvalidSegment
+
+	}
+
+	footer := make([]byte, segmentFooterSize)
+
+	if _, err = segment.file.ReadAt(footer, fileStats.Size()-int64(segmentFooterSize)); err != nil {
+
+		return err
+
+	}
+
+	if string(footer[16:]) != segmentMagic {
+
+		return ErrInvalidSegment
+
+	}
+
+	indexOffset, indexLength := binary.LittleEndian.Uint64(footer), binary.LittleEndian.Uint32(footer[8:])
+
+	if indexOffset+uint64(indexLength) > uint64(fileStats.Size()-int64(segmentFooterSize)) {
+
+		return ErrInvalidSegment
+
+	}
+
+	indexBytes := make([]byte, indexLength)
+
+	if _, err = segment.file.ReadAt(indexBytes, int64(indexOffset)); err != nil {
+
+		return err
+
+	}
+
+	if crc32.Checksum(indexBytes, segmentChecksumTable) != binary.LittleEndian.Uint32(footer[12:]) {
+
+		return ErrInvalidSegment
+
+	}
+
+	return msgpack.Unmarshal(indexBytes, &segment.index)
+
+}
+
+func (segment *segment) entry(key uint32) (segmentEntry, bool) {
+
+	position, found := slices.BinarySearchFunc(segment.index.Entries, key, func(entry segmentEntry, key uint32) int {
+
+		return int(int64(entry.Key) - int64(key))
+
+	})
+
+	if !found {
+
+		return segmentEntry{}, false
+
+	}
+
+	return segment.index.Entries[position], true
+
+}
+
+// read returns the compressed data of the entry, ok is false when it does not match its checksum.
+func (segment *segment) read(entry segmentEntry) (compressed []byte, ok bool, err error) {
+
+	compressed = make([]byte, entry.Length)
+
+	if _, err = segment.file.ReadAt(compressed, int64(entry.Offset)); err != nil {
+
+		return nil, false, err
+
+	}
+
+	return compressed, crc32.Checksum(compressed, segmentChecksumTable) == entry.Checksum, nil
+
+}
+
+// get returns the data of the entry, ok is false when it does not match its checksum.
+func (segment *segment) get(entry segmentEntry) (data []byte, ok bool, err error) {
+
+	compressed, ok, err := segment.read(entry)
+
+	if err != nil || !ok {
+
+		return nil, ok, err
+
+	}
+
+	data = make([]byte, entry.Size)
+
+	if _, err = io.ReadFull(flate.NewReader(bytes.NewReader(compressed)), data); err != nil {
+
+		return nil, false, err
+
+	}
+
+	return data, true, nil
+
+}
+
+func (segment *segment) keys() []uint32 {
+
+	keys := make([]uint32, len(segment.index.Entries))
+
+	for position, entry := range segment.index.Entries {
+
+		keys[position] = entry.Key
+
+	}
+
+	return keys
+
+}
+
+func (segment *segment) close() error {
+
+	return segment.file.Close()
+
+}
+
+// CompactStorage rewrites the day-storage at storagePath into a segment, which replaces its partitions and index
+// files. The storage must not be open meanwhile. A storage already compacted only has the leftovers of an
+// interrupted compaction removed.
+func CompactStorage(storagePath string, config *Config) error {
+
+	if IsSegment(storagePath) {
+
+		return removeMutableFiles(storagePath)
+
+	}
+
+	storage, err := NewStorage(storagePath, Metadata{}, config, false)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	err = writeSegment(storage)
+
+	storage.ClearStorage()
+
+	if err != nil {
+
+		_ = os.Remove(storagePath + "/" + segmentFileName + ".tmp")
+
+		return err
+
+	}
+
+	// The segment is complete once renamed in, the mutable files are only removed after
+	if err = os.Rename(storagePath+"/"+segmentFileName+".tmp", storagePath+"/"+segmentFileName); err != nil {
+
+		return err
+
+	}
+
+	if err = syncDirectory(storagePath); err != nil {
+
+		return err
+
+	}
+
+	config.Logger.Info("Compacted storage into a segment", zap.String("storagePath", storagePath))
+
+	return removeMutableFiles(storagePath)
+
+}
+
+// ExpandSegment rewrites the compacted storage at storagePath back into a mutable storage, for the points written late
+// to its day. The storage must not be open meanwhile. The mutable files are written aside and moved in, the segment is
+// only removed once they are all in place, so an interrupted expansion leaves the segment as it was.
+func ExpandSegment(storagePath string, config *Config) error {
+
+	segment, err := openSegment(storagePath)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	defer segment.close()
+
+	expandingPath := storagePath + ".expanding"
+
+	if err = os.RemoveAll(expandingPath); err != nil {
+
+		return err
+
+	}
+
+	defer os.RemoveAll(expandingPath)
+
+	storage, err := NewStorage(expandingPath, segment.index.Metadata, config, true)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	err = writeInstances(expandingPath, segment.index.Instances)
+
+	for _, entry := range segment.index.Entries {
+
+		if err != nil {
+
+			break
+
+		}
+
+		data, ok, readErr := segment.get(entry)
+
+		if readErr != nil {
+
+			err = readErr
+
+			break
+
+		}
+
+		// Corrupt data is left out, like by the compaction
+		if !ok {
+
+			storage.reportCorruptBlock(entry.Key, entry.Offset)
+
+			continue
+
+		}
+
+		err = storage.Put(entry.Key, data)
+
+	}
+
+	storage.ClearStorage()
+
+	if err != nil {
+
+		return err
+
+	}
+
+	files, err := os.ReadDir(expandingPath)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	for _, file := range files {
+
+		if err = syncFile(expandingPath + "/" + file.Name()); err != nil {
+
+			return err
+
+		}
+
+		if err = os.Rename(expandingPath+"/"+file.Name(), storagePath+"/"+file.Name()); err != nil {
+
+			return err
+
+		}
+
+	}
+
+	if err = syncDirectory(storagePath); err != nil {
+
+		return err
+
+	}
+
+	if err = os.Remove(storagePath + "/" + segmentFileName); err != nil {
+
+		return err
+
+	}
+
+	config.Logger.Info("Expanded segment for late writes", zap.String("storagePath", storagePath))
+
+	return syncDirectory(storagePath)
+
+}
+
+func writeSegment(storage *Storage) error {
+
+	keys, err := storage.GetAllKeys()
+
+	if err != nil {
+
+		return err
+
+	}
+
+	slices.Sort(keys)
+
+	file, err := os.Create(storage.storagePath + "/" + segmentFileName + ".tmp")
+
+	if err != nil {
+
+		return err
+
+	}
+
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+
+	index := segmentIndex{Metadata: storage.metadata, Entries: make([]segmentEntry, 0, len(keys))}
+
+	storage.instances.lock.RLock()
+
+	index.Instances = slices.Clone(storage.instances.instances)
+
+	storage.instances.lock.RUnlock()
+
+	offset := uint64(len(segmentMagic) + 1)
+
+	writer.WriteString(segmentMagic)
+
+	writer.WriteByte(segmentVersion)
+
+	var compressed bytes.Buffer
+
+	compressor, _ := flate.NewWriter(&compressed, flate.DefaultCompression)
+
+	for _, key := range keys {
+
+		// Corrupt blocks are skipped and reported by Get, the segment only holds verified data
+		data, err := storage.Get(key)
+
+		if err != nil {
+
+			return err
+
+		}
+
+		compressed.Reset()
+
+		compressor.Reset(&compressed)
+
+		if _, err = compressor.Write(data); err != nil {
+
+			return err
+
+		}
+
+		if err = compressor.Close(); err != nil {
+
+			return err
+
+		}
+
+		index.Entries = append(index.Entries, segmentEntry{
+
+			Key: key,
+
+			Offset: offset,
+
+			Length: uint32(compressed.Len()),
+
+			Size: uint32(len(data)),
+
+			Checksum: crc32.Checksum(compressed.Bytes(), segmentChecksumTable),
+		})
+
+		if _, err = writer.Write(compressed.Bytes()); err != nil {
+
+			return err
+
+		}
+
+		offset += uint64(compressed.Len())
+
+	}
+
+	indexBytes, err := msgpack.Marshal(&index)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	writer.Write(indexBytes)
+
+	footer := binary.LittleEndian.AppendUint64(nil, offset)
+
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(indexBytes)))
+
+	footer = binary.LittleEndian.AppendUint32(footer, crc32.Checksum(indexBytes, segmentChecksumTable))
+
+	if _, err = writer.Write(append(footer, segmentMagic...)); err != nil {
+
+		return err
+
+	}
+
+	if err = writer.Flush(); err != nil {
+
+		return err
+
+	}
+
+	return file.Sync()
+
+}
+
+func syncFile(path string) error {
+
+	file, err := os.Open(path)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	defer file.Close()
+
+	return file.Sync()
+
+}
+
+// syncDirectory makes the files renamed into or removed from the directory durable.
+func syncDirectory(path string) error {
+
+	return syncFile(path)
+
+}
+
+// removeMutableFiles removes the partitions, indexes, metadata and instances of a compacted storage.
+func removeMutableFiles(storagePath string) error {
+
+	for _, pattern := range []string{"data_*.bin", "index_*.bin", metadataFileName, instancesFileName} {
+
+		paths, _ := filepath.Glob(storagePath + "/" + pattern)
+
+		for _, path := range paths {
+
+			if err := os.Remove(path); err != nil {
+
+				return err
+
+			}
+
+		}
+
+	}
+
+	return nil
+
+}
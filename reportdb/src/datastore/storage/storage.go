@@ -7,6 +7,7 @@ import (
 	"go.uber.org/zap"
 	"os"
 	"strconv"
+	"time"
 )
 
 var ErrObjectDoesNotExist = errors.New("object does not exist")
@@ -23,32 +24,98 @@ type Storage struct {
 	openFilesPool *OpenFilesPool
 
 	indexPool *IndexPool
+
+	metadata Metadata
+
+	instances *instanceDictionary
+
+	logger *zap.Logger
+
+	metrics *Metrics
+
+	// segment of a compacted storage, which is read-only. Nil for the mutable storages.
+	segment *segment
 }
 
-func NewStorage(storagePath string, partitionCount uint32, blockSize uint32, createIfNotExist bool) (*Storage, error) {
+// NewStorage opens the storage at storagePath, with the partitions and block size of the config. A storage created
+// records the metadata, the metadata of an existing storage is the one it was created with. Compacted storages are
+// opened read-only.
+func NewStorage(storagePath string, metadata Metadata, config *Config, createIfNotExist bool) (*Storage, error) {
 
 	// Ensure that storage directory exist, if not create the storage dir and files
 
-	if err := ensureStorageDirectory(storagePath, partitionCount, blockSize, createIfNotExist); err != nil {
+	if err := ensureStorageDirectory(storagePath, metadata, config, createIfNotExist); err != nil {
+
+		return nil, err
+
+	}
+
+	if IsSegment(storagePath) {
+
+		return newSegmentStorage(storagePath, config)
+
+	}
+
+	metadata, err := ReadMetadata(storagePath)
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	instances, err := loadInstanceDictionary(storagePath)
+
+	if err != nil {
 
 		return nil, err
 
 	}
 
-	openFilesPool := NewOpenFilesPool()
+	openFilesPool := NewOpenFilesPool(config)
 
-	indexPool := NewIndexPool()
+	indexPool := NewIndexPool(config)
 
 	return &Storage{
 		storagePath,
-		partitionCount,
-		blockSize,
+		config.Partitions,
+		config.BlockSize,
 		openFilesPool,
 		indexPool,
+		metadata,
+		instances,
+		config.Logger,
+		config.Metrics,
+		nil,
 	}, nil
 }
 
-func ensureStorageDirectory(storagePath string, partitionCount uint32, blockSize uint32, createIfNotExist bool) error {
+func newSegmentStorage(storagePath string, config *Config) (*Storage, error) {
+
+	segment, err := openSegment(storagePath)
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	return &Storage{
+		storagePath:    storagePath,
+		partitionCount: config.Partitions,
+		blockSize:      config.BlockSize,
+		openFilesPool:  NewOpenFilesPool(config),
+		indexPool:      NewIndexPool(config),
+		metadata:       segment.index.Metadata,
+		instances:      newInstanceDictionary(segment.index.Instances),
+		logger:         config.Logger,
+		metrics:        config.Metrics,
+		segment:        segment,
+	}, nil
+
+}
+
+func ensureStorageDirectory(storagePath string, metadata Metadata, config *Config, createIfNotExist bool) error {
 
 	if _, err := os.Stat(storagePath); os.IsNotExist(err) {
 
@@ -58,24 +125,37 @@ func ensureStorageDirectory(storagePath string, partitionCount uint32, blockSize
 
 		}
 
-		Logger.Info("Creating storage", zap.String("storagePath", storagePath))
+		config.Logger.Info("Creating storage", zap.String("storagePath", storagePath))
 
 		if err = os.MkdirAll(storagePath, 0755); err != nil {
 
-			Logger.Info("Failed to create storage directory:", zap.Error(err))
+			config.Logger.Info("Failed to create storage directory:", zap.Error(err))
 
 			return err
 
 		}
 
+		// A storage not fully created, like on a full disk, is not left behind
+		created := false
+
+		defer func() {
+
+			if !created {
+
+				_ = os.RemoveAll(storagePath)
+
+			}
+
+		}()
+
 		// Make partition files and respective index
-		for partitionIndex := range partitionCount {
+		for partitionIndex := range config.Partitions {
 
 			file, err := os.Create(storagePath + "/data_" + strconv.Itoa(int(partitionIndex)) + ".bin")
 
 			if err != nil {
 
-				Logger.Error("error creating new data partition", zap.Error(err))
+				config.Logger.Error("error creating new data partition", zap.Error(err))
 
 				return err
 
@@ -87,34 +167,44 @@ func ensureStorageDirectory(storagePath string, partitionCount uint32, blockSize
 
 				if err != nil {
 
-					Logger.Error("error closing data partition", zap.Error(err))
+					config.Logger.Error("error closing data partition", zap.Error(err))
 
 				}
 
 			}(file)
 
-			if err = os.Truncate(file.Name(), InitialFileSize); err != nil {
+			if err = AllocateFile(file, 0, config.InitialFileSize); err != nil {
 
-				Logger.Error("error truncating new data partition", zap.Error(err))
+				config.Logger.Error("error allocating new data partition", zap.Error(err))
 
 				return err
 
 			}
 
-			index := NewIndex(blockSize)
+			index := NewIndex(config.BlockSize)
 
 			if err = index.SyncFile(storagePath, partitionIndex); err != nil {
 
-				Logger.Error("error marshalling index ", zap.Error(err))
+				config.Logger.Error("error marshalling index ", zap.Error(err))
 
 				return err
 
 			}
 		}
 
+		if err = WriteMetadata(storagePath, metadata); err != nil {
+
+			config.Logger.Error("error writing storage metadata", zap.Error(err))
+
+			return err
+
+		}
+
+		created = true
+
 	} else if err != nil {
 
-		Logger.Info("Failed to stat storage directory:", zap.Error(err))
+		config.Logger.Info("Failed to stat storage directory:", zap.Error(err))
 
 		return err
 	}
@@ -122,10 +212,41 @@ func ensureStorageDirectory(storagePath string, partitionCount uint32, blockSize
 	return nil
 }
 
+// DataType returns the dataType recorded at the storage's creation, empty for the storages created before it was
+// recorded.
+func (storage *Storage) DataType() string {
+
+	return storage.metadata.DataType
+
+}
+
+// Precision returns the precision of the stored timestamps, PrecisionSeconds for the storages created before it was
+// recorded.
+func (storage *Storage) Precision() string {
+
+	return NormalizePrecision(storage.metadata.Precision)
+
+}
+
+// Compacted reports whether the storage is a read-only segment.
+func (storage *Storage) Compacted() bool {
+
+	return storage.segment != nil
+
+}
+
 // -------------- Storage Engine Interface functions -----------------
 
 func (storage *Storage) Put(key uint32, value []byte) error {
 
+	if storage.segment != nil {
+
+		return ErrStorageImmutable
+
+	}
+
+	defer storage.metrics.StoragePutDuration.ObserveSince(time.Now())
+
 	file, err := storage.openFilesPool.GetFileMapping(key%storage.partitionCount, storage.storagePath)
 
 	if err != nil {
@@ -160,6 +281,31 @@ func (storage *Storage) Put(key uint32, value []byte) error {
 
 func (storage *Storage) Get(key uint32) ([]byte, error) {
 
+	if storage.segment != nil {
+
+		entry, found := storage.segment.entry(key)
+
+		if !found {
+
+			return nil, ErrObjectDoesNotExist
+
+		}
+
+		data, ok, err := storage.segment.get(entry)
+
+		// The key's data is skipped like a corrupt block
+		if err == nil && !ok {
+
+			storage.reportCorruptBlock(key, entry.Offset)
+
+			return []byte{}, nil
+
+		}
+
+		return data, err
+
+	}
+
 	file, err := storage.openFilesPool.GetFileMapping(key%storage.partitionCount, storage.storagePath)
 
 	if err != nil {
@@ -184,14 +330,101 @@ func (storage *Storage) Get(key uint32) ([]byte, error) {
 
 	}
 
-	data := file.ReadBlocks(blocks, storage.blockSize)
+	data, corruptBlocks := file.ReadBlocks(blocks, storage.blockSize)
+
+	for _, block := range corruptBlocks {
+
+		storage.reportCorruptBlock(key, block.Offset)
+
+	}
 
 	return data, nil
 
 }
 
+// Verify checks the blocks of every key against their checksums, returning the number of corrupt blocks found.
+func (storage *Storage) Verify() (int, error) {
+
+	var corrupt int
+
+	if storage.segment != nil {
+
+		for _, entry := range storage.segment.index.Entries {
+
+			_, ok, err := storage.segment.read(entry)
+
+			if err != nil {
+
+				return corrupt, err
+
+			}
+
+			if !ok {
+
+				storage.reportCorruptBlock(entry.Key, entry.Offset)
+
+				corrupt++
+
+			}
+
+		}
+
+		return corrupt, nil
+
+	}
+
+	for partitionIndex := range storage.partitionCount {
+
+		file, err := storage.openFilesPool.GetFileMapping(partitionIndex, storage.storagePath)
+
+		if err != nil {
+
+			return corrupt, err
+
+		}
+
+		index, err := storage.indexPool.Get(partitionIndex, storage.storagePath)
+
+		if err != nil {
+
+			return corrupt, err
+
+		}
+
+		for _, key := range index.Keys() {
+
+			for _, block := range file.VerifyBlocks(index.GetIndexObjectBlocks(key), storage.blockSize) {
+
+				storage.reportCorruptBlock(key, block.Offset)
+
+				corrupt++
+
+			}
+
+		}
+
+	}
+
+	return corrupt, nil
+
+}
+
+func (storage *Storage) reportCorruptBlock(key uint32, offset uint64) {
+
+	storage.metrics.CorruptBlocks.Add(1)
+
+	storage.logger.Error("corrupt block skipped, checksum mismatch", zap.String("storagePath", storage.storagePath), zap.Uint32("key", key), zap.Uint64("offset", offset))
+
+}
+
 func (storage *Storage) GetAllKeys() ([]uint32, error) {
 
+	if storage.segment != nil {
+
+		return storage.segment.keys(), nil
+
+	}
+
 	keys := make([]uint32, 0)
 
 	// Get all keys from all partitions
@@ -219,6 +452,18 @@ func (storage *Storage) GetAllKeys() ([]uint32, error) {
 
 func (storage *Storage) ClearStorage() {
 
+	if storage.segment != nil {
+
+		if err := storage.segment.close(); err != nil {
+
+			storage.logger.Error("error closing segment", zap.String("storagePath", storage.storagePath), zap.Error(err))
+
+		}
+
+		return
+
+	}
+
 	storage.openFilesPool.Close()
 
 	storage.indexPool.Close(storage.storagePath)
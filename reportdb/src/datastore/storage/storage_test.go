@@ -3,12 +3,26 @@ package storage
 import (
 	. "datastore/utils"
 	"fmt"
+	"os"
+	"strings"
 	"testing"
 )
 
+func testConfig() *Config {
+
+	config := DefaultConfig()
+
+	config.Partitions, config.BlockSize = 5, 120
+
+	return config
+
+}
+
 func TestNewStorage(t *testing.T) {
 
-	_, err := NewStorage(StorageDirectory+"/2025/4/2/1/", 5, 120, true)
+	config := testConfig()
+
+	_, err := NewStorage(config.StorageDirectory+"/2025/4/2/1/", Metadata{DataType: "string"}, config, true)
 
 	if err != nil {
 		t.Error(err)
@@ -17,7 +31,9 @@ func TestNewStorage(t *testing.T) {
 
 func TestStorage_Put(t *testing.T) {
 
-	storage, err := NewStorage(StorageDirectory+"/2025/4/2/1/", 5, 120, true)
+	config := testConfig()
+
+	storage, err := NewStorage(config.StorageDirectory+"/2025/4/2/1/", Metadata{DataType: "string"}, config, true)
 
 	if err != nil {
 		t.Error(err)
@@ -55,7 +71,9 @@ func TestStorage_Put(t *testing.T) {
 
 func TestStorage_Get(t *testing.T) {
 
-	storage, err := NewStorage(StorageDirectory+"/2025/4/2/1/", 5, 120, false)
+	config := testConfig()
+
+	storage, err := NewStorage(config.StorageDirectory+"/2025/4/2/1/", Metadata{DataType: "string"}, config, false)
 
 	if err != nil {
 
@@ -72,3 +90,286 @@ func TestStorage_Get(t *testing.T) {
 	fmt.Println(string(data))
 
 }
+
+func TestStorageChecksums(t *testing.T) {
+
+	config := testConfig()
+
+	storagePath := t.TempDir() + "/2025/4/2/1"
+
+	storage, err := NewStorage(storagePath, Metadata{DataType: "string"}, config, true)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer storage.ClearStorage()
+
+	// Batches of 50 bytes over blocks of 120, the second block holding the end of C, D and the start of E
+	for _, batch := range []string{"A", "B", "C", "D", "E"} {
+
+		if err = storage.Put(7, []byte(strings.Repeat(batch, 50))); err != nil {
+
+			t.Fatal(err)
+
+		}
+
+	}
+
+	file, _ := storage.openFilesPool.GetFileMapping(7%config.Partitions, storagePath)
+
+	index, _ := storage.indexPool.Get(7%config.Partitions, storagePath)
+
+	blocks := index.GetIndexObjectBlocks(7)
+
+	expectations := []struct {
+		block    int
+		expected string
+	}{
+		// The batch running into the corrupt block is dropped, as are those running over from it
+		{1, strings.Repeat("A", 50) + strings.Repeat("B", 50)},
+		{0, strings.Repeat("D", 50) + strings.Repeat("E", 50)},
+	}
+
+	for _, expectation := range expectations {
+
+		offset := blocks[expectation.block].Offset + 5
+
+		partition, _ := os.ReadFile(storagePath + "/data_2.bin")
+
+		original := partition[offset]
+
+		_ = file.WriteAt([]byte{original ^ 0xFF}, offset)
+
+		corruptBlocks := config.Metrics.CorruptBlocks.Value()
+
+		data, err := storage.Get(7)
+
+		if err != nil || string(data) != expectation.expected || config.Metrics.CorruptBlocks.Value() != corruptBlocks+1 {
+
+			t.Errorf("block %d corrupt: unexpected data %s, %v", expectation.block, data, err)
+
+		}
+
+		if corrupt, err := storage.Verify(); corrupt != 1 || err != nil {
+
+			t.Errorf("block %d corrupt: expected 1 corrupt block, got %d, %v", expectation.block, corrupt, err)
+
+		}
+
+		_ = file.WriteAt([]byte{original}, offset)
+
+	}
+
+	if corrupt, err := storage.Verify(); corrupt != 0 || err != nil {
+
+		t.Errorf("expected no corrupt block, got %d, %v", corrupt, err)
+
+	}
+
+}
+
+func TestStorageLegacyBlocks(t *testing.T) {
+
+	config := testConfig()
+
+	storagePath := t.TempDir() + "/2025/4/2/1"
+
+	storage, err := NewStorage(storagePath, Metadata{DataType: "string"}, config, true)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer storage.ClearStorage()
+
+	for _, batch := range []string{"A", "B", "C", "D", "E"} {
+
+		if err = storage.Put(7, []byte(strings.Repeat(batch, 50))); err != nil {
+
+			t.Fatal(err)
+
+		}
+
+	}
+
+	file, _ := storage.openFilesPool.GetFileMapping(7%config.Partitions, storagePath)
+
+	index, _ := storage.indexPool.Get(7%config.Partitions, storagePath)
+
+	// The first block was written before checksums, the second one is corrupt
+	index.ObjectIndex[7][0].Checksummed = false
+
+	_ = file.WriteAt([]byte{'X'}, index.ObjectIndex[7][1].Offset+5)
+
+	// The legacy block's data is kept whole, batch C running into the corrupt block included
+	if data, err := storage.Get(7); err != nil || string(data) != strings.Repeat("A", 50)+strings.Repeat("B", 50)+strings.Repeat("C", 20) {
+
+		t.Errorf("unexpected data %s, %v", data, err)
+
+	}
+
+}
+
+func TestStorageConcurrentReads(t *testing.T) {
+
+	config := testConfig()
+
+	storagePath := t.TempDir() + "/2025/4/2/1"
+
+	storage, err := NewStorage(storagePath, Metadata{DataType: "string"}, config, true)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer storage.ClearStorage()
+
+	written := make(chan struct{})
+
+	go func() {
+
+		defer close(written)
+
+		for range 200 {
+
+			if err := storage.Put(7, []byte(strings.Repeat("A", 50))); err != nil {
+
+				t.Error(err)
+
+				return
+
+			}
+
+		}
+
+	}()
+
+	// Reads of the key being appended to never see a capacity and checksum that don't belong together
+	for reading := true; reading; {
+
+		select {
+
+		case <-written:
+
+			reading = false
+
+		default:
+
+		}
+
+		if _, err := storage.Get(7); err != nil && err != ErrObjectDoesNotExist {
+
+			t.Fatal(err)
+
+		}
+
+	}
+
+	if corrupt := config.Metrics.CorruptBlocks.Value(); corrupt != 0 {
+
+		t.Errorf("expected no corrupt block, got %d", corrupt)
+
+	}
+
+}
+
+func TestCompactStorage(t *testing.T) {
+
+	config := testConfig()
+
+	storagePath := t.TempDir() + "/2025/4/2/1"
+
+	storage, err := NewStorage(storagePath, Metadata{DataType: "string", Precision: PrecisionMilliseconds}, config, true)
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	instanceKey, _ := storage.SeriesKey(3, "eth0")
+
+	expected := map[uint32]string{1: strings.Repeat("object 1 ", 40), 2: "object 2", instanceKey: "instance eth0"}
+
+	for key, data := range expected {
+
+		if err = storage.Put(key, []byte(data)); err != nil {
+
+			t.Fatal(err)
+
+		}
+
+	}
+
+	storage.ClearStorage()
+
+	if err = CompactStorage(storagePath, config); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	if files, _ := os.ReadDir(storagePath); len(files) != 1 || files[0].Name() != segmentFileName {
+
+		t.Fatalf("expected the segment alone, got %v", files)
+
+	}
+
+	if storage, err = NewStorage(storagePath, Metadata{}, config, false); err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	defer storage.ClearStorage()
+
+	for key, data := range expected {
+
+		if stored, err := storage.Get(key); err != nil || string(stored) != data {
+
+			t.Errorf("key %d: expected %s, got %s, %v", key, data, stored, err)
+
+		}
+
+	}
+
+	if keys, _ := storage.GetAllKeys(); len(keys) != 3 || storage.Precision() != PrecisionMilliseconds || storage.ObjectInstanceKeys(3)[0] != instanceKey {
+
+		t.Errorf("unexpected keys %v, precision %s or instances", keys, storage.Precision())
+
+	}
+
+	if err = storage.Put(1, []byte("late")); err != ErrStorageImmutable {
+
+		t.Errorf("expected %v, got %v", ErrStorageImmutable, err)
+
+	}
+
+	entry, _ := storage.segment.entry(2)
+
+	segmentFile, _ := os.OpenFile(storagePath+"/"+segmentFileName, os.O_RDWR, 0644)
+
+	_, _ = segmentFile.WriteAt([]byte{0xFF}, int64(entry.Offset))
+
+	_ = segmentFile.Close()
+
+	if data, err := storage.Get(2); err != nil || len(data) != 0 {
+
+		t.Errorf("expected the corrupt entry skipped, got %s, %v", data, err)
+
+	}
+
+	if corrupt, err := storage.Verify(); corrupt != 1 || err != nil {
+
+		t.Errorf("expected 1 corrupt entry, got %d, %v", corrupt, err)
+
+	}
+
+}
@@ -2,44 +2,137 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/bytedance/gopkg/util/gctuner"
 	"go.uber.org/zap"
 	"log"
 	"os"
+	"sync"
 	"syscall"
+	"time"
 )
 
 const DataType = "dataType"
 
-var CounterConfig = map[uint16]map[string]interface{}{}
-
-var (
-	Writers                   int
-	DataWriteChannelSize      int
-	Readers                   int
-	ReaderRequestChannelSize  int
-	ReaderResponseChannelSize int
-	QueryParsers              int
-	QueryChannelSize          int
-	QueryTimeoutTime          int
-	Partitions                uint32
-	BlockSize                 uint32
-	FileSizeGrowthDelta       int64
-	InitialFileSize           int64
-	StorageCleanupInterval    int
-	MaxCacheKeys              int64
-	MaxCacheSizeInMB          int64
-	PollListenerBindPort      string
-	QueryListenerBindPort     string
-	QueryResultBindPort       string
-	ProfilingPort             string
-	StorageDirectory          string
-	IsProductionEnvironment   bool
-	MaxLogFileSizeInMB        int
-	LogFileRetentionInDays    int
-)
+// Precision of the counter's timestamps, see PrecisionSeconds
+const Precision = "precision"
+
+var ErrCounterExists = errors.New("counter already exists")
+
+var ErrUnsupportedDataType = errors.New("unsupported data type")
+
+var ErrCounterDataTypeChange = errors.New("data type change would make the counter's stored data undecodable")
+
+var ErrNoCountersFile = errors.New("config has no counters file to reload")
+
+// dataTypeEncodings maps every supported dataType to its on-disk encoding, dataTypes sharing an encoding can read
+// each other's stored data.
+var dataTypeEncodings = map[string]string{
+	"float64":   "float64",
+	"float32":   "float32",
+	"uint64":    "64bit",
+	"uint":      "64bit",
+	"int64":     "64bit",
+	"int":       "64bit",
+	"uint32":    "32bit",
+	"int32":     "32bit",
+	"string":    "string",
+	"bool":      "bool",
+	"enum":      "enum",
+	"histogram": "histogram",
+}
+
+// CounterConfig is the dataType and timestamp precision of a counter.
+type CounterConfig struct {
+	DataType string
+
+	Precision string
+}
+
+// Config holds the counters, tunables and loggers of a datastore. Every component of a datastore is given the same
+// Config, so that datastores with different configs can run side by side in a process.
+type Config struct {
+	counters map[uint16]map[string]interface{}
+
+	// Counters may be added at runtime, like the derived counters of continuous queries
+	countersLock sync.RWMutex
+
+	Writers                       int
+	DataWriteChannelSize          int
+	Readers                       int
+	ReaderRequestChannelSize      int
+	ReaderResponseChannelSize     int
+	QueryParsers                  int
+	QueryChannelSize              int
+	QueryTimeoutTime              int
+	SlowQueryThresholdInMS        int
+	ContinuousQueryGracePeriod    int
+	LatestValuesPersistInterval   int
+	LatestValuesRetentionInDays   int
+	ResultChunkSize               int
+	InteractiveDayScanBudget      int
+	BatchDayScanBudget            int
+	InteractiveAdmissionQueueSize int
+	BatchAdmissionQueueSize       int
+	Partitions                    uint32
+	BlockSize                     uint32
+	FileSizeGrowthDelta           int64
+	InitialFileSize               int64
+	StorageCleanupInterval        int
+	ScrubInterval                 int
+	CompactionInterval            int
+	CompactionAgeInDays           int
+	DiskCheckInterval             int
+	MaxCacheKeys                  int64
+	MaxCacheSizeInMB              int64
+	MaxQueryCacheKeys             int64
+	MaxQueryCacheSizeInMB         int64
+	PollListenerBindPort          string
+	QueryListenerBindPort         string
+	QueryResultBindPort           string
+	ProfilingPort                 string
+	MetricsPort                   string
+	StorageDirectory              string
+
+	// Ingest is rejected while the free space of StorageDirectory is below MinFreeDiskSpaceInMB. Below
+	// RetentionFreeDiskSpaceInMB an emergency retention deletes the oldest days till it is not, never when 0.
+	MinFreeDiskSpaceInMB int64
+
+	RetentionFreeDiskSpaceInMB int64
+
+	// CountersFile is the counters.json the counters were loaded from, reloaded by ReloadCounters
+	CountersFile string
+
+	IsProductionEnvironment bool
+	MaxLogFileSizeInMB      int
+	LogFileRetentionInDays  int
+
+	// Interval of the writers' batch buffer flush
+	FlushDuration time.Duration
+
+	Logger *zap.Logger
+
+	// SlowQueryLogger records the queries taking longer than SlowQueryThresholdInMS, in its own file and at any environment.
+	SlowQueryLogger *zap.Logger
+
+	Metrics *Metrics
+}
 
-func LoadConfig() (err error) {
+// DefaultConfig returns the config shipped in config/general.json without any counter, its loggers discard the logs.
+func DefaultConfig() *Config {
+
+	config := newConfig()
+
+	config.applyGeneralConfig(defaultGeneralConfig())
+
+	return config
+
+}
+
+// LoadConfig reads the counter and general config files of the working directory, keys missing from the general
+// config keep their default.
+func LoadConfig() (config *Config, err error) {
 
 	defer func() {
 
@@ -47,7 +140,7 @@ func LoadConfig() (err error) {
 
 			log.Println("Panic while Loading Config: ", r)
 
-			err = r.(error)
+			err = fmt.Errorf("%v", r)
 
 		}
 
@@ -55,104 +148,405 @@ func LoadConfig() (err error) {
 
 	currentWorkingDirectory, _ := os.Getwd()
 
-	StorageDirectory = currentWorkingDirectory + "/data"
-
 	configFilesDir := currentWorkingDirectory + "/config"
 
-	countersConfigBytes, err := os.ReadFile(configFilesDir + "/counters.json")
+	config = newConfig()
 
-	if err != nil {
+	config.StorageDirectory = currentWorkingDirectory + "/data"
+
+	config.CountersFile = configFilesDir + "/counters.json"
 
-		Logger.Info("Unable to read counter file: ", zap.Error(err))
+	if config.counters, err = readCountersFile(config.CountersFile); err != nil {
 
-		return err
+		return nil, err
 
 	}
 
-	if err = json.Unmarshal(countersConfigBytes, &CounterConfig); err != nil {
+	generalConfigBytes, err := os.ReadFile(configFilesDir + "/general.json")
 
-		Logger.Info("Unable to unmarshal counter config data: ", zap.Error(err))
+	if err != nil {
 
-		return err
+		return nil, fmt.Errorf("unable to read general config file: %w", err)
 
 	}
 
-	generalConfigBytes, err := os.ReadFile(configFilesDir + "/general.json")
+	generalConfig := defaultGeneralConfig()
 
-	if err != nil {
-
-		Logger.Info("Unable to read general config file: ", zap.Error(err))
+	if err = json.Unmarshal(generalConfigBytes, &generalConfig); err != nil {
 
-		return err
+		return nil, fmt.Errorf("unable to unmarshal general config data: %w", err)
 
 	}
 
-	var generalConfig map[string]interface{}
+	config.applyGeneralConfig(generalConfig)
 
-	if err = json.Unmarshal(generalConfigBytes, &generalConfig); err != nil {
+	//Get system memory and set GC tuning
+	memoryThreshold := (sysTotalMemory() * uint64(generalConfig["MemoryFraction"].(float64))) / 100
+
+	gctuner.Tuning(memoryThreshold)
+
+	return config, nil
+
+}
+
+func newConfig() *Config {
 
-		Logger.Info("Unable to unmarshal general config data: ", zap.Error(err))
+	return &Config{
 
-		return err
+		counters: make(map[uint16]map[string]interface{}),
 
+		FlushDuration: time.Second * 5,
+
+		Logger: zap.NewNop(),
+
+		SlowQueryLogger: zap.NewNop(),
+
+		Metrics: NewMetrics(),
 	}
 
+}
+
+func (config *Config) applyGeneralConfig(generalConfig map[string]interface{}) {
+
 	// Set General Config Variables
-	Writers = int(generalConfig["Writers"].(float64))
+	config.Writers = int(generalConfig["Writers"].(float64))
+
+	config.DataWriteChannelSize = int(generalConfig["DataWriteChannelSize"].(float64))
+
+	config.Readers = int(generalConfig["Readers"].(float64))
+
+	config.ReaderRequestChannelSize = int(generalConfig["ReaderRequestChannelSize"].(float64))
+
+	config.ReaderResponseChannelSize = int(generalConfig["ReaderResponseChannelSize"].(float64))
+
+	config.QueryParsers = int(generalConfig["QueryParsers"].(float64))
 
-	DataWriteChannelSize = int(generalConfig["DataWriteChannelSize"].(float64))
+	config.QueryChannelSize = int(generalConfig["QueryChannelSize"].(float64))
 
-	Readers = int(generalConfig["Readers"].(float64))
+	config.QueryTimeoutTime = int(generalConfig["QueryTimeoutTime"].(float64))
 
-	ReaderRequestChannelSize = int(generalConfig["ReaderRequestChannelSize"].(float64))
+	config.SlowQueryThresholdInMS = int(generalConfig["SlowQueryThresholdInMS"].(float64))
 
-	ReaderResponseChannelSize = int(generalConfig["ReaderResponseChannelSize"].(float64))
+	config.ContinuousQueryGracePeriod = int(generalConfig["ContinuousQueryGracePeriod"].(float64))
 
-	QueryParsers = int(generalConfig["QueryParsers"].(float64))
+	config.LatestValuesPersistInterval = int(generalConfig["LatestValuesPersistInterval"].(float64))
 
-	QueryChannelSize = int(generalConfig["QueryChannelSize"].(float64))
+	config.LatestValuesRetentionInDays = int(generalConfig["LatestValuesRetentionInDays"].(float64))
 
-	QueryTimeoutTime = int(generalConfig["QueryTimeoutTime"].(float64))
+	config.ResultChunkSize = int(generalConfig["ResultChunkSize"].(float64))
 
-	Partitions = uint32(generalConfig["Partitions"].(float64))
+	config.InteractiveDayScanBudget = int(generalConfig["InteractiveDayScanBudget"].(float64))
 
-	BlockSize = uint32(generalConfig["BlockSize"].(float64))
+	config.BatchDayScanBudget = int(generalConfig["BatchDayScanBudget"].(float64))
+
+	config.InteractiveAdmissionQueueSize = int(generalConfig["InteractiveAdmissionQueueSize"].(float64))
+
+	config.BatchAdmissionQueueSize = int(generalConfig["BatchAdmissionQueueSize"].(float64))
+
+	config.Partitions = uint32(generalConfig["Partitions"].(float64))
+
+	config.BlockSize = uint32(generalConfig["BlockSize"].(float64))
 
 	pageSize := int64(os.Getpagesize())
 
-	InitialFileSize = int64(generalConfig["InitialFileSize"].(float64)) * pageSize
+	config.InitialFileSize = int64(generalConfig["InitialFileSize"].(float64)) * pageSize
 
-	FileSizeGrowthDelta = int64(generalConfig["FileSizeGrowthDelta"].(float64)) * pageSize
+	config.FileSizeGrowthDelta = int64(generalConfig["FileSizeGrowthDelta"].(float64)) * pageSize
 
-	StorageCleanupInterval = int(generalConfig["StorageCleanupInterval"].(float64))
+	config.StorageCleanupInterval = int(generalConfig["StorageCleanupInterval"].(float64))
 
-	MaxCacheKeys = int64(generalConfig["MaxCacheKeys"].(float64))
+	config.ScrubInterval = int(generalConfig["ScrubInterval"].(float64))
 
-	MaxCacheSizeInMB = int64(generalConfig["MaxCacheSizeInMB"].(float64))
+	config.CompactionInterval = int(generalConfig["CompactionInterval"].(float64))
 
-	PollListenerBindPort = generalConfig["PollListenerBindPort"].(string)
+	config.CompactionAgeInDays = int(generalConfig["CompactionAgeInDays"].(float64))
 
-	QueryListenerBindPort = generalConfig["QueryListenerBindPort"].(string)
+	config.DiskCheckInterval = int(generalConfig["DiskCheckInterval"].(float64))
 
-	QueryResultBindPort = generalConfig["QueryResultBindPort"].(string)
+	config.MinFreeDiskSpaceInMB = int64(generalConfig["MinFreeDiskSpaceInMB"].(float64))
 
-	ProfilingPort = generalConfig["ProfilingPort"].(string)
+	config.RetentionFreeDiskSpaceInMB = int64(generalConfig["RetentionFreeDiskSpaceInMB"].(float64))
 
-	IsProductionEnvironment = generalConfig["IsProductionEnvironment"].(bool)
+	config.MaxCacheKeys = int64(generalConfig["MaxCacheKeys"].(float64))
 
-	MaxLogFileSizeInMB = int(generalConfig["MaxLogFileSizeInMB"].(float64))
+	config.MaxCacheSizeInMB = int64(generalConfig["MaxCacheSizeInMB"].(float64))
 
-	LogFileRetentionInDays = int(generalConfig["LogFileRetentionInDays"].(float64))
+	config.MaxQueryCacheKeys = int64(generalConfig["MaxQueryCacheKeys"].(float64))
 
-	//Get system memory and set GC tuning
-	memoryThreshold := (sysTotalMemory() * uint64(generalConfig["MemoryFraction"].(float64))) / 100
+	config.MaxQueryCacheSizeInMB = int64(generalConfig["MaxQueryCacheSizeInMB"].(float64))
 
-	gctuner.Tuning(memoryThreshold)
+	config.PollListenerBindPort = generalConfig["PollListenerBindPort"].(string)
+
+	config.QueryListenerBindPort = generalConfig["QueryListenerBindPort"].(string)
+
+	config.QueryResultBindPort = generalConfig["QueryResultBindPort"].(string)
+
+	config.ProfilingPort = generalConfig["ProfilingPort"].(string)
+
+	config.MetricsPort = generalConfig["MetricsPort"].(string)
+
+	config.IsProductionEnvironment = generalConfig["IsProductionEnvironment"].(bool)
+
+	config.MaxLogFileSizeInMB = int(generalConfig["MaxLogFileSizeInMB"].(float64))
+
+	config.LogFileRetentionInDays = int(generalConfig["LogFileRetentionInDays"].(float64))
+
+}
+
+// defaultGeneralConfig returns the general config shipped in config/general.json.
+func defaultGeneralConfig() map[string]interface{} {
+
+	return map[string]interface{}{
+		"Writers":                       5.0,
+		"DataWriteChannelSize":          100.0,
+		"Readers":                       7.0,
+		"ReaderRequestChannelSize":      20.0,
+		"ReaderResponseChannelSize":     20.0,
+		"QueryParsers":                  10.0,
+		"QueryChannelSize":              100.0,
+		"QueryTimeoutTime":              30.0,
+		"SlowQueryThresholdInMS":        2000.0,
+		"ContinuousQueryGracePeriod":    15.0,
+		"LatestValuesPersistInterval":   30.0,
+		"LatestValuesRetentionInDays":   30.0,
+		"ResultChunkSize":               50000.0,
+		"InteractiveDayScanBudget":      200.0,
+		"BatchDayScanBudget":            60.0,
+		"InteractiveAdmissionQueueSize": 50.0,
+		"BatchAdmissionQueueSize":       4.0,
+		"Partitions":                    5.0,
+		"BlockSize":                     1024.0,
+		"FileSizeGrowthDelta":           10.0,
+		"InitialFileSize":               5.0,
+		"StorageCleanupInterval":        300.0,
+		"ScrubInterval":                 86400.0,
+		"CompactionInterval":            3600.0,
+		"CompactionAgeInDays":           1.0,
+		"DiskCheckInterval":             10.0,
+		"MinFreeDiskSpaceInMB":          512.0,
+		"RetentionFreeDiskSpaceInMB":    0.0,
+		"MaxCacheKeys":                  5000.0,
+		"MaxCacheSizeInMB":              500.0,
+		"MaxQueryCacheKeys":             10000.0,
+		"MaxQueryCacheSizeInMB":         100.0,
+		"PollListenerBindPort":          "7000",
+		"QueryListenerBindPort":         "7001",
+		"QueryResultBindPort":           "7002",
+		"ProfilingPort":                 "6060",
+		"MetricsPort":                   "7003",
+		"IsProductionEnvironment":       false,
+		"MaxLogFileSizeInMB":            10.0,
+		"LogFileRetentionInDays":        10.0,
+		"MemoryFraction":                60.0,
+	}
+
+}
+
+// CounterDataType returns the dataType of the counter, ok is false for an unknown counter.
+func (config *Config) CounterDataType(counterId uint16) (dataType string, ok bool) {
+
+	config.countersLock.RLock()
+
+	defer config.countersLock.RUnlock()
+
+	counter, ok := config.counters[counterId]
+
+	if !ok {
+
+		return "", false
+
+	}
+
+	return counter[DataType].(string), true
+
+}
+
+// CounterPrecision returns the precision of the counter's timestamps, PrecisionSeconds unless configured otherwise.
+func (config *Config) CounterPrecision(counterId uint16) string {
+
+	config.countersLock.RLock()
+
+	defer config.countersLock.RUnlock()
+
+	precision, _ := config.counters[counterId][Precision].(string)
+
+	return NormalizePrecision(precision)
+
+}
+
+// CounterDataTypes returns the dataType of every configured counter.
+func (config *Config) CounterDataTypes() map[uint16]string {
+
+	config.countersLock.RLock()
+
+	defer config.countersLock.RUnlock()
+
+	dataTypes := make(map[uint16]string, len(config.counters))
+
+	for counterId, counter := range config.counters {
+
+		dataTypes[counterId] = counter[DataType].(string)
+
+	}
+
+	return dataTypes
+
+}
+
+// AddCounter adds a counter to the counter config at runtime.
+func (config *Config) AddCounter(counterId uint16, dataType string) error {
+
+	config.countersLock.Lock()
+
+	defer config.countersLock.Unlock()
+
+	if _, ok := config.counters[counterId]; ok {
+
+		return ErrCounterExists
+
+	}
+
+	config.counters[counterId] = map[string]interface{}{DataType: dataType}
 
 	return nil
 
 }
 
+// SupportedDataType reports whether counters can be configured with the dataType.
+func SupportedDataType(dataType string) bool {
+
+	_, ok := dataTypeEncodings[dataType]
+
+	return ok
+
+}
+
+// RegisterCounters adds the new counters and applies the dataType and precision changes of the known ones, all or none
+// of them. A dataType change is refused with ErrCounterDataTypeChange when hasData reports stored data for the counter
+// and the new dataType encodes it differently, precisions may change as every day records its own. Counters absent
+// from counters are kept. The added counterIds are returned.
+func (config *Config) RegisterCounters(counters map[uint16]CounterConfig, hasData func(counterId uint16) bool) ([]uint16, error) {
+
+	config.countersLock.Lock()
+
+	defer config.countersLock.Unlock()
+
+	added := make([]uint16, 0)
+
+	for counterId, counterConfig := range counters {
+
+		dataType := counterConfig.DataType
+
+		encoding, ok := dataTypeEncodings[dataType]
+
+		if !ok {
+
+			return nil, fmt.Errorf("counter c%d: %w: %s", counterId, ErrUnsupportedDataType, dataType)
+
+		}
+
+		if !SupportedPrecision(counterConfig.Precision) {
+
+			return nil, fmt.Errorf("counter c%d: %w", counterId, ErrUnsupportedPrecision)
+
+		}
+
+		counter, ok := config.counters[counterId]
+
+		if !ok {
+
+			added = append(added, counterId)
+
+			continue
+
+		}
+
+		configured := counter[DataType].(string)
+
+		if configured != dataType && dataTypeEncodings[configured] != encoding && hasData(counterId) {
+
+			return nil, fmt.Errorf("counter c%d from %s to %s: %w", counterId, configured, dataType, ErrCounterDataTypeChange)
+
+		}
+
+	}
+
+	for counterId, counterConfig := range counters {
+
+		config.counters[counterId] = map[string]interface{}{
+
+			DataType: counterConfig.DataType,
+
+			Precision: NormalizePrecision(counterConfig.Precision),
+		}
+
+	}
+
+	return added, nil
+
+}
+
+// ReloadCounters registers the counters of the CountersFile, see RegisterCounters.
+func (config *Config) ReloadCounters(hasData func(counterId uint16) bool) ([]uint16, error) {
+
+	if config.CountersFile == "" {
+
+		return nil, ErrNoCountersFile
+
+	}
+
+	countersConfig, err := readCountersFile(config.CountersFile)
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	counters := make(map[uint16]CounterConfig, len(countersConfig))
+
+	for counterId, counter := range countersConfig {
+
+		dataType, ok := counter[DataType].(string)
+
+		if !ok {
+
+			return nil, fmt.Errorf("counter c%d: %w: %v", counterId, ErrUnsupportedDataType, counter[DataType])
+
+		}
+
+		precision, _ := counter[Precision].(string)
+
+		counters[counterId] = CounterConfig{DataType: dataType, Precision: precision}
+
+	}
+
+	return config.RegisterCounters(counters, hasData)
+
+}
+
+func readCountersFile(path string) (map[uint16]map[string]interface{}, error) {
+
+	countersConfigBytes, err := os.ReadFile(path)
+
+	if err != nil {
+
+		return nil, fmt.Errorf("unable to read counter file: %w", err)
+
+	}
+
+	counters := make(map[uint16]map[string]interface{})
+
+	if err = json.Unmarshal(countersConfigBytes, &counters); err != nil {
+
+		return nil, fmt.Errorf("unable to unmarshal counter config data: %w", err)
+
+	}
+
+	return counters, nil
+
+}
+
 func sysTotalMemory() uint64 {
 
 	in := &syscall.Sysinfo_t{}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var ErrStorageDirectoryLocked = errors.New("storage directory is in use by another process")
+
+// LockStorageDirectory takes an exclusive lock on the storage directory, so that the server and the offline tools
+// never write the storages at the same time. The returned function releases it.
+func LockStorageDirectory(directory string) (func(), error) {
+
+	lockFile, err := os.OpenFile(directory+"/.lock", os.O_CREATE|os.O_RDWR, 0644)
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	if err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+
+		_ = lockFile.Close()
+
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+
+			return nil, ErrStorageDirectoryLocked
+
+		}
+
+		return nil, err
+
+	}
+
+	return func() {
+
+		_ = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+
+		_ = lockFile.Close()
+
+	}, nil
+
+}
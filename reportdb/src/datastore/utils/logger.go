@@ -8,9 +8,8 @@ import (
 	"time"
 )
 
-var Logger *zap.Logger
-
-func InitLogger() error {
+// InitLogger replaces the config's discarding loggers with the rotating file loggers.
+func InitLogger(config *Config) error {
 
 	if err := os.MkdirAll("./logs/", os.ModePerm); err != nil {
 
@@ -18,7 +17,7 @@ func InitLogger() error {
 
 	}
 
-	if IsProductionEnvironment {
+	if config.IsProductionEnvironment {
 
 		encoderConfig := zap.NewProductionEncoderConfig()
 
@@ -28,11 +27,11 @@ func InitLogger() error {
 
 			Filename: "./logs/prod_" + time.Now().Format("2006_01_02") + ".log",
 
-			MaxSize: MaxLogFileSizeInMB, // In MB
+			MaxSize: config.MaxLogFileSizeInMB, // In MB
 
 			MaxBackups: 3,
 
-			MaxAge: LogFileRetentionInDays, // In days
+			MaxAge: config.LogFileRetentionInDays, // In days
 
 			Compress: true,
 		}
@@ -53,7 +52,7 @@ func InitLogger() error {
 			levelEnabler,
 		)
 
-		Logger = zap.New(core, zap.AddCaller())
+		config.Logger = zap.New(core, zap.AddCaller())
 
 	} else {
 
@@ -72,11 +71,11 @@ func InitLogger() error {
 
 			Filename: "./logs/dev_" + time.Now().Format("2006_01_02") + ".log",
 
-			MaxSize: MaxLogFileSizeInMB,
+			MaxSize: config.MaxLogFileSizeInMB,
 
 			MaxBackups: 3,
 
-			MaxAge: LogFileRetentionInDays,
+			MaxAge: config.LogFileRetentionInDays,
 
 			Compress: false,
 		}
@@ -103,10 +102,36 @@ func InitLogger() error {
 
 		core := zapcore.NewTee(consoleCore, fileCore)
 
-		Logger = zap.New(core, zap.AddCaller())
+		config.Logger = zap.New(core, zap.AddCaller())
+
+	}
+
+	slowQueryEncoderConfig := zap.NewProductionEncoderConfig()
+
+	slowQueryEncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	slowQueryRotatingLogger := &lumberjack.Logger{
+
+		Filename: "./logs/slow_queries_" + time.Now().Format("2006_01_02") + ".log",
 
+		MaxSize: config.MaxLogFileSizeInMB,
+
+		MaxBackups: 3,
+
+		MaxAge: config.LogFileRetentionInDays,
+
+		Compress: config.IsProductionEnvironment,
 	}
 
+	config.SlowQueryLogger = zap.New(zapcore.NewCore(
+
+		zapcore.NewJSONEncoder(slowQueryEncoderConfig),
+
+		zapcore.AddSync(slowQueryRotatingLogger),
+
+		zapcore.InfoLevel,
+	))
+
 	return nil
 
 }
@@ -0,0 +1,392 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"go.uber.org/zap"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Buckets in seconds of the latencies of a single storage operation
+var operationBuckets = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1}
+
+// Buckets in seconds of the flushes and queries
+var requestBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (counter *Counter) Add(delta uint64) {
+
+	counter.value.Add(delta)
+
+}
+
+func (counter *Counter) Value() uint64 {
+
+	return counter.value.Load()
+
+}
+
+type Gauge struct {
+	value atomic.Int64
+}
+
+func (gauge *Gauge) Add(delta int64) {
+
+	gauge.value.Add(delta)
+
+}
+
+func (gauge *Gauge) Value() int64 {
+
+	return gauge.value.Load()
+
+}
+
+// LatencyHistogram counts the observations falling in each of its buckets, by their upper bound.
+type LatencyHistogram struct {
+	buckets []float64
+
+	// Observations of each bucket, the last one counting those above every bucket
+	counts []atomic.Uint64
+
+	// Float64 bits of the observations' sum
+	sum atomic.Uint64
+
+	count atomic.Uint64
+}
+
+func NewLatencyHistogram(buckets []float64) *LatencyHistogram {
+
+	return &LatencyHistogram{buckets: buckets, counts: make([]atomic.Uint64, len(buckets)+1)}
+
+}
+
+func (histogram *LatencyHistogram) Observe(value float64) {
+
+	bucket, _ := slices.BinarySearch(histogram.buckets, value)
+
+	histogram.counts[bucket].Add(1)
+
+	for {
+
+		sum := histogram.sum.Load()
+
+		if histogram.sum.CompareAndSwap(sum, math.Float64bits(math.Float64frombits(sum)+value)) {
+
+			break
+
+		}
+
+	}
+
+	histogram.count.Add(1)
+
+}
+
+// ObserveSince observes the seconds elapsed since start.
+func (histogram *LatencyHistogram) ObserveSince(start time.Time) {
+
+	histogram.Observe(time.Since(start).Seconds())
+
+}
+
+func (histogram *LatencyHistogram) Count() uint64 {
+
+	return histogram.count.Load()
+
+}
+
+type gaugeFunc struct {
+	name, help, metricType string
+
+	value func() float64
+}
+
+// Metrics of a datastore, served in the Prometheus text format by InitMetrics.
+type Metrics struct {
+	IngestedPoints Counter
+
+	// Points refused by Write while the disk is full
+	RejectedPoints Counter
+
+	CacheHits Counter
+
+	CacheMisses Counter
+
+	QueryTimeouts Counter
+
+	// Blocks found not matching their checksum, by reads and scrubs
+	CorruptBlocks Counter
+
+	// Bytes of the storage files mapped in memory
+	MappedBytes Gauge
+
+	FlushDuration *LatencyHistogram
+
+	StoragePutDuration *LatencyHistogram
+
+	QueryDuration *LatencyHistogram
+
+	// Values read at exposition from the components owning them, see GaugeFunc
+	funcs []*gaugeFunc
+
+	funcsLock sync.Mutex
+}
+
+func NewMetrics() *Metrics {
+
+	return &Metrics{
+
+		FlushDuration: NewLatencyHistogram(requestBuckets),
+
+		StoragePutDuration: NewLatencyHistogram(operationBuckets),
+
+		QueryDuration: NewLatencyHistogram(requestBuckets),
+	}
+
+}
+
+// GaugeFunc exposes the value returned by value as a gauge till unregister is called. The values of the functions
+// registered under the same name are summed, like the depths of the channels of every query parser.
+func (metrics *Metrics) GaugeFunc(name, help string, value func() float64) (unregister func()) {
+
+	return metrics.register(&gaugeFunc{name: name, help: help, metricType: "gauge", value: value})
+
+}
+
+// CounterFunc is GaugeFunc for the values only ever growing.
+func (metrics *Metrics) CounterFunc(name, help string, value func() float64) (unregister func()) {
+
+	return metrics.register(&gaugeFunc{name: name, help: help, metricType: "counter", value: value})
+
+}
+
+func (metrics *Metrics) register(function *gaugeFunc) func() {
+
+	metrics.funcsLock.Lock()
+
+	defer metrics.funcsLock.Unlock()
+
+	metrics.funcs = append(metrics.funcs, function)
+
+	return func() {
+
+		metrics.funcsLock.Lock()
+
+		defer metrics.funcsLock.Unlock()
+
+		metrics.funcs = slices.DeleteFunc(metrics.funcs, func(registered *gaugeFunc) bool {
+
+			return registered == function
+
+		})
+
+	}
+
+}
+
+// CacheHitRatio is the share of the DataPoints cache lookups that were hits, 0 before any lookup.
+func (metrics *Metrics) CacheHitRatio() float64 {
+
+	hits, misses := metrics.CacheHits.Value(), metrics.CacheMisses.Value()
+
+	if hits+misses == 0 {
+
+		return 0
+
+	}
+
+	return float64(hits) / float64(hits+misses)
+
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (metrics *Metrics) WriteTo(writer io.Writer) (int64, error) {
+
+	exposition := &exposition{writer: bufio.NewWriter(writer)}
+
+	exposition.value("reportdb_ingested_points_total", "Data points accepted by Write.", "counter", float64(metrics.IngestedPoints.Value()))
+
+	exposition.value("reportdb_rejected_points_total", "Data points rejected by Write while the disk space is low.", "counter", float64(metrics.RejectedPoints.Value()))
+
+	exposition.value("reportdb_datapoints_cache_hits_total", "DataPoints cache lookups of the readers that were hits.", "counter", float64(metrics.CacheHits.Value()))
+
+	exposition.value("reportdb_datapoints_cache_misses_total", "DataPoints cache lookups of the readers that were misses.", "counter", float64(metrics.CacheMisses.Value()))
+
+	exposition.value("reportdb_datapoints_cache_hit_ratio", "Share of the DataPoints cache lookups that were hits.", "gauge", metrics.CacheHitRatio())
+
+	exposition.value("reportdb_query_timeouts_total", "Queries that timed out, waiting for admission or running.", "counter", float64(metrics.QueryTimeouts.Value()))
+
+	exposition.value("reportdb_corrupt_blocks_total", "Storage blocks found not matching their checksum.", "counter", float64(metrics.CorruptBlocks.Value()))
+
+	exposition.value("reportdb_mapped_bytes", "Bytes of the storage files mapped in memory.", "gauge", float64(metrics.MappedBytes.Value()))
+
+	exposition.histogram("reportdb_flush_duration_seconds", "Duration of the batch buffer flushes, till every batch is handed to a writer.", metrics.FlushDuration)
+
+	exposition.histogram("reportdb_storage_put_duration_seconds", "Duration of Storage.Put.", metrics.StoragePutDuration)
+
+	exposition.histogram("reportdb_query_duration_seconds", "Duration of the queries, from parsing to the result.", metrics.QueryDuration)
+
+	metrics.funcsLock.Lock()
+
+	// Summed by name, in the order of the first registration
+	var names []string
+
+	values := make(map[string]float64)
+
+	functions := make(map[string]*gaugeFunc)
+
+	for _, function := range metrics.funcs {
+
+		if _, ok := functions[function.name]; !ok {
+
+			names = append(names, function.name)
+
+			functions[function.name] = function
+
+		}
+
+		values[function.name] += function.value()
+
+	}
+
+	metrics.funcsLock.Unlock()
+
+	for _, name := range names {
+
+		exposition.value(name, functions[name].help, functions[name].metricType, values[name])
+
+	}
+
+	return exposition.written, exposition.writer.Flush()
+
+}
+
+// ServeHTTP serves the metrics, see WriteTo.
+func (metrics *Metrics) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	_, _ = metrics.WriteTo(writer)
+
+}
+
+type exposition struct {
+	writer *bufio.Writer
+
+	written int64
+}
+
+func (exposition *exposition) line(parts ...string) {
+
+	for _, part := range parts {
+
+		written, _ := exposition.writer.WriteString(part)
+
+		exposition.written += int64(written)
+
+	}
+
+	exposition.writer.WriteByte('\n')
+
+	exposition.written++
+
+}
+
+func (exposition *exposition) header(name, help, metricType string) {
+
+	exposition.line("# HELP ", name, " ", help)
+
+	exposition.line("# TYPE ", name, " ", metricType)
+
+}
+
+func (exposition *exposition) value(name, help, metricType string, value float64) {
+
+	exposition.header(name, help, metricType)
+
+	exposition.line(name, " ", formatFloat(value))
+
+}
+
+func (exposition *exposition) histogram(name, help string, histogram *LatencyHistogram) {
+
+	exposition.header(name, help, "histogram")
+
+	// Buckets are cumulative
+	var count uint64
+
+	for bucket, upperBound := range histogram.buckets {
+
+		count += histogram.counts[bucket].Load()
+
+		exposition.line(name, `_bucket{le="`, formatFloat(upperBound), `"} `, strconv.FormatUint(count, 10))
+
+	}
+
+	count += histogram.counts[len(histogram.buckets)].Load()
+
+	exposition.line(name, `_bucket{le="+Inf"} `, strconv.FormatUint(count, 10))
+
+	exposition.line(name, "_sum ", formatFloat(math.Float64frombits(histogram.sum.Load())))
+
+	exposition.line(name, "_count ", strconv.FormatUint(count, 10))
+
+}
+
+func formatFloat(value float64) string {
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+
+}
+
+// InitMetrics serves the metrics at /metrics of the MetricsPort, in production too, till the returned server is shut
+// down. Disabled, returning nil, when MetricsPort is empty.
+func InitMetrics(config *Config) *http.Server {
+
+	if config.MetricsPort == "" {
+
+		return nil
+
+	}
+
+	mux := http.NewServeMux()
+
+	mux.Handle("/metrics", config.Metrics)
+
+	listener, err := net.Listen("tcp", ":"+config.MetricsPort)
+
+	if err != nil {
+
+		config.Logger.Error("error starting metrics server", zap.Error(err))
+
+		return nil
+
+	}
+
+	server := &http.Server{Handler: mux}
+
+	go func() {
+
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+
+			config.Logger.Error("error serving metrics", zap.Error(err))
+
+		}
+
+	}()
+
+	return server
+
+}
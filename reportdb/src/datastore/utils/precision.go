@@ -0,0 +1,73 @@
+package utils
+
+import "errors"
+
+// Timestamp precisions, of counters and queries. "" stands for PrecisionSeconds, the precision of the counters not
+// configured otherwise and of the days stored before precisions existed.
+const (
+	PrecisionSeconds = "s"
+
+	PrecisionMilliseconds = "ms"
+)
+
+var ErrUnsupportedPrecision = errors.New("unsupported precision, it must be either 's' or 'ms'")
+
+// NormalizePrecision returns the precision, PrecisionSeconds for "".
+func NormalizePrecision(precision string) string {
+
+	if precision == "" {
+
+		return PrecisionSeconds
+
+	}
+
+	return precision
+
+}
+
+// SupportedPrecision reports whether counters and queries can use the precision.
+func SupportedPrecision(precision string) bool {
+
+	switch NormalizePrecision(precision) {
+
+	case PrecisionSeconds, PrecisionMilliseconds:
+
+		return true
+
+	default:
+
+		return false
+
+	}
+
+}
+
+// ConvertTimestamp converts the timestamp from a precision to another, milliseconds are truncated to seconds.
+func ConvertTimestamp(timestamp uint64, from, to string) uint64 {
+
+	from, to = NormalizePrecision(from), NormalizePrecision(to)
+
+	switch {
+
+	case from == to:
+
+		return timestamp
+
+	case to == PrecisionMilliseconds:
+
+		return timestamp * 1000
+
+	default:
+
+		return timestamp / 1000
+
+	}
+
+}
+
+// TimestampSeconds returns the timestamp of the precision in seconds, for the day it belongs to.
+func TimestampSeconds(timestamp uint64, precision string) uint64 {
+
+	return ConvertTimestamp(timestamp, precision, PrecisionSeconds)
+
+}
@@ -7,16 +7,16 @@ import (
 	"os"
 )
 
-func InitProfiling() {
+func InitProfiling(config *Config) {
 
-	if !IsProductionEnvironment {
+	if !config.IsProductionEnvironment {
 
 		// Log processID for debug purposes.
-		Logger.Debug("Process ID: ", zap.Int("id", os.Getpid()))
+		config.Logger.Debug("Process ID: ", zap.Int("id", os.Getpid()))
 
-		if err := http.ListenAndServe("localhost:"+ProfilingPort, nil); err != nil {
+		if err := http.ListenAndServe("localhost:"+config.ProfilingPort, nil); err != nil {
 
-			Logger.Error("error starting profiling server", zap.Error(err))
+			config.Logger.Error("error starting profiling server", zap.Error(err))
 
 		}
 
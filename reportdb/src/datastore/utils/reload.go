@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// InitReloadHandler calls reload on every SIGHUP, till the shutdown channel is closed.
+func InitReloadHandler(reload func(), shutdown <-chan struct{}, logger *zap.Logger) {
+
+	osSignal := make(chan os.Signal, 1)
+
+	signal.Notify(osSignal, syscall.SIGHUP)
+
+	defer signal.Stop(osSignal)
+
+	for {
+
+		select {
+
+		case <-osSignal:
+
+			logger.Info("SIGHUP received, reloading")
+
+			reload()
+
+		case <-shutdown:
+
+			return
+
+		}
+
+	}
+
+}
@@ -1,12 +1,13 @@
 package utils
 
 import (
+	"go.uber.org/zap"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
-func InitShutdownHandler(signalCount int) <-chan bool {
+func InitShutdownHandler(signalCount int, logger *zap.Logger) <-chan bool {
 
 	GlobalShutdown := make(chan bool, signalCount)
 
@@ -26,7 +27,7 @@ func InitShutdownHandler(signalCount int) <-chan bool {
 
 		}
 
-		Logger.Info("global shutdown signals sent.")
+		logger.Info("global shutdown signals sent.")
 
 	}(signalCount)
 
@@ -2,25 +2,32 @@ package writer
 
 import (
 	. "datastore/containers"
+	. "datastore/continuous"
+	. "datastore/storage"
+	. "datastore/utils"
 	"sync"
 	"time"
 )
 
 type BatchBuffer struct {
-	buffer map[StoragePoolKey]map[uint32][]DataPoint // StoragePoolKey -> {Date,CounterId},
+	buffer map[StoragePoolKey]map[SeriesInstance][]DataPoint // StoragePoolKey -> {Date,CounterId},
 
 	flushTicker *time.Ticker
 
 	EmptyBuffer bool
 
+	latestValues *LatestValues
+
+	flushDurations *LatencyHistogram
+
 	flushLock sync.RWMutex
 }
 
-func NewBatchBuffer() *BatchBuffer {
+func NewBatchBuffer(latestValues *LatestValues, flushDuration time.Duration, flushDurations *LatencyHistogram) *BatchBuffer {
 
-	pool := make(map[StoragePoolKey]map[uint32][]DataPoint)
+	pool := make(map[StoragePoolKey]map[SeriesInstance][]DataPoint)
 
-	flushTicker := time.NewTicker(FlushDuration)
+	flushTicker := time.NewTicker(flushDuration)
 
 	return &BatchBuffer{
 
@@ -29,11 +36,15 @@ func NewBatchBuffer() *BatchBuffer {
 		flushTicker: flushTicker,
 
 		EmptyBuffer: true,
+
+		latestValues: latestValues,
+
+		flushDurations: flushDurations,
 	}
 
 }
 
-func (buffer *BatchBuffer) AddDataPoint(key StoragePoolKey, objectId uint32, dataPoint DataPoint) {
+func (buffer *BatchBuffer) AddDataPoint(key StoragePoolKey, series SeriesInstance, dataPoint DataPoint) {
 
 	buffer.flushLock.Lock()
 
@@ -43,21 +54,23 @@ func (buffer *BatchBuffer) AddDataPoint(key StoragePoolKey, objectId uint32, dat
 
 	if _, ok := buffer.buffer[key]; !ok {
 
-		buffer.buffer[key] = make(map[uint32][]DataPoint)
+		buffer.buffer[key] = make(map[SeriesInstance][]DataPoint)
 
 	}
 
-	buffer.buffer[key][objectId] = append(buffer.buffer[key][objectId], dataPoint)
+	buffer.buffer[key][series] = append(buffer.buffer[key][series], dataPoint)
+
+	buffer.latestValues.Update(key.CounterId, series.ObjectId, series.Instance, dataPoint)
 
 }
 
-func (buffer *BatchBuffer) GetDataPoints(key StoragePoolKey, objectId uint32) []DataPoint {
+func (buffer *BatchBuffer) GetDataPoints(key StoragePoolKey, series SeriesInstance) []DataPoint {
 
 	buffer.flushLock.RLock()
 
 	defer buffer.flushLock.RUnlock()
 
-	return buffer.buffer[key][objectId]
+	return buffer.buffer[key][series]
 }
 
 func (buffer *BatchBuffer) Flush(dataChannel chan<- WritableObjectBatch) {
@@ -66,14 +79,19 @@ func (buffer *BatchBuffer) Flush(dataChannel chan<- WritableObjectBatch) {
 
 	defer buffer.flushLock.Unlock()
 
+	// Till every batch is handed to a writer
+	defer buffer.flushDurations.ObserveSince(time.Now())
+
 	for storageKey, objects := range buffer.buffer {
 
-		for objectId, dataPoints := range objects {
+		for series, dataPoints := range objects {
 
 			objectData := WritableObjectBatch{
 				storageKey,
 
-				objectId,
+				series.ObjectId,
+
+				series.Instance,
 
 				dataPoints,
 			}
@@ -81,7 +99,7 @@ func (buffer *BatchBuffer) Flush(dataChannel chan<- WritableObjectBatch) {
 			dataChannel <- objectData
 
 			// Empty the buffer for that object
-			delete(objects, objectId)
+			delete(objects, series)
 
 		}
 
@@ -91,7 +109,7 @@ func (buffer *BatchBuffer) Flush(dataChannel chan<- WritableObjectBatch) {
 
 }
 
-func batchBufferFlushRoutine(batchBuffer *BatchBuffer, writersChannel chan<- WritableObjectBatch, flushRoutineShutdown chan bool) {
+func batchBufferFlushRoutine(batchBuffer *BatchBuffer, writersChannel chan<- WritableObjectBatch, continuousQueries *ContinuousQueries, flushRoutineShutdown chan bool) {
 
 	for {
 
@@ -115,6 +133,23 @@ func batchBufferFlushRoutine(batchBuffer *BatchBuffer, writersChannel chan<- Wri
 
 		case <-batchBuffer.flushTicker.C:
 
+			// Derived counters of the closed continuous query intervals are flushed along with the polled data
+			for _, dataPoint := range continuousQueries.Due(uint64(time.Now().Unix())) {
+
+				batchBuffer.AddDataPoint(StoragePoolKey{
+
+					Date: UnixToDate(dataPoint.Timestamp),
+
+					CounterId: dataPoint.CounterId,
+				}, SeriesInstance{ObjectId: dataPoint.ObjectId}, DataPoint{
+
+					Timestamp: dataPoint.Timestamp,
+
+					Value: dataPoint.Value,
+				})
+
+			}
+
 			if !batchBuffer.EmptyBuffer {
 
 				batchBuffer.Flush(writersChannel)
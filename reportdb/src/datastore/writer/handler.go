@@ -2,49 +2,88 @@ package writer
 
 import (
 	. "datastore/containers"
+	. "datastore/continuous"
+	. "datastore/storage"
 	. "datastore/utils"
 	"go.uber.org/zap"
 	"sync"
-	"time"
 )
 
-var (
-	FlushDuration = time.Second * 5
-)
-
-func InitWriteHandler(dataWriteChannel <-chan []PolledDataPoint, storagePool *StoragePool, shutdownWaitGroup *sync.WaitGroup) {
+func InitWriteHandler(dataWriteChannel <-chan []PolledDataPoint, storagePool *StoragePool, continuousQueries *ContinuousQueries, latestValues *LatestValues, shutdownWaitGroup *sync.WaitGroup) {
 
 	defer shutdownWaitGroup.Done()
 
-	defer Logger.Info("Write Handler Exiting")
+	config := storagePool.Config
 
-	writersChannel := make(chan WritableObjectBatch, Writers)
+	defer config.Logger.Info("Write Handler Exiting")
+
+	writersChannel := make(chan WritableObjectBatch, config.Writers)
 
 	flushRoutineShutdown := make(chan bool)
 
 	var writersWaitGroup sync.WaitGroup
 
-	writersWaitGroup.Add(Writers)
+	writersWaitGroup.Add(config.Writers)
 
-	for range Writers {
+	for range config.Writers {
 
-		go writer(writersChannel, storagePool, &writersWaitGroup)
+		go writer(writersChannel, storagePool, continuousQueries, &writersWaitGroup)
 
 	}
 
-	batchBuffer := NewBatchBuffer()
+	batchBuffer := NewBatchBuffer(latestValues, config.FlushDuration, config.Metrics.FlushDuration)
+
+	latestValuesPersistShutdown := make(chan bool)
 
-	go batchBufferFlushRoutine(batchBuffer, writersChannel, flushRoutineShutdown)
+	go LatestValuesPersistRoutine(latestValues, latestValuesPersistShutdown)
+
+	go batchBufferFlushRoutine(batchBuffer, writersChannel, continuousQueries, flushRoutineShutdown)
 
 	// Listen
 	for polledData := range dataWriteChannel {
 
 		for _, dataPoint := range polledData {
 
-			if _, ok := CounterConfig[dataPoint.CounterId]; !ok {
+			dataType, ok := config.CounterDataType(dataPoint.CounterId)
+
+			if !ok {
 
 				// Invalid counterId, skip
-				Logger.Info("bad counterId, dropping dataPoint.", zap.Any("dataPoint", dataPoint))
+				config.Logger.Info("bad counterId, dropping dataPoint.", zap.Any("dataPoint", dataPoint))
+
+				continue
+
+			}
+
+			// Values are given the type the serializers expect, like the Histogram of a decoded histogram map
+			value, err := ConvertValue(dataPoint.Value, dataType)
+
+			if err != nil {
+
+				config.Logger.Info("value not of the counter's dataType, dropping dataPoint.", zap.Any("dataPoint", dataPoint), zap.Error(err))
+
+				continue
+
+			}
+
+			if dataPoint.Object != "" {
+
+				objectId, err := storagePool.Objects.Register(dataPoint.Object)
+
+				if err != nil {
+
+					config.Logger.Error("error registering object, dropping dataPoint.", zap.Any("dataPoint", dataPoint), zap.Error(err))
+
+					continue
+
+				}
+
+				dataPoint.ObjectId = objectId
+
+			} else if dataPoint.ObjectId >= ObjectKeyBase {
+
+				// These objectIds are assigned by the registry, or are instance keys
+				config.Logger.Info("objectId in the range of the registered objects, dropping dataPoint.", zap.Any("dataPoint", dataPoint))
 
 				continue
 
@@ -52,7 +91,7 @@ func InitWriteHandler(dataWriteChannel <-chan []PolledDataPoint, storagePool *St
 
 			storageKey := StoragePoolKey{
 
-				Date: UnixToDate(dataPoint.Timestamp),
+				Date: UnixToDate(TimestampSeconds(dataPoint.Timestamp, config.CounterPrecision(dataPoint.CounterId))),
 
 				CounterId: dataPoint.CounterId,
 			}
@@ -61,13 +100,13 @@ func InitWriteHandler(dataWriteChannel <-chan []PolledDataPoint, storagePool *St
 
 				storageKey,
 
-				dataPoint.ObjectId,
+				SeriesInstance{ObjectId: dataPoint.ObjectId, Instance: dataPoint.Instance},
 
 				DataPoint{
 
 					Timestamp: dataPoint.Timestamp,
 
-					Value: dataPoint.Value,
+					Value: value,
 				},
 			)
 
@@ -82,6 +121,10 @@ func InitWriteHandler(dataWriteChannel <-chan []PolledDataPoint, storagePool *St
 	// Wait for final flush
 	<-flushRoutineShutdown
 
+	latestValuesPersistShutdown <- true
+
+	<-latestValuesPersistShutdown
+
 	// Close writers
 	close(writersChannel)
 
@@ -2,7 +2,7 @@ package writer
 
 import (
 	. "datastore/containers"
-	. "datastore/utils"
+	. "datastore/continuous"
 	"go.uber.org/zap"
 	"sync"
 )
@@ -10,50 +10,110 @@ import (
 type WritableObjectBatch struct {
 	StorageKey StoragePoolKey
 	ObjectId   uint32
+	Instance   string
 	Values     []DataPoint
 }
 
-func writer(writersChannel <-chan WritableObjectBatch, storagePool *StoragePool, writerWaitGroup *sync.WaitGroup) {
+func writer(writersChannel <-chan WritableObjectBatch, storagePool *StoragePool, continuousQueries *ContinuousQueries, writerWaitGroup *sync.WaitGroup) {
 
 	defer writerWaitGroup.Done()
 
+	config := storagePool.Config
+
 	dataBytesContainer := make([]byte, 0)
 
 	for dataBatch := range writersChannel {
 
-		Logger.Info("writer received data", zap.Any("dataBatch", dataBatch))
+		config.Logger.Info("writer received data", zap.Any("dataBatch", dataBatch))
 
-		// Serialize the Data
+		storageEngine, err := storagePool.GetStorage(dataBatch.StorageKey, true)
 
-		if err := SerializeBatch(dataBatch.Values, &dataBytesContainer, CounterConfig[dataBatch.StorageKey.CounterId][DataType].(string)); err != nil {
+		if err != nil {
 
-			Logger.Error("error serializing the batch", zap.Error(err))
+			config.Logger.Error("error acquiring storage engine for writing", zap.Error(err))
+
+			continue
 
 		}
 
-		storageEngine, err := storagePool.GetStorage(dataBatch.StorageKey, true)
+		// Serialize the Data, with the dataType and precision the day was created with
 
-		if err != nil {
+		dataType, _ := config.CounterDataType(dataBatch.StorageKey.CounterId)
+
+		precision := config.CounterPrecision(dataBatch.StorageKey.CounterId)
+
+		storageDataType, storagePrecision := storageEngine.DataType(), storageEngine.Precision()
+
+		if storageDataType == "" {
 
-			Logger.Error("error acquiring storage engine for writing", zap.Error(err))
+			storageDataType = dataType
 
 		}
 
-		err = storageEngine.Put(dataBatch.ObjectId, dataBytesContainer)
+		values := dataBatch.Values
+
+		// The batch stays as is for the continuous queries, the day gets a converted copy
+		if storageDataType != dataType || storagePrecision != precision {
+
+			values = make([]DataPoint, len(dataBatch.Values))
+
+			copy(values, dataBatch.Values)
+
+			ConvertTimestamps(values, precision, storagePrecision)
+
+			if storageDataType != dataType {
+
+				if err = ConvertDataPoints(values, storageDataType); err != nil {
+
+					config.Logger.Error("error converting the batch to the day's dataType", zap.String("dataType", storageDataType), zap.Error(err))
+
+					continue
+
+				}
+
+			}
+
+		}
+
+		if err := SerializeBatch(values, &dataBytesContainer, storageDataType, storagePrecision); err != nil {
+
+			config.Logger.Error("error serializing the batch", zap.Error(err))
+
+			dataBytesContainer = dataBytesContainer[:0]
+
+			continue
+
+		}
+
+		seriesKey, err := storageEngine.SeriesKey(dataBatch.ObjectId, dataBatch.Instance)
+
+		if err == nil {
+
+			err = storageEngine.Put(seriesKey, dataBytesContainer)
+
+		}
 
 		if err != nil {
 
-			Logger.Error("error writing to storage:", zap.Error(err))
+			config.Logger.Error("error writing to storage:", zap.Error(err))
+
+		} else if dataBatch.Instance == "" {
+
+			// Continuous queries are evaluated over the objects' own series
+			continuousQueries.Written(dataBatch.StorageKey, dataBatch.ObjectId, dataBatch.Values)
 
 		}
 
-		// Clear the cache for this object
-		DataPointsCache.Del(CreateCacheKey(dataBatch.StorageKey, dataBatch.ObjectId))
+		// Clear the cache for this series
+		storagePool.Caches.DataPoints.Del(CreateCacheKey(dataBatch.StorageKey, seriesKey))
+
+		// Cached query results over this day are stale now
+		storagePool.Caches.InvalidateQueryResults(dataBatch.StorageKey)
 
 		// reslice the dataBytesContainer
 		dataBytesContainer = dataBytesContainer[:0]
 
 	}
 
-	Logger.Info("Writer exiting.")
+	config.Logger.Info("Writer exiting.")
 }